@@ -36,6 +36,7 @@ import (
 
 const (
 	defaultHTTPClientTimeout = 5 * time.Second
+	outputsFlushTimeout      = 30 * time.Second
 )
 
 type App struct {
@@ -0,0 +1,311 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/karimra/gnmic/collector"
+	"github.com/karimra/gnmic/config"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var processorInputFormats = []string{"json", "protojson", "event"}
+
+func (a *App) ProcessorPreRunE(cmd *cobra.Command, args []string) error {
+	a.Config.SetLocalFlagsFromFile(cmd)
+	a.Config.LocalFlags.ProcessorName = config.SanitizeArrayFlagValue(a.Config.LocalFlags.ProcessorName)
+	if len(a.Config.LocalFlags.ProcessorName) == 0 {
+		return errors.New("missing processor name")
+	}
+	switch a.Config.LocalFlags.ProcessorInputFormat {
+	case "", "json", "protojson", "event":
+	default:
+		return fmt.Errorf("input-format must be one of %q", processorInputFormats)
+	}
+	return nil
+}
+
+func (a *App) ProcessorRunE(cmd *cobra.Command, args []string) error {
+	defer a.InitProcessorFlags(cmd)
+
+	epConfig, err := a.Config.GetEventProcessors()
+	if err != nil {
+		return fmt.Errorf("failed reading event processors config: %v", err)
+	}
+	// the processors names are case insensitive
+	names := make([]string, 0, len(a.Config.LocalFlags.ProcessorName))
+	for _, n := range a.Config.LocalFlags.ProcessorName {
+		names = append(names, strings.ToLower(n))
+	}
+	evps, err := formatters.MakeEventProcessors(epConfig, names, a.Logger, nil)
+	if err != nil {
+		return err
+	}
+	defer formatters.CloseEventProcessors(evps)
+	var r io.Reader = os.Stdin
+	if a.Config.LocalFlags.ProcessorInput != "" && a.Config.LocalFlags.ProcessorInput != "-" {
+		f, err := os.Open(a.Config.LocalFlags.ProcessorInput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	msgs, err := readProcessorInput(r, a.Config.LocalFlags.ProcessorInputFormat)
+	if err != nil {
+		return fmt.Errorf("failed reading input: %v", err)
+	}
+	for i, evs := range msgs {
+		err = a.printEvents(fmt.Sprintf("message %d input", i+1), evs)
+		if err != nil {
+			return err
+		}
+		for j, ep := range evps {
+			evs = ep.Apply(evs...)
+			err = a.printEvents(fmt.Sprintf("message %d after processor %q (%s)", i+1, names[j], processorType(epConfig[names[j]])), evs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *App) InitProcessorFlags(cmd *cobra.Command) {
+	cmd.ResetFlags()
+	cmd.Flags().StringVarP(&a.Config.LocalFlags.ProcessorInput, "input", "", "", "file containing the input messages, reads from stdin if not set or set to '-'")
+	cmd.Flags().StringVarP(&a.Config.LocalFlags.ProcessorInputFormat, "input-format", "", "", fmt.Sprintf("input messages format, one of %q, detected from each message if not set", processorInputFormats))
+	cmd.Flags().StringSliceVarP(&a.Config.LocalFlags.ProcessorName, "name", "", []string{}, "event processors names, applied in the order they are set")
+	cmd.LocalFlags().VisitAll(func(flag *pflag.Flag) {
+		a.Config.FileConfig.BindPFlag(fmt.Sprintf("%s-%s", cmd.Name(), flag.Name), flag)
+	})
+}
+
+func (a *App) printEvents(title string, evs []*formatters.EventMsg) error {
+	b, err := json.MarshalIndent(evs, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "%s:\n%s\n", title, string(b))
+	return nil
+}
+
+func processorType(epCfg map[string]interface{}) string {
+	for k := range epCfg {
+		return k
+	}
+	return ""
+}
+
+// readProcessorInput reads a stream of JSON messages from r and converts each of them to a list of events.
+// A JSON array is either a list of events, e.g: a message written with the event format,
+// or a list of subscribe responses.
+func readProcessorInput(r io.Reader, format string) ([][]*formatters.EventMsg, error) {
+	msgs := make([][]*formatters.EventMsg, 0)
+	dec := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 || raw[0] != '[' {
+			evs, err := toEventMsgs(raw, format)
+			if err != nil {
+				return nil, err
+			}
+			if len(evs) > 0 {
+				msgs = append(msgs, evs)
+			}
+			continue
+		}
+		items := make([]json.RawMessage, 0)
+		err = json.Unmarshal(raw, &items)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			continue
+		}
+		f := format
+		if f == "" {
+			f, err = detectInputFormat(items[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+		if f == "event" {
+			evs := make([]*formatters.EventMsg, 0, len(items))
+			err = json.Unmarshal(raw, &evs)
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, evs)
+			continue
+		}
+		for _, item := range items {
+			evs, err := toEventMsgs(item, f)
+			if err != nil {
+				return nil, err
+			}
+			if len(evs) > 0 {
+				msgs = append(msgs, evs)
+			}
+		}
+	}
+}
+
+// toEventMsgs converts a single JSON message to events,
+// the format is detected from the message fields if empty.
+func toEventMsgs(raw json.RawMessage, format string) ([]*formatters.EventMsg, error) {
+	var err error
+	if format == "" {
+		format, err = detectInputFormat(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch format {
+	case "event":
+		ev := new(formatters.EventMsg)
+		err = json.Unmarshal(raw, ev)
+		if err != nil {
+			return nil, err
+		}
+		return []*formatters.EventMsg{ev}, nil
+	case "protojson":
+		rsp := new(gnmi.SubscribeResponse)
+		err = protojson.Unmarshal(raw, rsp)
+		if err != nil {
+			return nil, err
+		}
+		return formatters.ResponseToEventMsgs("default", rsp, nil)
+	case "json":
+		rsp, meta, err := jsonToSubscribeResponse(raw)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := meta["subscription-name"]
+		if !ok {
+			name = "default"
+		}
+		return formatters.ResponseToEventMsgs(name, rsp, meta)
+	}
+	return nil, fmt.Errorf("unknown input format %q", format)
+}
+
+// detectInputFormat returns the format of a JSON message based on its fields.
+func detectInputFormat(raw json.RawMessage) (string, error) {
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range []string{"update", "syncResponse", "sync_response", "error"} {
+		if _, ok := fields[k]; ok {
+			return "protojson", nil
+		}
+	}
+	for _, k := range []string{"name", "tags", "values"} {
+		if _, ok := fields[k]; ok {
+			return "event", nil
+		}
+	}
+	for _, k := range []string{"updates", "deletes", "prefix", "target", "source", "subscription-name", "timestamp"} {
+		if _, ok := fields[k]; ok {
+			return "json", nil
+		}
+	}
+	return "", fmt.Errorf("failed to detect the format of message: %s", string(raw))
+}
+
+// jsonToSubscribeResponse builds a subscribe response from a message written with the json format,
+// the returned meta contains the message source, system-name and subscription-name.
+func jsonToSubscribeResponse(raw json.RawMessage) (*gnmi.SubscribeResponse, map[string]string, error) {
+	msg := new(formatters.NotificationRspMsg)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	err := dec.Decode(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix, err := collector.CreatePrefix(msg.Prefix, msg.Target)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := &gnmi.Notification{
+		Timestamp: msg.Timestamp,
+		Prefix:    prefix,
+		Update:    make([]*gnmi.Update, 0, len(msg.Updates)),
+	}
+	for _, upd := range msg.Updates {
+		p, err := collector.ParsePath(upd.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, v := range upd.Values {
+			tv, err := jsonValueToTypedValue(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			n.Update = append(n.Update, &gnmi.Update{Path: p, Val: tv})
+		}
+	}
+	for _, del := range msg.Deletes {
+		p, err := collector.ParsePath(del)
+		if err != nil {
+			return nil, nil, err
+		}
+		n.Delete = append(n.Delete, p)
+	}
+	meta := make(map[string]string)
+	if msg.Source != "" {
+		meta["source"] = msg.Source
+	}
+	if msg.SystemName != "" {
+		meta["system-name"] = msg.SystemName
+	}
+	if msg.SubscriptionName != "" {
+		meta["subscription-name"] = msg.SubscriptionName
+	}
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{Update: n},
+	}, meta, nil
+}
+
+// jsonValueToTypedValue converts a value decoded from a json format message to a TypedValue,
+// the integers keep their type, the other numbers and the non scalar values are JSON encoded.
+func jsonValueToTypedValue(v interface{}) (*gnmi.TypedValue, error) {
+	switch v := v.(type) {
+	case string:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}, nil
+	case bool:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_BoolVal{BoolVal: v}}, nil
+	case json.Number:
+		if i, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: i}}, nil
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: u}}, nil
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: b}}, nil
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+const testProcessorInput = `
+{"update":{"timestamp":"42","prefix":{"elem":[{"name":"interfaces"},{"name":"interface","key":{"name":"e1"}}]},"update":[{"path":{"elem":[{"name":"state"},{"name":"counters"}]},"val":{"jsonVal":"eyJpbi1vY3RldHMiOjB9"}}]}}
+{"syncResponse":true}
+{"source":"r1","subscription-name":"sub1","timestamp":43,"prefix":"interfaces/interface[name=e2]","updates":[{"Path":"state/counters/in-octets","values":{"state/counters/in-octets":18446744073709551615}}]}
+[{"name":"sub1","timestamp":44,"tags":{"interface_name":"e3"},"values":{"/interfaces/interface/state/oper-status":"UP"}},{"name":"sub1","timestamp":44}]
+`
+
+func TestReadProcessorInput(t *testing.T) {
+	msgs, err := readProcessorInput(strings.NewReader(testProcessorInput), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sync response produces no events
+	if len(msgs) != 3 {
+		t.Fatalf("unexpected number of messages: %d", len(msgs))
+	}
+	// protojson
+	if len(msgs[0]) != 1 || msgs[0][0].Name != "default" || msgs[0][0].Tags["interface_name"] != "e1" ||
+		msgs[0][0].Values["/interfaces/interface/state/counters/in-octets"] != float64(0) {
+		t.Errorf("unexpected protojson message events: %+v", msgs[0])
+	}
+	// json
+	if len(msgs[1]) != 1 || msgs[1][0].Name != "sub1" || msgs[1][0].Timestamp != 43 ||
+		msgs[1][0].Tags["interface_name"] != "e2" || msgs[1][0].Tags["source"] != "r1" ||
+		msgs[1][0].Values["/interfaces/interface/state/counters/in-octets"] != uint64(18446744073709551615) {
+		t.Errorf("unexpected json message events: %+v", msgs[1])
+	}
+	// event
+	if len(msgs[2]) != 2 || msgs[2][0].Values["/interfaces/interface/state/oper-status"] != "UP" {
+		t.Errorf("unexpected event message events: %+v", msgs[2])
+	}
+}
+
+func TestReadProcessorInputErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input  string
+		format string
+	}{
+		"unknown_fields": {input: `{"foo":"bar"}`},
+		"bad_json":       {input: `{"name":`},
+		"wrong_format":   {input: `{"name":"sub1"}`, format: "protojson"},
+		"bad_path":       {input: `{"updates":[{"Path":"a[b=c","values":{"a":1}}]}`},
+	} {
+		if _, err := readProcessorInput(strings.NewReader(tc.input), tc.format); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -407,6 +407,10 @@ func (a *App) SubscribeRunONCE(cmd *cobra.Command, args []string) error {
 		limiter.Stop()
 	}
 	a.wg.Wait()
+	// flush the outputs holding the written messages, e.g: prometheus pushgateway
+	fctx, cancel := context.WithTimeout(a.ctx, outputsFlushTimeout)
+	defer cancel()
+	a.collector.FlushOutputs(fctx)
 	return a.checkErrors()
 }
 
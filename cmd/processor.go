@@ -0,0 +1,35 @@
+// Copyright © 2021 Karim Radhouani <medkarimrdi@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// newProcessorCmd represents the processor command
+func newProcessorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "processor",
+		Short: "apply a chain of event processors to sample messages",
+		Annotations: map[string]string{
+			"--input": "FILE",
+		},
+		PreRunE:      gApp.ProcessorPreRunE,
+		RunE:         gApp.ProcessorRunE,
+		SilenceUsage: true,
+	}
+	gApp.InitProcessorFlags(cmd)
+	return cmd
+}
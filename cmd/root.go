@@ -73,6 +73,7 @@ func newRootCmd() *cobra.Command {
 	genCmd.AddCommand(newGeneratePathCmd())
 	gApp.RootCmd.AddCommand(genCmd)
 	//
+	gApp.RootCmd.AddCommand(newProcessorCmd())
 	gApp.RootCmd.AddCommand(newPromptCmd())
 	gApp.RootCmd.AddCommand(newSetCmd())
 	gApp.RootCmd.AddCommand(newSubscribeCmd())
@@ -15,9 +15,12 @@ import (
 	"github.com/fullstorydev/grpcurl"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/jhump/protoreflect/desc"
+	"github.com/karimra/gnmic/formatters"
 	"github.com/karimra/gnmic/inputs"
 	"github.com/karimra/gnmic/lockers"
 	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/outputs/filter"
+	"github.com/karimra/gnmic/outputs/queue"
 	"github.com/mitchellh/mapstructure"
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/openconfig/gnmi/proto/gnmi_ext"
@@ -48,11 +51,12 @@ type Collector struct {
 	Config   *Config
 	dialOpts []grpc.DialOption
 	//
-	m             *sync.Mutex
+	m             *sync.RWMutex
 	Subscriptions map[string]*SubscriptionConfig
 
-	outputsConfig map[string]map[string]interface{}
-	Outputs       map[string]outputs.Output
+	outputsConfig  map[string]map[string]interface{}
+	Outputs        map[string]outputs.Output
+	outputsFilters map[string]*filter.Filter
 
 	inputsConfig map[string]map[string]interface{}
 	Inputs       map[string]inputs.Input
@@ -132,10 +136,11 @@ func NewCollector(config *Config, targetConfigs map[string]*TargetConfig, opts .
 	}
 	c := &Collector{
 		Config:         config,
-		m:              new(sync.Mutex),
+		m:              new(sync.RWMutex),
 		targetsConfig:  make(map[string]*TargetConfig),
 		Targets:        make(map[string]*Target),
 		Outputs:        make(map[string]outputs.Output),
+		outputsFilters: make(map[string]*filter.Filter),
 		Inputs:         make(map[string]inputs.Input),
 		httpServer:     httpServer,
 		targetsChan:    make(chan *Target),
@@ -155,6 +160,9 @@ func NewCollector(config *Config, targetConfigs map[string]*TargetConfig, opts .
 		c.reg.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
 		grpcMetrics.EnableClientHandlingTimeHistogram()
 		c.reg.MustRegister(grpcMetrics)
+		if err := formatters.RegisterMetrics(c.reg); err != nil {
+			c.logger.Printf("failed to register event processors metrics: %v", err)
+		}
 		handler := http.NewServeMux()
 		handler.Handle("/metrics", promhttp.HandlerFor(c.reg, promhttp.HandlerOpts{}))
 		c.httpServer = &http.Server{
@@ -431,7 +439,18 @@ func (c *Collector) InitOutput(ctx context.Context, name string, tcs map[string]
 		if outType, ok := cfg["type"]; ok {
 			c.logger.Printf("starting output type %s", outType)
 			if initializer, ok := outputs.Outputs[outType.(string)]; ok {
-				out := initializer()
+				if filter.Enabled(cfg) {
+					f, err := filter.New(cfg)
+					if err != nil {
+						c.logger.Printf("failed to init output %q filter: %v", name, err)
+						return
+					}
+					c.outputsFilters[name] = f
+				}
+				var out outputs.Output = initializer()
+				if queue.Enabled(cfg) {
+					out = queue.NewOutput(out)
+				}
 				go func() {
 					err := out.Init(ctx, name, cfg,
 						outputs.WithLogger(c.logger),
@@ -442,6 +461,13 @@ func (c *Collector) InitOutput(ctx context.Context, name string, tcs map[string]
 					)
 					if err != nil {
 						c.logger.Printf("failed to init output type %q: %v", outType, err)
+						// the output is not usable, stop writing to it
+						c.m.Lock()
+						if c.Outputs[name] == out {
+							delete(c.Outputs, name)
+							delete(c.outputsFilters, name)
+						}
+						c.m.Unlock()
 					}
 				}()
 				c.Outputs[name] = out
@@ -458,17 +484,43 @@ func (c *Collector) InitOutputs(ctx context.Context) {
 }
 
 func (c *Collector) DeleteOutput(name string) error {
-	if c.Outputs == nil {
-		return nil
-	}
-	if _, ok := c.Outputs[name]; !ok {
+	c.m.Lock()
+	o, ok := c.Outputs[name]
+	if !ok {
+		c.m.Unlock()
 		return fmt.Errorf("output '%s' does not exist", name)
 	}
-	c.m.Lock()
-	defer c.m.Unlock()
-	o := c.Outputs[name]
-	o.Close()
-	return nil
+	delete(c.Outputs, name)
+	delete(c.outputsFilters, name)
+	c.m.Unlock()
+	// the output is closed without holding the lock,
+	// a slow close does not block the other outputs writes.
+	return o.Close()
+}
+
+// FlushOutputs flushes the outputs holding the written messages until they send them,
+// it returns once all the outputs are flushed or ctx is done.
+func (c *Collector) FlushOutputs(ctx context.Context) {
+	flushers := make(map[string]outputs.Flusher)
+	c.m.RLock()
+	for name, o := range c.Outputs {
+		if f, ok := o.(outputs.Flusher); ok {
+			flushers[name] = f
+		}
+	}
+	c.m.RUnlock()
+	wg := new(sync.WaitGroup)
+	wg.Add(len(flushers))
+	for name, f := range flushers {
+		go func(name string, f outputs.Flusher) {
+			defer wg.Done()
+			err := f.Flush(ctx)
+			if err != nil {
+				c.logger.Printf("failed to flush output %q: %v", name, err)
+			}
+		}(name, f)
+	}
+	wg.Wait()
 }
 
 // AddSubscriptionConfig adds a subscriptionConfig sc to Collector's map if it does not already exists
@@ -776,30 +828,50 @@ func (c *Collector) Export(ctx context.Context, rsp *gnmi.SubscribeResponse, m o
 	if rsp == nil {
 		return
 	}
-	wg := new(sync.WaitGroup)
+	// the outputs and their filters are looked up under the lock
+	// since they can be added or removed at runtime.
+	c.m.RLock()
 	if len(outs) == 0 {
-		wg.Add(len(c.Outputs))
-		for _, o := range c.Outputs {
-			go func(o outputs.Output) {
-				defer wg.Done()
-				o.Write(ctx, rsp, m)
-			}(o)
+		outs = make([]string, 0, len(c.Outputs))
+		for name := range c.Outputs {
+			outs = append(outs, name)
 		}
-		wg.Wait()
-		return
 	}
+	writers := make([]outputWriter, 0, len(outs))
 	for _, name := range outs {
 		if o, ok := c.Outputs[name]; ok {
-			wg.Add(1)
-			go func(o outputs.Output) {
-				defer wg.Done()
-				o.Write(ctx, rsp, m)
-			}(o)
+			writers = append(writers, outputWriter{o: o, f: c.outputsFilters[name]})
 		}
 	}
+	c.m.RUnlock()
+	wg := new(sync.WaitGroup)
+	wg.Add(len(writers))
+	for _, w := range writers {
+		go func(w outputWriter) {
+			defer wg.Done()
+			w.write(ctx, rsp, m)
+		}(w)
+	}
 	wg.Wait()
 }
 
+// outputWriter is an output and its filter, if any
+type outputWriter struct {
+	o outputs.Output
+	f *filter.Filter
+}
+
+// write applies the output filter, if any, to rsp before writing it to the output
+func (w outputWriter) write(ctx context.Context, rsp *gnmi.SubscribeResponse, m outputs.Meta) {
+	if w.f != nil {
+		rsp = w.f.Apply(m["subscription-name"], rsp, m)
+		if rsp == nil {
+			return
+		}
+	}
+	w.o.Write(ctx, rsp, m)
+}
+
 func (c *Collector) Capabilities(ctx context.Context, tName string, ext ...*gnmi_ext.Extension) (*gnmi.CapabilityResponse, error) {
 	if _, ok := c.Targets[tName]; !ok {
 		err := c.initTarget(tName)
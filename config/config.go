@@ -199,6 +199,10 @@ type LocalFlags struct {
 	DiffRef     string   `mapstructure:"diff-ref,omitempty" json:"diff-ref,omitempty" yaml:"diff-ref,omitempty"`
 	DiffCompare []string `mapstructure:"diff-compare,omitempty" json:"diff-compare,omitempty" yaml:"diff-compare,omitempty"`
 	DiffQos     uint32   `mapstructure:"diff-qos,omitempty" json:"diff-qos,omitempty" yaml:"diff-qos,omitempty"`
+	//
+	ProcessorInput       string   `mapstructure:"processor-input,omitempty" json:"processor-input,omitempty" yaml:"processor-input,omitempty"`
+	ProcessorInputFormat string   `mapstructure:"processor-input-format,omitempty" json:"processor-input-format,omitempty" yaml:"processor-input-format,omitempty"`
+	ProcessorName        []string `mapstructure:"processor-name,omitempty" json:"processor-name,omitempty" yaml:"processor-name,omitempty"`
 }
 
 func New() *Config {
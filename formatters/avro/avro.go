@@ -0,0 +1,159 @@
+package avro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/linkedin/goavro/v2"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// EventSchema is the Avro schema used to encode formatters.EventMsg.
+// The values are a union of the scalar types, non scalar values are encoded as JSON strings.
+const EventSchema = `{
+  "type": "record",
+  "name": "EventMsg",
+  "namespace": "gnmic",
+  "fields": [
+    {"name": "name", "type": "string"},
+    {"name": "timestamp", "type": "long"},
+    {"name": "tags", "type": {"type": "map", "values": "string"}},
+    {"name": "values", "type": {"type": "map", "values": ["null", "boolean", "long", "double", "string", "bytes"]}},
+    {"name": "deletes", "type": {"type": "array", "items": "string"}}
+  ]
+}`
+
+var eventCodec *goavro.Codec
+
+func init() {
+	var err error
+	eventCodec, err = goavro.NewCodec(EventSchema)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// eventToNative converts an event message into the goavro native form of EventSchema
+func eventToNative(ev *formatters.EventMsg) (map[string]interface{}, error) {
+	tags := make(map[string]interface{}, len(ev.Tags))
+	for k, v := range ev.Tags {
+		tags[k] = v
+	}
+	values := make(map[string]interface{}, len(ev.Values))
+	for k, v := range ev.Values {
+		nv, err := valueToNative(v)
+		if err != nil {
+			return nil, fmt.Errorf("value %q: %v", k, err)
+		}
+		values[k] = nv
+	}
+	deletes := make([]interface{}, 0, len(ev.Deletes))
+	for _, d := range ev.Deletes {
+		deletes = append(deletes, d)
+	}
+	return map[string]interface{}{
+		"name":      ev.Name,
+		"timestamp": ev.Timestamp,
+		"tags":      tags,
+		"values":    values,
+		"deletes":   deletes,
+	}, nil
+}
+
+func valueToNative(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return goavro.Union("boolean", v), nil
+	case int:
+		return goavro.Union("long", int64(v)), nil
+	case int8:
+		return goavro.Union("long", int64(v)), nil
+	case int16:
+		return goavro.Union("long", int64(v)), nil
+	case int32:
+		return goavro.Union("long", int64(v)), nil
+	case int64:
+		return goavro.Union("long", v), nil
+	case uint:
+		return uintToNative(uint64(v)), nil
+	case uint8:
+		return goavro.Union("long", int64(v)), nil
+	case uint16:
+		return goavro.Union("long", int64(v)), nil
+	case uint32:
+		return goavro.Union("long", int64(v)), nil
+	case uint64:
+		return uintToNative(v), nil
+	case float32:
+		return goavro.Union("double", float64(v)), nil
+	case float64:
+		return goavro.Union("double", v), nil
+	case string:
+		return goavro.Union("string", v), nil
+	case []byte:
+		return goavro.Union("bytes", v), nil
+	case *gnmi.Decimal64:
+		return goavro.Union("double", float64(v.Digits)/math.Pow10(int(v.Precision))), nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return goavro.Union("string", string(b)), nil
+	}
+}
+
+// uint64 values that do not fit in an Avro long are encoded as doubles
+func uintToNative(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return goavro.Union("double", float64(v))
+	}
+	return goavro.Union("long", int64(v))
+}
+
+// nativeToEvent converts the goavro native form of EventSchema into an event message
+func nativeToEvent(n interface{}) (*formatters.EventMsg, error) {
+	m, ok := n.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected avro record type %T", n)
+	}
+	ev := &formatters.EventMsg{}
+	ev.Name, _ = m["name"].(string)
+	ev.Timestamp, _ = m["timestamp"].(int64)
+	if tags, ok := m["tags"].(map[string]interface{}); ok && len(tags) > 0 {
+		ev.Tags = make(map[string]string, len(tags))
+		for k, v := range tags {
+			ev.Tags[k], _ = v.(string)
+		}
+	}
+	if values, ok := m["values"].(map[string]interface{}); ok && len(values) > 0 {
+		ev.Values = make(map[string]interface{}, len(values))
+		for k, v := range values {
+			// non null union values are decoded as a single entry map: {type: value}
+			switch v := v.(type) {
+			case nil:
+				ev.Values[k] = nil
+			case map[string]interface{}:
+				for _, uv := range v {
+					ev.Values[k] = uv
+				}
+			default:
+				return nil, errors.New("unexpected avro value type")
+			}
+		}
+	}
+	if deletes, ok := m["deletes"].([]interface{}); ok && len(deletes) > 0 {
+		ev.Deletes = make([]string, 0, len(deletes))
+		for _, d := range deletes {
+			if ds, ok := d.(string); ok {
+				ev.Deletes = append(ev.Deletes, ds)
+			}
+		}
+	}
+	return ev, nil
+}
@@ -0,0 +1,173 @@
+package avro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+)
+
+// testRegistry is a minimal schema registry
+type testRegistry struct {
+	m             sync.Mutex
+	schemas       []string
+	registrations int
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	switch {
+	case req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/subjects/"):
+		body := struct {
+			Schema string `json:"schema"`
+		}{}
+		err := json.NewDecoder(req.Body).Decode(&body)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprintf(w, `{"error_code":42201,"message":"invalid schema"}`)
+			return
+		}
+		r.registrations++
+		for i, s := range r.schemas {
+			if s == body.Schema {
+				fmt.Fprintf(w, `{"id":%d}`, i+1)
+				return
+			}
+		}
+		r.schemas = append(r.schemas, body.Schema)
+		fmt.Fprintf(w, `{"id":%d}`, len(r.schemas))
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/schemas/ids/"):
+		var id int
+		fmt.Sscanf(strings.TrimPrefix(req.URL.Path, "/schemas/ids/"), "%d", &id)
+		if id < 1 || id > len(r.schemas) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error_code":40403,"message":"Schema not found"}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"schema": r.schemas[id-1]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+var testEvent = &formatters.EventMsg{
+	Name:      "sub1",
+	Timestamp: 1606824673847153523,
+	Tags: map[string]string{
+		"source":         "router1",
+		"interface_name": "ethernet-1/1",
+	},
+	Values: map[string]interface{}{
+		"/interface/statistics/in-octets":     uint64(42),
+		"/interface/statistics/out-octets":    uint64(1 << 63),
+		"/interface/oper-state":               "up",
+		"/interface/mtu":                      int32(9000),
+		"/interface/admin-enabled":            true,
+		"/interface/load":                     0.5,
+		"/interface/ipv4/address/ip-prefixes": []interface{}{"10.0.0.1/31", "10.0.1.1/31"},
+		"/interface/description":              nil,
+	},
+}
+
+func TestSerializer(t *testing.T) {
+	reg := &testRegistry{}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	s, err := NewSerializer(&RegistryConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var b []byte
+	for i := 0; i < 3; i++ {
+		b, err = s.Encode(ctx, Subject("telemetry"), testEvent)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reg.registrations != 1 {
+		t.Errorf("expected the schema to be registered once, got %d registrations", reg.registrations)
+	}
+	if b[0] != 0 || b[4] != 1 {
+		t.Errorf("unexpected message header: %v", b[:5])
+	}
+	// a new serializer reads the schema from the registry
+	d, err := NewSerializer(&RegistryConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Decode(ctx, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &formatters.EventMsg{
+		Name:      testEvent.Name,
+		Timestamp: testEvent.Timestamp,
+		Tags:      testEvent.Tags,
+		Values: map[string]interface{}{
+			"/interface/statistics/in-octets":     int64(42),
+			"/interface/statistics/out-octets":    float64(1 << 63),
+			"/interface/oper-state":               "up",
+			"/interface/mtu":                      int64(9000),
+			"/interface/admin-enabled":            true,
+			"/interface/load":                     0.5,
+			"/interface/ipv4/address/ip-prefixes": `["10.0.0.1/31","10.0.1.1/31"]`,
+			"/interface/description":              nil,
+		},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("decoded event mismatch: %s", cmp.Diff(want, got))
+	}
+}
+
+func TestSerializerDeletes(t *testing.T) {
+	srv := httptest.NewServer(&testRegistry{})
+	defer srv.Close()
+	s, err := NewSerializer(&RegistryConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 42,
+		Tags:      map[string]string{"source": "router1"},
+		Deletes:   []string{"/interface[name=ethernet-1/1]"},
+	}
+	b, err := s.Encode(context.Background(), "telemetry-value", ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Decode(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(got, ev) {
+		t.Errorf("decoded event mismatch: %s", cmp.Diff(ev, got))
+	}
+}
+
+func TestSerializerDecodeErrors(t *testing.T) {
+	srv := httptest.NewServer(&testRegistry{})
+	defer srv.Close()
+	s, err := NewSerializer(&RegistryConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Decode(context.Background(), []byte(`[{"name":"sub1"}]`))
+	if err == nil {
+		t.Errorf("expected an error for a non avro message")
+	}
+	_, err = s.Decode(context.Background(), []byte{0, 0, 0, 0, 7, 0})
+	if err == nil || !strings.Contains(err.Error(), "Schema not found") {
+		t.Errorf("expected a schema not found error, got %v", err)
+	}
+}
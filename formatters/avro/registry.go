@@ -0,0 +1,201 @@
+package avro
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/linkedin/goavro/v2"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	contentType    = "application/vnd.schemaregistry.v1+json"
+	// Confluent wire format: magic byte + 4 bytes schema ID
+	magicByte  = 0
+	headerSize = 5
+)
+
+// RegistryConfig is the Confluent Schema Registry client configuration
+type RegistryConfig struct {
+	URL        string        `mapstructure:"url,omitempty" json:"url,omitempty"`
+	Username   string        `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password   string        `mapstructure:"password,omitempty" json:"password,omitempty"`
+	Timeout    time.Duration `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+	SkipVerify bool          `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+// Serializer encodes and decodes event messages to and from Avro
+// using the Confluent Schema Registry wire format.
+type Serializer struct {
+	cfg    *RegistryConfig
+	client *http.Client
+
+	m *sync.RWMutex
+	// schema ID of EventSchema per subject
+	ids map[string]int32
+	// codecs of the schemas read from the registry, per schema ID
+	codecs map[int32]*goavro.Codec
+}
+
+// NewSerializer //
+func NewSerializer(cfg *RegistryConfig) (*Serializer, error) {
+	if cfg == nil || cfg.URL == "" {
+		return nil, errors.New("missing schema registry url")
+	}
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid schema registry url: %v", err)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &Serializer{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.SkipVerify},
+			},
+		},
+		m:      new(sync.RWMutex),
+		ids:    make(map[string]int32),
+		codecs: make(map[int32]*goavro.Codec),
+	}, nil
+}
+
+// Subject returns the subject name of a topic messages values,
+// following the registry default TopicNameStrategy.
+func Subject(topic string) string {
+	return topic + "-value"
+}
+
+// Encode encodes event ev using EventSchema registered under subject.
+// The schema is registered on the first use of a subject.
+func (s *Serializer) Encode(ctx context.Context, subject string, ev *formatters.EventMsg) ([]byte, error) {
+	id, err := s.schemaID(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	native, err := eventToNative(ev)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, headerSize, 256)
+	b[0] = magicByte
+	binary.BigEndian.PutUint32(b[1:headerSize], uint32(id))
+	return eventCodec.BinaryFromNative(b, native)
+}
+
+// Decode decodes a message encoded with the schema registry wire format,
+// the writer schema is read from the registry using the message schema ID.
+func (s *Serializer) Decode(ctx context.Context, b []byte) (*formatters.EventMsg, error) {
+	if len(b) < headerSize || b[0] != magicByte {
+		return nil, errors.New("unknown avro message framing")
+	}
+	id := int32(binary.BigEndian.Uint32(b[1:headerSize]))
+	codec, err := s.codec(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := codec.NativeFromBinary(b[headerSize:])
+	if err != nil {
+		return nil, err
+	}
+	return nativeToEvent(native)
+}
+
+func (s *Serializer) schemaID(ctx context.Context, subject string) (int32, error) {
+	s.m.RLock()
+	id, ok := s.ids[subject]
+	s.m.RUnlock()
+	if ok {
+		return id, nil
+	}
+	b, err := json.Marshal(map[string]string{"schema": EventSchema})
+	if err != nil {
+		return 0, err
+	}
+	rsp := struct {
+		ID int32 `json:"id,omitempty"`
+	}{}
+	err = s.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", b, &rsp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register schema under subject %q: %v", subject, err)
+	}
+	s.m.Lock()
+	s.ids[subject] = rsp.ID
+	s.codecs[rsp.ID] = eventCodec
+	s.m.Unlock()
+	return rsp.ID, nil
+}
+
+func (s *Serializer) codec(ctx context.Context, id int32) (*goavro.Codec, error) {
+	s.m.RLock()
+	codec, ok := s.codecs[id]
+	s.m.RUnlock()
+	if ok {
+		return codec, nil
+	}
+	rsp := struct {
+		Schema string `json:"schema,omitempty"`
+	}{}
+	err := s.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &rsp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schema ID %d: %v", id, err)
+	}
+	codec, err = goavro.NewCodec(rsp.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema ID %d: %v", id, err)
+	}
+	s.m.Lock()
+	s.codecs[id] = codec
+	s.m.Unlock()
+	return codec, nil
+}
+
+func (s *Serializer) do(ctx context.Context, method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, strings.TrimRight(s.cfg.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	rb, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		regErr := struct {
+			ErrorCode int    `json:"error_code,omitempty"`
+			Message   string `json:"message,omitempty"`
+		}{}
+		if json.Unmarshal(rb, &regErr) == nil && regErr.Message != "" {
+			return fmt.Errorf("%s: %d: %s", rsp.Status, regErr.ErrorCode, regErr.Message)
+		}
+		return errors.New(rsp.Status)
+	}
+	return json.Unmarshal(rb, result)
+}
@@ -3,6 +3,9 @@ package formatters
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 
 	flattener "github.com/karimra/go-map-flattener"
@@ -18,6 +21,26 @@ type EventMsg struct {
 	Deletes   []string               `json:"deletes,omitempty"`
 }
 
+// SeriesKey builds a string identifying a series from an event name and tags,
+// two events with the same name and tags have the same key regardless of the tags order.
+func SeriesKey(name string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb := new(strings.Builder)
+	sb.WriteString(name)
+	sb.WriteString("|")
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(tags[k])
+		sb.WriteString("|")
+	}
+	return sb.String()
+}
+
 // ResponseToEventMsgs //
 func ResponseToEventMsgs(name string, rsp *gnmi.SubscribeResponse, meta map[string]string, eps ...EventProcessor) ([]*EventMsg, error) {
 	if rsp == nil {
@@ -92,7 +115,8 @@ func ResponseToEventMsgs(name string, rsp *gnmi.SubscribeResponse, meta map[stri
 				e.Tags[k] = v
 			}
 			for _, del := range rsp.Update.Delete {
-				e.Deletes = append(e.Deletes, gnmiPathToXPath(del))
+				// the prefix keys are part of the event tags
+				e.Deletes = append(e.Deletes, strings.TrimRight(namePrefix, "/")+"/"+strings.TrimLeft(gnmiPathToXPath(del), "/"))
 			}
 			evs = append(evs, e)
 		}
@@ -316,3 +340,51 @@ func num64(n interface{}) interface{} {
 	}
 	return nil
 }
+
+// ToFloat converts an event value to a float64, it returns false if v is not a number.
+// The booleans are converted to 1 or 0, the strings are parsed.
+func ToFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case *gnmi.Decimal64:
+		if v == nil {
+			return 0, false
+		}
+		return float64(v.Digits) / math.Pow10(int(v.Precision)), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, false
+		}
+		return f, true
+	}
+	return 0, false
+}
@@ -0,0 +1,368 @@
+package event_aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+const (
+	processorType = "event-aggregate"
+	loggingPrefix = "[" + processorType + "] "
+
+	windowTumbling = "tumbling"
+	windowSliding  = "sliding"
+
+	defaultWindow = time.Minute
+)
+
+var defaultFunctions = []string{"min", "max", "mean"}
+
+// Aggregate groups the values with a name matching one of the regexes by event name and tags,
+// over a time window. When the window closes, an event summarizing each group is emitted.
+type Aggregate struct {
+	ValueNames []string `mapstructure:"value-names,omitempty" json:"value-names,omitempty"`
+	// tag names the events are grouped by, all the tags if empty
+	GroupBy []string `mapstructure:"group-by,omitempty" json:"group-by,omitempty"`
+	// tumbling or sliding
+	Type   string        `mapstructure:"type,omitempty" json:"type,omitempty"`
+	Window time.Duration `mapstructure:"window,omitempty" json:"window,omitempty"`
+	// sliding windows only, interval between two emissions
+	Step time.Duration `mapstructure:"step,omitempty" json:"step,omitempty"`
+	// min, max, mean, sum, count, last and percentiles, e.g: p95
+	Functions []string `mapstructure:"functions,omitempty" json:"functions,omitempty"`
+	Debug     bool     `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	valueNames []*regexp.Regexp
+	funcs      []*aggFunc
+
+	m         *sync.Mutex
+	groups    map[string]*group
+	nextFlush time.Time
+	logger    *log.Logger
+}
+
+type group struct {
+	name string
+	tags map[string]string
+	// samples indexed by value name, in arrival order
+	samples map[string][]sample
+}
+
+type sample struct {
+	t time.Time
+	v float64
+}
+
+type aggFunc struct {
+	name string
+	// fn is called with the samples values in arrival order and sorted
+	fn func(vs, sorted []float64) interface{}
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Aggregate{
+			m:      new(sync.Mutex),
+			groups: make(map[string]*group),
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Aggregate) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if len(p.ValueNames) == 0 {
+		return errors.New("missing value-names")
+	}
+	p.valueNames = make([]*regexp.Regexp, 0, len(p.ValueNames))
+	for _, reg := range p.ValueNames {
+		re, err := regexp.Compile(reg)
+		if err != nil {
+			return err
+		}
+		p.valueNames = append(p.valueNames, re)
+	}
+	if p.Window <= 0 {
+		p.Window = defaultWindow
+	}
+	switch p.Type {
+	case "":
+		p.Type = windowTumbling
+		p.Step = p.Window
+	case windowTumbling:
+		p.Step = p.Window
+	case windowSliding:
+		if p.Step <= 0 {
+			p.Step = p.Window / 4
+		}
+		if p.Step > p.Window {
+			return errors.New("step cannot be greater than window")
+		}
+	default:
+		return fmt.Errorf("unknown window type %q", p.Type)
+	}
+	if len(p.Functions) == 0 {
+		p.Functions = defaultFunctions
+	}
+	p.funcs = make([]*aggFunc, 0, len(p.Functions))
+	for _, name := range p.Functions {
+		f, err := newAggFunc(name)
+		if err != nil {
+			return err
+		}
+		p.funcs = append(p.funcs, f)
+	}
+	p.nextFlush = time.Now().Truncate(p.Step).Add(p.Step)
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Aggregate) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	return p.apply(time.Now(), es...)
+}
+
+// apply aggregates the values of the events received at time now,
+// and appends the events of the closed windows to the result
+func (p *Aggregate) apply(now time.Time, es ...*formatters.EventMsg) []*formatters.EventMsg {
+	p.m.Lock()
+	defer p.m.Unlock()
+	result := make([]*formatters.EventMsg, 0, len(es))
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		var g *group
+		for k, v := range e.Values {
+			if !p.match(k) {
+				continue
+			}
+			f, ok := formatters.ToFloat(v)
+			if !ok {
+				p.logger.Printf("value %q=%v of type %T is not a number", k, v, v)
+				continue
+			}
+			if g == nil {
+				g = p.getGroup(e)
+			}
+			g.samples[k] = append(g.samples[k], sample{t: now, v: f})
+			delete(e.Values, k)
+		}
+		// the events left without values are dropped
+		if len(e.Values) == 0 && len(e.Deletes) == 0 {
+			continue
+		}
+		result = append(result, e)
+	}
+	return append(result, p.flush(now)...)
+}
+
+func (p *Aggregate) WithLogger(l *log.Logger) {
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Aggregate) WithTargets(tcs map[string]interface{}) {}
+
+// WithEmitter starts a timer flushing the closed windows,
+// without it the closed windows are emitted with the output of the next Apply call.
+func (p *Aggregate) WithEmitter(ctx context.Context, fn func([]*formatters.EventMsg)) {
+	go func() {
+		for {
+			p.m.Lock()
+			timer := time.NewTimer(time.Until(p.nextFlush))
+			p.m.Unlock()
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				p.m.Lock()
+				es := p.flush(time.Now())
+				p.m.Unlock()
+				if len(es) > 0 {
+					fn(es)
+				}
+			}
+		}
+	}()
+}
+
+func (p *Aggregate) match(k string) bool {
+	for _, re := range p.valueNames {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Aggregate) getGroup(e *formatters.EventMsg) *group {
+	tags := e.Tags
+	if len(p.GroupBy) > 0 {
+		tags = make(map[string]string, len(p.GroupBy))
+		for _, t := range p.GroupBy {
+			if v, ok := e.Tags[t]; ok {
+				tags[t] = v
+			}
+		}
+	}
+	key := formatters.SeriesKey(e.Name, tags)
+	g, ok := p.groups[key]
+	if !ok {
+		g = &group{
+			name:    e.Name,
+			tags:    make(map[string]string, len(tags)),
+			samples: make(map[string][]sample),
+		}
+		for k, v := range tags {
+			g.tags[k] = v
+		}
+		p.groups[key] = g
+	}
+	return g
+}
+
+// flush returns an event per group if the current window is closed
+func (p *Aggregate) flush(now time.Time) []*formatters.EventMsg {
+	if now.Before(p.nextFlush) {
+		return nil
+	}
+	end := p.nextFlush
+	p.nextFlush = now.Truncate(p.Step).Add(p.Step)
+	keys := make([]string, 0, len(p.groups))
+	for k := range p.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	es := make([]*formatters.EventMsg, 0, len(keys))
+	for _, k := range keys {
+		g := p.groups[k]
+		if p.Type == windowSliding {
+			g.prune(end.Add(-p.Window))
+		}
+		if len(g.samples) == 0 {
+			delete(p.groups, k)
+			continue
+		}
+		es = append(es, p.summarize(g, end))
+		if p.Type == windowTumbling {
+			delete(p.groups, k)
+		}
+	}
+	if len(es) > 0 {
+		p.logger.Printf("window ending at %s closed, emitting %d event(s)", end, len(es))
+	}
+	return es
+}
+
+func (p *Aggregate) summarize(g *group, end time.Time) *formatters.EventMsg {
+	e := &formatters.EventMsg{
+		Name:      g.name,
+		Timestamp: end.UnixNano(),
+		Tags:      make(map[string]string, len(g.tags)),
+		Values:    make(map[string]interface{}, len(g.samples)*len(p.funcs)),
+	}
+	for k, v := range g.tags {
+		e.Tags[k] = v
+	}
+	for vn, samples := range g.samples {
+		vs := make([]float64, 0, len(samples))
+		for _, s := range samples {
+			vs = append(vs, s.v)
+		}
+		sorted := make([]float64, len(vs))
+		copy(sorted, vs)
+		sort.Float64s(sorted)
+		for _, f := range p.funcs {
+			e.Values[vn+"_"+f.name] = f.fn(vs, sorted)
+		}
+	}
+	return e
+}
+
+// prune removes the samples received before start
+func (g *group) prune(start time.Time) {
+	for vn, samples := range g.samples {
+		i := 0
+		for i < len(samples) && !samples[i].t.After(start) {
+			i++
+		}
+		if i == len(samples) {
+			delete(g.samples, vn)
+			continue
+		}
+		g.samples[vn] = samples[i:]
+	}
+}
+
+func newAggFunc(name string) (*aggFunc, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "min":
+		return &aggFunc{name: name, fn: func(_, sorted []float64) interface{} { return sorted[0] }}, nil
+	case "max":
+		return &aggFunc{name: name, fn: func(_, sorted []float64) interface{} { return sorted[len(sorted)-1] }}, nil
+	case "sum":
+		return &aggFunc{name: name, fn: func(vs, _ []float64) interface{} { return sum(vs) }}, nil
+	case "mean", "avg":
+		return &aggFunc{name: name, fn: func(vs, _ []float64) interface{} { return sum(vs) / float64(len(vs)) }}, nil
+	case "count":
+		return &aggFunc{name: name, fn: func(vs, _ []float64) interface{} { return int64(len(vs)) }}, nil
+	case "last":
+		return &aggFunc{name: name, fn: func(vs, _ []float64) interface{} { return vs[len(vs)-1] }}, nil
+	}
+	if strings.HasPrefix(name, "p") {
+		pct, err := strconv.ParseFloat(name[1:], 64)
+		if err == nil && pct > 0 && pct <= 100 {
+			return &aggFunc{name: name, fn: func(_, sorted []float64) interface{} { return percentile(sorted, pct) }}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown aggregation function %q", name)
+}
+
+func sum(vs []float64) float64 {
+	var s float64
+	for _, v := range vs {
+		s += v
+	}
+	return s
+}
+
+// percentile returns the nearest-rank percentile of the sorted values
+func percentile(sorted []float64, pct float64) float64 {
+	rank := int(math.Ceil(pct / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,248 @@
+package event_aggregate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	_ "github.com/karimra/gnmic/formatters/event_add_tag"
+)
+
+type item struct {
+	// time elapsed since the start of the first window
+	elapsed time.Duration
+	input   []*formatters.EventMsg
+	output  []*formatters.EventMsg
+}
+
+// startTime is aligned on a minute boundary
+var startTime = time.Unix(1599999960, 0)
+
+func powerEvent(intf string, values map[string]interface{}) *formatters.EventMsg {
+	return &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 1,
+		Tags:      map[string]string{"source": "router1", "interface_name": intf, "channel_index": "1"},
+		Values:    values,
+	}
+}
+
+func cpuEvent(v interface{}) *formatters.EventMsg {
+	return &formatters.EventMsg{Name: "sub1", Tags: map[string]string{"source": "r1"}, Values: map[string]interface{}{"cpu": v}}
+}
+
+func cpuMaxEvent(end time.Duration, v float64) *formatters.EventMsg {
+	return &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: startTime.Add(end).UnixNano(),
+		Tags:      map[string]string{"source": "r1"},
+		Values:    map[string]interface{}{"cpu_max": v},
+	}
+}
+
+var testset = map[string]struct {
+	processorType string
+	processor     map[string]interface{}
+	tests         []item
+}{
+	"tumbling_window": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"value-names": []string{"power$"},
+			"group-by":    []string{"source", "interface_name"},
+			"window":      "1m",
+			"functions":   []string{"min", "max", "mean", "sum", "count", "last", "p50"},
+		},
+		tests: []item{
+			// the aggregated values are removed from the events
+			{
+				input:  []*formatters.EventMsg{powerEvent("eth1", map[string]interface{}{"power": float64(-2), "oper-state": "up"})},
+				output: []*formatters.EventMsg{powerEvent("eth1", map[string]interface{}{"oper-state": "up"})},
+			},
+			{
+				elapsed: 10 * time.Second,
+				input:   []*formatters.EventMsg{powerEvent("eth1", map[string]interface{}{"power": "-4.5", "oper-state": "up"})},
+				output:  []*formatters.EventMsg{powerEvent("eth1", map[string]interface{}{"oper-state": "up"})},
+			},
+			{
+				elapsed: 20 * time.Second,
+				input:   []*formatters.EventMsg{powerEvent("eth1", map[string]interface{}{"power": int64(-3), "oper-state": "up"})},
+				output:  []*formatters.EventMsg{powerEvent("eth1", map[string]interface{}{"oper-state": "up"})},
+			},
+			{
+				elapsed: 30 * time.Second,
+				input:   []*formatters.EventMsg{powerEvent("eth1", map[string]interface{}{"power": float64(-1.5), "oper-state": "up"})},
+				output:  []*formatters.EventMsg{powerEvent("eth1", map[string]interface{}{"oper-state": "up"})},
+			},
+			// an event left without values is dropped
+			{
+				elapsed: 40 * time.Second,
+				input:   []*formatters.EventMsg{powerEvent("eth2", map[string]interface{}{"power": 1})},
+				output:  []*formatters.EventMsg{},
+			},
+			// window close
+			{
+				elapsed: 70 * time.Second,
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: startTime.Add(time.Minute).UnixNano(),
+						Tags:      map[string]string{"source": "router1", "interface_name": "eth1"},
+						Values: map[string]interface{}{
+							"power_min":   float64(-4.5),
+							"power_max":   float64(-1.5),
+							"power_mean":  float64(-2.75),
+							"power_sum":   float64(-11),
+							"power_count": int64(4),
+							"power_last":  float64(-1.5),
+							"power_p50":   float64(-3),
+						},
+					},
+					{
+						Name:      "sub1",
+						Timestamp: startTime.Add(time.Minute).UnixNano(),
+						Tags:      map[string]string{"source": "router1", "interface_name": "eth2"},
+						Values: map[string]interface{}{
+							"power_min":   float64(1),
+							"power_max":   float64(1),
+							"power_mean":  float64(1),
+							"power_sum":   float64(1),
+							"power_count": int64(1),
+							"power_last":  float64(1),
+							"power_p50":   float64(1),
+						},
+					},
+				},
+			},
+			// the window state is reset
+			{
+				elapsed: 130 * time.Second,
+				output:  []*formatters.EventMsg{},
+			},
+		},
+	},
+	// each emission is the max over the last 30s,
+	// the value received when the window closes is part of it
+	"sliding_window": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"value-names": []string{"cpu"},
+			"type":        "sliding",
+			"window":      "30s",
+			"step":        "10s",
+			"functions":   []string{"max"},
+		},
+		tests: []item{
+			{
+				input:  []*formatters.EventMsg{cpuEvent(50)},
+				output: []*formatters.EventMsg{},
+			},
+			{
+				elapsed: 10 * time.Second,
+				input:   []*formatters.EventMsg{cpuEvent(90)},
+				output:  []*formatters.EventMsg{cpuMaxEvent(10*time.Second, 90)},
+			},
+			{
+				elapsed: 20 * time.Second,
+				input:   []*formatters.EventMsg{cpuEvent(10)},
+				output:  []*formatters.EventMsg{cpuMaxEvent(20*time.Second, 90)},
+			},
+			{
+				elapsed: 30 * time.Second,
+				input:   []*formatters.EventMsg{cpuEvent(20)},
+				output:  []*formatters.EventMsg{cpuMaxEvent(30*time.Second, 90)},
+			},
+			{
+				elapsed: 40 * time.Second,
+				input:   []*formatters.EventMsg{cpuEvent(30)},
+				output:  []*formatters.EventMsg{cpuMaxEvent(40*time.Second, 30)},
+			},
+			{
+				elapsed: 50 * time.Second,
+				input:   []*formatters.EventMsg{cpuEvent(40)},
+				output:  []*formatters.EventMsg{cpuMaxEvent(50*time.Second, 40)},
+			},
+		},
+	},
+}
+
+func TestEventAggregate(t *testing.T) {
+	for name, ts := range testset {
+		if pi, ok := formatters.EventProcessors[ts.processorType]; ok {
+			p := pi().(*Aggregate)
+			err := p.Init(ts.processor)
+			if err != nil {
+				t.Errorf("failed to initialize processors: %v", err)
+				return
+			}
+			t.Logf("processor: %+v", p)
+			// the first window starts at startTime
+			p.nextFlush = startTime.Add(p.Step)
+			t.Run(name, func(t *testing.T) {
+				for i, item := range ts.tests {
+					outs := p.apply(startTime.Add(item.elapsed), item.input...)
+					if !reflect.DeepEqual(outs, item.output) {
+						t.Logf("failed at event aggregate, item %d", i)
+						t.Logf("expected: %+v", item.output)
+						t.Logf("     got: %+v", outs)
+						t.Fail()
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEmitter(t *testing.T) {
+	p := formatters.EventProcessors[processorType]()
+	err := p.Init(map[string]interface{}{
+		"value-names": []string{"cpu"},
+		"window":      "50ms",
+		"functions":   []string{"count"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the event-add-tag processor following the aggregation is applied to the emitted events
+	tagger := formatters.EventProcessors["event-add-tag"]()
+	err = tagger.Init(map[string]interface{}{
+		"value-names": []string{"_count$"},
+		"add":         map[string]string{"aggregated": "true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emitted := make(chan []*formatters.EventMsg, 10)
+	formatters.ConnectEmitters(ctx, []formatters.EventProcessor{p, tagger}, func(es []*formatters.EventMsg) {
+		emitted <- es
+	})
+	p.Apply(&formatters.EventMsg{Name: "sub1", Values: map[string]interface{}{"cpu": 1}})
+	// no new event is received, the window is flushed by the timer
+	select {
+	case es := <-emitted:
+		if len(es) != 1 || es[0].Values["cpu_count"] != int64(1) || es[0].Tags["aggregated"] != "true" {
+			t.Errorf("unexpected emitted events: %+v", es)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the window flush")
+	}
+}
+
+func TestInitErrors(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"no_value_names": {},
+		"bad_type":       {"value-names": []string{"cpu"}, "type": "hopping"},
+		"bad_step":       {"value-names": []string{"cpu"}, "type": "sliding", "window": "10s", "step": "1m"},
+		"bad_function":   {"value-names": []string{"cpu"}, "functions": []string{"median"}},
+		"bad_percentile": {"value-names": []string{"cpu"}, "functions": []string{"p101"}},
+	} {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package event_calc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+const (
+	processorType = "event-calc"
+	loggingPrefix = "[" + processorType + "] "
+)
+
+// Calc evaluates arithmetic expressions over the event values and tags,
+// and adds the results to the event values, named after the expressions.
+type Calc struct {
+	// result value names to expressions
+	Expressions map[string]string `mapstructure:"expressions,omitempty" json:"expressions,omitempty"`
+	// overwrite an existing value with the same name as the expression
+	Overwrite bool `mapstructure:"overwrite,omitempty" json:"overwrite,omitempty"`
+	Debug     bool `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	// expressions sorted by name
+	names       []string
+	expressions map[string]*expression
+	logger      *log.Logger
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Calc{
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Calc) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if len(p.Expressions) == 0 {
+		return errors.New("missing expressions")
+	}
+	p.names = make([]string, 0, len(p.Expressions))
+	p.expressions = make(map[string]*expression, len(p.Expressions))
+	for name, s := range p.Expressions {
+		x, err := compile(s)
+		if err != nil {
+			return fmt.Errorf("expression %q: %v", name, err)
+		}
+		p.names = append(p.names, name)
+		p.expressions[name] = x
+	}
+	sort.Strings(p.names)
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Calc) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		// the results are added once all the expressions are evaluated,
+		// an expression cannot use the result of another one
+		var results map[string]float64
+		for _, name := range p.names {
+			if _, ok := e.Values[name]; ok && !p.Overwrite {
+				continue
+			}
+			x := p.expressions[name]
+			// the expressions sharing no operand with the event are skipped silently
+			if !x.applicable(e) {
+				continue
+			}
+			f, err := x.root.eval(e)
+			if err != nil {
+				CalcNumberOfFailedExpressions.WithLabelValues(name, reason(err)).Inc()
+				p.logger.Printf("failed to evaluate expression %q: %v", name, err)
+				continue
+			}
+			if results == nil {
+				results = make(map[string]float64)
+			}
+			results[name] = f
+		}
+		if len(results) == 0 {
+			continue
+		}
+		if e.Values == nil {
+			e.Values = make(map[string]interface{}, len(results))
+		}
+		for name, f := range results {
+			e.Values[name] = f
+		}
+	}
+	return es
+}
+
+func (p *Calc) WithLogger(l *log.Logger) {
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Calc) WithTargets(tcs map[string]interface{}) {}
+
+// reason returns the metric label of an evaluation error
+func reason(err error) string {
+	switch {
+	case errors.Is(err, errMissingOperand):
+		return "missing_operand"
+	case errors.Is(err, errDivisionByZero):
+		return "division_by_zero"
+	case errors.Is(err, errNotANumber):
+		return "not_a_number"
+	}
+	return "other"
+}
@@ -0,0 +1,17 @@
+package event_calc
+
+import (
+	"github.com/karimra/gnmic/formatters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var CalcNumberOfFailedExpressions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "event_calc",
+	Name:      "number_of_failed_expressions_total",
+	Help:      "Number of gnmic event-calc expressions evaluation failures",
+}, []string{"name", "reason"})
+
+func init() {
+	formatters.RegisterCollectors(CalcNumberOfFailedExpressions)
+}
@@ -0,0 +1,228 @@
+package event_calc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var temperatureDecimal = &gnmi.Decimal64{Digits: 4225, Precision: 2}
+
+type item struct {
+	input  []*formatters.EventMsg
+	output []*formatters.EventMsg
+}
+
+var testset = map[string]struct {
+	processor map[string]interface{}
+	tests     []item
+}{
+	"utilization": {
+		processor: map[string]interface{}{
+			"expressions": map[string]string{
+				"utilization": `values["/interface/in-octets-rate"] * 8 / tags["speed"] * 100`,
+			},
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Tags:   map[string]string{"speed": "1000"},
+						Values: map[string]interface{}{"/interface/in-octets-rate": "25"},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Tags:   map[string]string{"speed": "1000"},
+						Values: map[string]interface{}{"/interface/in-octets-rate": "25", "utilization": float64(20)},
+					},
+				},
+			},
+			// no operand in the event
+			{
+				input: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"/interface/oper-state": "up"},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"/interface/oper-state": "up"},
+					},
+				},
+			},
+		},
+	},
+	"fahrenheit": {
+		processor: map[string]interface{}{
+			"expressions": map[string]string{
+				"temperature_f": `temperature * 9 / 5 + 32`,
+				"temperature":   `-(-temperature) + 0.5`,
+			},
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"temperature": int64(100)},
+					},
+				},
+				// the existing value is not overwritten, the expressions are evaluated on the input values
+				output: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"temperature": int64(100), "temperature_f": float64(212)},
+					},
+				},
+			},
+		},
+	},
+	"decimal64": {
+		processor: map[string]interface{}{
+			"expressions": map[string]string{
+				"temperature_x2": `values["temperature"] * 2`,
+			},
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"temperature": temperatureDecimal},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"temperature": temperatureDecimal, "temperature_x2": float64(84.5)},
+					},
+				},
+			},
+		},
+	},
+	"overwrite": {
+		processor: map[string]interface{}{
+			"expressions": map[string]string{
+				"temperature": `round(values["temperature"] * 10) / 10`,
+			},
+			"overwrite": true,
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"temperature": " 42.26 "},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"temperature": float64(42.3)},
+					},
+				},
+			},
+		},
+	},
+	"functions": {
+		processor: map[string]interface{}{
+			"expressions": map[string]string{
+				"total_power": `sum("lane=[0-9]+/power$")`,
+				"max_power":   `max("power$")`,
+				"avg_power":   `avg(values["lane=1/power"], values["lane=2/power"])`,
+				"spread":      `abs(min("power$") - max("power$"))`,
+				"squared":     `pow(values["lane=1/power"], 2) % 3`,
+			},
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{"lane=1/power": float64(-2), "lane=2/power": "-3"},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Values: map[string]interface{}{
+							"lane=1/power": float64(-2),
+							"lane=2/power": "-3",
+							"total_power":  float64(-5),
+							"max_power":    float64(-2),
+							"avg_power":    float64(-2.5),
+							"spread":       float64(1),
+							"squared":      float64(1),
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestEventCalc(t *testing.T) {
+	for name, ts := range testset {
+		p := formatters.EventProcessors[processorType]()
+		err := p.Init(ts.processor)
+		if err != nil {
+			t.Errorf("failed to initialize processors: %v", err)
+			return
+		}
+		t.Logf("initialized for test %s: %+v", name, p)
+		for i, item := range ts.tests {
+			t.Run(name, func(t *testing.T) {
+				outs := p.Apply(item.input...)
+				if !reflect.DeepEqual(outs, item.output) {
+					t.Errorf("failed at %s item %d, expected %+v, got: %+v", name, i, item.output, outs)
+				}
+			})
+		}
+	}
+}
+
+func TestEventCalcErrors(t *testing.T) {
+	p := formatters.EventProcessors[processorType]()
+	err := p.Init(map[string]interface{}{
+		"expressions": map[string]string{
+			"ratio": `values["in"] / values["out"]`,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for reason, values := range map[string]map[string]interface{}{
+		"division_by_zero": {"in": 1, "out": "0"},
+		"missing_operand":  {"in": 1},
+		"not_a_number":     {"in": 1, "out": "n/a"},
+	} {
+		before := testutil.ToFloat64(CalcNumberOfFailedExpressions.WithLabelValues("ratio", reason))
+		e := &formatters.EventMsg{Values: values}
+		p.Apply(e)
+		if _, ok := e.Values["ratio"]; ok {
+			t.Errorf("%s: unexpected result %v", reason, e.Values["ratio"])
+		}
+		if after := testutil.ToFloat64(CalcNumberOfFailedExpressions.WithLabelValues("ratio", reason)); after != before+1 {
+			t.Errorf("%s: unexpected errors count %f, expected %f", reason, after, before+1)
+		}
+	}
+}
+
+func TestEventCalcInitErrors(t *testing.T) {
+	for name, expr := range map[string]string{
+		"syntax":          `values["in"] *`,
+		"operator":        `values["in"] << 2`,
+		"unknown_map":     `counters["in"]`,
+		"index_not_lit":   `values[in]`,
+		"unknown_func":    `median(in)`,
+		"bad_regex":       `sum("(")`,
+		"regex_and_args":  `sum("in", out)`,
+		"wrong_arg_count": `abs(in, out)`,
+		"string_literal":  `"in" + 1`,
+	} {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(map[string]interface{}{"expressions": map[string]string{"x": expr}}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	p := formatters.EventProcessors[processorType]()
+	if err := p.Init(map[string]interface{}{}); err == nil {
+		t.Error("no_expressions: expected an error")
+	}
+}
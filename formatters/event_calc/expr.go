@@ -0,0 +1,349 @@
+package event_calc
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+var (
+	errMissingOperand = errors.New("missing operand")
+	errDivisionByZero = errors.New("division by zero")
+	errNotANumber     = errors.New("operand is not a number")
+)
+
+// node is a compiled expression node
+type node interface {
+	eval(e *formatters.EventMsg) (float64, error)
+}
+
+// expression is a compiled arithmetic expression
+type expression struct {
+	root node
+	// operands referenced by the expression
+	values  []string
+	tags    []string
+	regexes []*regexp.Regexp
+}
+
+// applicable returns true if the event carries at least one of the expression operands.
+// an expression without operands is applicable to all the events
+func (x *expression) applicable(e *formatters.EventMsg) bool {
+	if len(x.values) == 0 && len(x.tags) == 0 && len(x.regexes) == 0 {
+		return true
+	}
+	for _, v := range x.values {
+		if _, ok := e.Values[v]; ok {
+			return true
+		}
+	}
+	for _, t := range x.tags {
+		if _, ok := e.Tags[t]; ok {
+			return true
+		}
+	}
+	for _, re := range x.regexes {
+		for k := range e.Values {
+			if re.MatchString(k) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func compile(s string) (*expression, error) {
+	ex, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	x := new(expression)
+	x.root, err = x.compileNode(ex)
+	if err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *expression) compileNode(ex ast.Expr) (node, error) {
+	switch ex := ex.(type) {
+	case *ast.BasicLit:
+		switch ex.Kind {
+		case token.INT, token.FLOAT:
+			f, err := strconv.ParseFloat(ex.Value, 64)
+			if err != nil {
+				return nil, err
+			}
+			return constant(f), nil
+		}
+		return nil, fmt.Errorf("unexpected literal %s", ex.Value)
+	case *ast.Ident:
+		// a bare identifier is a value name
+		x.values = append(x.values, ex.Name)
+		return valueRef(ex.Name), nil
+	case *ast.ParenExpr:
+		return x.compileNode(ex.X)
+	case *ast.UnaryExpr:
+		n, err := x.compileNode(ex.X)
+		if err != nil {
+			return nil, err
+		}
+		switch ex.Op {
+		case token.ADD:
+			return n, nil
+		case token.SUB:
+			return &unary{x: n}, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s", ex.Op)
+	case *ast.BinaryExpr:
+		switch ex.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO, token.REM:
+		default:
+			return nil, fmt.Errorf("unsupported operator %s", ex.Op)
+		}
+		l, err := x.compileNode(ex.X)
+		if err != nil {
+			return nil, err
+		}
+		r, err := x.compileNode(ex.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &binary{op: ex.Op, x: l, y: r}, nil
+	case *ast.IndexExpr:
+		id, ok := ex.X.(*ast.Ident)
+		if !ok {
+			return nil, errors.New("unexpected index expression, expected values[\"name\"] or tags[\"name\"]")
+		}
+		name, err := stringLit(ex.Index)
+		if err != nil {
+			return nil, err
+		}
+		switch id.Name {
+		case "values":
+			x.values = append(x.values, name)
+			return valueRef(name), nil
+		case "tags":
+			x.tags = append(x.tags, name)
+			return tagRef(name), nil
+		}
+		return nil, fmt.Errorf("unknown map %q, expected values or tags", id.Name)
+	case *ast.CallExpr:
+		return x.compileCall(ex)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", ex)
+}
+
+func (x *expression) compileCall(ex *ast.CallExpr) (node, error) {
+	id, ok := ex.Fun.(*ast.Ident)
+	if !ok {
+		return nil, errors.New("unexpected function call")
+	}
+	name := id.Name
+	c := &call{name: name}
+	switch name {
+	case "abs", "ceil", "floor", "round", "sqrt", "log10":
+		if len(ex.Args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument", name)
+		}
+	case "pow":
+		if len(ex.Args) != 2 {
+			return nil, fmt.Errorf("%s expects 2 arguments", name)
+		}
+	case "sum", "min", "max", "avg":
+		if len(ex.Args) == 0 {
+			return nil, fmt.Errorf("%s expects at least 1 argument", name)
+		}
+		// a single string argument is a regex matched against the value names
+		if lit, ok := ex.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			if len(ex.Args) != 1 {
+				return nil, fmt.Errorf("%s expects a single regex argument", name)
+			}
+			s, err := stringLit(lit)
+			if err != nil {
+				return nil, err
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, err
+			}
+			x.regexes = append(x.regexes, re)
+			c.re = re
+			return c, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	for _, arg := range ex.Args {
+		n, err := x.compileNode(arg)
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, n)
+	}
+	return c, nil
+}
+
+func stringLit(ex ast.Expr) (string, error) {
+	lit, ok := ex.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", errors.New("expected a string literal")
+	}
+	return strconv.Unquote(lit.Value)
+}
+
+type constant float64
+
+func (c constant) eval(*formatters.EventMsg) (float64, error) { return float64(c), nil }
+
+type valueRef string
+
+func (v valueRef) eval(e *formatters.EventMsg) (float64, error) {
+	val, ok := e.Values[string(v)]
+	if !ok {
+		return 0, fmt.Errorf("%w: value %q", errMissingOperand, string(v))
+	}
+	f, ok := formatters.ToFloat(val)
+	if !ok {
+		return 0, fmt.Errorf("%w: value %q=%v", errNotANumber, string(v), val)
+	}
+	return f, nil
+}
+
+type tagRef string
+
+func (t tagRef) eval(e *formatters.EventMsg) (float64, error) {
+	val, ok := e.Tags[string(t)]
+	if !ok {
+		return 0, fmt.Errorf("%w: tag %q", errMissingOperand, string(t))
+	}
+	f, ok := formatters.ToFloat(val)
+	if !ok {
+		return 0, fmt.Errorf("%w: tag %q=%v", errNotANumber, string(t), val)
+	}
+	return f, nil
+}
+
+type unary struct {
+	x node
+}
+
+func (u *unary) eval(e *formatters.EventMsg) (float64, error) {
+	f, err := u.x.eval(e)
+	return -f, err
+}
+
+type binary struct {
+	op   token.Token
+	x, y node
+}
+
+func (b *binary) eval(e *formatters.EventMsg) (float64, error) {
+	l, err := b.x.eval(e)
+	if err != nil {
+		return 0, err
+	}
+	r, err := b.y.eval(e)
+	if err != nil {
+		return 0, err
+	}
+	switch b.op {
+	case token.ADD:
+		return l + r, nil
+	case token.SUB:
+		return l - r, nil
+	case token.MUL:
+		return l * r, nil
+	case token.QUO:
+		if r == 0 {
+			return 0, errDivisionByZero
+		}
+		return l / r, nil
+	case token.REM:
+		if r == 0 {
+			return 0, errDivisionByZero
+		}
+		return math.Mod(l, r), nil
+	}
+	return 0, fmt.Errorf("unsupported operator %s", b.op)
+}
+
+type call struct {
+	name string
+	args []node
+	// values names regex, for the aggregation functions
+	re *regexp.Regexp
+}
+
+func (c *call) eval(e *formatters.EventMsg) (float64, error) {
+	var args []float64
+	if c.re != nil {
+		for k, v := range e.Values {
+			if !c.re.MatchString(k) {
+				continue
+			}
+			f, ok := formatters.ToFloat(v)
+			if !ok {
+				return 0, fmt.Errorf("%w: value %q=%v", errNotANumber, k, v)
+			}
+			args = append(args, f)
+		}
+		if len(args) == 0 {
+			return 0, fmt.Errorf("%w: no value matching %q", errMissingOperand, c.re.String())
+		}
+	} else {
+		args = make([]float64, 0, len(c.args))
+		for _, a := range c.args {
+			f, err := a.eval(e)
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, f)
+		}
+	}
+	switch c.name {
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "ceil":
+		return math.Ceil(args[0]), nil
+	case "floor":
+		return math.Floor(args[0]), nil
+	case "round":
+		return math.Round(args[0]), nil
+	case "sqrt":
+		return math.Sqrt(args[0]), nil
+	case "log10":
+		return math.Log10(args[0]), nil
+	case "pow":
+		return math.Pow(args[0], args[1]), nil
+	case "sum", "avg":
+		var s float64
+		for _, a := range args {
+			s += a
+		}
+		if c.name == "avg" {
+			return s / float64(len(args)), nil
+		}
+		return s, nil
+	case "min":
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Min(m, a)
+		}
+		return m, nil
+	case "max":
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Max(m, a)
+		}
+		return m, nil
+	}
+	return 0, fmt.Errorf("unknown function %q", c.name)
+}
@@ -0,0 +1,202 @@
+package event_dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+const (
+	processorType = "event-dedup"
+	loggingPrefix = "[" + processorType + "] "
+
+	defaultTTL = time.Hour
+	// below the prometheus output default expiration
+	defaultMaxAge = 30 * time.Second
+)
+
+// Dedup removes the values that did not change since they were last seen,
+// per event name, tags and value name.
+// An unchanged value is still emitted every RefreshCount occurrences or after MaxAge,
+// MaxAge defaults to 30s if neither is set.
+type Dedup struct {
+	// list of regexes matched against the value names, all the values if empty
+	ValueNames []string `mapstructure:"value-names,omitempty" json:"value-names,omitempty"`
+	// an unchanged value is emitted every refresh-count occurrences
+	RefreshCount int `mapstructure:"refresh-count,omitempty" json:"refresh-count,omitempty"`
+	// an unchanged value is emitted if it was last emitted more than max-age ago
+	MaxAge time.Duration `mapstructure:"max-age,omitempty" json:"max-age,omitempty"`
+	// duration after which the state of a value that was not received is discarded
+	TTL   time.Duration `mapstructure:"ttl,omitempty" json:"ttl,omitempty"`
+	Debug bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	valueNames []*regexp.Regexp
+
+	m         *sync.Mutex
+	last      map[string]*lastValue
+	lastPurge time.Time
+	logger    *log.Logger
+}
+
+type lastValue struct {
+	value interface{}
+	// number of occurrences since the value was last emitted
+	suppressed int
+	emitted    time.Time
+	seen       time.Time
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Dedup{
+			m:      new(sync.Mutex),
+			last:   make(map[string]*lastValue),
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (d *Dedup) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, d)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.valueNames = make([]*regexp.Regexp, 0, len(d.ValueNames))
+	for _, reg := range d.ValueNames {
+		re, err := regexp.Compile(reg)
+		if err != nil {
+			return err
+		}
+		d.valueNames = append(d.valueNames, re)
+	}
+	if d.RefreshCount < 0 {
+		return fmt.Errorf("invalid refresh-count %d", d.RefreshCount)
+	}
+	if d.MaxAge < 0 {
+		return fmt.Errorf("invalid max-age %s", d.MaxAge)
+	}
+	// an unchanged value is never suppressed forever
+	if d.RefreshCount == 0 && d.MaxAge == 0 {
+		d.MaxAge = defaultMaxAge
+	}
+	if d.TTL <= 0 {
+		d.TTL = defaultTTL
+	}
+	if d.MaxAge > d.TTL {
+		d.TTL = d.MaxAge
+	}
+	d.lastPurge = time.Now()
+	if d.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(d)
+		if err != nil {
+			d.logger.Printf("initialized processor '%s': %+v", processorType, d)
+			return nil
+		}
+		d.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (d *Dedup) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	return d.apply(time.Now(), es...)
+}
+
+// apply removes the duplicate values of the events received at time now
+func (d *Dedup) apply(now time.Time, es ...*formatters.EventMsg) []*formatters.EventMsg {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.purge(now)
+	result := make([]*formatters.EventMsg, 0, len(es))
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		if len(e.Values) == 0 {
+			result = append(result, e)
+			continue
+		}
+		var tagsKey string
+		for k, v := range e.Values {
+			if !d.match(k) {
+				continue
+			}
+			if tagsKey == "" {
+				tagsKey = formatters.SeriesKey(e.Name, e.Tags)
+			}
+			if d.duplicate(tagsKey+k, v, now) {
+				d.logger.Printf("value %q=%v unchanged, removed", k, v)
+				delete(e.Values, k)
+			}
+		}
+		// the events left without values are dropped
+		if len(e.Values) == 0 && len(e.Deletes) == 0 {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func (d *Dedup) WithLogger(l *log.Logger) {
+	if d.Debug && l != nil {
+		d.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if d.Debug {
+		d.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (d *Dedup) WithTargets(tcs map[string]interface{}) {}
+
+func (d *Dedup) match(k string) bool {
+	if len(d.valueNames) == 0 {
+		return true
+	}
+	for _, re := range d.valueNames {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicate records the value v and returns true if it should be removed
+func (d *Dedup) duplicate(key string, v interface{}, now time.Time) bool {
+	lv, ok := d.last[key]
+	if !ok || now.Sub(lv.seen) > d.TTL || !reflect.DeepEqual(lv.value, v) {
+		d.last[key] = &lastValue{value: v, emitted: now, seen: now}
+		return false
+	}
+	lv.seen = now
+	lv.suppressed++
+	if (d.RefreshCount > 0 && lv.suppressed >= d.RefreshCount) ||
+		(d.MaxAge > 0 && now.Sub(lv.emitted) >= d.MaxAge) {
+		lv.suppressed = 0
+		lv.emitted = now
+		return false
+	}
+	return true
+}
+
+// purge deletes the values not received within the TTL
+func (d *Dedup) purge(now time.Time) {
+	if now.Sub(d.lastPurge) < d.TTL {
+		return
+	}
+	d.lastPurge = now
+	for k, lv := range d.last {
+		if now.Sub(lv.seen) > d.TTL {
+			delete(d.last, k)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package event_dedup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+type item struct {
+	// time elapsed since the first item
+	elapsed time.Duration
+	input   []*formatters.EventMsg
+	output  []*formatters.EventMsg
+}
+
+func intfEvent(intf string, values map[string]interface{}) *formatters.EventMsg {
+	return &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 1,
+		Tags:      map[string]string{"source": "router1", "interface_name": intf},
+		Values:    values,
+	}
+}
+
+// adminStateItems returns n items with an unchanged admin-state received every 10s,
+// the values of the emitted indexes are kept.
+func adminStateItems(n int, emitted ...int) []item {
+	items := make([]item, 0, n)
+	for i := 0; i < n; i++ {
+		it := item{
+			elapsed: time.Duration(i) * 10 * time.Second,
+			input:   []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable"})},
+			output:  []*formatters.EventMsg{},
+		}
+		for _, j := range emitted {
+			if i == j {
+				it.output = []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable"})}
+			}
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+var testset = map[string]struct {
+	processorType string
+	processor     map[string]interface{}
+	tests         []item
+}{
+	"dedup": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"value-names": []string{"admin-state$", "description$"},
+			"max-age":     "5m",
+		},
+		tests: []item{
+			// first occurrence
+			{
+				input:  []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable", "description": "uplink", "in-octets": uint64(1)})},
+				output: []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable", "description": "uplink", "in-octets": uint64(1)})},
+			},
+			// unchanged values are removed, the values not matching value-names are kept
+			{
+				elapsed: 10 * time.Second,
+				input:   []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable", "description": "uplink", "in-octets": uint64(1)})},
+				output:  []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"in-octets": uint64(1)})},
+			},
+			// the event left without values is dropped
+			{
+				elapsed: 20 * time.Second,
+				input:   []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable"})},
+				output:  []*formatters.EventMsg{},
+			},
+			// changed value
+			{
+				elapsed: 30 * time.Second,
+				input:   []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "disable", "description": "uplink"})},
+				output:  []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "disable"})},
+			},
+			// different tags, different series
+			{
+				elapsed: 40 * time.Second,
+				input:   []*formatters.EventMsg{intfEvent("eth2", map[string]interface{}{"admin-state": "disable"})},
+				output:  []*formatters.EventMsg{intfEvent("eth2", map[string]interface{}{"admin-state": "disable"})},
+			},
+			// same value with a different type
+			{
+				elapsed: 50 * time.Second,
+				input:   []*formatters.EventMsg{intfEvent("eth2", map[string]interface{}{"description": int64(1)})},
+				output:  []*formatters.EventMsg{intfEvent("eth2", map[string]interface{}{"description": int64(1)})},
+			},
+			{
+				elapsed: 60 * time.Second,
+				input:   []*formatters.EventMsg{intfEvent("eth2", map[string]interface{}{"description": "1"})},
+				output:  []*formatters.EventMsg{intfEvent("eth2", map[string]interface{}{"description": "1"})},
+			},
+		},
+	},
+	"refresh_count": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"refresh-count": 3,
+		},
+		tests: adminStateItems(8, 0, 3, 6),
+	},
+	"max_age": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"max-age": "25s",
+		},
+		tests: adminStateItems(7, 0, 3, 6),
+	},
+	"default_max_age": {
+		processorType: processorType,
+		processor:     map[string]interface{}{},
+		tests:         adminStateItems(7, 0, 3, 6),
+	},
+	"ttl": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"refresh-count": 100,
+			"ttl":           "1m",
+		},
+		tests: []item{
+			{
+				input:  []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable"})},
+				output: []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable"})},
+			},
+			{
+				elapsed: 30 * time.Second,
+				input:   []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable"})},
+				output:  []*formatters.EventMsg{},
+			},
+			// the previous value expired
+			{
+				elapsed: 2 * time.Minute,
+				input:   []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable"})},
+				output:  []*formatters.EventMsg{intfEvent("eth1", map[string]interface{}{"admin-state": "enable"})},
+			},
+		},
+	},
+}
+
+func TestEventDedup(t *testing.T) {
+	for name, ts := range testset {
+		if pi, ok := formatters.EventProcessors[ts.processorType]; ok {
+			p := pi().(*Dedup)
+			err := p.Init(ts.processor)
+			if err != nil {
+				t.Errorf("failed to initialize processors: %v", err)
+				return
+			}
+			t.Logf("processor: %+v", p)
+			start := time.Now()
+			t.Run(name, func(t *testing.T) {
+				for i, item := range ts.tests {
+					outs := p.apply(start.Add(item.elapsed), item.input...)
+					if !reflect.DeepEqual(outs, item.output) {
+						t.Logf("failed at event dedup, item %d", i)
+						t.Logf("expected: %+v", item.output)
+						t.Logf("     got: %+v", outs)
+						t.Fail()
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDedupDefaults(t *testing.T) {
+	p := formatters.EventProcessors[processorType]().(*Dedup)
+	err := p.Init(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MaxAge != defaultMaxAge {
+		t.Errorf("unexpected default max-age %s", p.MaxAge)
+	}
+	// refresh by count only
+	p = formatters.EventProcessors[processorType]().(*Dedup)
+	err = p.Init(map[string]interface{}{"refresh-count": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.MaxAge != 0 {
+		t.Errorf("unexpected max-age %s with refresh-count set", p.MaxAge)
+	}
+}
+
+func TestDedupPurge(t *testing.T) {
+	p := formatters.EventProcessors[processorType]().(*Dedup)
+	err := p.Init(map[string]interface{}{"ttl": "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	p.apply(start, intfEvent("eth1", map[string]interface{}{"admin-state": "enable"}))
+	p.apply(start.Add(2*time.Minute), intfEvent("eth2", map[string]interface{}{"admin-state": "enable"}))
+	// the eth1 value was not received within the ttl
+	if n := len(p.last); n != 1 {
+		t.Errorf("unexpected number of values %d, expected 1", n)
+	}
+}
+
+func TestDedupInitErrors(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"bad_regex":         {"value-names": []string{"("}},
+		"bad_refresh_count": {"refresh-count": -1},
+		"bad_max_age":       {"max-age": "-1s"},
+	} {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
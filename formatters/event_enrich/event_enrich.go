@@ -0,0 +1,336 @@
+package event_enrich
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/karimra/gnmic/formatters"
+)
+
+const (
+	processorType = "event-enrich"
+	loggingPrefix = "[" + processorType + "] "
+
+	defaultTTL = time.Hour
+	// the tag identifying the target an event was received from
+	sourceTag = "source"
+)
+
+// Enrich caches the values and tags of the events matching the source selector, keyed by the key-tags values.
+// The cached data is added as tags to the events matching the target selector with the same key-tags values,
+// the cached values are added as tags named after their last path element, unless renamed.
+// The cache is scoped per target, using the `source` tag.
+type Enrich struct {
+	Source *selector `mapstructure:"source,omitempty" json:"source,omitempty"`
+	Target *selector `mapstructure:"target,omitempty" json:"target,omitempty"`
+	// tag names identifying a cache entry, they must be present in both the source and target events
+	KeyTags []string `mapstructure:"key-tags,omitempty" json:"key-tags,omitempty"`
+	// maps the cached value or tag names to the names of the tags added to the target events
+	Rename map[string]string `mapstructure:"rename,omitempty" json:"rename,omitempty"`
+	// overwrite the existing tags of the target events
+	Overwrite bool `mapstructure:"overwrite,omitempty" json:"overwrite,omitempty"`
+	// duration after which a cache entry that was not updated is discarded
+	TTL   time.Duration `mapstructure:"ttl,omitempty" json:"ttl,omitempty"`
+	Debug bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	m         *sync.Mutex
+	cache     map[string]*entry
+	lastPurge time.Time
+	logger    *log.Logger
+}
+
+// selector matches events using a jq condition and/or regexes on values and tags names
+type selector struct {
+	Condition  string   `mapstructure:"condition,omitempty" json:"condition,omitempty"`
+	ValueNames []string `mapstructure:"value-names,omitempty" json:"value-names,omitempty"`
+	TagNames   []string `mapstructure:"tag-names,omitempty" json:"tag-names,omitempty"`
+
+	code       *gojq.Code
+	valueNames []*regexp.Regexp
+	tagNames   []*regexp.Regexp
+}
+
+type entry struct {
+	tags    map[string]string
+	updated time.Time
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Enrich{
+			m:      new(sync.Mutex),
+			cache:  make(map[string]*entry),
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Enrich) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.Source == nil || (len(p.Source.ValueNames) == 0 && len(p.Source.TagNames) == 0) {
+		return errors.New("missing source value-names or tag-names")
+	}
+	if len(p.KeyTags) == 0 {
+		return errors.New("missing key-tags")
+	}
+	err = p.Source.init()
+	if err != nil {
+		return fmt.Errorf("source: %v", err)
+	}
+	if p.Target == nil {
+		p.Target = new(selector)
+	}
+	err = p.Target.init()
+	if err != nil {
+		return fmt.Errorf("target: %v", err)
+	}
+	if p.TTL <= 0 {
+		p.TTL = defaultTTL
+	}
+	p.lastPurge = time.Now()
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Enrich) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	return p.apply(time.Now(), es...)
+}
+
+// apply caches the source events and enriches the target events received at time now
+func (p *Enrich) apply(now time.Time, es ...*formatters.EventMsg) []*formatters.EventMsg {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.purge(now)
+	// the cache is updated first so that the events
+	// received together with their source are enriched
+	for _, e := range es {
+		if e == nil || !p.Source.conditionMatch(e, p.logger) {
+			continue
+		}
+		p.store(e, now)
+	}
+	for _, e := range es {
+		if e == nil || !p.Target.match(e, p.logger) {
+			continue
+		}
+		p.enrich(e, now)
+	}
+	return es
+}
+
+func (p *Enrich) WithLogger(l *log.Logger) {
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Enrich) WithTargets(tcs map[string]interface{}) {}
+
+// store adds the values and tags of the source event e to its cache entry
+func (p *Enrich) store(e *formatters.EventMsg, now time.Time) {
+	data := make(map[string]string)
+	for k, v := range e.Values {
+		if matchAny(p.Source.valueNames, k) {
+			data[p.tagName(k, true)] = fmt.Sprint(v)
+		}
+	}
+	for k, v := range e.Tags {
+		if k == sourceTag || p.isKeyTag(k) {
+			continue
+		}
+		if matchAny(p.Source.tagNames, k) {
+			data[p.tagName(k, false)] = v
+		}
+	}
+	if len(data) == 0 {
+		return
+	}
+	key, ok := p.cacheKey(e)
+	if !ok {
+		p.logger.Printf("source event is missing one of the key-tags %v: %+v", p.KeyTags, e)
+		return
+	}
+	en, ok := p.cache[key]
+	if !ok || now.Sub(en.updated) > p.TTL {
+		en = &entry{tags: make(map[string]string, len(data))}
+		p.cache[key] = en
+	}
+	for k, v := range data {
+		en.tags[k] = v
+	}
+	en.updated = now
+	p.logger.Printf("cache entry %q updated: %v", key, en.tags)
+}
+
+// enrich adds the cached tags to the target event e
+func (p *Enrich) enrich(e *formatters.EventMsg, now time.Time) {
+	key, ok := p.cacheKey(e)
+	if !ok {
+		return
+	}
+	en, ok := p.cache[key]
+	if !ok || now.Sub(en.updated) > p.TTL {
+		return
+	}
+	if e.Tags == nil {
+		e.Tags = make(map[string]string, len(en.tags))
+	}
+	for k, v := range en.tags {
+		if _, ok := e.Tags[k]; ok && !p.Overwrite {
+			continue
+		}
+		e.Tags[k] = v
+	}
+}
+
+// tagName returns the name of the tag carrying the cached value or tag k,
+// the value names default to their last path element, e.g: /interface/description becomes description.
+func (p *Enrich) tagName(k string, isValue bool) string {
+	if n, ok := p.Rename[k]; ok {
+		return n
+	}
+	if isValue {
+		return path.Base(k)
+	}
+	return k
+}
+
+// cacheKey builds the cache key of an event from its source and key-tags values,
+// it returns false if one of the key-tags is missing
+func (p *Enrich) cacheKey(e *formatters.EventMsg) (string, bool) {
+	sb := new(strings.Builder)
+	sb.WriteString(e.Tags[sourceTag])
+	for _, t := range p.KeyTags {
+		v, ok := e.Tags[t]
+		if !ok {
+			return "", false
+		}
+		sb.WriteString("|")
+		sb.WriteString(t)
+		sb.WriteString("=")
+		sb.WriteString(v)
+	}
+	return sb.String(), true
+}
+
+func (p *Enrich) isKeyTag(k string) bool {
+	for _, t := range p.KeyTags {
+		if t == k {
+			return true
+		}
+	}
+	return false
+}
+
+// purge deletes the cache entries not updated within the TTL
+func (p *Enrich) purge(now time.Time) {
+	if now.Sub(p.lastPurge) < p.TTL {
+		return
+	}
+	p.lastPurge = now
+	for k, en := range p.cache {
+		if now.Sub(en.updated) > p.TTL {
+			delete(p.cache, k)
+		}
+	}
+}
+
+func (s *selector) init() error {
+	var err error
+	if s.Condition != "" {
+		s.Condition = strings.TrimSpace(s.Condition)
+		q, err := gojq.Parse(s.Condition)
+		if err != nil {
+			return err
+		}
+		s.code, err = gojq.Compile(q)
+		if err != nil {
+			return err
+		}
+	}
+	s.valueNames, err = compileRegexes(s.ValueNames)
+	if err != nil {
+		return err
+	}
+	s.tagNames, err = compileRegexes(s.TagNames)
+	return err
+}
+
+func (s *selector) conditionMatch(e *formatters.EventMsg, logger *log.Logger) bool {
+	if s.code == nil {
+		return true
+	}
+	ok, err := formatters.CheckCondition(s.code, e)
+	if err != nil {
+		logger.Printf("condition check failed: %v", err)
+	}
+	return ok
+}
+
+// match returns true if the event matches the condition and has a value or a tag matching the regexes.
+// an empty selector matches all the events
+func (s *selector) match(e *formatters.EventMsg, logger *log.Logger) bool {
+	if !s.conditionMatch(e, logger) {
+		return false
+	}
+	if len(s.valueNames) == 0 && len(s.tagNames) == 0 {
+		return true
+	}
+	for k := range e.Values {
+		if matchAny(s.valueNames, k) {
+			return true
+		}
+	}
+	for k := range e.Tags {
+		if matchAny(s.tagNames, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileRegexes(regs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(regs))
+	for _, reg := range regs {
+		re, err := regexp.Compile(reg)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
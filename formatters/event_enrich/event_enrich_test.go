@@ -0,0 +1,287 @@
+package event_enrich
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+type item struct {
+	// time elapsed since the first item
+	elapsed time.Duration
+	input   []*formatters.EventMsg
+	output  []*formatters.EventMsg
+}
+
+// intfEvent returns an interface event, tags is a list of additional tag names and values
+func intfEvent(source, intf string, values map[string]interface{}, tags ...string) *formatters.EventMsg {
+	e := &formatters.EventMsg{
+		Name:   "sub1",
+		Tags:   map[string]string{"source": source, "interface_name": intf},
+		Values: values,
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		e.Tags[tags[i]] = tags[i+1]
+	}
+	return e
+}
+
+var descriptionCfg = map[string]interface{}{
+	"source": map[string]interface{}{
+		"value-names": []string{"/description$"},
+	},
+	"target": map[string]interface{}{
+		"value-names": []string{"/statistics/"},
+	},
+	"key-tags": []string{"interface_name"},
+	"ttl":      "1m",
+}
+
+var testset = map[string]struct {
+	processorType string
+	processor     map[string]interface{}
+	tests         []item
+}{
+	"enrich": {
+		processorType: processorType,
+		processor:     descriptionCfg,
+		tests: []item{
+			// a target event received before its source is not enriched
+			{
+				input:  []*formatters.EventMsg{intfEvent("r1", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 1})},
+				output: []*formatters.EventMsg{intfEvent("r1", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 1})},
+			},
+			// source events are kept unchanged
+			{
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}),
+					intfEvent("r2", "eth1", map[string]interface{}{"/interface/description": "to-r1"}),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}),
+					intfEvent("r2", "eth1", map[string]interface{}{"/interface/description": "to-r1"}),
+				},
+			},
+			// the cache is scoped per source,
+			// the cached values are added as tags named after their last path element
+			{
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 2}),
+					intfEvent("r2", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 3}),
+					intfEvent("r2", "eth2", map[string]interface{}{"/interface/statistics/in-octets": 4}),
+					intfEvent("r2", "eth1", map[string]interface{}{"/interface/oper-state": "up"}),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 2}, "description", "uplink"),
+					intfEvent("r2", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 3}, "description", "to-r1"),
+					intfEvent("r2", "eth2", map[string]interface{}{"/interface/statistics/in-octets": 4}),
+					intfEvent("r2", "eth1", map[string]interface{}{"/interface/oper-state": "up"}),
+				},
+			},
+		},
+	},
+	"same_notification": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"source": map[string]interface{}{
+				"condition":   `.tags.member_of != null`,
+				"value-names": []string{"/description$"},
+				"tag-names":   []string{"^member_of$"},
+			},
+			"key-tags": []string{"interface_name"},
+		},
+		tests: []item{
+			// without a condition, the target selector matches all the events
+			{
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"in-octets": 1}),
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}, "member_of", "lag1"),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"in-octets": 1}, "member_of", "lag1", "description", "uplink"),
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}, "member_of", "lag1", "description", "uplink"),
+				},
+			},
+			// the source condition is not met, the cache is not updated
+			{
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "changed"}),
+					intfEvent("r1", "eth1", map[string]interface{}{"in-octets": 2}),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "changed"}, "member_of", "lag1", "description", "uplink"),
+					intfEvent("r1", "eth1", map[string]interface{}{"in-octets": 2}, "member_of", "lag1", "description", "uplink"),
+				},
+			},
+		},
+	},
+	"rename": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"source": map[string]interface{}{
+				"value-names": []string{"/description$"},
+				"tag-names":   []string{"^member_of$"},
+			},
+			"target": map[string]interface{}{
+				"value-names": []string{"/statistics/"},
+			},
+			"key-tags": []string{"interface_name"},
+			"rename": map[string]string{
+				"/interface/description": "interface_description",
+				"member_of":              "lag",
+			},
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}, "member_of", "lag1"),
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 1}),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}, "member_of", "lag1"),
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 1}, "interface_description", "uplink", "lag", "lag1"),
+				},
+			},
+		},
+	},
+	"no_overwrite": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"source":   map[string]interface{}{"value-names": []string{"description"}},
+			"target":   map[string]interface{}{"value-names": []string{"in-octets"}},
+			"key-tags": []string{"interface_name"},
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"description": "uplink"}),
+					intfEvent("r1", "eth1", map[string]interface{}{"in-octets": 1}, "description", "old"),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"description": "uplink"}),
+					intfEvent("r1", "eth1", map[string]interface{}{"in-octets": 1}, "description", "old"),
+				},
+			},
+		},
+	},
+	"overwrite": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"source":    map[string]interface{}{"value-names": []string{"description"}},
+			"target":    map[string]interface{}{"value-names": []string{"in-octets"}},
+			"key-tags":  []string{"interface_name"},
+			"overwrite": true,
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"description": "uplink"}),
+					intfEvent("r1", "eth1", map[string]interface{}{"in-octets": 1}, "description", "old"),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"description": "uplink"}),
+					intfEvent("r1", "eth1", map[string]interface{}{"in-octets": 1}, "description", "uplink"),
+				},
+			},
+		},
+	},
+	"ttl": {
+		processorType: processorType,
+		processor:     descriptionCfg,
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}),
+					intfEvent("r1", "eth2", map[string]interface{}{"/interface/description": "downlink"}),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}),
+					intfEvent("r1", "eth2", map[string]interface{}{"/interface/description": "downlink"}),
+				},
+			},
+			{
+				elapsed: 30 * time.Second,
+				input:   []*formatters.EventMsg{intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"})},
+				output:  []*formatters.EventMsg{intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"})},
+			},
+			// the eth2 entry expired
+			{
+				elapsed: 75 * time.Second,
+				input: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 1}),
+					intfEvent("r1", "eth2", map[string]interface{}{"/interface/statistics/in-octets": 1}),
+				},
+				output: []*formatters.EventMsg{
+					intfEvent("r1", "eth1", map[string]interface{}{"/interface/statistics/in-octets": 1}, "description", "uplink"),
+					intfEvent("r1", "eth2", map[string]interface{}{"/interface/statistics/in-octets": 1}),
+				},
+			},
+		},
+	},
+}
+
+func TestEventEnrich(t *testing.T) {
+	for name, ts := range testset {
+		if pi, ok := formatters.EventProcessors[ts.processorType]; ok {
+			p := pi().(*Enrich)
+			err := p.Init(ts.processor)
+			if err != nil {
+				t.Errorf("failed to initialize processors: %v", err)
+				return
+			}
+			t.Logf("processor: %+v", p)
+			start := time.Now()
+			t.Run(name, func(t *testing.T) {
+				for i, item := range ts.tests {
+					outs := p.apply(start.Add(item.elapsed), item.input...)
+					if !reflect.DeepEqual(outs, item.output) {
+						t.Logf("failed at event enrich, item %d", i)
+						t.Logf("expected: %+v", item.output)
+						t.Logf("     got: %+v", outs)
+						t.Fail()
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEnrichPurge(t *testing.T) {
+	p := formatters.EventProcessors[processorType]().(*Enrich)
+	err := p.Init(descriptionCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	p.apply(start, intfEvent("r1", "eth1", map[string]interface{}{"/interface/description": "uplink"}))
+	p.apply(start.Add(2*time.Minute), intfEvent("r1", "eth2", map[string]interface{}{"/interface/description": "downlink"}))
+	// the eth1 entry was not updated within the ttl
+	if n := len(p.cache); n != 1 {
+		t.Errorf("unexpected number of cache entries %d, expected 1", n)
+	}
+}
+
+func TestEnrichInitErrors(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"no_source": {"key-tags": []string{"interface_name"}},
+		"no_key_tags": {
+			"source": map[string]interface{}{"value-names": []string{"description"}},
+		},
+		"bad_regex": {
+			"source":   map[string]interface{}{"value-names": []string{"("}},
+			"key-tags": []string{"interface_name"},
+		},
+		"bad_condition": {
+			"source":   map[string]interface{}{"value-names": []string{"description"}},
+			"target":   map[string]interface{}{"condition": ".tags |"},
+			"key-tags": []string{"interface_name"},
+		},
+	} {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
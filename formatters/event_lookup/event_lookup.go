@@ -0,0 +1,378 @@
+package event_lookup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/karimra/gnmic/formatters"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	processorType = "event-lookup"
+	loggingPrefix = "[" + processorType + "] "
+)
+
+// Lookup adds tags to the events from the columns of a lookup table loaded from a CSV, YAML or JSON file.
+// A table row matches an event if the values of its match columns are equal to (or match, if Regex is true)
+// the values of the corresponding event tags.
+// The file is reloaded when it changes.
+type Lookup struct {
+	// path to the table file
+	File string `mapstructure:"file,omitempty" json:"file,omitempty"`
+	// csv, yaml or json, derived from the file extension if not set
+	Format string `mapstructure:"format,omitempty" json:"format,omitempty"`
+	// event tag names to table column names
+	Match map[string]string `mapstructure:"match,omitempty" json:"match,omitempty"`
+	// if true, the table match columns are regular expressions
+	Regex bool `mapstructure:"regex,omitempty" json:"regex,omitempty"`
+	// columns added as tags, all the columns other than the match ones if empty
+	Add []string `mapstructure:"add,omitempty" json:"add,omitempty"`
+	// overwrite the existing event tags
+	Overwrite bool `mapstructure:"overwrite,omitempty" json:"overwrite,omitempty"`
+	Debug     bool `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	// sorted event tag names
+	matchTags []string
+
+	m       *sync.RWMutex
+	table   *table
+	watcher *fsnotify.Watcher
+	logger  *log.Logger
+}
+
+// table is a loaded lookup table
+type table struct {
+	// rows indexed by their match columns values, when Regex is false
+	index map[string]map[string]string
+	// rows in file order, when Regex is true
+	rows []*row
+}
+
+type row struct {
+	match []*regexp.Regexp
+	tags  map[string]string
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Lookup{
+			m:      new(sync.RWMutex),
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Lookup) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.File == "" {
+		return errors.New("missing file")
+	}
+	if len(p.Match) == 0 {
+		return errors.New("missing match")
+	}
+	if p.Format == "" {
+		p.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(p.File)), ".")
+	}
+	switch p.Format {
+	case "csv", "json":
+	case "yaml", "yml":
+		p.Format = "yaml"
+	default:
+		return fmt.Errorf("unsupported lookup file format %q", p.Format)
+	}
+	p.matchTags = make([]string, 0, len(p.Match))
+	for t := range p.Match {
+		p.matchTags = append(p.matchTags, t)
+	}
+	sort.Strings(p.matchTags)
+	err = p.load()
+	if err != nil {
+		return err
+	}
+	err = p.watch()
+	if err != nil {
+		return err
+	}
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Lookup) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		tags, ok := p.lookup(e)
+		if !ok {
+			continue
+		}
+		if e.Tags == nil {
+			e.Tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			if _, ok := e.Tags[k]; ok && !p.Overwrite {
+				continue
+			}
+			e.Tags[k] = v
+		}
+	}
+	return es
+}
+
+func (p *Lookup) WithLogger(l *log.Logger) {
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Lookup) WithTargets(tcs map[string]interface{}) {}
+
+// lookup returns the tags of the first table row matching the event
+func (p *Lookup) lookup(e *formatters.EventMsg) (map[string]string, bool) {
+	values := make([]string, 0, len(p.matchTags))
+	for _, t := range p.matchTags {
+		v, ok := e.Tags[t]
+		if !ok {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	if !p.Regex {
+		tags, ok := p.table.index[indexKey(values)]
+		return tags, ok
+	}
+OUTER:
+	for _, r := range p.table.rows {
+		for i, re := range r.match {
+			if !re.MatchString(values[i]) {
+				continue OUTER
+			}
+		}
+		return r.tags, true
+	}
+	return nil, false
+}
+
+// load reads the table file and replaces the current table,
+// the current table is kept if the file cannot be read
+func (p *Lookup) load() error {
+	b, err := ioutil.ReadFile(p.File)
+	if err != nil {
+		return err
+	}
+	records, err := decodeRecords(b, p.Format)
+	if err != nil {
+		return fmt.Errorf("failed to decode %q: %v", p.File, err)
+	}
+	t, err := p.newTable(records)
+	if err != nil {
+		return fmt.Errorf("failed to load %q: %v", p.File, err)
+	}
+	p.m.Lock()
+	p.table = t
+	p.m.Unlock()
+	p.logger.Printf("loaded %d row(s) from %q", len(records), p.File)
+	return nil
+}
+
+func (p *Lookup) newTable(records []map[string]string) (*table, error) {
+	t := &table{}
+	if p.Regex {
+		t.rows = make([]*row, 0, len(records))
+	} else {
+		t.index = make(map[string]map[string]string, len(records))
+	}
+	for i, rec := range records {
+		values := make([]string, 0, len(p.matchTags))
+		for _, tn := range p.matchTags {
+			v, ok := rec[p.Match[tn]]
+			if !ok {
+				return nil, fmt.Errorf("row %d: missing column %q", i+1, p.Match[tn])
+			}
+			values = append(values, v)
+		}
+		tags := p.rowTags(rec)
+		if !p.Regex {
+			// the first row wins
+			key := indexKey(values)
+			if _, ok := t.index[key]; !ok {
+				t.index[key] = tags
+			}
+			continue
+		}
+		r := &row{match: make([]*regexp.Regexp, 0, len(values)), tags: tags}
+		for _, v := range values {
+			re, err := regexp.Compile("^(?:" + v + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("row %d: %v", i+1, err)
+			}
+			r.match = append(r.match, re)
+		}
+		t.rows = append(t.rows, r)
+	}
+	return t, nil
+}
+
+// rowTags returns the columns of a row to be added as tags
+func (p *Lookup) rowTags(rec map[string]string) map[string]string {
+	tags := make(map[string]string)
+	if len(p.Add) > 0 {
+		for _, c := range p.Add {
+			if v, ok := rec[c]; ok {
+				tags[c] = v
+			}
+		}
+		return tags
+	}
+OUTER:
+	for c, v := range rec {
+		for _, mc := range p.Match {
+			if c == mc {
+				continue OUTER
+			}
+		}
+		tags[c] = v
+	}
+	return tags
+}
+
+// watch reloads the table when the file changes.
+// the file directory is watched, since editors often replace the file instead of writing it
+func (p *Lookup) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	file := filepath.Clean(p.File)
+	err = watcher.Add(filepath.Dir(file))
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	p.m.Lock()
+	p.watcher = watcher
+	p.m.Unlock()
+	// the watcher channels are closed by Close
+	go func() {
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != file || ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				err := p.load()
+				if err != nil {
+					p.logger.Printf("failed to reload lookup table: %v", err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				p.logger.Printf("file watcher error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops watching the lookup file
+func (p *Lookup) Close() error {
+	p.m.Lock()
+	watcher := p.watcher
+	p.watcher = nil
+	p.m.Unlock()
+	// closed without the lock, a reload may be in progress
+	if watcher == nil {
+		return nil
+	}
+	return watcher.Close()
+}
+
+// decodeRecords decodes a list of rows from a CSV file with a header line,
+// or from a YAML or JSON list of objects
+func decodeRecords(b []byte, format string) ([]map[string]string, error) {
+	switch format {
+	case "csv":
+		r := csv.NewReader(bytes.NewReader(b))
+		r.TrimLeadingSpace = true
+		lines, err := r.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 {
+			return nil, errors.New("missing csv header")
+		}
+		header := lines[0]
+		records := make([]map[string]string, 0, len(lines)-1)
+		for _, l := range lines[1:] {
+			rec := make(map[string]string, len(header))
+			for i, c := range header {
+				rec[c] = l[i]
+			}
+			records = append(records, rec)
+		}
+		return records, nil
+	}
+	var rows []map[string]interface{}
+	var err error
+	if format == "json" {
+		// numbers are kept as written in the file
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&rows)
+	} else {
+		err = yaml.Unmarshal(b, &rows)
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := make([]map[string]string, 0, len(rows))
+	for _, r := range rows {
+		rec := make(map[string]string, len(r))
+		for k, v := range r {
+			if v == nil {
+				continue
+			}
+			rec[k] = fmt.Sprint(v)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func indexKey(values []string) string {
+	return strings.Join(values, "\x00")
+}
@@ -0,0 +1,236 @@
+package event_lookup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+const csvTable = `target,interface,site,region,circuit-id
+router1,ethernet-1/1,par1,eu,C100
+router1,ethernet-1/2,par1,eu,C101
+router2,ethernet-1/1,nyc1,us,C200
+`
+
+const yamlTable = `
+- target: router1
+  interface: ethernet-1/1
+  site: par1
+  region: eu
+  circuit-id: 100
+- target: router2
+  interface: ethernet-1/1
+  site: nyc1
+  region: us
+  circuit-id: 200
+`
+
+const jsonTable = `[
+  {"target": "router1", "interface": "ethernet-1/1", "site": "par1", "region": "eu", "circuit-id": 100},
+  {"target": "router2", "interface": "ethernet-1/1", "site": "nyc1", "region": "us", "circuit-id": 200}
+]`
+
+const regexTable = `target,site,region
+router1|router2,par1,eu
+router.*,nyc1,us
+`
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	err := ioutil.WriteFile(file, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func intfEvent(source, intf string) *formatters.EventMsg {
+	return &formatters.EventMsg{
+		Name:   "sub1",
+		Tags:   map[string]string{"source": source, "interface_name": intf},
+		Values: map[string]interface{}{"in-octets": 1},
+	}
+}
+
+var intfMatch = map[string]string{"source": "target", "interface_name": "interface"}
+
+func TestLookupFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range []string{
+		writeFile(t, dir, "table.csv", csvTable),
+		writeFile(t, dir, "table.yaml", yamlTable),
+		writeFile(t, dir, "table.json", jsonTable),
+	} {
+		p := formatters.EventProcessors[processorType]()
+		err := p.Init(map[string]interface{}{
+			"file":  file,
+			"match": intfMatch,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		outs := p.Apply(intfEvent("router2", "ethernet-1/1"), intfEvent("router2", "ethernet-1/2"))
+		want := map[string]string{"source": "router2", "interface_name": "ethernet-1/1", "site": "nyc1", "region": "us", "circuit-id": "C200"}
+		if filepath.Ext(file) != ".csv" {
+			want["circuit-id"] = "200"
+		}
+		if !reflect.DeepEqual(outs[0].Tags, want) {
+			t.Errorf("%s: unexpected tags: %v", file, outs[0].Tags)
+		}
+		// no matching row
+		if len(outs[1].Tags) != 2 {
+			t.Errorf("%s: unexpected tags: %v", file, outs[1].Tags)
+		}
+	}
+}
+
+func TestLookupAddOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "table.csv", csvTable)
+	for _, overwrite := range []bool{false, true} {
+		p := formatters.EventProcessors[processorType]()
+		err := p.Init(map[string]interface{}{
+			"file":      file,
+			"match":     intfMatch,
+			"add":       []string{"site", "circuit-id"},
+			"overwrite": overwrite,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := intfEvent("router1", "ethernet-1/2")
+		e.Tags["site"] = "unknown"
+		p.Apply(e)
+		want := map[string]string{"source": "router1", "interface_name": "ethernet-1/2", "site": "unknown", "circuit-id": "C101"}
+		if overwrite {
+			want["site"] = "par1"
+		}
+		if !reflect.DeepEqual(e.Tags, want) {
+			t.Errorf("overwrite=%v: unexpected tags: %v", overwrite, e.Tags)
+		}
+	}
+}
+
+func TestLookupRegex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := formatters.EventProcessors[processorType]()
+	err = p.Init(map[string]interface{}{
+		"file":  writeFile(t, dir, "table.csv", regexTable),
+		"match": map[string]string{"source": "target"},
+		"regex": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first matching row wins, the regexes are anchored
+	for source, site := range map[string]string{"router1": "par1", "router3": "nyc1", "core-router1": ""} {
+		e := intfEvent(source, "ethernet-1/1")
+		p.Apply(e)
+		if e.Tags["site"] != site {
+			t.Errorf("%s: unexpected tags: %v", source, e.Tags)
+		}
+	}
+}
+
+func TestLookupReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "table.csv", csvTable)
+	p := formatters.EventProcessors[processorType]()
+	err = p.Init(map[string]interface{}{
+		"file":  file,
+		"match": intfMatch,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.(*Lookup).Close()
+	writeFile(t, dir, "table.csv", "target,interface,site\nrouter1,ethernet-1/1,lon1\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e := intfEvent("router1", "ethernet-1/1")
+		p.Apply(e)
+		if e.Tags["site"] == "lon1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the table was not reloaded: %v", e.Tags)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLookupClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "table.csv", csvTable)
+	p := formatters.EventProcessors[processorType]().(*Lookup)
+	err = p.Init(map[string]interface{}{
+		"file":  file,
+		"match": intfMatch,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatters.CloseEventProcessors([]formatters.EventProcessor{p})
+	if p.watcher != nil {
+		t.Fatal("expected the file watcher to be stopped")
+	}
+	// closing twice is a no-op
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the file is no longer reloaded
+	writeFile(t, dir, "table.csv", "target,interface,site\nrouter1,ethernet-1/1,lon1\n")
+	time.Sleep(200 * time.Millisecond)
+	e := intfEvent("router1", "ethernet-1/1")
+	p.Apply(e)
+	if e.Tags["site"] == "lon1" {
+		t.Errorf("the table was reloaded after the processor was closed")
+	}
+}
+
+func TestLookupInitErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "table.csv", csvTable)
+	for name, cfg := range map[string]map[string]interface{}{
+		"no_file":        {"match": intfMatch},
+		"no_match":       {"file": file},
+		"missing_file":   {"file": filepath.Join(dir, "missing.csv"), "match": intfMatch},
+		"bad_format":     {"file": file, "format": "xml", "match": intfMatch},
+		"missing_column": {"file": file, "match": map[string]string{"source": "name"}},
+		"bad_regex":      {"file": writeFile(t, dir, "bad.csv", "target\n(\n"), "match": map[string]string{"source": "target"}, "regex": true},
+	} {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
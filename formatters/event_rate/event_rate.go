@@ -0,0 +1,283 @@
+package event_rate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+const (
+	processorType = "event-rate"
+	loggingPrefix = "[" + processorType + "] "
+
+	defaultTTL         = 10 * time.Minute
+	defaultCounterBits = 64
+)
+
+// Rate replaces the counters with a value name matching one of the regexes,
+// with their per second rate of change.
+// The previous value and timestamp of each counter is kept per event name, tags and value name.
+type Rate struct {
+	ValueNames []string `mapstructure:"value-names,omitempty" json:"value-names,omitempty"`
+	// if set, the rate is added as a new value named after the counter followed by the suffix,
+	// otherwise the counter value is replaced
+	Suffix string `mapstructure:"suffix,omitempty" json:"suffix,omitempty"`
+	// counters size in bits, 32 or 64, used to detect counter wraps
+	CounterBits int `mapstructure:"counter-bits,omitempty" json:"counter-bits,omitempty"`
+	// duration after which the state of a counter that was not updated is discarded
+	TTL   time.Duration `mapstructure:"ttl,omitempty" json:"ttl,omitempty"`
+	Debug bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	valueNames []*regexp.Regexp
+	maxCounter uint64
+
+	m         *sync.Mutex
+	samples   map[string]*sample
+	lastPurge time.Time
+	logger    *log.Logger
+}
+
+// sample is the last value and timestamp of a counter
+type sample struct {
+	ts int64
+	// the value is kept as an uint64 if possible to avoid losing precision on large counters
+	u        uint64
+	f        float64
+	isUint   bool
+	lastSeen time.Time
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Rate{
+			m:       new(sync.Mutex),
+			samples: make(map[string]*sample),
+			logger:  log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (r *Rate) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, r)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if len(r.ValueNames) == 0 {
+		return errors.New("missing value-names")
+	}
+	r.valueNames = make([]*regexp.Regexp, 0, len(r.ValueNames))
+	for _, reg := range r.ValueNames {
+		re, err := regexp.Compile(reg)
+		if err != nil {
+			return err
+		}
+		r.valueNames = append(r.valueNames, re)
+	}
+	switch r.CounterBits {
+	case 0:
+		r.CounterBits = defaultCounterBits
+		r.maxCounter = math.MaxUint64
+	case 64:
+		r.maxCounter = math.MaxUint64
+	case 32:
+		r.maxCounter = math.MaxUint32
+	default:
+		return fmt.Errorf("unsupported counter-bits %d, must be 32 or 64", r.CounterBits)
+	}
+	if r.TTL <= 0 {
+		r.TTL = defaultTTL
+	}
+	r.lastPurge = time.Now()
+	if r.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(r)
+		if err != nil {
+			r.logger.Printf("initialized processor '%s': %+v", processorType, r)
+			return nil
+		}
+		r.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (r *Rate) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	now := time.Now()
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.purge(now)
+	result := make([]*formatters.EventMsg, 0, len(es))
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		if len(e.Values) == 0 {
+			result = append(result, e)
+			continue
+		}
+		var tagsKey string
+		rates := make(map[string]float64)
+		for k, v := range e.Values {
+			if !r.match(k) {
+				continue
+			}
+			if tagsKey == "" {
+				tagsKey = formatters.SeriesKey(e.Name, e.Tags)
+			}
+			rate, ok := r.rate(tagsKey+k, e.Timestamp, v, now)
+			if r.Suffix == "" {
+				delete(e.Values, k)
+			}
+			if !ok {
+				continue
+			}
+			r.logger.Printf("value %q rate: %f/s", k, rate)
+			rates[k+r.Suffix] = rate
+		}
+		for k, rate := range rates {
+			e.Values[k] = rate
+		}
+		// the events left without values are dropped
+		if len(e.Values) == 0 && len(e.Deletes) == 0 {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func (r *Rate) WithLogger(l *log.Logger) {
+	if r.Debug && l != nil {
+		r.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if r.Debug {
+		r.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (r *Rate) WithTargets(tcs map[string]interface{}) {}
+
+func (r *Rate) match(k string) bool {
+	for _, re := range r.valueNames {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// rate stores the counter value v and returns its rate since the previous sample.
+// it returns false if there is no previous sample, or if the counter was reset.
+func (r *Rate) rate(key string, ts int64, v interface{}, now time.Time) (float64, bool) {
+	cur, ok := newSample(v)
+	if !ok {
+		r.logger.Printf("value %v of type %T is not a counter", v, v)
+		return 0, false
+	}
+	cur.ts = ts
+	cur.lastSeen = now
+	prev, ok := r.samples[key]
+	if !ok || now.Sub(prev.lastSeen) > r.TTL {
+		r.samples[key] = cur
+		return 0, false
+	}
+	dt := float64(ts-prev.ts) / float64(time.Second)
+	if dt <= 0 {
+		// duplicate or out of order sample
+		return 0, false
+	}
+	r.samples[key] = cur
+	var delta float64
+	switch {
+	case prev.isUint && cur.isUint:
+		if cur.u >= prev.u {
+			delta = float64(cur.u - prev.u)
+			break
+		}
+		// a decrease is considered a wrap if the previous value
+		// was in the upper quarter of the counter range, otherwise a reset
+		if prev.u <= r.maxCounter && prev.u >= r.maxCounter-r.maxCounter/4 && cur.u <= r.maxCounter/4 {
+			delta = float64(r.maxCounter-prev.u) + float64(cur.u) + 1
+			r.logger.Printf("counter %q wrapped", key)
+			break
+		}
+		r.logger.Printf("counter %q reset", key)
+		return 0, false
+	default:
+		delta = cur.f - prev.f
+		if delta < 0 {
+			r.logger.Printf("counter %q reset", key)
+			return 0, false
+		}
+	}
+	return delta / dt, true
+}
+
+// purge deletes the samples not updated within the TTL
+func (r *Rate) purge(now time.Time) {
+	if now.Sub(r.lastPurge) < r.TTL {
+		return
+	}
+	r.lastPurge = now
+	for k, s := range r.samples {
+		if now.Sub(s.lastSeen) > r.TTL {
+			delete(r.samples, k)
+		}
+	}
+}
+
+func newSample(v interface{}) (*sample, bool) {
+	switch v := v.(type) {
+	case uint:
+		return uintSample(uint64(v)), true
+	case uint8:
+		return uintSample(uint64(v)), true
+	case uint16:
+		return uintSample(uint64(v)), true
+	case uint32:
+		return uintSample(uint64(v)), true
+	case uint64:
+		return uintSample(v), true
+	case int:
+		return intSample(int64(v)), true
+	case int8:
+		return intSample(int64(v)), true
+	case int16:
+		return intSample(int64(v)), true
+	case int32:
+		return intSample(int64(v)), true
+	case int64:
+		return intSample(v), true
+	case string:
+		if u, err := strconv.ParseUint(strings.TrimSpace(v), 10, 64); err == nil {
+			return uintSample(u), true
+		}
+	}
+	f, ok := formatters.ToFloat(v)
+	if !ok {
+		return nil, false
+	}
+	return &sample{f: f}, true
+}
+
+func uintSample(u uint64) *sample {
+	return &sample{u: u, f: float64(u), isUint: true}
+}
+
+func intSample(i int64) *sample {
+	if i < 0 {
+		return &sample{f: float64(i)}
+	}
+	return uintSample(uint64(i))
+}
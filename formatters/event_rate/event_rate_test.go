@@ -0,0 +1,219 @@
+package event_rate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+type item struct {
+	input  []*formatters.EventMsg
+	output []*formatters.EventMsg
+}
+
+const sec = int64(time.Second)
+
+var tags = map[string]string{"interface_name": "ethernet-1/1", "source": "router1"}
+
+func counterEvent(ts int64, values map[string]interface{}) []*formatters.EventMsg {
+	return []*formatters.EventMsg{
+		{
+			Name:      "sub1",
+			Timestamp: ts,
+			Tags:      tags,
+			Values:    values,
+		},
+	}
+}
+
+var testset = map[string]struct {
+	processor map[string]interface{}
+	tests     []item
+}{
+	"decimal": {
+		processor: map[string]interface{}{
+			"value-names": []string{"energy$"},
+		},
+		tests: []item{
+			{
+				input:  counterEvent(10*sec, map[string]interface{}{"energy": &gnmi.Decimal64{Digits: 1050, Precision: 1}}),
+				output: []*formatters.EventMsg{},
+			},
+			{
+				input:  counterEvent(20*sec, map[string]interface{}{"energy": &gnmi.Decimal64{Digits: 2050, Precision: 1}}),
+				output: counterEvent(20*sec, map[string]interface{}{"energy": float64(10)}),
+			},
+		},
+	},
+	"replace": {
+		processor: map[string]interface{}{
+			"value-names": []string{"octets$"},
+		},
+		tests: []item{
+			// first sample, the counter is removed
+			{
+				input:  counterEvent(10*sec, map[string]interface{}{"in-octets": uint64(1000), "oper-state": "up"}),
+				output: counterEvent(10*sec, map[string]interface{}{"oper-state": "up"}),
+			},
+			{
+				input:  counterEvent(20*sec, map[string]interface{}{"in-octets": uint64(3000), "oper-state": "up"}),
+				output: counterEvent(20*sec, map[string]interface{}{"in-octets": float64(200), "oper-state": "up"}),
+			},
+			// string counter
+			{
+				input:  counterEvent(30*sec, map[string]interface{}{"in-octets": "4000"}),
+				output: counterEvent(30*sec, map[string]interface{}{"in-octets": float64(100)}),
+			},
+			// duplicate sample, the event is left without values
+			{
+				input:  counterEvent(30*sec, map[string]interface{}{"in-octets": uint64(4000)}),
+				output: []*formatters.EventMsg{},
+			},
+			// reset
+			{
+				input:  counterEvent(40*sec, map[string]interface{}{"in-octets": uint64(10)}),
+				output: []*formatters.EventMsg{},
+			},
+			{
+				input:  counterEvent(50*sec, map[string]interface{}{"in-octets": uint64(110)}),
+				output: counterEvent(50*sec, map[string]interface{}{"in-octets": float64(10)}),
+			},
+		},
+	},
+	"suffix": {
+		processor: map[string]interface{}{
+			"value-names": []string{"octets"},
+			"suffix":      "_rate",
+		},
+		tests: []item{
+			{
+				input:  counterEvent(10*sec, map[string]interface{}{"in-octets": uint64(1000)}),
+				output: counterEvent(10*sec, map[string]interface{}{"in-octets": uint64(1000)}),
+			},
+			{
+				input:  counterEvent(12*sec, map[string]interface{}{"in-octets": uint64(2000)}),
+				output: counterEvent(12*sec, map[string]interface{}{"in-octets": uint64(2000), "in-octets_rate": float64(500)}),
+			},
+		},
+	},
+	"wrap_32bits": {
+		processor: map[string]interface{}{
+			"value-names":  []string{"packets"},
+			"counter-bits": 32,
+		},
+		tests: []item{
+			{
+				input:  counterEvent(10*sec, map[string]interface{}{"in-packets": uint32(4294967195)}),
+				output: []*formatters.EventMsg{},
+			},
+			{
+				input:  counterEvent(11*sec, map[string]interface{}{"in-packets": uint32(99)}),
+				output: counterEvent(11*sec, map[string]interface{}{"in-packets": float64(200)}),
+			},
+		},
+	},
+	"float": {
+		processor: map[string]interface{}{
+			"value-names": []string{"energy"},
+		},
+		tests: []item{
+			{
+				input:  counterEvent(10*sec, map[string]interface{}{"energy": float64(-5)}),
+				output: []*formatters.EventMsg{},
+			},
+			{
+				input:  counterEvent(14*sec, map[string]interface{}{"energy": float64(1)}),
+				output: counterEvent(14*sec, map[string]interface{}{"energy": float64(1.5)}),
+			},
+		},
+	},
+}
+
+func TestEventRate(t *testing.T) {
+	for name, ts := range testset {
+		p := formatters.EventProcessors[processorType]()
+		err := p.Init(ts.processor)
+		if err != nil {
+			t.Errorf("failed to initialize processors: %v", err)
+			return
+		}
+		t.Logf("initialized for test %s: %+v", name, p)
+		for i, item := range ts.tests {
+			t.Run(name, func(t *testing.T) {
+				outs := p.Apply(copyEvents(item.input)...)
+				if !reflect.DeepEqual(outs, item.output) {
+					t.Errorf("failed at %s item %d, expected %+v, got: %+v", name, i, item.output, outs)
+				}
+			})
+		}
+	}
+}
+
+func TestEventRateSeries(t *testing.T) {
+	p := formatters.EventProcessors[processorType]()
+	err := p.Init(map[string]interface{}{"value-names": []string{"octets"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Apply(counterEvent(10*sec, map[string]interface{}{"in-octets": uint64(100)})...)
+	// same value name with different tags is a different counter
+	other := &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 11 * sec,
+		Tags:      map[string]string{"interface_name": "ethernet-1/2", "source": "router1"},
+		Values:    map[string]interface{}{"in-octets": uint64(500)},
+	}
+	if outs := p.Apply(other); len(outs) != 0 {
+		t.Errorf("unexpected rate for a new series: %+v", outs[0])
+	}
+	outs := p.Apply(counterEvent(11*sec, map[string]interface{}{"in-octets": uint64(200)})...)
+	if len(outs) != 1 || outs[0].Values["in-octets"] != float64(100) {
+		t.Errorf("unexpected output: %+v", outs)
+	}
+}
+
+func TestEventRateTTL(t *testing.T) {
+	p := formatters.EventProcessors[processorType]()
+	err := p.Init(map[string]interface{}{"value-names": []string{"octets"}, "ttl": "10ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Apply(counterEvent(10*sec, map[string]interface{}{"in-octets": uint64(100)})...)
+	time.Sleep(20 * time.Millisecond)
+	// the previous sample expired
+	if outs := p.Apply(counterEvent(11*sec, map[string]interface{}{"in-octets": uint64(200)})...); len(outs) != 0 {
+		t.Errorf("unexpected rate after the ttl: %+v", outs[0])
+	}
+	if n := len(p.(*Rate).samples); n != 1 {
+		t.Errorf("unexpected number of samples %d, expected 1", n)
+	}
+}
+
+func TestEventRateInitErrors(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"no_value_names": {},
+		"bad_regex":      {"value-names": []string{"("}},
+		"bad_bits":       {"value-names": []string{"octets"}, "counter-bits": 16},
+	} {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func copyEvents(es []*formatters.EventMsg) []*formatters.EventMsg {
+	res := make([]*formatters.EventMsg, 0, len(es))
+	for _, e := range es {
+		ce := *e
+		ce.Values = make(map[string]interface{}, len(e.Values))
+		for k, v := range e.Values {
+			ce.Values[k] = v
+		}
+		res = append(res, &ce)
+	}
+	return res
+}
@@ -0,0 +1,336 @@
+package event_starlark
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"go.starlark.net/starlark"
+)
+
+// event is the starlark representation of an EventMsg
+type event struct {
+	name      starlark.String
+	timestamp starlark.Int
+	tags      *starlark.Dict
+	values    *starlark.Dict
+	deletes   *starlark.List
+	frozen    bool
+}
+
+var eventAttrs = []string{"deletes", "name", "tags", "timestamp", "values"}
+
+var _ starlark.HasSetField = (*event)(nil)
+
+func (e *event) String() string {
+	return fmt.Sprintf("Event(name=%s, timestamp=%s, tags=%s, values=%s, deletes=%s)",
+		e.name.String(), e.timestamp.String(), e.tags.String(), e.values.String(), e.deletes.String())
+}
+func (e *event) Type() string         { return "Event" }
+func (e *event) Truth() starlark.Bool { return starlark.True }
+func (e *event) Hash() (uint32, error) {
+	return 0, errors.New("unhashable type: Event")
+}
+
+func (e *event) Freeze() {
+	if e.frozen {
+		return
+	}
+	e.frozen = true
+	e.tags.Freeze()
+	e.values.Freeze()
+	e.deletes.Freeze()
+}
+
+func (e *event) AttrNames() []string { return eventAttrs }
+
+func (e *event) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "name":
+		return e.name, nil
+	case "timestamp":
+		return e.timestamp, nil
+	case "tags":
+		return e.tags, nil
+	case "values":
+		return e.values, nil
+	case "deletes":
+		return e.deletes, nil
+	}
+	return nil, nil
+}
+
+func (e *event) SetField(name string, v starlark.Value) error {
+	if e.frozen {
+		return errors.New("cannot set field of a frozen Event")
+	}
+	var ok bool
+	switch name {
+	case "name":
+		e.name, ok = v.(starlark.String)
+	case "timestamp":
+		e.timestamp, ok = v.(starlark.Int)
+	case "tags":
+		e.tags, ok = v.(*starlark.Dict)
+	case "values":
+		e.values, ok = v.(*starlark.Dict)
+	case "deletes":
+		e.deletes, ok = v.(*starlark.List)
+	default:
+		return starlark.NoSuchAttrError(fmt.Sprintf("Event has no .%s field", name))
+	}
+	if !ok {
+		return fmt.Errorf("Event.%s: unexpected type %s", name, v.Type())
+	}
+	return nil
+}
+
+// newEvent is the starlark Event constructor:
+// Event(name, timestamp=0, tags={}, values={}, deletes=[])
+func newEvent(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	e := &event{
+		timestamp: starlark.MakeInt(0),
+		tags:      new(starlark.Dict),
+		values:    new(starlark.Dict),
+		deletes:   new(starlark.List),
+	}
+	err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"name", &e.name,
+		"timestamp?", &e.timestamp,
+		"tags?", &e.tags,
+		"values?", &e.values,
+		"deletes?", &e.deletes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func toStarlarkEvent(e *formatters.EventMsg) (*event, error) {
+	se := &event{
+		name:      starlark.String(e.Name),
+		timestamp: starlark.MakeInt64(e.Timestamp),
+		tags:      starlark.NewDict(len(e.Tags)),
+		values:    starlark.NewDict(len(e.Values)),
+		deletes:   starlark.NewList(make([]starlark.Value, 0, len(e.Deletes))),
+	}
+	for _, k := range sortedKeys(e.Tags) {
+		err := se.tags.SetKey(starlark.String(k), starlark.String(e.Tags[k]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range sortedValuesKeys(e.Values) {
+		v, err := toStarlarkValue(e.Values[k])
+		if err != nil {
+			return nil, fmt.Errorf("value %q: %v", k, err)
+		}
+		err = se.values.SetKey(starlark.String(k), v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range e.Deletes {
+		err := se.deletes.Append(starlark.String(d))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return se, nil
+}
+
+func (e *event) toEventMsg() (*formatters.EventMsg, error) {
+	ts, ok := e.timestamp.Int64()
+	if !ok {
+		return nil, fmt.Errorf("timestamp %s out of range", e.timestamp)
+	}
+	em := &formatters.EventMsg{
+		Name:      string(e.name),
+		Timestamp: ts,
+	}
+	if e.tags.Len() > 0 {
+		em.Tags = make(map[string]string, e.tags.Len())
+		for _, item := range e.tags.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("tag name %s is not a string", item[0])
+			}
+			// tags values are converted to strings
+			if s, ok := starlark.AsString(item[1]); ok {
+				em.Tags[k] = s
+				continue
+			}
+			em.Tags[k] = item[1].String()
+		}
+	}
+	if e.values.Len() > 0 {
+		em.Values = make(map[string]interface{}, e.values.Len())
+		for _, item := range e.values.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("value name %s is not a string", item[0])
+			}
+			v, err := fromStarlarkValue(item[1])
+			if err != nil {
+				return nil, fmt.Errorf("value %q: %v", k, err)
+			}
+			em.Values[k] = v
+		}
+	}
+	if e.deletes.Len() > 0 {
+		em.Deletes = make([]string, 0, e.deletes.Len())
+		for i := 0; i < e.deletes.Len(); i++ {
+			d, ok := starlark.AsString(e.deletes.Index(i))
+			if !ok {
+				return nil, fmt.Errorf("delete %s is not a string", e.deletes.Index(i))
+			}
+			em.Deletes = append(em.Deletes, d)
+		}
+	}
+	return em, nil
+}
+
+func toStarlarkValue(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int8:
+		return starlark.MakeInt64(int64(v)), nil
+	case int16:
+		return starlark.MakeInt64(int64(v)), nil
+	case int32:
+		return starlark.MakeInt64(int64(v)), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case uint:
+		return starlark.MakeUint(v), nil
+	case uint8:
+		return starlark.MakeUint64(uint64(v)), nil
+	case uint16:
+		return starlark.MakeUint64(uint64(v)), nil
+	case uint32:
+		return starlark.MakeUint64(uint64(v)), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case float32:
+		return starlark.Float(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case *gnmi.Decimal64:
+		f, ok := formatters.ToFloat(v)
+		if !ok {
+			return starlark.None, nil
+		}
+		return starlark.Float(f), nil
+	case []byte:
+		return starlark.String(v), nil
+	case []interface{}:
+		l := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			sv, err := toStarlarkValue(item)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, sv)
+		}
+		return starlark.NewList(l), nil
+	case map[string]interface{}:
+		d := starlark.NewDict(len(v))
+		for _, k := range sortedValuesKeys(v) {
+			sv, err := toStarlarkValue(v[k])
+			if err != nil {
+				return nil, err
+			}
+			err = d.SetKey(starlark.String(k), sv)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	}
+	// other types, e.g: *anypb.Any, are passed as their string representation
+	return starlark.String(fmt.Sprint(v)), nil
+}
+
+func fromStarlarkValue(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		if u, ok := v.Uint64(); ok {
+			return u, nil
+		}
+		return nil, fmt.Errorf("integer %s out of range", v)
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		l := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := fromStarlarkValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, item)
+		}
+		return l, nil
+	case starlark.Tuple:
+		l := make([]interface{}, 0, v.Len())
+		for _, sv := range v {
+			item, err := fromStarlarkValue(sv)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, item)
+		}
+		return l, nil
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("key %s is not a string", item[0])
+			}
+			mv, err := fromStarlarkValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[k] = mv
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedValuesKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
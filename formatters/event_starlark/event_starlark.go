@@ -0,0 +1,207 @@
+package event_starlark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"go.starlark.net/starlark"
+)
+
+const (
+	processorType = "event-starlark"
+	loggingPrefix = "[" + processorType + "] "
+
+	applyFunc    = "apply"
+	inlineScript = "inline"
+
+	defaultTimeout = 5 * time.Second
+)
+
+// Starlark runs the apply function of a starlark script over the event messages.
+// The function is called with a list of Event objects and returns the list of events to be kept.
+// The `state` dict is kept between calls.
+type Starlark struct {
+	// path to the script file
+	Script string `mapstructure:"script,omitempty" json:"script,omitempty"`
+	// inline script, used if Script is not set
+	Source string `mapstructure:"source,omitempty" json:"source,omitempty"`
+	// maximum duration of a script run, defaults to 5s
+	Timeout time.Duration `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+	// maximum number of starlark computation steps of a script run, no limit if zero
+	MaxSteps uint64 `mapstructure:"max-steps,omitempty" json:"max-steps,omitempty"`
+	Debug    bool   `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	// metrics label
+	label string
+
+	m      *sync.Mutex
+	fn     starlark.Value
+	logger *log.Logger
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Starlark{
+			m:      new(sync.Mutex),
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Starlark) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = defaultTimeout
+	}
+	var src interface{}
+	switch {
+	case p.Script != "":
+		b, err := ioutil.ReadFile(p.Script)
+		if err != nil {
+			return err
+		}
+		src = b
+		p.label = p.Script
+	case p.Source != "":
+		src = p.Source
+		p.label = inlineScript
+	default:
+		return errors.New("missing script or source")
+	}
+	predeclared := starlark.StringDict{
+		"Event": starlark.NewBuiltin("Event", newEvent),
+		// state is kept between apply calls
+		"state": starlark.NewDict(0),
+	}
+	thread, stop := p.newThread()
+	globals, err := starlark.ExecFile(thread, p.label, src, predeclared)
+	stop()
+	if err != nil {
+		return err
+	}
+	fn, ok := globals[applyFunc]
+	if !ok {
+		return fmt.Errorf("missing %s function", applyFunc)
+	}
+	if _, ok := fn.(starlark.Callable); !ok {
+		return fmt.Errorf("%s is not a function", applyFunc)
+	}
+	p.fn = fn
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Starlark) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	p.m.Lock()
+	defer p.m.Unlock()
+	start := time.Now()
+	res, err := p.apply(es)
+	StarlarkApplyDuration.WithLabelValues(p.label).Observe(time.Since(start).Seconds())
+	if err != nil {
+		// the events are returned unchanged
+		StarlarkNumberOfApplyErrors.WithLabelValues(p.label).Inc()
+		p.logger.Printf("failed to apply script: %v", err)
+		return es
+	}
+	return res
+}
+
+func (p *Starlark) WithLogger(l *log.Logger) {
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Starlark) WithTargets(tcs map[string]interface{}) {}
+
+// newThread returns a thread running the script for at most Timeout and MaxSteps,
+// the returned func stops the timeout timer.
+// A thread is created for each run since a cancelled thread can not be reused.
+func (p *Starlark) newThread() (*starlark.Thread, func() bool) {
+	thread := &starlark.Thread{
+		Name: processorType,
+		Print: func(_ *starlark.Thread, msg string) {
+			p.logger.Print(msg)
+		},
+	}
+	if p.MaxSteps > 0 {
+		thread.SetMaxExecutionSteps(p.MaxSteps)
+	}
+	timer := time.AfterFunc(p.Timeout, func() {
+		thread.Cancel("timeout")
+	})
+	return thread, timer.Stop
+}
+
+func (p *Starlark) apply(es []*formatters.EventMsg) ([]*formatters.EventMsg, error) {
+	input := make([]starlark.Value, 0, len(es))
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		se, err := toStarlarkEvent(e)
+		if err != nil {
+			return nil, err
+		}
+		input = append(input, se)
+	}
+	thread, stop := p.newThread()
+	rv, err := starlark.Call(thread, p.fn, starlark.Tuple{starlark.NewList(input)}, nil)
+	stop()
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return nil, errors.New(evalErr.Backtrace())
+		}
+		return nil, err
+	}
+	var output []starlark.Value
+	switch rv := rv.(type) {
+	case starlark.NoneType:
+		// the input events were modified in place
+		output = input
+	case *event:
+		output = []starlark.Value{rv}
+	case starlark.Indexable:
+		output = make([]starlark.Value, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			output = append(output, rv.Index(i))
+		}
+	default:
+		return nil, fmt.Errorf("%s returned an unexpected type %s", applyFunc, rv.Type())
+	}
+	res := make([]*formatters.EventMsg, 0, len(output))
+	for _, v := range output {
+		se, ok := v.(*event)
+		if !ok {
+			return nil, fmt.Errorf("%s returned an unexpected type %s, expected Event", applyFunc, v.Type())
+		}
+		e, err := se.toEventMsg()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
@@ -0,0 +1,25 @@
+package event_starlark
+
+import (
+	"github.com/karimra/gnmic/formatters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var StarlarkNumberOfApplyErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "event_starlark",
+	Name:      "number_of_apply_errors_total",
+	Help:      "Number of gnmic event-starlark script apply errors",
+}, []string{"script"})
+
+var StarlarkApplyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gnmic",
+	Subsystem: "event_starlark",
+	Name:      "apply_duration_seconds",
+	Help:      "gnmic event-starlark script apply duration in seconds",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"script"})
+
+func init() {
+	formatters.RegisterCollectors(StarlarkNumberOfApplyErrors, StarlarkApplyDuration)
+}
@@ -0,0 +1,271 @@
+package event_starlark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type item struct {
+	input  []*formatters.EventMsg
+	output []*formatters.EventMsg
+}
+
+var testset = map[string]struct {
+	source string
+	tests  []item
+}{
+	"modify_in_place": {
+		source: `
+def apply(events):
+    for e in events:
+        e.name = e.name + "_mod"
+        e.timestamp = e.timestamp + 1
+        if e.tags.get("vendor") == "vendorA":
+            e.values["power"] = e.values["power"] / 1000.0
+        e.tags["processed"] = True
+        e.deletes.append("/interface")
+`,
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 10,
+						Tags:      map[string]string{"vendor": "vendorA"},
+						Values:    map[string]interface{}{"power": int64(1500), "desc": "uplink"},
+					},
+					{
+						Name:      "sub1",
+						Timestamp: 10,
+						Tags:      map[string]string{"vendor": "vendorB"},
+						Values:    map[string]interface{}{"power": uint64(2)},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1_mod",
+						Timestamp: 11,
+						Tags:      map[string]string{"vendor": "vendorA", "processed": "True"},
+						Values:    map[string]interface{}{"power": float64(1.5), "desc": "uplink"},
+						Deletes:   []string{"/interface"},
+					},
+					{
+						Name:      "sub1_mod",
+						Timestamp: 11,
+						Tags:      map[string]string{"vendor": "vendorB", "processed": "True"},
+						Values:    map[string]interface{}{"power": int64(2)},
+						Deletes:   []string{"/interface"},
+					},
+				},
+			},
+		},
+	},
+	"split": {
+		source: `
+def apply(events):
+    res = []
+    for e in events:
+        for i, lane in enumerate(e.values["lanes"].split(",")):
+            tags = dict(e.tags)
+            tags["lane"] = str(i)
+            res.append(Event(e.name, timestamp=e.timestamp, tags=tags, values={"power": float(lane)}))
+    return res
+`,
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 10,
+						Tags:      map[string]string{"interface_name": "eth1"},
+						Values:    map[string]interface{}{"lanes": "-1.5,-2"},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 10,
+						Tags:      map[string]string{"interface_name": "eth1", "lane": "0"},
+						Values:    map[string]interface{}{"power": float64(-1.5)},
+					},
+					{
+						Name:      "sub1",
+						Timestamp: 10,
+						Tags:      map[string]string{"interface_name": "eth1", "lane": "1"},
+						Values:    map[string]interface{}{"power": float64(-2)},
+					},
+				},
+			},
+		},
+	},
+	"decimal_and_bytes": {
+		source: `
+def apply(events):
+    for e in events:
+        e.values["double"] = e.values["d"] * 2
+`,
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:   "sub1",
+						Values: map[string]interface{}{"d": &gnmi.Decimal64{Digits: 15, Precision: 1}, "b": []byte("abc")},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:   "sub1",
+						Values: map[string]interface{}{"d": float64(1.5), "b": "abc", "double": float64(3)},
+					},
+				},
+			},
+		},
+	},
+	"state": {
+		source: `
+def apply(events):
+    res = []
+    for e in events:
+        key = e.tags["source"]
+        count = state.get(key, 0) + 1
+        state[key] = count
+        # keep every other event
+        if count % 2 == 1:
+            res.append(e)
+    return res
+`,
+		tests: []item{
+			{
+				input:  []*formatters.EventMsg{{Name: "sub1", Tags: map[string]string{"source": "r1"}}},
+				output: []*formatters.EventMsg{{Name: "sub1", Tags: map[string]string{"source": "r1"}}},
+			},
+			{
+				input:  []*formatters.EventMsg{{Name: "sub1", Tags: map[string]string{"source": "r1"}}},
+				output: []*formatters.EventMsg{},
+			},
+			{
+				input:  []*formatters.EventMsg{{Name: "sub1", Tags: map[string]string{"source": "r1"}}},
+				output: []*formatters.EventMsg{{Name: "sub1", Tags: map[string]string{"source": "r1"}}},
+			},
+		},
+	},
+}
+
+func TestEventStarlark(t *testing.T) {
+	for name, ts := range testset {
+		p := formatters.EventProcessors[processorType]()
+		err := p.Init(map[string]interface{}{"source": ts.source})
+		if err != nil {
+			t.Errorf("failed to initialize processors: %v", err)
+			return
+		}
+		for i, item := range ts.tests {
+			t.Run(name, func(t *testing.T) {
+				outs := p.Apply(item.input...)
+				if !reflect.DeepEqual(outs, item.output) {
+					t.Errorf("failed at %s item %d, expected %+v, got: %+v", name, i, item.output, outs)
+				}
+			})
+		}
+	}
+}
+
+func TestEventStarlarkScriptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_starlark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "script.star")
+	err = ioutil.WriteFile(script, []byte(`
+def apply(events):
+    return [e for e in events if e.values.get("drop") != True]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := formatters.EventProcessors[processorType]()
+	err = p.Init(map[string]interface{}{"script": script})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outs := p.Apply(
+		&formatters.EventMsg{Name: "sub1", Values: map[string]interface{}{"drop": true}},
+		&formatters.EventMsg{Name: "sub2", Values: map[string]interface{}{"drop": false}},
+	)
+	if len(outs) != 1 || outs[0].Name != "sub2" {
+		t.Errorf("unexpected output: %+v", outs)
+	}
+}
+
+func TestEventStarlarkApplyError(t *testing.T) {
+	p := formatters.EventProcessors[processorType]()
+	err := p.Init(map[string]interface{}{"source": `
+def apply(events):
+    for e in events:
+        e.values["ratio"] = e.values["in"] / e.values["out"]
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(StarlarkNumberOfApplyErrors.WithLabelValues(inlineScript))
+	in := &formatters.EventMsg{Name: "sub1", Values: map[string]interface{}{"in": 1, "out": 0}}
+	// the events are returned unchanged
+	outs := p.Apply(in)
+	if len(outs) != 1 || outs[0] != in || len(in.Values) != 2 {
+		t.Errorf("unexpected output: %+v", outs)
+	}
+	if after := testutil.ToFloat64(StarlarkNumberOfApplyErrors.WithLabelValues(inlineScript)); after != before+1 {
+		t.Errorf("unexpected errors count %f, expected %f", after, before+1)
+	}
+}
+
+func TestEventStarlarkLimits(t *testing.T) {
+	source := `
+def apply(events):
+    for i in range(1000000000):
+        pass
+`
+	for name, cfg := range map[string]map[string]interface{}{
+		"max_steps": {"source": source, "max-steps": 1000},
+		"timeout":   {"source": source, "timeout": "10ms"},
+	} {
+		p := formatters.EventProcessors[processorType]()
+		err := p.Init(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := testutil.ToFloat64(StarlarkNumberOfApplyErrors.WithLabelValues(inlineScript))
+		in := &formatters.EventMsg{Name: "sub1", Values: map[string]interface{}{"v": 1}}
+		// the run is cancelled and the events are returned unchanged
+		outs := p.Apply(in)
+		if len(outs) != 1 || outs[0] != in {
+			t.Errorf("%s: unexpected output: %+v", name, outs)
+		}
+		if after := testutil.ToFloat64(StarlarkNumberOfApplyErrors.WithLabelValues(inlineScript)); after != before+1 {
+			t.Errorf("%s: unexpected errors count %f, expected %f", name, after, before+1)
+		}
+	}
+}
+
+func TestEventStarlarkInitErrors(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"no_script":    {},
+		"missing_file": {"script": "/does/not/exist.star"},
+		"syntax_error": {"source": "def apply(events)\n    return events\n"},
+		"no_apply":     {"source": "def transform(events):\n    return events\n"},
+		"not_function": {"source": "apply = 1\n"},
+	} {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package event_switch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"github.com/karimra/gnmic/formatters"
+)
+
+const (
+	processorType = "event-switch"
+	loggingPrefix = "[" + processorType + "] "
+)
+
+// Switch routes each event to the processors chain of the first case it matches,
+// or to the default chain. The results of all the chains are merged.
+type Switch struct {
+	Cases []*switchCase `mapstructure:"cases,omitempty" json:"cases,omitempty"`
+	// processors applied to the events not matching any case,
+	// these events are passed unchanged if empty
+	Default []string `mapstructure:"default,omitempty" json:"default,omitempty"`
+	Debug   bool     `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	defaultChain []formatters.EventProcessor
+
+	eps     map[string]map[string]interface{}
+	targets map[string]interface{}
+	// logger passed to the chains processors
+	parentLogger *log.Logger
+	logger       *log.Logger
+}
+
+type switchCase struct {
+	// optional case name, used in logs
+	Name      string `mapstructure:"name,omitempty" json:"name,omitempty"`
+	Condition string `mapstructure:"condition,omitempty" json:"condition,omitempty"`
+	// list of regexes matched against the value names, i.e the paths
+	ValueNames []string `mapstructure:"value-names,omitempty" json:"value-names,omitempty"`
+	Processors []string `mapstructure:"processors,omitempty" json:"processors,omitempty"`
+
+	code       *gojq.Code
+	valueNames []*regexp.Regexp
+	chain      []formatters.EventProcessor
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Switch{
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Switch) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if len(p.Cases) == 0 {
+		return errors.New("missing cases")
+	}
+	names := make([]string, 0)
+	for i, c := range p.Cases {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("case-%d", i)
+		}
+		if c.Condition == "" && len(c.ValueNames) == 0 {
+			return fmt.Errorf("%s: missing condition or value-names", c.Name)
+		}
+		err = c.init()
+		if err != nil {
+			return fmt.Errorf("%s: %v", c.Name, err)
+		}
+		names = append(names, c.Processors...)
+	}
+	names = append(names, p.Default...)
+	// a switch referring to itself, directly or not, would be initialized endlessly
+	err = checkCycles(p.eps, names, nil)
+	if err != nil {
+		return err
+	}
+	for _, c := range p.Cases {
+		c.chain, err = formatters.MakeEventProcessors(p.eps, c.Processors, p.parentLogger, p.targets)
+		if err != nil {
+			p.Close()
+			return fmt.Errorf("%s: %v", c.Name, err)
+		}
+	}
+	p.defaultChain, err = formatters.MakeEventProcessors(p.eps, p.Default, p.parentLogger, p.targets)
+	if err != nil {
+		p.Close()
+		return fmt.Errorf("default: %v", err)
+	}
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Switch) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	routed := make([][]*formatters.EventMsg, len(p.Cases))
+	unmatched := make([]*formatters.EventMsg, 0)
+OUTER:
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		for i, c := range p.Cases {
+			if c.match(e, p.logger) {
+				routed[i] = append(routed[i], e)
+				continue OUTER
+			}
+		}
+		unmatched = append(unmatched, e)
+	}
+	result := make([]*formatters.EventMsg, 0, len(es))
+	for i, c := range p.Cases {
+		if len(routed[i]) == 0 {
+			continue
+		}
+		p.logger.Printf("%s: routing %d event(s)", c.Name, len(routed[i]))
+		result = append(result, applyChain(c.chain, routed[i])...)
+	}
+	if len(unmatched) > 0 {
+		result = append(result, applyChain(p.defaultChain, unmatched)...)
+	}
+	return result
+}
+
+func (p *Switch) WithLogger(l *log.Logger) {
+	p.parentLogger = l
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Switch) WithTargets(tcs map[string]interface{}) {
+	p.targets = tcs
+}
+
+func (p *Switch) WithProcessors(eps map[string]map[string]interface{}) {
+	p.eps = eps
+}
+
+// WithEmitter connects the event emitters found in the chains, their events go through
+// the rest of their chain before being passed to fn.
+func (p *Switch) WithEmitter(ctx context.Context, fn func([]*formatters.EventMsg)) {
+	for _, c := range p.Cases {
+		formatters.ConnectEmitters(ctx, c.chain, fn)
+	}
+	formatters.ConnectEmitters(ctx, p.defaultChain, fn)
+}
+
+// Close closes the processors of the cases and of the default chain
+func (p *Switch) Close() error {
+	for _, c := range p.Cases {
+		formatters.CloseEventProcessors(c.chain)
+	}
+	formatters.CloseEventProcessors(p.defaultChain)
+	return nil
+}
+
+func (c *switchCase) init() error {
+	if c.Condition != "" {
+		c.Condition = strings.TrimSpace(c.Condition)
+		q, err := gojq.Parse(c.Condition)
+		if err != nil {
+			return err
+		}
+		c.code, err = gojq.Compile(q)
+		if err != nil {
+			return err
+		}
+	}
+	c.valueNames = make([]*regexp.Regexp, 0, len(c.ValueNames))
+	for _, reg := range c.ValueNames {
+		re, err := regexp.Compile(reg)
+		if err != nil {
+			return err
+		}
+		c.valueNames = append(c.valueNames, re)
+	}
+	return nil
+}
+
+// match returns true if the event satisfies the condition, if any,
+// and has a value name matching one of the regexes, if any
+func (c *switchCase) match(e *formatters.EventMsg, logger *log.Logger) bool {
+	if c.code != nil {
+		ok, err := formatters.CheckCondition(c.code, e)
+		if err != nil {
+			logger.Printf("%s: condition check failed: %v", c.Name, err)
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(c.valueNames) == 0 {
+		return true
+	}
+	for k := range e.Values {
+		for _, re := range c.valueNames {
+			if re.MatchString(k) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func applyChain(chain []formatters.EventProcessor, es []*formatters.EventMsg) []*formatters.EventMsg {
+	for _, ep := range chain {
+		es = ep.Apply(es...)
+	}
+	return es
+}
+
+// checkCycles returns an error if one of the processors named names is an event-switch
+// referring to one of the switches in path, directly or through other switches.
+func checkCycles(eps map[string]map[string]interface{}, names []string, path []string) error {
+	for _, name := range names {
+		for _, pn := range path {
+			if pn == name {
+				return fmt.Errorf("event processors cycle detected: %s -> %s", strings.Join(path, " -> "), name)
+			}
+		}
+		cfg, ok := eps[name][processorType]
+		if !ok {
+			continue
+		}
+		sw := new(Switch)
+		err := formatters.DecodeConfig(cfg, sw)
+		if err != nil {
+			return fmt.Errorf("%q: %v", name, err)
+		}
+		refs := append([]string{}, sw.Default...)
+		for _, c := range sw.Cases {
+			if c != nil {
+				refs = append(refs, c.Processors...)
+			}
+		}
+		err = checkCycles(eps, refs, append(path, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package event_switch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	_ "github.com/karimra/gnmic/formatters/event_add_tag"
+	_ "github.com/karimra/gnmic/formatters/event_aggregate"
+	_ "github.com/karimra/gnmic/formatters/event_drop"
+)
+
+var eps = map[string]map[string]interface{}{
+	"tag-bgp": {
+		"event-add-tag": map[string]interface{}{
+			"value-names": []string{"."},
+			"add":         map[string]string{"chain": "bgp"},
+		},
+	},
+	"tag-interface": {
+		"event-add-tag": map[string]interface{}{
+			"value-names": []string{"."},
+			"add":         map[string]string{"chain": "interface"},
+		},
+	},
+	"drop-all": {
+		"event-drop": map[string]interface{}{
+			"condition": "true",
+		},
+	},
+	"aggregate": {
+		"event-aggregate": map[string]interface{}{
+			"value-names": []string{"cpu"},
+			"window":      "50ms",
+			"functions":   []string{"count"},
+		},
+	},
+	"switch": {
+		"event-switch": map[string]interface{}{
+			"cases": []interface{}{
+				map[string]interface{}{"condition": "true", "processors": []string{"switch-loop"}},
+			},
+		},
+	},
+	"switch-loop": {
+		"event-switch": map[string]interface{}{
+			"cases": []interface{}{
+				map[string]interface{}{"condition": "true", "processors": []string{"switch"}},
+			},
+		},
+	},
+}
+
+func newSwitch(cfg map[string]interface{}) (formatters.EventProcessor, error) {
+	p := formatters.EventProcessors[processorType]()
+	err := p.Init(cfg, formatters.WithProcessors(eps))
+	return p, err
+}
+
+func TestSwitch(t *testing.T) {
+	p, err := newSwitch(map[string]interface{}{
+		"cases": []interface{}{
+			map[string]interface{}{
+				"name":       "bgp",
+				"condition":  `.tags["subscription-name"] == "bgp"`,
+				"processors": []string{"tag-bgp"},
+			},
+			map[string]interface{}{
+				"name":        "interfaces",
+				"value-names": []string{"^/interface/"},
+				"processors":  []string{"tag-interface"},
+			},
+			map[string]interface{}{
+				"name":        "system",
+				"value-names": []string{"^/system/"},
+				"processors":  []string{"drop-all"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outs := p.Apply(
+		&formatters.EventMsg{Name: "e1", Tags: map[string]string{"subscription-name": "if"}, Values: map[string]interface{}{"/interface/in-octets": 1}},
+		&formatters.EventMsg{Name: "e2", Tags: map[string]string{"subscription-name": "bgp"}, Values: map[string]interface{}{"/bgp/neighbor/state": "up"}},
+		&formatters.EventMsg{Name: "e3", Tags: map[string]string{"subscription-name": "other"}, Values: map[string]interface{}{"/lldp/neighbor": "r2"}},
+		&formatters.EventMsg{Name: "e4", Tags: map[string]string{"subscription-name": "system"}, Values: map[string]interface{}{"/system/cpu": 10}},
+		// the first matching case wins
+		&formatters.EventMsg{Name: "e5", Tags: map[string]string{"subscription-name": "bgp"}, Values: map[string]interface{}{"/interface/in-octets": 1}},
+	)
+	// the results are merged in cases order, the unmatched events are passed unchanged.
+	// event-drop empties the dropped events
+	want := []struct {
+		name  string
+		chain string
+	}{{"e2", "bgp"}, {"e5", "bgp"}, {"e1", "interface"}, {"", ""}, {"e3", ""}}
+	if len(outs) != len(want) {
+		t.Fatalf("unexpected output: %+v", outs)
+	}
+	for i, w := range want {
+		if outs[i].Name != w.name || outs[i].Tags["chain"] != w.chain {
+			t.Errorf("event %d: expected %s with chain %q, got: %+v", i, w.name, w.chain, outs[i])
+		}
+	}
+}
+
+func TestSwitchDefault(t *testing.T) {
+	p, err := newSwitch(map[string]interface{}{
+		"cases": []interface{}{
+			map[string]interface{}{
+				"value-names": []string{"^/interface/"},
+				"processors":  []string{"tag-interface"},
+			},
+		},
+		"default": []string{"drop-all"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outs := p.Apply(
+		&formatters.EventMsg{Name: "e1", Values: map[string]interface{}{"/interface/in-octets": 1}},
+		&formatters.EventMsg{Name: "e2", Values: map[string]interface{}{"/bgp/neighbor/state": "up"}},
+	)
+	if len(outs) != 2 || outs[0].Name != "e1" || outs[1].Name != "" {
+		t.Errorf("unexpected output: %+v", outs)
+	}
+}
+
+func TestSwitchEmitter(t *testing.T) {
+	p, err := newSwitch(map[string]interface{}{
+		"cases": []interface{}{
+			map[string]interface{}{
+				"value-names": []string{"cpu"},
+				"processors":  []string{"aggregate", "tag-interface"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emitted := make(chan []*formatters.EventMsg, 10)
+	formatters.ConnectEmitters(ctx, []formatters.EventProcessor{p}, func(es []*formatters.EventMsg) {
+		emitted <- es
+	})
+	p.Apply(&formatters.EventMsg{Name: "sub1", Values: map[string]interface{}{"cpu": 1}})
+	select {
+	case es := <-emitted:
+		if len(es) != 1 || es[0].Values["cpu_count"] != int64(1) || es[0].Tags["chain"] != "interface" {
+			t.Errorf("unexpected emitted events: %+v", es)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the aggregation window flush")
+	}
+}
+
+func TestSwitchInitErrors(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"no_cases": {},
+		"no_selector": {
+			"cases": []interface{}{map[string]interface{}{"processors": []string{"tag-bgp"}}},
+		},
+		"bad_condition": {
+			"cases": []interface{}{map[string]interface{}{"condition": ".tags |", "processors": []string{"tag-bgp"}}},
+		},
+		"unknown_processor": {
+			"cases": []interface{}{map[string]interface{}{"condition": "true", "processors": []string{"missing"}}},
+		},
+		"unknown_default": {
+			"cases":   []interface{}{map[string]interface{}{"condition": "true", "processors": []string{"tag-bgp"}}},
+			"default": []string{"missing"},
+		},
+		"cycle": {
+			"cases": []interface{}{map[string]interface{}{"condition": "true", "processors": []string{"switch"}}},
+		},
+	} {
+		if _, err := newSwitch(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -195,3 +195,67 @@ func TestResponseToEventMsgs(t *testing.T) {
 	//b, _ := json.MarshalIndent(evs, "", "  ")
 	//fmt.Println(string(b))
 }
+
+func TestResponseToEventMsgsDeletes(t *testing.T) {
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Prefix: &gnmi.Path{
+					Elem: []*gnmi.PathElem{
+						{Name: "interfaces"},
+					},
+				},
+				Delete: []*gnmi.Path{
+					{
+						Elem: []*gnmi.PathElem{
+							{Name: "interface",
+								Key: map[string]string{
+									"name": "ethernet-1/1",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	evs, err := ResponseToEventMsgs("subname", rsp, map[string]string{"source": "router1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(evs))
+	}
+	want := []string{"/interfaces/interface[name=ethernet-1/1]"}
+	if !reflect.DeepEqual(evs[0].Deletes, want) {
+		t.Errorf("expected deletes %v, got %v", want, evs[0].Deletes)
+	}
+}
+
+func TestSeriesKey(t *testing.T) {
+	k := SeriesKey("sub1", map[string]string{"source": "router1", "interface_name": "ethernet-1/1"})
+	if k != "sub1|interface_name=ethernet-1/1|source=router1|" {
+		t.Errorf("unexpected key %q", k)
+	}
+	if SeriesKey("sub1", map[string]string{"source": "router1"}) == SeriesKey("sub2", map[string]string{"source": "router1"}) {
+		t.Errorf("expected different keys for different names")
+	}
+}
+
+func TestToFloat(t *testing.T) {
+	for _, v := range []interface{}{int8(-2), uint64(3), float32(1.5), true, " 42.5 ", &gnmi.Decimal64{Digits: 4250, Precision: 2}} {
+		if _, ok := ToFloat(v); !ok {
+			t.Errorf("%v of type %T: expected a number", v, v)
+		}
+	}
+	f, ok := ToFloat(&gnmi.Decimal64{Digits: -4225, Precision: 2})
+	if !ok || f != -42.25 {
+		t.Errorf("unexpected decimal64 conversion: %v, %v", f, ok)
+	}
+	for _, v := range []interface{}{"up", nil, []interface{}{1}, (*gnmi.Decimal64)(nil)} {
+		if _, ok := ToFloat(v); ok {
+			t.Errorf("%v of type %T: expected not a number", v, v)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"text/template"
 	"time"
 
 	"github.com/openconfig/gnmi/proto/gnmi"
@@ -18,11 +19,27 @@ type MarshalOptions struct {
 	Indent     string
 	Format     string
 	OverrideTS bool
+	// message template used by the `template` format
+	Template *template.Template
 }
 
 // Marshal //
 func (o *MarshalOptions) Marshal(msg proto.Message, meta map[string]string, eps ...EventProcessor) ([]byte, error) {
 	msg = o.OverrideTimestamp(msg)
+	// the event based formats apply the processors to the events they produce,
+	// the others to the notification rebuilt from the processed events.
+	if len(eps) > 0 && o.Format != "event" && o.Format != "template" {
+		if rsp, ok := msg.ProtoReflect().Interface().(*gnmi.SubscribeResponse); ok {
+			rsp, tags, err := processResponse(rsp, meta, eps...)
+			if err != nil {
+				return nil, err
+			}
+			if rsp == nil {
+				return nil, nil
+			}
+			return o.marshalProcessed(rsp, meta, tags)
+		}
+	}
 	switch o.Format {
 	default: // json
 		return o.FormatJSON(msg, meta)
@@ -60,6 +77,8 @@ func (o *MarshalOptions) Marshal(msg proto.Message, meta map[string]string, eps
 		default:
 			return nil, fmt.Errorf("format 'event' not supported for msg type %T", msg.ProtoReflect().Interface())
 		}
+	case "template":
+		return o.formatTemplate(msg, meta, eps...)
 	case "flat":
 		flatMsg, err := responseFlat(msg)
 		if err != nil {
@@ -84,6 +103,85 @@ func (o *MarshalOptions) Marshal(msg proto.Message, meta map[string]string, eps
 	}
 }
 
+// MarshalEvents marshals the events emitted by the event processors, e.g: when an aggregation window closes.
+// The events are already processed, the non event formats marshal a notification built from them, see ProcessResponse.
+// The original paths of the events are unknown, so their tags are not turned into path keys, the json format carries them.
+func (o *MarshalOptions) MarshalEvents(evs []*EventMsg, meta map[string]string) ([]byte, error) {
+	if len(evs) == 0 {
+		return nil, nil
+	}
+	switch o.Format {
+	case "event":
+		if o.Multiline {
+			return json.MarshalIndent(evs, "", o.Indent)
+		}
+		return json.Marshal(evs)
+	case "template":
+		if o.Template == nil {
+			return nil, fmt.Errorf("format 'template' requires a msg-template")
+		}
+		return o.renderEvents(evs...)
+	}
+	n, tags, err := eventsToNotification(evs, meta, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(n.Update) == 0 {
+		return nil, nil
+	}
+	rsp := o.OverrideTimestamp(&gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{Update: n},
+	}).(*gnmi.SubscribeResponse)
+	return o.marshalProcessed(rsp, meta, tags)
+}
+
+// marshalProcessed marshals a response rebuilt from processed events,
+// the json format carries the extra tags of each update, the other formats drop them.
+func (o *MarshalOptions) marshalProcessed(rsp *gnmi.SubscribeResponse, meta map[string]string, tags []map[string]string) ([]byte, error) {
+	if DropsTags(o.Format) {
+		return o.Marshal(rsp, meta)
+	}
+	return o.formatSubscribeResponse(rsp, meta, tags)
+}
+
+// DropsTags reports whether the format drops the event tags which are not path keys,
+// e.g: the tags added by the event processors, see ProcessResponse.
+func DropsTags(format string) bool {
+	switch format {
+	case "proto", "protojson", "prototext", "flat":
+		return true
+	}
+	return false
+}
+
+// WarnDroppedTags logs a warning using logf if the event processors evps are applied
+// with a format dropping the tags they may add.
+func WarnDroppedTags(logf func(string, ...interface{}), format string, evps []EventProcessor) {
+	if len(evps) == 0 || !DropsTags(format) {
+		return
+	}
+	logf("WARNING: format %q drops the event tags which are not path keys, including the tags added by the event processors. "+
+		"Use format 'json' or 'event' to keep them", format)
+}
+
+// EventMeta returns the metadata of an event emitted by the event processors,
+// i.e the message meta added to its tags: source, system-name and subscription-name.
+func EventMeta(ev *EventMsg) map[string]string {
+	meta := make(map[string]string)
+	if ev == nil {
+		return meta
+	}
+	for _, k := range []string{"source", "system-name", "subscription-name"} {
+		if v, ok := ev.Tags[k]; ok {
+			meta[k] = v
+		}
+	}
+	if _, ok := meta["subscription-name"]; !ok && ev.Name != "" {
+		meta["subscription-name"] = ev.Name
+	}
+	return meta
+}
+
 func (o *MarshalOptions) OverrideTimestamp(msg proto.Message) proto.Message {
 	if o.OverrideTS {
 		ts := time.Now().UnixNano()
@@ -30,7 +30,7 @@ func (o *MarshalOptions) FormatJSON(m proto.Message, meta map[string]string) ([]
 	case *gnmi.SubscribeRequest:
 		return o.formatsubscribeRequest(m)
 	case *gnmi.SubscribeResponse:
-		return o.formatSubscribeResponse(m, meta)
+		return o.formatSubscribeResponse(m, meta, nil)
 	}
 	return nil, nil
 }
@@ -76,7 +76,9 @@ func (o *MarshalOptions) formatsubscribeRequest(m *gnmi.SubscribeRequest) ([]byt
 	return json.Marshal(msg)
 }
 
-func (o *MarshalOptions) formatSubscribeResponse(m *gnmi.SubscribeResponse, meta map[string]string) ([]byte, error) {
+// formatSubscribeResponse formats a subscribe response to json,
+// tags are the extra tags of each update of a response rebuilt from processed events, if any.
+func (o *MarshalOptions) formatSubscribeResponse(m *gnmi.SubscribeResponse, meta map[string]string, tags []map[string]string) ([]byte, error) {
 	switch m := m.Response.(type) {
 	case *gnmi.SubscribeResponse_Update:
 		msg := NotificationRspMsg{
@@ -116,6 +118,9 @@ func (o *MarshalOptions) formatSubscribeResponse(m *gnmi.SubscribeResponse, meta
 					Values: make(map[string]interface{}),
 				})
 			msg.Updates[i].Values[strings.Join(pathElems, "/")] = value
+			if i < len(tags) && len(tags[i]) > 0 {
+				msg.Updates[i].Tags = tags[i]
+			}
 		}
 		for _, del := range m.Update.Delete {
 			msg.Deletes = append(msg.Deletes, gnmiPathToXPath(del))
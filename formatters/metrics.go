@@ -0,0 +1,32 @@
+package formatters
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cm         sync.Mutex
+	collectors []prometheus.Collector
+)
+
+// RegisterCollectors adds event processors metrics to the ones registered by RegisterMetrics.
+// It is meant to be called from the event processors packages init functions.
+func RegisterCollectors(cs ...prometheus.Collector) {
+	cm.Lock()
+	defer cm.Unlock()
+	collectors = append(collectors, cs...)
+}
+
+// RegisterMetrics registers the event processors metrics with reg
+func RegisterMetrics(reg *prometheus.Registry) error {
+	cm.Lock()
+	defer cm.Unlock()
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -23,6 +23,9 @@ type NotificationRspMsg struct {
 type update struct {
 	Path   string
 	Values map[string]interface{} `json:"values,omitempty"`
+	// tags of the processed event the update was built from,
+	// which are neither a path key nor part of the message meta
+	Tags map[string]string `json:"tags,omitempty"`
 }
 type capRequest struct {
 	Extentions []string `json:"extentions,omitempty"`
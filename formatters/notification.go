@@ -0,0 +1,242 @@
+package formatters
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// ProcessResponse applies the event processors eps to a subscribe response update:
+// the notification is converted to events, processed, then converted back to a notification.
+// The response is returned unchanged if it is not an update or if eps is empty,
+// nil is returned if all the events were dropped.
+//
+// The conversion is lossy:
+//   - the prefix is merged into the updates paths, only its target is kept.
+//   - the path keys are rebuilt from the tags named after the keys of the original update paths, `<element>_<key>`,
+//     the other tags, e.g: the tags added by a processor, are not part of the notification,
+//     the json format carries them in each update, see processResponse.
+//   - the JSON values are flattened, each leaf becomes an update.
+//   - the values types may change, see EventValueToTypedValue.
+//   - the deletes are not processed.
+func ProcessResponse(rsp *gnmi.SubscribeResponse, meta map[string]string, eps ...EventProcessor) (*gnmi.SubscribeResponse, error) {
+	rsp, _, err := processResponse(rsp, meta, eps...)
+	return rsp, err
+}
+
+// processResponse is ProcessResponse, it also returns the tags of each update of the processed notification
+// which are neither a path key nor part of the message meta, e.g: the tags added by the processors.
+func processResponse(rsp *gnmi.SubscribeResponse, meta map[string]string, eps ...EventProcessor) (*gnmi.SubscribeResponse, []map[string]string, error) {
+	if rsp == nil || len(eps) == 0 {
+		return rsp, nil, nil
+	}
+	upd, ok := rsp.GetResponse().(*gnmi.SubscribeResponse_Update)
+	if !ok || upd.Update == nil {
+		return rsp, nil, nil
+	}
+	name, ok := meta["subscription-name"]
+	if !ok {
+		name = "default"
+	}
+	// the deletes are handled separately, they are not passed to the processors.
+	evs, err := ResponseToEventMsgs(name, &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: upd.Update.GetTimestamp(),
+				Prefix:    upd.Update.GetPrefix(),
+				Update:    upd.Update.GetUpdate(),
+			},
+		},
+	}, meta, eps...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed converting response to events: %v", err)
+	}
+	n, tags, err := eventsToNotification(evs, meta, pathKeys(upd.Update))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(n.Update) == 0 && len(upd.Update.GetDelete()) == 0 {
+		return nil, nil, nil
+	}
+	if n.Timestamp == 0 {
+		n.Timestamp = upd.Update.GetTimestamp()
+	}
+	prefix := upd.Update.GetPrefix()
+	if prefix.GetTarget() != "" {
+		n.Prefix = &gnmi.Path{Target: prefix.GetTarget()}
+	}
+	for _, del := range upd.Update.GetDelete() {
+		n.Delete = append(n.Delete, joinPaths(prefix, del))
+	}
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{Update: n},
+	}, tags, nil
+}
+
+// pathKeys returns the names of the tags built from the keys of the notification updates paths,
+// see TagsFromGNMIPath.
+func pathKeys(n *gnmi.Notification) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, u := range n.GetUpdate() {
+		_, tags := TagsFromGNMIPath(joinPaths(n.GetPrefix(), u.GetPath()))
+		for k := range tags {
+			keys[k] = struct{}{}
+		}
+	}
+	return keys
+}
+
+// eventsToNotification builds a notification from the events values,
+// the notification timestamp is the first event's timestamp.
+// The tags listed in keys are the path keys, see valueNameToPath.
+// It also returns the extra tags of each update: the event tags which are not a path key,
+// a meta key or a meta key renamed `meta:<key>`.
+func eventsToNotification(evs []*EventMsg, meta map[string]string, keys map[string]struct{}) (*gnmi.Notification, []map[string]string, error) {
+	n := new(gnmi.Notification)
+	tags := make([]map[string]string, 0)
+	for _, ev := range evs {
+		if ev == nil || len(ev.Values) == 0 {
+			continue
+		}
+		if n.Timestamp == 0 {
+			n.Timestamp = ev.Timestamp
+		}
+		names := make([]string, 0, len(ev.Values))
+		for k := range ev.Values {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, vn := range names {
+			tv, err := EventValueToTypedValue(ev.Values[vn])
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed converting value %q: %v", vn, err)
+			}
+			p, extra := valueNameToPath(vn, ev.Tags, keys)
+			// the target is the notification prefix target
+			delete(extra, "target")
+			for k := range extra {
+				if _, ok := meta[strings.TrimPrefix(k, "meta:")]; ok {
+					delete(extra, k)
+				}
+			}
+			n.Update = append(n.Update, &gnmi.Update{
+				Path: p,
+				Val:  tv,
+			})
+			tags = append(tags, extra)
+		}
+	}
+	return n, tags, nil
+}
+
+// valueNameToPath builds a gNMI path from an event value name,
+// the tags listed in keys and named `<element>_<key>` are added as keys of the last path element named <element>,
+// the other tags are returned.
+// A tag added by a processor, e.g: `interface_description`, is not listed in keys so it is never turned into a path key.
+func valueNameToPath(name string, tags map[string]string, keys map[string]struct{}) (*gnmi.Path, map[string]string) {
+	p := new(gnmi.Path)
+	extra := make(map[string]string)
+	if i := strings.Index(name, ":/"); i > 0 && !strings.Contains(name[:i], "/") {
+		p.Origin = name[:i]
+		name = name[i+1:]
+	}
+	for _, pe := range strings.Split(strings.Trim(name, "/"), "/") {
+		if pe == "" {
+			continue
+		}
+		p.Elem = append(p.Elem, &gnmi.PathElem{Name: pe})
+	}
+	for k, v := range tags {
+		isKey := false
+		if _, ok := keys[k]; !ok {
+			extra[k] = v
+			continue
+		}
+		for i := len(p.Elem) - 1; i >= 0; i-- {
+			elems := strings.Split(p.Elem[i].Name, ":")
+			elemName := elems[len(elems)-1]
+			if !strings.HasPrefix(k, elemName+"_") || len(k) == len(elemName)+1 {
+				continue
+			}
+			if p.Elem[i].Key == nil {
+				p.Elem[i].Key = make(map[string]string)
+			}
+			p.Elem[i].Key[strings.TrimPrefix(k, elemName+"_")] = v
+			isKey = true
+			break
+		}
+		if !isKey {
+			extra[k] = v
+		}
+	}
+	return p, extra
+}
+
+// EventValueToTypedValue converts an event value to a gNMI TypedValue.
+// The float64 values, as well as the values without a matching scalar type, e.g: lists or maps,
+// are JSON encoded, since the gNMI float_val is a 32 bits float.
+func EventValueToTypedValue(v interface{}) (*gnmi.TypedValue, error) {
+	switch v := v.(type) {
+	case string:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}, nil
+	case bool:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_BoolVal{BoolVal: v}}, nil
+	case int:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: int64(v)}}, nil
+	case int8:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: int64(v)}}, nil
+	case int16:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: int64(v)}}, nil
+	case int32:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: int64(v)}}, nil
+	case int64:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: v}}, nil
+	case uint:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: uint64(v)}}, nil
+	case uint8:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: uint64(v)}}, nil
+	case uint16:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: uint64(v)}}, nil
+	case uint32:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: uint64(v)}}, nil
+	case uint64:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: v}}, nil
+	case float32:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_FloatVal{FloatVal: v}}, nil
+	case []byte:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_BytesVal{BytesVal: v}}, nil
+	case *gnmi.Decimal64:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_DecimalVal{DecimalVal: v}}, nil
+	case *anypb.Any:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_AnyVal{AnyVal: v}}, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: b}}, nil
+}
+
+// TypedValueToEventValue converts a gNMI TypedValue to an event value,
+// the JSON values are decoded but not flattened.
+func TypedValueToEventValue(tv *gnmi.TypedValue) (interface{}, error) {
+	return getValue(tv)
+}
+
+// joinPaths returns the path p appended to the prefix elements.
+func joinPaths(prefix, p *gnmi.Path) *gnmi.Path {
+	jp := &gnmi.Path{
+		Origin: prefix.GetOrigin(),
+		Elem:   make([]*gnmi.PathElem, 0, len(prefix.GetElem())+len(p.GetElem())),
+	}
+	if p.GetOrigin() != "" {
+		jp.Origin = p.GetOrigin()
+	}
+	jp.Elem = append(jp.Elem, prefix.GetElem()...)
+	jp.Elem = append(jp.Elem, p.GetElem()...)
+	return jp
+}
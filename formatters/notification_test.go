@@ -0,0 +1,284 @@
+package formatters
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+type testProcessor struct {
+	apply func(...*EventMsg) []*EventMsg
+}
+
+func (p *testProcessor) Init(interface{}, ...Option) error  { return nil }
+func (p *testProcessor) Apply(es ...*EventMsg) []*EventMsg  { return p.apply(es...) }
+func (p *testProcessor) WithTargets(map[string]interface{}) {}
+func (p *testProcessor) WithLogger(*log.Logger)             {}
+
+func testResponse() *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Prefix: &gnmi.Path{
+					Target: "router1",
+					Elem: []*gnmi.PathElem{
+						{Name: "interfaces"},
+						{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+					},
+				},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "state"}, {Name: "oper-status"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "UP"}},
+					},
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "state"}, {Name: "counters"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: []byte(`{"in-octets":100}`)}},
+					},
+				},
+				Delete: []*gnmi.Path{
+					{Elem: []*gnmi.PathElem{{Name: "state"}, {Name: "description"}}},
+				},
+			},
+		},
+	}
+}
+
+func TestProcessResponse(t *testing.T) {
+	// deletes the oper-status value and renames the counters
+	p := &testProcessor{apply: func(es ...*EventMsg) []*EventMsg {
+		for _, e := range es {
+			delete(e.Values, "/interfaces/interface/state/oper-status")
+			if v, ok := e.Values["/interfaces/interface/state/counters/in-octets"]; ok {
+				delete(e.Values, "/interfaces/interface/state/counters/in-octets")
+				e.Values["/interfaces/interface/in-octets"] = v
+			}
+		}
+		return es
+	}}
+	rsp := testResponse()
+	orig := proto.Clone(rsp)
+	prsp, err := ProcessResponse(rsp, map[string]string{"source": "router1"}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(rsp, orig) {
+		t.Errorf("the original response was modified: %v", rsp)
+	}
+	want := &gnmi.Notification{
+		Timestamp: 42,
+		Prefix:    &gnmi.Path{Target: "router1"},
+		Update: []*gnmi.Update{
+			{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{
+					{Name: "interfaces"},
+					{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+					{Name: "in-octets"},
+				}},
+				Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: []byte("100")}},
+			},
+		},
+		Delete: []*gnmi.Path{
+			{Elem: []*gnmi.PathElem{
+				{Name: "interfaces"},
+				{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+				{Name: "state"},
+				{Name: "description"},
+			}},
+		},
+	}
+	if !proto.Equal(prsp.GetUpdate(), want) {
+		t.Errorf("unexpected notification:\n got: %v\nwant: %v", prsp.GetUpdate(), want)
+	}
+}
+
+func TestProcessResponseDropped(t *testing.T) {
+	drop := &testProcessor{apply: func(es ...*EventMsg) []*EventMsg {
+		return nil
+	}}
+	rsp := testResponse()
+	rsp.GetUpdate().Delete = nil
+	prsp, err := ProcessResponse(rsp, nil, drop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prsp != nil {
+		t.Errorf("expected a nil response, got: %v", prsp)
+	}
+	o := &MarshalOptions{Format: "json"}
+	b, err := o.Marshal(rsp, nil, drop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Errorf("expected an empty message, got: %s", b)
+	}
+}
+
+func TestMarshalProcessors(t *testing.T) {
+	p := &testProcessor{apply: func(es ...*EventMsg) []*EventMsg {
+		for _, e := range es {
+			e.Values["/interfaces/interface/state/admin-status"] = "UP"
+		}
+		return es
+	}}
+	o := &MarshalOptions{Format: "json"}
+	b, err := o.Marshal(testResponse(), map[string]string{"source": "router1"}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(NotificationRspMsg)
+	err = json.Unmarshal(b, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Source != "router1" || msg.Target != "router1" || msg.Prefix != "" {
+		t.Errorf("unexpected message: %s", b)
+	}
+	values := make(map[string]interface{})
+	for _, u := range msg.Updates {
+		for k, v := range u.Values {
+			values[k] = v
+		}
+	}
+	if len(values) != 3 || values["interfaces/interface/state/admin-status"] != "UP" ||
+		values["interfaces/interface/state/counters/in-octets"] != float64(100) {
+		t.Errorf("unexpected values: %v", values)
+	}
+}
+
+func TestMarshalProcessorsTags(t *testing.T) {
+	p := &testProcessor{apply: func(es ...*EventMsg) []*EventMsg {
+		for _, e := range es {
+			e.Tags["site"] = "paris"
+			// named like a key of the interface element but not part of the original path
+			e.Tags["interface_description"] = "uplink"
+		}
+		return es
+	}}
+	meta := map[string]string{"source": "router1", "subscription-name": "sub1"}
+	o := &MarshalOptions{Format: "json"}
+	b, err := o.Marshal(testResponse(), meta, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(NotificationRspMsg)
+	err = json.Unmarshal(b, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Updates) != 2 {
+		t.Fatalf("unexpected message: %s", b)
+	}
+	// the path keys, the target and the meta are not repeated in the tags
+	for _, u := range msg.Updates {
+		if !strings.HasPrefix(u.Path, "interfaces/interface[name=ethernet-1/1]/") {
+			t.Errorf("unexpected update path: %s", u.Path)
+		}
+		if len(u.Tags) != 2 || u.Tags["site"] != "paris" || u.Tags["interface_description"] != "uplink" {
+			t.Errorf("unexpected update tags: %v", u.Tags)
+		}
+	}
+	b, err = o.MarshalEvents([]*EventMsg{
+		{
+			Name:   "sub1",
+			Tags:   map[string]string{"source": "router1", "interface_name": "ethernet-1/1", "site": "paris"},
+			Values: map[string]interface{}{"/interfaces/interface/in-octets": 1},
+		},
+	}, map[string]string{"source": "router1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg = new(NotificationRspMsg)
+	err = json.Unmarshal(b, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the original path is unknown, the tags are not turned into path keys
+	if len(msg.Updates) != 1 || msg.Updates[0].Path != "interfaces/interface/in-octets" ||
+		len(msg.Updates[0].Tags) != 2 || msg.Updates[0].Tags["interface_name"] != "ethernet-1/1" {
+		t.Errorf("unexpected message: %s", b)
+	}
+	if !DropsTags("protojson") || DropsTags("json") {
+		t.Errorf("unexpected formats dropping the tags")
+	}
+}
+
+func TestEventValueToTypedValue(t *testing.T) {
+	for _, v := range []interface{}{"s", true, 1, int64(-1), uint32(1), uint64(1), float32(1.5), []byte("b")} {
+		tv, err := EventValueToTypedValue(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gv, err := getValue(tv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := gv.(map[string]interface{}); ok {
+			t.Errorf("%v: unexpected json value", v)
+		}
+	}
+	tv, err := EventValueToTypedValue(0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gv, err := getValue(tv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gv != 0.1 {
+		t.Errorf("float64: unexpected value %v", gv)
+	}
+}
+
+func TestMarshalEvents(t *testing.T) {
+	evs := []*EventMsg{
+		{
+			Name:      "sub1",
+			Timestamp: 42,
+			Tags:      map[string]string{"source": "router1", "subscription-name": "sub1", "interface_name": "ethernet-1/1"},
+			Values:    map[string]interface{}{"/interfaces/interface/state/counters/in-octets_avg": 10.5},
+		},
+	}
+	meta := EventMeta(evs[0])
+	if len(meta) != 2 || meta["source"] != "router1" || meta["subscription-name"] != "sub1" {
+		t.Errorf("unexpected meta: %v", meta)
+	}
+	o := &MarshalOptions{Format: "event"}
+	b, err := o.MarshalEvents(evs, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mevs := make([]*EventMsg, 0)
+	err = json.Unmarshal(b, &mevs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mevs) != 1 || mevs[0].Tags["interface_name"] != "ethernet-1/1" {
+		t.Errorf("unexpected events: %s", b)
+	}
+	o = &MarshalOptions{Format: "json"}
+	b, err = o.MarshalEvents(evs, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(NotificationRspMsg)
+	err = json.Unmarshal(b, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Source != "router1" || msg.SubscriptionName != "sub1" || msg.Timestamp != 42 ||
+		len(msg.Updates) != 1 || msg.Updates[0].Path != "interfaces/interface/state/counters/in-octets_avg" ||
+		msg.Updates[0].Tags["interface_name"] != "ethernet-1/1" {
+		t.Errorf("unexpected message: %s", b)
+	}
+	b, err = o.MarshalEvents(nil, nil)
+	if err != nil || len(b) != 0 {
+		t.Errorf("expected an empty message, got: %s, %v", b, err)
+	}
+}
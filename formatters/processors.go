@@ -1,8 +1,10 @@
 package formatters
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 
 	"github.com/itchyny/gojq"
@@ -13,16 +15,24 @@ var EventProcessors = map[string]Initializer{}
 
 var EventProcessorTypes = []string{
 	"event-add-tag",
+	"event-aggregate",
 	"event-allow",
+	"event-calc",
 	"event-convert",
 	"event-date-string",
+	"event-dedup",
 	"event-delete",
 	"event-drop",
+	"event-enrich",
 	"event-extract-tags",
 	"event-jq",
+	"event-lookup",
 	"event-merge",
 	"event-override-ts",
+	"event-rate",
+	"event-starlark",
 	"event-strings",
+	"event-switch",
 	"event-to-tag",
 	"event-trigger",
 	"event-write",
@@ -43,6 +53,34 @@ type EventProcessor interface {
 	WithLogger(l *log.Logger)
 }
 
+// EventEmitter is an optional interface implemented by the event processors
+// producing events outside of Apply, e.g: when a time window closes.
+type EventEmitter interface {
+	// WithEmitter sets the function called with the produced events,
+	// the processor stops producing events once ctx is done.
+	WithEmitter(ctx context.Context, fn func([]*EventMsg))
+}
+
+// ConnectEmitters sets the emit function of the event emitters found in the processors chain evps:
+// the events they produce go through the rest of the chain before being passed to fn.
+func ConnectEmitters(ctx context.Context, evps []EventProcessor, fn func([]*EventMsg)) {
+	for i, ep := range evps {
+		em, ok := ep.(EventEmitter)
+		if !ok {
+			continue
+		}
+		next := evps[i+1:]
+		em.WithEmitter(ctx, func(es []*EventMsg) {
+			for _, p := range next {
+				es = p.Apply(es...)
+			}
+			if len(es) > 0 {
+				fn(es)
+			}
+		})
+	}
+}
+
 func DecodeConfig(src, dst interface{}) error {
 	decoder, err := mapstructure.NewDecoder(
 		&mapstructure.DecoderConfig{
@@ -66,6 +104,67 @@ func WithTargets(tcs map[string]interface{}) Option {
 	}
 }
 
+// EventProcessorsUser is an optional interface implemented by the event processors
+// running other processors, e.g: event-switch.
+type EventProcessorsUser interface {
+	// WithProcessors sets the event processors configurations the processor can refer to by name.
+	WithProcessors(map[string]map[string]interface{})
+}
+
+func WithProcessors(eps map[string]map[string]interface{}) Option {
+	return func(p EventProcessor) {
+		if pu, ok := p.(EventProcessorsUser); ok {
+			pu.WithProcessors(eps)
+		}
+	}
+}
+
+// EventProcessorCloser is an optional interface implemented by the event processors
+// holding resources which must be released once they are no longer used, e.g: a file watcher.
+type EventProcessorCloser interface {
+	Close() error
+}
+
+// CloseEventProcessors closes the event processors of evps implementing EventProcessorCloser,
+// it is called by the inputs and outputs when they are closed.
+func CloseEventProcessors(evps []EventProcessor) {
+	for _, ep := range evps {
+		if c, ok := ep.(EventProcessorCloser); ok {
+			c.Close()
+		}
+	}
+}
+
+// MakeEventProcessors initializes the chain of event processors named names, using their configurations from eps.
+func MakeEventProcessors(eps map[string]map[string]interface{}, names []string, logger *log.Logger, tcs map[string]interface{}) ([]EventProcessor, error) {
+	evps := make([]EventProcessor, 0, len(names))
+	for _, epName := range names {
+		epCfg, ok := eps[epName]
+		if !ok {
+			CloseEventProcessors(evps)
+			return nil, fmt.Errorf("%q event processor not found", epName)
+		}
+		epType := ""
+		for k := range epCfg {
+			epType = k
+			break
+		}
+		in, ok := EventProcessors[epType]
+		if !ok {
+			CloseEventProcessors(evps)
+			return nil, fmt.Errorf("%q event processor has an unknown type=%q", epName, epType)
+		}
+		ep := in()
+		err := ep.Init(epCfg[epType], WithLogger(logger), WithTargets(tcs), WithProcessors(eps))
+		if err != nil {
+			CloseEventProcessors(evps)
+			return nil, fmt.Errorf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+		}
+		evps = append(evps, ep)
+	}
+	return evps, nil
+}
+
 func CheckCondition(code *gojq.Code, e *EventMsg) (bool, error) {
 	var res interface{}
 	if code != nil {
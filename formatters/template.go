@@ -0,0 +1,60 @@
+package formatters
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+// ParseMsgTemplate parses the Go template used by the `template` format,
+// the sprig functions are available to the template.
+func ParseMsgTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, fmt.Errorf("format 'template' requires a msg-template")
+	}
+	return template.New("msg-template").
+		Funcs(sprig.TxtFuncMap()).
+		Option("missingkey=zero").
+		Parse(text)
+}
+
+// formatTemplate converts the subscribe response to events,
+// and renders each of them using the message template.
+// The format is events only: the messages without any event,
+// e.g: sync responses or non subscribe responses, render nothing.
+func (o *MarshalOptions) formatTemplate(msg proto.Message, meta map[string]string, eps ...EventProcessor) ([]byte, error) {
+	if o.Template == nil {
+		return nil, fmt.Errorf("format 'template' requires a msg-template")
+	}
+	switch msg := msg.ProtoReflect().Interface().(type) {
+	case *gnmi.SubscribeResponse:
+		subscriptionName, ok := meta["subscription-name"]
+		if !ok {
+			subscriptionName = "default"
+		}
+		events, err := ResponseToEventMsgs(subscriptionName, msg, meta, eps...)
+		if err != nil {
+			return nil, fmt.Errorf("failed converting response to events: %v", err)
+		}
+		return o.renderEvents(events...)
+	default:
+		return nil, nil
+	}
+}
+
+// renderEvents renders the events using the message template,
+// the rendered events are concatenated.
+func (o *MarshalOptions) renderEvents(evs ...*EventMsg) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, ev := range evs {
+		err := o.Template.Execute(buf, ev)
+		if err != nil {
+			return nil, fmt.Errorf("failed rendering msg template: %v", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
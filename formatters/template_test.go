@@ -0,0 +1,75 @@
+package formatters
+
+import (
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestFormatTemplate(t *testing.T) {
+	tpl, err := ParseMsgTemplate(`{{ .Tags.source }} {{ .Tags.interface_name }}
+{{- range $k, $v := .Values }} {{ base $k }}={{ $v }}{{ end }} {{ div .Timestamp 1000000000 }}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mo := &MarshalOptions{Format: "template", Template: tpl}
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 1606824673000000000,
+				Prefix: &gnmi.Path{
+					Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}}},
+				},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "in-octets"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 42}},
+					},
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "oper-state"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "up"}},
+					},
+				},
+			},
+		},
+	}
+	b, err := mo.Marshal(rsp, map[string]string{"source": "router1", "subscription-name": "sub1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "router1 ethernet-1/1 in-octets=42 1606824673\nrouter1 ethernet-1/1 oper-state=up 1606824673\n"
+	if string(b) != want {
+		t.Errorf("got %q, want %q", string(b), want)
+	}
+
+	// sync responses do not produce any event
+	b, err = mo.Marshal(&gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Errorf("unexpected output for a sync response: %q", string(b))
+	}
+	// neither do the other messages
+	b, err = mo.Marshal(&gnmi.CapabilityResponse{GNMIVersion: "0.7.0"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 0 {
+		t.Errorf("unexpected output for a capability response: %q", string(b))
+	}
+}
+
+func TestParseMsgTemplateErrors(t *testing.T) {
+	if _, err := ParseMsgTemplate(""); err == nil {
+		t.Error("expected an error for an empty template")
+	}
+	if _, err := ParseMsgTemplate("{{ .Tags"); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+	mo := &MarshalOptions{Format: "template"}
+	if _, err := mo.Marshal(&gnmi.SubscribeResponse{}, nil); err == nil {
+		t.Error("expected an error without a template")
+	}
+}
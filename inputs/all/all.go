@@ -1,6 +1,7 @@
 package all
 
 import (
+	_ "github.com/karimra/gnmic/inputs/grpc_input"
 	_ "github.com/karimra/gnmic/inputs/kafka_input"
 	_ "github.com/karimra/gnmic/inputs/nats_input"
 	_ "github.com/karimra/gnmic/inputs/stan_input"
@@ -0,0 +1,296 @@
+package grpc_input
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/inputs"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/relay"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	loggingPrefix               = "[grpc_input] "
+	defaultNumWorkers           = 1
+	defaultBufferSize           = 100
+	defaultMaxConcurrentStreams = 256
+)
+
+func init() {
+	inputs.Register("grpc", func() inputs.Input {
+		return &GRPCInput{
+			Cfg:    &Config{},
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			wg:     new(sync.WaitGroup),
+		}
+	})
+}
+
+// GRPCInput receives the messages sent by gnmic grpc outputs
+type GRPCInput struct {
+	relay.UnimplementedRelayServer
+
+	Cfg    *Config
+	ctx    context.Context
+	cfn    context.CancelFunc
+	logger *log.Logger
+
+	server  *grpc.Server
+	msgChan chan *relay.Message
+
+	wg      *sync.WaitGroup
+	outputs []outputs.Output
+	evps    []formatters.EventProcessor
+}
+
+// Config //
+type Config struct {
+	Name string `mapstructure:"name,omitempty"`
+	// listen address
+	Address string `mapstructure:"address,omitempty"`
+	TLSCA   string `mapstructure:"tls-ca,omitempty"`
+	TLSCert string `mapstructure:"tls-cert,omitempty"`
+	TLSKey  string `mapstructure:"tls-key,omitempty"`
+	// plain text connections, without TLS
+	Insecure             bool     `mapstructure:"insecure,omitempty"`
+	MaxMsgSize           int      `mapstructure:"max-msg-size,omitempty"`
+	MaxConcurrentStreams uint32   `mapstructure:"max-concurrent-streams,omitempty"`
+	Debug                bool     `mapstructure:"debug,omitempty"`
+	NumWorkers           int      `mapstructure:"num-workers,omitempty"`
+	BufferSize           int      `mapstructure:"buffer-size,omitempty"`
+	Outputs              []string `mapstructure:"outputs,omitempty"`
+	EventProcessors      []string `mapstructure:"event-processors,omitempty"`
+}
+
+// Start //
+func (g *GRPCInput) Start(ctx context.Context, name string, cfg map[string]interface{}, opts ...inputs.Option) error {
+	err := outputs.DecodeConfig(cfg, g.Cfg)
+	if err != nil {
+		return err
+	}
+	if g.Cfg.Name == "" {
+		g.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	err = g.setDefaults()
+	if err != nil {
+		return err
+	}
+	srvOpts := []grpc.ServerOption{
+		grpc.MaxConcurrentStreams(g.Cfg.MaxConcurrentStreams),
+	}
+	if g.Cfg.MaxMsgSize > 0 {
+		srvOpts = append(srvOpts, grpc.MaxRecvMsgSize(g.Cfg.MaxMsgSize))
+	}
+	if !g.Cfg.Insecure {
+		tlsCfg := &relay.TLSConfig{
+			CA:   g.Cfg.TLSCA,
+			Cert: g.Cfg.TLSCert,
+			Key:  g.Cfg.TLSKey,
+		}
+		tlsConfig, err := tlsCfg.ServerTLS()
+		if err != nil {
+			return err
+		}
+		srvOpts = append(srvOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	l, err := net.Listen("tcp", g.Cfg.Address)
+	if err != nil {
+		return err
+	}
+	g.ctx, g.cfn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(g.ctx, g.evps, func(es []*formatters.EventMsg) {
+		for _, o := range g.outputs {
+			for _, ev := range es {
+				o.WriteEvent(g.ctx, ev)
+			}
+		}
+	})
+	g.logger.Printf("input starting with config: %+v", g.Cfg)
+	g.msgChan = make(chan *relay.Message, g.Cfg.BufferSize)
+	g.wg.Add(g.Cfg.NumWorkers)
+	for i := 0; i < g.Cfg.NumWorkers; i++ {
+		go g.worker(g.ctx, i)
+	}
+	g.server = grpc.NewServer(srvOpts...)
+	relay.RegisterRelayServer(g.server, g)
+	go func() {
+		err := g.server.Serve(l)
+		if err != nil {
+			g.logger.Printf("grpc server stopped: %v", err)
+		}
+	}()
+	go func() {
+		<-g.ctx.Done()
+		g.server.Stop()
+	}()
+	return nil
+}
+
+// Publish implements relay.RelayServer,
+// a stream is not read further while the messages buffer is full.
+func (g *GRPCInput) Publish(stream relay.Relay_PublishServer) error {
+	var peerAddr string
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		peerAddr = p.Addr.String()
+	}
+	g.logger.Printf("received Publish stream from peer=%s", peerAddr)
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			g.logger.Printf("peer=%s closed its Publish stream", peerAddr)
+			return stream.SendAndClose(&relay.PublishResponse{})
+		}
+		if err != nil {
+			g.logger.Printf("peer=%s Publish stream error: %v", peerAddr, err)
+			return err
+		}
+		if g.Cfg.Debug {
+			g.logger.Printf("received msg from peer=%s: %+v", peerAddr, msg)
+		}
+		select {
+		case <-g.ctx.Done():
+			return g.ctx.Err()
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case g.msgChan <- msg:
+		}
+	}
+}
+
+func (g *GRPCInput) worker(ctx context.Context, idx int) {
+	defer g.wg.Done()
+	g.logger.Printf("worker-%d starting", idx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-g.msgChan:
+			if msg.Event != nil {
+				ev, err := msg.Event.EventMsg()
+				if err != nil {
+					g.logger.Printf("failed to convert event: %v", err)
+					continue
+				}
+				evMsgs := []*formatters.EventMsg{ev}
+				for _, p := range g.evps {
+					evMsgs = p.Apply(evMsgs...)
+				}
+				for _, o := range g.outputs {
+					for _, ev := range evMsgs {
+						o.WriteEvent(ctx, ev)
+					}
+				}
+				continue
+			}
+			if msg.Response == nil {
+				continue
+			}
+			if len(g.evps) == 0 {
+				for _, o := range g.outputs {
+					o.Write(ctx, msg.Response, outputs.Meta(msg.Meta))
+				}
+				continue
+			}
+			// the response is converted to events to be processed
+			name, ok := msg.Meta["subscription-name"]
+			if !ok {
+				name = "default"
+			}
+			evMsgs, err := formatters.ResponseToEventMsgs(name, msg.Response, msg.Meta, g.evps...)
+			if err != nil {
+				g.logger.Printf("failed to convert response to events: %v", err)
+				continue
+			}
+			for _, o := range g.outputs {
+				for _, ev := range evMsgs {
+					o.WriteEvent(ctx, ev)
+				}
+			}
+		}
+	}
+}
+
+// Close //
+func (g *GRPCInput) Close() error {
+	defer formatters.CloseEventProcessors(g.evps)
+	g.cfn()
+	g.wg.Wait()
+	return nil
+}
+
+// SetLogger //
+func (g *GRPCInput) SetLogger(logger *log.Logger) {
+	if logger != nil && g.logger != nil {
+		g.logger.SetOutput(logger.Writer())
+		g.logger.SetFlags(logger.Flags())
+	}
+}
+
+// SetOutputs //
+func (g *GRPCInput) SetOutputs(outs map[string]outputs.Output) {
+	if len(g.Cfg.Outputs) == 0 {
+		for _, o := range outs {
+			g.outputs = append(g.outputs, o)
+		}
+		return
+	}
+	for _, name := range g.Cfg.Outputs {
+		if o, ok := outs[name]; ok {
+			g.outputs = append(g.outputs, o)
+		}
+	}
+}
+
+func (g *GRPCInput) SetName(name string) {}
+
+func (g *GRPCInput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]interface{}) {
+	for _, epName := range g.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
+				if err != nil {
+					g.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
+					continue
+				}
+				g.evps = append(g.evps, ep)
+				g.logger.Printf("added event processor %q of type=%q to grpc input", epName, epType)
+			}
+		}
+	}
+}
+
+// helper functions
+
+func (g *GRPCInput) setDefaults() error {
+	if g.Cfg.Address == "" {
+		return fmt.Errorf("missing address")
+	}
+	if g.Cfg.MaxConcurrentStreams == 0 {
+		g.Cfg.MaxConcurrentStreams = defaultMaxConcurrentStreams
+	}
+	if g.Cfg.NumWorkers <= 0 {
+		g.Cfg.NumWorkers = defaultNumWorkers
+	}
+	if g.Cfg.BufferSize <= 0 {
+		g.Cfg.BufferSize = defaultBufferSize
+	}
+	return nil
+}
@@ -22,6 +22,7 @@ var InputTypes = []string{
 	"nats",
 	"stan",
 	"kafka",
+	"grpc",
 }
 
 var Inputs = map[string]Initializer{}
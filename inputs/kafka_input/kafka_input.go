@@ -15,6 +15,7 @@ import (
 	"github.com/damiannolan/sasl/oauthbearer"
 	"github.com/google/uuid"
 	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/formatters/avro"
 	"github.com/karimra/gnmic/inputs"
 	"github.com/karimra/gnmic/outputs"
 	"google.golang.org/protobuf/proto"
@@ -52,24 +53,27 @@ type KafkaInput struct {
 	wg      *sync.WaitGroup
 	outputs []outputs.Output
 	evps    []formatters.EventProcessor
+	// avro format decoder
+	serializer *avro.Serializer
 }
 
 // Config //
 type Config struct {
-	Name              string        `mapstructure:"name,omitempty"`
-	Address           string        `mapstructure:"address,omitempty"`
-	Topics            string        `mapstructure:"topics,omitempty"`
-	SASL              *sasl         `mapstructure:"sasl,omitempty"`
-	GroupID           string        `mapstructure:"group-id,omitempty"`
-	SessionTimeout    time.Duration `mapstructure:"session-timeout,omitempty"`
-	HeartbeatInterval time.Duration `mapstructure:"heartbeat-interval,omitempty"`
-	RecoveryWaitTime  time.Duration `mapstructure:"recovery-wait-time,omitempty"`
-	Version           string        `mapstructure:"version,omitempty"`
-	Format            string        `mapstructure:"format,omitempty"`
-	Debug             bool          `mapstructure:"debug,omitempty"`
-	NumWorkers        int           `mapstructure:"num-workers,omitempty"`
-	Outputs           []string      `mapstructure:"outputs,omitempty"`
-	EventProcessors   []string      `mapstructure:"event-processors,omitempty"`
+	Name              string               `mapstructure:"name,omitempty"`
+	Address           string               `mapstructure:"address,omitempty"`
+	Topics            string               `mapstructure:"topics,omitempty"`
+	SASL              *sasl                `mapstructure:"sasl,omitempty"`
+	GroupID           string               `mapstructure:"group-id,omitempty"`
+	SessionTimeout    time.Duration        `mapstructure:"session-timeout,omitempty"`
+	HeartbeatInterval time.Duration        `mapstructure:"heartbeat-interval,omitempty"`
+	RecoveryWaitTime  time.Duration        `mapstructure:"recovery-wait-time,omitempty"`
+	Version           string               `mapstructure:"version,omitempty"`
+	Format            string               `mapstructure:"format,omitempty"`
+	SchemaRegistry    *avro.RegistryConfig `mapstructure:"schema-registry,omitempty"`
+	Debug             bool                 `mapstructure:"debug,omitempty"`
+	NumWorkers        int                  `mapstructure:"num-workers,omitempty"`
+	Outputs           []string             `mapstructure:"outputs,omitempty"`
+	EventProcessors   []string             `mapstructure:"event-processors,omitempty"`
 
 	kafkaVersion sarama.KafkaVersion
 }
@@ -96,6 +100,13 @@ func (k *KafkaInput) Start(ctx context.Context, name string, cfg map[string]inte
 	if err != nil {
 		return err
 	}
+	formatters.ConnectEmitters(ctx, k.evps, func(es []*formatters.EventMsg) {
+		for _, o := range k.outputs {
+			for _, ev := range es {
+				o.WriteEvent(ctx, ev)
+			}
+		}
+	})
 	k.wg.Add(k.Cfg.NumWorkers)
 	for i := 0; i < k.Cfg.NumWorkers; i++ {
 		go k.worker(ctx, i)
@@ -166,6 +177,26 @@ START:
 					evMsgs = p.Apply(evMsgs...)
 				}
 
+				go func() {
+					for _, o := range k.outputs {
+						for _, ev := range evMsgs {
+							o.WriteEvent(ctx, ev)
+						}
+					}
+				}()
+			case "avro":
+				ev, err := k.serializer.Decode(ctx, m.Value)
+				if err != nil {
+					if k.Cfg.Debug {
+						k.logger.Printf("%s failed to decode avro msg: %v", workerLogPrefix, err)
+					}
+					continue
+				}
+				evMsgs := []*formatters.EventMsg{ev}
+				for _, p := range k.evps {
+					evMsgs = p.Apply(evMsgs...)
+				}
+
 				go func() {
 					for _, o := range k.outputs {
 						for _, ev := range evMsgs {
@@ -198,6 +229,7 @@ START:
 }
 
 func (k *KafkaInput) Close() error {
+	defer formatters.CloseEventProcessors(k.evps)
 	k.cfn()
 	k.wg.Wait()
 	return nil
@@ -245,7 +277,7 @@ func (k *KafkaInput) SetEventProcessors(ps map[string]map[string]interface{}, lo
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					k.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
 					continue
@@ -273,9 +305,16 @@ func (k *KafkaInput) setDefaults() error {
 	if k.Cfg.Format == "" {
 		k.Cfg.Format = defaultFormat
 	}
-	if !(strings.ToLower(k.Cfg.Format) == "event" || strings.ToLower(k.Cfg.Format) == "proto") {
+	if !(strings.ToLower(k.Cfg.Format) == "event" || strings.ToLower(k.Cfg.Format) == "proto" || strings.ToLower(k.Cfg.Format) == "avro") {
 		return fmt.Errorf("unsupported input format")
 	}
+	if strings.ToLower(k.Cfg.Format) == "avro" {
+		k.Cfg.Format = "avro"
+		k.serializer, err = avro.NewSerializer(k.Cfg.SchemaRegistry)
+		if err != nil {
+			return err
+		}
+	}
 	if k.Cfg.Topics == "" {
 		k.Cfg.Topics = defaultTopic
 	}
@@ -86,6 +86,13 @@ func (n *NatsInput) Start(ctx context.Context, name string, cfg map[string]inter
 		return err
 	}
 	n.ctx, n.cfn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(n.ctx, n.evps, func(es []*formatters.EventMsg) {
+		for _, o := range n.outputs {
+			for _, ev := range es {
+				o.WriteEvent(n.ctx, ev)
+			}
+		}
+	})
 	n.logger.Printf("input starting with config: %+v", n.Cfg)
 	n.wg.Add(n.Cfg.NumWorkers)
 	for i := 0; i < n.Cfg.NumWorkers; i++ {
@@ -189,6 +196,7 @@ START:
 
 // Close //
 func (n *NatsInput) Close() error {
+	defer formatters.CloseEventProcessors(n.evps)
 	n.cfn()
 	n.wg.Wait()
 	return nil
@@ -238,7 +246,7 @@ func (n *NatsInput) SetEventProcessors(ps map[string]map[string]interface{}, log
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					n.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
 					continue
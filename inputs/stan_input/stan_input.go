@@ -88,6 +88,13 @@ func (s *StanInput) Start(ctx context.Context, name string, cfg map[string]inter
 		return err
 	}
 	s.ctx, s.cfn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(s.ctx, s.evps, func(es []*formatters.EventMsg) {
+		for _, o := range s.outputs {
+			for _, ev := range es {
+				o.WriteEvent(s.ctx, ev)
+			}
+		}
+	})
 	s.wg.Add(s.Cfg.NumWorkers)
 	for i := 0; i < s.Cfg.NumWorkers; i++ {
 		go s.worker(ctx, i)
@@ -122,6 +129,7 @@ CRCONN:
 }
 
 func (s *StanInput) Close() error {
+	defer formatters.CloseEventProcessors(s.evps)
 	s.cfn()
 	s.wg.Wait()
 	return nil
@@ -169,7 +177,7 @@ func (s *StanInput) SetEventProcessors(ps map[string]map[string]interface{}, log
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					s.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
 					continue
@@ -2,11 +2,18 @@ package all
 
 import (
 	_ "github.com/karimra/gnmic/outputs/file"
+	_ "github.com/karimra/gnmic/outputs/graphite_output"
+	_ "github.com/karimra/gnmic/outputs/grpc_output"
 	_ "github.com/karimra/gnmic/outputs/influxdb_output"
 	_ "github.com/karimra/gnmic/outputs/kafka_output"
+	_ "github.com/karimra/gnmic/outputs/loki_output"
 	_ "github.com/karimra/gnmic/outputs/nats_output"
+	_ "github.com/karimra/gnmic/outputs/opentsdb_output"
+	_ "github.com/karimra/gnmic/outputs/otlp_output"
 	_ "github.com/karimra/gnmic/outputs/prometheus_output"
+	_ "github.com/karimra/gnmic/outputs/sql_output"
 	_ "github.com/karimra/gnmic/outputs/stan_output"
+	_ "github.com/karimra/gnmic/outputs/statsd_output"
 	_ "github.com/karimra/gnmic/outputs/tcp_output"
 	_ "github.com/karimra/gnmic/outputs/udp_output"
 )
@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -22,6 +25,7 @@ const (
 	defaultFormat           = "json"
 	defaultWriteConcurrency = 1000
 	defaultSeparator        = "\n"
+	defaultIdleTimeout      = 5 * time.Minute
 	loggingPrefix           = "[file_output] "
 )
 
@@ -36,31 +40,42 @@ func init() {
 
 // File //
 type File struct {
-	Cfg    *Config
-	file   *os.File
+	Cfg *Config
+	// stdout or stderr
+	file *os.File
+	// disk files indexed by file name
+	m     *sync.Mutex
+	files map[string]*rotatingFile
+	// set by Close, no file is opened afterwards
+	closed bool
+
 	logger *log.Logger
 	mo     *formatters.MarshalOptions
 	sem    *semaphore.Weighted
 	evps   []formatters.EventProcessor
 
-	targetTpl *template.Template
+	targetTpl   *template.Template
+	filenameTpl *template.Template
 }
 
 // Config //
 type Config struct {
-	FileName           string   `mapstructure:"filename,omitempty"`
-	FileType           string   `mapstructure:"file-type,omitempty"`
-	Format             string   `mapstructure:"format,omitempty"`
-	Multiline          bool     `mapstructure:"multiline,omitempty"`
-	Indent             string   `mapstructure:"indent,omitempty"`
-	Separator          string   `mapstructure:"separator,omitempty"`
-	OverrideTimestamps bool     `mapstructure:"override-timestamps,omitempty"`
-	AddTarget          string   `mapstructure:"add-target,omitempty"`
-	TargetTemplate     string   `mapstructure:"target-template,omitempty"`
-	EventProcessors    []string `mapstructure:"event-processors,omitempty"`
-	ConcurrencyLimit   int      `mapstructure:"concurrency-limit,omitempty"`
-	EnableMetrics      bool     `mapstructure:"enable-metrics,omitempty"`
-	Debug              bool     `mapstructure:"debug,omitempty"`
+	FileName           string          `mapstructure:"filename,omitempty"`
+	FileType           string          `mapstructure:"file-type,omitempty"`
+	Format             string          `mapstructure:"format,omitempty"`
+	MsgTemplate        string          `mapstructure:"msg-template,omitempty"`
+	Multiline          bool            `mapstructure:"multiline,omitempty"`
+	Indent             string          `mapstructure:"indent,omitempty"`
+	Separator          string          `mapstructure:"separator,omitempty"`
+	OverrideTimestamps bool            `mapstructure:"override-timestamps,omitempty"`
+	AddTarget          string          `mapstructure:"add-target,omitempty"`
+	TargetTemplate     string          `mapstructure:"target-template,omitempty"`
+	EventProcessors    []string        `mapstructure:"event-processors,omitempty"`
+	ConcurrencyLimit   int             `mapstructure:"concurrency-limit,omitempty"`
+	Rotation           *RotationConfig `mapstructure:"rotation,omitempty"`
+	IdleTimeout        time.Duration   `mapstructure:"idle-timeout,omitempty"`
+	EnableMetrics      bool            `mapstructure:"enable-metrics,omitempty"`
+	Debug              bool            `mapstructure:"debug,omitempty"`
 }
 
 func (f *File) String() string {
@@ -81,7 +96,7 @@ func (f *File) SetEventProcessors(ps map[string]map[string]interface{}, logger *
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					f.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -128,12 +143,9 @@ func (f *File) Init(ctx context.Context, name string, cfg map[string]interface{}
 	case "stderr":
 		f.file = os.Stderr
 	default:
-	CRFILE:
-		f.file, err = os.OpenFile(f.Cfg.FileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		err = f.initFiles(ctx)
 		if err != nil {
-			f.logger.Printf("failed to create file: %v", err)
-			time.Sleep(10 * time.Second)
-			goto CRFILE
+			return err
 		}
 	}
 
@@ -153,12 +165,19 @@ func (f *File) Init(ctx context.Context, name string, cfg map[string]interface{}
 
 	f.sem = semaphore.NewWeighted(int64(f.Cfg.ConcurrencyLimit))
 
+	formatters.WarnDroppedTags(f.logger.Printf, f.Cfg.Format, f.evps)
 	f.mo = &formatters.MarshalOptions{
 		Multiline:  f.Cfg.Multiline,
 		Indent:     f.Cfg.Indent,
 		Format:     f.Cfg.Format,
 		OverrideTS: f.Cfg.OverrideTimestamps,
 	}
+	if f.Cfg.Format == "template" {
+		f.mo.Template, err = formatters.ParseMsgTemplate(f.Cfg.MsgTemplate)
+		if err != nil {
+			return err
+		}
+	}
 	if f.Cfg.TargetTemplate == "" {
 		f.targetTpl = outputs.DefaultTargetTemplate
 	} else if f.Cfg.AddTarget != "" {
@@ -169,6 +188,9 @@ func (f *File) Init(ctx context.Context, name string, cfg map[string]interface{}
 			return err
 		}
 	}
+	formatters.ConnectEmitters(ctx, f.evps, func(es []*formatters.EventMsg) {
+		f.writeEvents(ctx, es)
+	})
 	f.logger.Printf("initialized file output: %s", f.String())
 	go func() {
 		<-ctx.Done()
@@ -192,7 +214,13 @@ func (f *File) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta)
 	}
 	defer f.sem.Release(1)
 
-	NumberOfReceivedMsgs.WithLabelValues(f.file.Name()).Inc()
+	w, fileName, err := f.getWriter(meta)
+	if err != nil {
+		f.logger.Printf("failed to get file writer: %v", err)
+		NumberOfFailWriteMsgs.WithLabelValues("", "filename_error").Inc()
+		return
+	}
+	NumberOfReceivedMsgs.WithLabelValues(fileName).Inc()
 	err = outputs.AddSubscriptionTarget(rsp, meta, f.Cfg.AddTarget, f.targetTpl)
 	if err != nil {
 		f.logger.Printf("failed to add target to the response: %v", err)
@@ -202,27 +230,94 @@ func (f *File) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta)
 		if f.Cfg.Debug {
 			f.logger.Printf("failed marshaling proto msg: %v", err)
 		}
-		NumberOfFailWriteMsgs.WithLabelValues(f.file.Name(), "marshal_error").Inc()
+		NumberOfFailWriteMsgs.WithLabelValues(fileName, "marshal_error").Inc()
+		return
+	}
+	f.write(w, fileName, b)
+}
+
+func (f *File) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	f.writeEvents(ctx, []*formatters.EventMsg{ev})
+}
+
+// writeEvents writes the events emitted by the event processors,
+// the file name template is executed with the first event meta.
+func (f *File) writeEvents(ctx context.Context, evs []*formatters.EventMsg) {
+	if len(evs) == 0 {
+		return
+	}
+	err := f.sem.Acquire(ctx, 1)
+	if errors.Is(err, context.Canceled) {
 		return
 	}
-	n, err := f.file.Write(append(b, []byte(f.Cfg.Separator)...))
+	if err != nil {
+		f.logger.Printf("failed acquiring semaphore: %v", err)
+		return
+	}
+	defer f.sem.Release(1)
+
+	meta := formatters.EventMeta(evs[0])
+	w, fileName, err := f.getWriter(meta)
+	if err != nil {
+		f.logger.Printf("failed to get file writer: %v", err)
+		NumberOfFailWriteMsgs.WithLabelValues("", "filename_error").Inc()
+		return
+	}
+	NumberOfReceivedMsgs.WithLabelValues(fileName).Inc()
+	b, err := f.mo.MarshalEvents(evs, meta)
 	if err != nil {
 		if f.Cfg.Debug {
-			f.logger.Printf("failed to write to file '%s': %v", f.file.Name(), err)
+			f.logger.Printf("failed marshaling events: %v", err)
 		}
-		NumberOfFailWriteMsgs.WithLabelValues(f.file.Name(), "write_error").Inc()
+		NumberOfFailWriteMsgs.WithLabelValues(fileName, "marshal_error").Inc()
 		return
 	}
-	NumberOfWrittenBytes.WithLabelValues(f.file.Name()).Add(float64(n))
-	NumberOfWrittenMsgs.WithLabelValues(f.file.Name()).Inc()
+	f.write(w, fileName, b)
 }
 
-func (f *File) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+// write writes the marshaled message b followed by the separator to w.
+func (f *File) write(w io.Writer, fileName string, b []byte) {
+	// all the updates were dropped by the event processors
+	if len(b) == 0 {
+		return
+	}
+	n, err := w.Write(append(b, []byte(f.Cfg.Separator)...))
+	if err != nil {
+		if f.Cfg.Debug {
+			f.logger.Printf("failed to write to file '%s': %v", fileName, err)
+		}
+		NumberOfFailWriteMsgs.WithLabelValues(fileName, "write_error").Inc()
+		return
+	}
+	NumberOfWrittenBytes.WithLabelValues(fileName).Add(float64(n))
+	NumberOfWrittenMsgs.WithLabelValues(fileName).Inc()
+}
 
 // Close //
 func (f *File) Close() error {
-	f.logger.Printf("closing file '%s' output", f.file.Name())
-	return f.file.Close()
+	defer formatters.CloseEventProcessors(f.evps)
+	if f.file != nil {
+		// stdout and stderr are not closed, they are still used by the process
+		if f.file == os.Stdout || f.file == os.Stderr {
+			return nil
+		}
+		f.logger.Printf("closing file '%s' output", f.file.Name())
+		return f.file.Close()
+	}
+	if f.m == nil {
+		return nil
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.closed = true
+	var err error
+	for name, rf := range f.files {
+		f.logger.Printf("closing file '%s' output", name)
+		if cerr := rf.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return err
 }
 
 // Metrics //
@@ -237,3 +332,108 @@ func (f *File) RegisterMetrics(reg *prometheus.Registry) {
 
 func (f *File) SetName(name string)        {}
 func (f *File) SetClusterName(name string) {}
+
+func (f *File) initFiles(ctx context.Context) error {
+	var err error
+	if f.Cfg.Rotation != nil && f.Cfg.Rotation.MaxSize != "" {
+		f.Cfg.Rotation.maxSize, err = outputs.ParseSize(f.Cfg.Rotation.MaxSize)
+		if err != nil {
+			return fmt.Errorf("invalid rotation max-size %q: %v", f.Cfg.Rotation.MaxSize, err)
+		}
+	}
+	if strings.Contains(f.Cfg.FileName, "{{") {
+		f.filenameTpl, err = template.New("filename").
+			Funcs(outputs.TemplateFuncs).
+			Option("missingkey=zero").
+			Parse(f.Cfg.FileName)
+		if err != nil {
+			return err
+		}
+		if f.Cfg.IdleTimeout == 0 {
+			f.Cfg.IdleTimeout = defaultIdleTimeout
+		}
+	}
+	f.m = new(sync.Mutex)
+	f.files = make(map[string]*rotatingFile)
+	if f.Cfg.IdleTimeout > 0 {
+		go f.closeIdleFiles(ctx)
+	}
+	return nil
+}
+
+// getWriter returns the writer and the file name the message with the given meta is written to.
+// Disk files are opened on the first write.
+func (f *File) getWriter(meta outputs.Meta) (io.Writer, string, error) {
+	if f.file != nil {
+		return f.file, f.file.Name(), nil
+	}
+	fileName := f.Cfg.FileName
+	if f.filenameTpl != nil {
+		// the meta values cannot add directories to the file name
+		safeMeta := make(outputs.Meta, len(meta))
+		for k, v := range meta {
+			safeMeta[k] = sanitizeFileName(v)
+		}
+		sb := new(strings.Builder)
+		err := f.filenameTpl.Execute(sb, safeMeta)
+		if err != nil {
+			return nil, "", err
+		}
+		fileName = sb.String()
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.closed {
+		return nil, "", errClosed
+	}
+	rf, ok := f.files[fileName]
+	if !ok {
+		rf = newRotatingFile(fileName, f.Cfg.Rotation, f.logger)
+		f.files[fileName] = rf
+	}
+	return rf, fileName, nil
+}
+
+// sanitizeFileName replaces the path separators in s with '_',
+// as well as s itself if it is the current or the parent directory.
+func sanitizeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
+
+// closeIdleFiles periodically closes and forgets the files that were not written to for idle-timeout,
+// they are reopened on the next write
+func (f *File) closeIdleFiles(ctx context.Context) {
+	ticker := time.NewTicker(f.Cfg.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			f.m.Lock()
+			for name, rf := range f.files {
+				closed, err := rf.closeIfIdle(now, f.Cfg.IdleTimeout)
+				if closed {
+					delete(f.files, name)
+				}
+				if err != nil {
+					f.logger.Printf("failed to close idle file '%s': %v", name, err)
+					continue
+				}
+				if closed && f.Cfg.Debug {
+					f.logger.Printf("closed idle file '%s'", name)
+				}
+			}
+			f.m.Unlock()
+		}
+	}
+}
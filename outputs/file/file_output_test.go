@@ -0,0 +1,186 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// testResponse returns an update of "counter" with the given value
+func testResponse(v int64) *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "counter"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: v}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestFileWriteEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := outputs.Outputs["file"]().(*File)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = f.Init(ctx, "test", map[string]interface{}{
+		"filename": filepath.Join(dir, "{{ .source }}.json"),
+		"format":   "event",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteEvent(ctx, &formatters.EventMsg{
+		Name:   "sub1",
+		Tags:   map[string]string{"source": "router1"},
+		Values: map[string]interface{}{"counter_avg": 1.5},
+	})
+	f.Close()
+	b, err := ioutil.ReadFile(filepath.Join(dir, "router1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"counter_avg":1.5`) {
+		t.Errorf("unexpected file content: %s", b)
+	}
+}
+
+func TestFileCloseIdleFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := outputs.Outputs["file"]().(*File)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = f.Init(ctx, "test", map[string]interface{}{
+		"filename":     filepath.Join(dir, "{{ .source }}.json"),
+		"format":       "event",
+		"idle-timeout": "100ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, source := range []string{"router1", "router2"} {
+		f.Write(ctx, testResponse(1), outputs.Meta{"source": source})
+	}
+	// the idle files are closed and removed from the output
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.m.Lock()
+		n := len(f.files)
+		f.m.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the idle files to be removed, %d left", n)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	f.Write(ctx, testResponse(2), outputs.Meta{"source": "router1"})
+	b, err := ioutil.ReadFile(filepath.Join(dir, "router1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), "counter") != 2 {
+		t.Errorf("unexpected file content: %s", b)
+	}
+}
+
+func TestFileWriteAfterClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := outputs.Outputs["file"]().(*File)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = f.Init(ctx, "test", map[string]interface{}{
+		"filename": filepath.Join(dir, "{{ .source }}.json"),
+		"format":   "event",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(ctx, testResponse(1), outputs.Meta{"source": "router1"})
+	f.Close()
+	// the files are not reopened
+	for _, source := range []string{"router1", "router2"} {
+		f.Write(ctx, testResponse(2), outputs.Meta{"source": source})
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "router1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), "counter") != 1 {
+		t.Errorf("unexpected write after close: %s", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "router2.json")); !os.IsNotExist(err) {
+		t.Errorf("unexpected file opened after close: %v", err)
+	}
+}
+
+func TestFileNameSanitized(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := outputs.Outputs["file"]().(*File)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = f.Init(ctx, "test", map[string]interface{}{
+		"filename": filepath.Join(dir, "out", "{{ .source }}"),
+		"format":   "event",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, source := range []string{"..", "../../escape", `..\escape`} {
+		_, fileName, err := f.getWriter(outputs.Meta{"source": source})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Dir(fileName) != filepath.Join(dir, "out") {
+			t.Errorf("source %q: file name %q is outside of the output directory", source, fileName)
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := map[string]string{
+		"router1:57400":  "router1:57400",
+		"..":             "_",
+		".":              "_",
+		"..router1":      "..router1",
+		"../../etc/cron": ".._.._etc_cron",
+		`..\windows`:     ".._windows",
+	}
+	for in, want := range tests {
+		if got := sanitizeFileName(in); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package file
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+)
+
+var errClosed = errors.New("file closed")
+
+// RotationConfig //
+type RotationConfig struct {
+	// max size of the file before it gets rotated, e.g: 100MB
+	MaxSize string `mapstructure:"max-size,omitempty" json:"max-size,omitempty"`
+	// rotation interval, the file is rotated at every multiple of the interval
+	Interval time.Duration `mapstructure:"interval,omitempty" json:"interval,omitempty"`
+	// max number of rotated files to keep
+	MaxBackups int `mapstructure:"max-backups,omitempty" json:"max-backups,omitempty"`
+	// max age of the rotated files to keep
+	MaxAge time.Duration `mapstructure:"max-age,omitempty" json:"max-age,omitempty"`
+	// gzip compress the rotated files
+	Compress bool `mapstructure:"compress,omitempty" json:"compress,omitempty"`
+
+	maxSize int64
+}
+
+// rotatingFile is an io.WriteCloser writing to a disk file.
+// The file is opened on the first write and rotated based on its size and/or a time interval.
+type rotatingFile struct {
+	name   string
+	cfg    *RotationConfig
+	logger *log.Logger
+
+	m            *sync.Mutex
+	file         *os.File
+	size         int64
+	nextRotation time.Time
+	lastWrite    time.Time
+	// set once the file is closed for being idle,
+	// the output no longer references it.
+	idle bool
+	// set by Close, the file is not reopened
+	closed bool
+
+	// serializes the compression and deletion of backups
+	millMu *sync.Mutex
+}
+
+func newRotatingFile(name string, cfg *RotationConfig, logger *log.Logger) *rotatingFile {
+	if cfg == nil {
+		cfg = &RotationConfig{}
+	}
+	return &rotatingFile{
+		name:   name,
+		cfg:    cfg,
+		logger: logger,
+		m:      new(sync.Mutex),
+		millMu: new(sync.Mutex),
+	}
+}
+
+func (r *rotatingFile) Write(b []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.closed {
+		return 0, errClosed
+	}
+	now := time.Now()
+	if r.file == nil {
+		err := r.open(now)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if r.shouldRotate(now, int64(len(b))) {
+		err := r.rotate(now)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(b)
+	r.size += int64(n)
+	r.lastWrite = now
+	// a write racing with the idle close is not lost,
+	// the file is closed right after it since nothing else will close it.
+	if r.idle {
+		if cerr := r.close(); err == nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.closed = true
+	return r.close()
+}
+
+// closeIfIdle closes the file if it was not written to for the given duration,
+// the caller is expected to drop the file once closed.
+func (r *rotatingFile) closeIfIdle(now time.Time, timeout time.Duration) (bool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.file == nil || now.Sub(r.lastWrite) < timeout {
+		return false, nil
+	}
+	r.idle = true
+	return true, r.close()
+}
+
+func (r *rotatingFile) close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// open opens the file in append mode, creating it and its directory if needed.
+// the next time based rotation is computed from the file modification time
+// so that a file reopened after the end of its interval gets rotated.
+func (r *rotatingFile) open(now time.Time) error {
+	err := os.MkdirAll(filepath.Dir(r.name), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = fi.Size()
+	r.lastWrite = now
+	if r.cfg.Interval > 0 {
+		start := now
+		if r.size > 0 {
+			start = fi.ModTime()
+		}
+		r.nextRotation = start.Truncate(r.cfg.Interval).Add(r.cfg.Interval)
+	}
+	return nil
+}
+
+func (r *rotatingFile) shouldRotate(now time.Time, n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.cfg.maxSize > 0 && r.size+n > r.cfg.maxSize {
+		return true
+	}
+	return r.cfg.Interval > 0 && !now.Before(r.nextRotation)
+}
+
+// rotate renames the current file to a timestamped backup and opens a new file
+func (r *rotatingFile) rotate(now time.Time) error {
+	err := r.close()
+	if err != nil {
+		return err
+	}
+	backup := r.backupName(now)
+	err = os.Rename(r.name, backup)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = r.open(now)
+	if err != nil {
+		return err
+	}
+	go r.mill(backup)
+	return nil
+}
+
+// backupName returns the rotated file name: for a file /path/name.ext
+// rotated at time t it is /path/name-<t>.ext
+func (r *rotatingFile) backupName(t time.Time) string {
+	dir, prefix, ext := r.nameParts()
+	return filepath.Join(dir, prefix+t.Format(backupTimeFormat)+ext)
+}
+
+func (r *rotatingFile) nameParts() (string, string, string) {
+	dir := filepath.Dir(r.name)
+	base := filepath.Base(r.name)
+	ext := filepath.Ext(base)
+	return dir, strings.TrimSuffix(base, ext) + "-", ext
+}
+
+// mill compresses the new backup if configured to,
+// then deletes the backups exceeding max-backups or max-age.
+func (r *rotatingFile) mill(backup string) {
+	r.millMu.Lock()
+	defer r.millMu.Unlock()
+	if r.cfg.Compress {
+		err := compressFile(backup)
+		if err != nil {
+			r.logger.Printf("failed to compress file %q: %v", backup, err)
+		}
+	}
+	if r.cfg.MaxBackups <= 0 && r.cfg.MaxAge <= 0 {
+		return
+	}
+	backups, err := r.backups()
+	if err != nil {
+		r.logger.Printf("failed to list rotated files of %q: %v", r.name, err)
+		return
+	}
+	now := time.Now()
+	for i, b := range backups {
+		if (r.cfg.MaxBackups > 0 && i >= r.cfg.MaxBackups) ||
+			(r.cfg.MaxAge > 0 && now.Sub(b.ts) > r.cfg.MaxAge) {
+			err = os.Remove(b.name)
+			if err != nil && !os.IsNotExist(err) {
+				r.logger.Printf("failed to remove rotated file %q: %v", b.name, err)
+			}
+		}
+	}
+}
+
+type backupFile struct {
+	name string
+	ts   time.Time
+}
+
+// backups returns the rotated files, newest first
+func (r *rotatingFile) backups() ([]backupFile, error) {
+	dir, prefix, ext := r.nameParts()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]backupFile, 0)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), compressSuffix)
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		ts, err := time.ParseInLocation(backupTimeFormat,
+			strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{name: filepath.Join(dir, e.Name()), ts: ts})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ts.After(backups[j].ts)
+	})
+	return backups, nil
+}
+
+// compressFile gzips file name into name.gz and removes the original file
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+compressSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + compressSuffix)
+		return fmt.Errorf("failed to compress: %v", err)
+	}
+	src.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,174 @@
+package file
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRotatingFile(t *testing.T, cfg *RotationConfig) (*rotatingFile, string) {
+	dir, err := ioutil.TempDir("", "gnmic-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newRotatingFile(filepath.Join(dir, "out.json"), cfg, log.New(ioutil.Discard, "", 0)), dir
+}
+
+// waitFiles waits for the rotated files background processing
+// and returns the directory content
+func waitFiles(t *testing.T, r *rotatingFile, dir string) []string {
+	time.Sleep(50 * time.Millisecond)
+	r.millMu.Lock()
+	defer r.millMu.Unlock()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestRotatingFileMaxSize(t *testing.T) {
+	r, dir := testRotatingFile(t, &RotationConfig{maxSize: 20, MaxBackups: 2})
+	defer os.RemoveAll(dir)
+	defer r.Close()
+	for i := 0; i < 5; i++ {
+		_, err := r.Write([]byte("0123456789\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// distinct backup names
+		time.Sleep(2 * time.Millisecond)
+	}
+	names := waitFiles(t, r, dir)
+	// current file + 2 backups
+	if len(names) != 3 {
+		t.Fatalf("expected 3 files, got %v", names)
+	}
+	b, err := ioutil.ReadFile(r.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "0123456789\n" {
+		t.Fatalf("unexpected current file content: %q", string(b))
+	}
+	for _, n := range names {
+		if n != "out.json" && !(strings.HasPrefix(n, "out-") && strings.HasSuffix(n, ".json")) {
+			t.Errorf("unexpected rotated file name %q", n)
+		}
+	}
+}
+
+func TestRotatingFileCompress(t *testing.T) {
+	r, dir := testRotatingFile(t, &RotationConfig{maxSize: 10, Compress: true})
+	defer os.RemoveAll(dir)
+	defer r.Close()
+	r.Write([]byte("first line\n"))
+	r.Write([]byte("second line\n"))
+	names := waitFiles(t, r, dir)
+	if len(names) != 2 {
+		t.Fatalf("expected 2 files, got %v", names)
+	}
+	var gz string
+	for _, n := range names {
+		if strings.HasSuffix(n, ".json.gz") {
+			gz = n
+		}
+	}
+	if gz == "" {
+		t.Fatalf("compressed file not found: %v", names)
+	}
+	f, err := os.Open(filepath.Join(dir, gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "first line\n" {
+		t.Fatalf("unexpected compressed content: %q", string(b))
+	}
+}
+
+func TestRotatingFileInterval(t *testing.T) {
+	r, dir := testRotatingFile(t, &RotationConfig{Interval: time.Hour})
+	defer os.RemoveAll(dir)
+	r.Write([]byte("old\n"))
+	r.Close()
+	// the file was last modified in a previous interval
+	old := time.Now().Add(-2 * time.Hour)
+	err := os.Chtimes(r.name, old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reopened, e.g: after a restart
+	r = newRotatingFile(r.name, r.cfg, r.logger)
+	defer r.Close()
+	r.Write([]byte("new\n"))
+	names := waitFiles(t, r, dir)
+	if len(names) != 2 {
+		t.Fatalf("expected the reopened file to be rotated, got %v", names)
+	}
+	b, err := ioutil.ReadFile(r.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "new\n" {
+		t.Fatalf("unexpected current file content: %q", string(b))
+	}
+}
+
+func TestRotatingFileCloseIfIdle(t *testing.T) {
+	r, dir := testRotatingFile(t, nil)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+	r.Write([]byte("a\n"))
+	closed, err := r.closeIfIdle(time.Now(), time.Minute)
+	if err != nil || closed {
+		t.Fatalf("expected the file to stay open: closed=%v, err=%v", closed, err)
+	}
+	closed, err = r.closeIfIdle(time.Now().Add(2*time.Minute), time.Minute)
+	if err != nil || !closed {
+		t.Fatalf("expected the file to be closed: closed=%v, err=%v", closed, err)
+	}
+	// reopened on write, then closed since the file is idle
+	r.Write([]byte("b\n"))
+	if r.file != nil {
+		t.Errorf("expected the idle file to be closed after the write")
+	}
+	b, err := ioutil.ReadFile(r.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "a\nb\n" {
+		t.Fatalf("unexpected file content: %q", string(b))
+	}
+}
+
+func TestRotatingFileWriteAfterClose(t *testing.T) {
+	r, dir := testRotatingFile(t, nil)
+	defer os.RemoveAll(dir)
+	r.Write([]byte("a\n"))
+	r.Close()
+	_, err := r.Write([]byte("b\n"))
+	if err != errClosed {
+		t.Errorf("expected %v, got %v", errClosed, err)
+	}
+	if r.file != nil {
+		t.Errorf("expected the file to stay closed")
+	}
+}
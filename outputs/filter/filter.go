@@ -0,0 +1,230 @@
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/mitchellh/mapstructure"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// Config is the output filter configuration,
+// set under the `filter` field of an output.
+type Config struct {
+	// path prefixes, the elements may contain glob patterns, e.g: /interfaces/interface/*/counters
+	Paths []string `mapstructure:"paths,omitempty" json:"paths,omitempty"`
+	// tag name to regular expression, matched against the update event tags
+	Tags map[string]string `mapstructure:"tags,omitempty" json:"tags,omitempty"`
+	// jq condition evaluated against the update event
+	Condition string `mapstructure:"condition,omitempty" json:"condition,omitempty"`
+}
+
+// Filter selects the updates and deletes of a subscribe response
+// that are handed over to an output.
+// An update is kept if its path starts with one of the paths,
+// and if its event matches all the tags and the condition.
+type Filter struct {
+	Cfg *Config
+
+	paths [][]string
+	tags  map[string]*regexp.Regexp
+	code  *gojq.Code
+}
+
+// Enabled returns true if the output configuration contains a filter section
+func Enabled(cfg map[string]interface{}) bool {
+	fcfg, ok := cfg["filter"]
+	return ok && fcfg != nil
+}
+
+// New creates a Filter from the `filter` section of an output configuration
+func New(cfg map[string]interface{}) (*Filter, error) {
+	f := &Filter{Cfg: new(Config)}
+	err := mapstructure.Decode(cfg["filter"], f.Cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range f.Cfg.Paths {
+		elems := splitPath(p)
+		for _, e := range elems {
+			// validate the pattern
+			if _, err := path.Match(e, ""); err != nil {
+				return nil, fmt.Errorf("invalid path %q: %v", p, err)
+			}
+		}
+		f.paths = append(f.paths, elems)
+	}
+	f.tags = make(map[string]*regexp.Regexp, len(f.Cfg.Tags))
+	for k, v := range f.Cfg.Tags {
+		f.tags[k], err = regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag %q regex: %v", k, err)
+		}
+	}
+	f.Cfg.Condition = strings.TrimSpace(f.Cfg.Condition)
+	if f.Cfg.Condition != "" {
+		q, err := gojq.Parse(f.Cfg.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition: %v", err)
+		}
+		f.code, err = gojq.Compile(q)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition: %v", err)
+		}
+	}
+	if len(f.paths) == 0 && len(f.tags) == 0 && f.code == nil {
+		return nil, errors.New("empty filter")
+	}
+	return f, nil
+}
+
+// Apply returns a subscribe response containing the updates and deletes of rsp matching the filter,
+// or nil if none of them matches.
+// rsp is not modified, responses other than notifications are returned as is.
+func (f *Filter) Apply(name string, rsp *gnmi.SubscribeResponse, meta map[string]string) *gnmi.SubscribeResponse {
+	notif := rsp.GetUpdate()
+	if notif == nil {
+		return rsp
+	}
+	prefix := pathElems(notif.GetPrefix())
+	fnotif := &gnmi.Notification{
+		Timestamp: notif.GetTimestamp(),
+		Prefix:    notif.GetPrefix(),
+		Alias:     notif.GetAlias(),
+		Atomic:    notif.GetAtomic(),
+	}
+	for _, upd := range notif.GetUpdate() {
+		if !f.matchPath(prefix, upd.GetPath()) {
+			continue
+		}
+		single := &gnmi.Notification{
+			Timestamp: notif.GetTimestamp(),
+			Prefix:    notif.GetPrefix(),
+			Update:    []*gnmi.Update{upd},
+		}
+		if !f.matchEvents(name, single, meta) {
+			continue
+		}
+		fnotif.Update = append(fnotif.Update, upd)
+	}
+	for _, del := range notif.GetDelete() {
+		if !f.matchPath(prefix, del) {
+			continue
+		}
+		single := &gnmi.Notification{
+			Timestamp: notif.GetTimestamp(),
+			Prefix:    notif.GetPrefix(),
+			Delete:    []*gnmi.Path{del},
+		}
+		if !f.matchEvents(name, single, meta) {
+			continue
+		}
+		fnotif.Delete = append(fnotif.Delete, del)
+	}
+	if len(fnotif.Update) == 0 && len(fnotif.Delete) == 0 {
+		return nil
+	}
+	if len(fnotif.Update) == len(notif.GetUpdate()) && len(fnotif.Delete) == len(notif.GetDelete()) {
+		return rsp
+	}
+	return &gnmi.SubscribeResponse{
+		Response:  &gnmi.SubscribeResponse_Update{Update: fnotif},
+		Extension: rsp.GetExtension(),
+	}
+}
+
+// matchPath returns true if the prefix and p elements start with one of the filter paths
+func (f *Filter) matchPath(prefix []string, p *gnmi.Path) bool {
+	if len(f.paths) == 0 {
+		return true
+	}
+	elems := append(prefix[:len(prefix):len(prefix)], pathElems(p)...)
+OUTER:
+	for _, fp := range f.paths {
+		if len(fp) > len(elems) {
+			continue
+		}
+		for i, pattern := range fp {
+			if ok, _ := path.Match(pattern, elems[i]); !ok {
+				continue OUTER
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// matchEvents converts the single update or delete notification to events,
+// and returns true if one of them matches the tags and the condition.
+func (f *Filter) matchEvents(name string, notif *gnmi.Notification, meta map[string]string) bool {
+	if len(f.tags) == 0 && f.code == nil {
+		return true
+	}
+	evs, err := formatters.ResponseToEventMsgs(name,
+		&gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: notif}},
+		meta)
+	if err != nil {
+		return false
+	}
+	for _, ev := range evs {
+		if f.matchEvent(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchEvent(ev *formatters.EventMsg) bool {
+	for k, re := range f.tags {
+		v, ok := ev.Tags[k]
+		if !ok || !re.MatchString(v) {
+			return false
+		}
+	}
+	if f.code == nil {
+		return true
+	}
+	ok, err := formatters.CheckCondition(f.code, ev)
+	return err == nil && ok
+}
+
+// splitPath splits an xpath into its element names, the keys are ignored
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	elems := make([]string, 0)
+	sb := new(strings.Builder)
+	inKey := false
+	for _, r := range p {
+		switch {
+		case r == '[':
+			inKey = true
+		case r == ']':
+			inKey = false
+		case inKey:
+		case r == '/':
+			elems = append(elems, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return append(elems, sb.String())
+}
+
+func pathElems(p *gnmi.Path) []string {
+	elems := make([]string, 0, len(p.GetElem()))
+	for _, e := range p.GetElem() {
+		if e.GetName() != "" {
+			elems = append(elems, e.GetName())
+		}
+	}
+	return elems
+}
@@ -0,0 +1,163 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+func intfPath(name, leaf string) *gnmi.Path {
+	return &gnmi.Path{
+		Elem: []*gnmi.PathElem{
+			{Name: "srl_nokia-interfaces:interface", Key: map[string]string{"name": name}},
+			{Name: "statistics"},
+			{Name: leaf},
+		},
+	}
+}
+
+func testResponse() *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Update: []*gnmi.Update{
+					{
+						Path: intfPath("ethernet-1/1", "in-octets"),
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 100}},
+					},
+					{
+						Path: intfPath("ethernet-1/2", "in-octets"),
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 200}},
+					},
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "router1"}},
+					},
+				},
+				Delete: []*gnmi.Path{intfPath("ethernet-1/3", "in-octets")},
+			},
+		},
+	}
+}
+
+var testMeta = map[string]string{
+	"source":            "router1:57400",
+	"subscription-name": "sub1",
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         map[string]interface{}
+		wantUpdates []uint64
+		wantDeletes int
+		wantNil     bool
+	}{
+		{
+			name: "path_glob",
+			cfg: map[string]interface{}{
+				"paths": []string{"/*:interface[name=*]/statistics"},
+			},
+			wantUpdates: []uint64{100, 200},
+			wantDeletes: 1,
+		},
+		{
+			name: "path_no_match",
+			cfg: map[string]interface{}{
+				"paths": []string{"/network-instance"},
+			},
+			wantNil: true,
+		},
+		{
+			name: "tags",
+			cfg: map[string]interface{}{
+				"tags": map[string]string{"interface_name": `^ethernet-1/2$`},
+			},
+			wantUpdates: []uint64{200},
+		},
+		{
+			name: "meta_tags",
+			cfg: map[string]interface{}{
+				"tags": map[string]string{"source": `^router1`},
+			},
+			wantUpdates: []uint64{100, 200, 0},
+			wantDeletes: 1,
+		},
+		{
+			name: "condition",
+			cfg: map[string]interface{}{
+				"condition": `any(.values[]; type == "number" and . > 150)`,
+			},
+			wantUpdates: []uint64{200},
+		},
+		{
+			name: "path_and_condition",
+			cfg: map[string]interface{}{
+				"paths":     []string{"/system"},
+				"condition": `.tags.source == "router1:57400"`,
+			},
+			wantUpdates: []uint64{0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := New(map[string]interface{}{"filter": tt.cfg})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsp := testResponse()
+			orig := proto.Clone(rsp)
+			got := f.Apply("sub1", rsp, testMeta)
+			if !proto.Equal(rsp, orig) {
+				t.Errorf("the original response was modified")
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("expected a nil response, got %v", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("unexpected nil response")
+			}
+			upds := got.GetUpdate().GetUpdate()
+			if len(upds) != len(tt.wantUpdates) {
+				t.Fatalf("got %d updates, expected %d", len(upds), len(tt.wantUpdates))
+			}
+			for i, upd := range upds {
+				if upd.GetVal().GetUintVal() != tt.wantUpdates[i] {
+					t.Errorf("update %d: got value %v, expected %d", i, upd.GetVal(), tt.wantUpdates[i])
+				}
+			}
+			if len(got.GetUpdate().GetDelete()) != tt.wantDeletes {
+				t.Errorf("got %d deletes, expected %d", len(got.GetUpdate().GetDelete()), tt.wantDeletes)
+			}
+		})
+	}
+}
+
+func TestApplySync(t *testing.T) {
+	f, err := New(map[string]interface{}{"filter": map[string]interface{}{"paths": []string{"/system"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp := &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}}
+	if got := f.Apply("sub1", rsp, testMeta); got != rsp {
+		t.Errorf("sync response not passed through: %v", got)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for name, cfg := range map[string]interface{}{
+		"empty":     map[string]interface{}{},
+		"bad_glob":  map[string]interface{}{"paths": []string{"/interface/a\\"}},
+		"bad_regex": map[string]interface{}{"tags": map[string]string{"source": "("}},
+		"bad_jq":    map[string]interface{}{"condition": ".tags |"},
+	} {
+		if _, err := New(map[string]interface{}{"filter": cfg}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
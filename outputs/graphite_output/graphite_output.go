@@ -0,0 +1,272 @@
+package graphite_output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/outputs/socket"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultAddress        = "localhost:2003"
+	defaultNetwork        = "tcp"
+	defaultMetricTemplate = `{{ .Name }}{{ range .Tags }}.{{ . }}{{ end }}.{{ .Path }}`
+
+	loggingPrefix = "[graphite_output] "
+)
+
+func init() {
+	outputs.Register("graphite", func() outputs.Output {
+		return &GraphiteOutput{
+			Cfg:    &Config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+// GraphiteOutput writes event values as Graphite plaintext protocol lines:
+// <metric path> <value> <timestamp>
+type GraphiteOutput struct {
+	Cfg      *Config
+	logger   *log.Logger
+	cancelFn context.CancelFunc
+	done     <-chan struct{}
+	wg       *sync.WaitGroup
+	evps     []formatters.EventProcessor
+	bw       *socket.BatchWriter
+
+	metricTpl *template.Template
+	targetTpl *template.Template
+}
+
+// Config //
+type Config struct {
+	// Graphite server address, host:port
+	Address string `mapstructure:"address,omitempty"`
+	// tcp or udp
+	Network string `mapstructure:"network,omitempty"`
+	// string prepended to the metric paths
+	Prefix string `mapstructure:"prefix,omitempty"`
+	// Go template executed with each value to produce its metric path
+	MetricTemplate     string        `mapstructure:"metric-template,omitempty"`
+	BatchSize          int           `mapstructure:"batch-size,omitempty"`
+	BufferSize         int           `mapstructure:"buffer-size,omitempty"`
+	FlushTimer         time.Duration `mapstructure:"flush-timer,omitempty"`
+	RetryTimer         time.Duration `mapstructure:"retry-timer,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
+}
+
+func (g *GraphiteOutput) String() string {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (g *GraphiteOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && g.logger != nil {
+		g.logger.SetOutput(logger.Writer())
+		g.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (g *GraphiteOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]interface{}) {
+	for _, epName := range g.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
+				if err != nil {
+					g.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				g.evps = append(g.evps, ep)
+				g.logger.Printf("added event processor '%s' of type=%s to graphite output", epName, epType)
+				continue
+			}
+			g.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		g.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (g *GraphiteOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, g.Cfg)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.Cfg.Address == "" {
+		g.Cfg.Address = defaultAddress
+	}
+	if g.Cfg.Network == "" {
+		g.Cfg.Network = defaultNetwork
+	}
+	if g.Cfg.Network != "tcp" && g.Cfg.Network != "udp" {
+		return fmt.Errorf("unsupported network %q", g.Cfg.Network)
+	}
+	if g.Cfg.MetricTemplate == "" {
+		g.Cfg.MetricTemplate = defaultMetricTemplate
+	}
+	if g.Cfg.TargetTemplate == "" {
+		g.targetTpl = outputs.DefaultTargetTemplate
+	} else if g.Cfg.AddTarget != "" {
+		g.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(g.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	g.metricTpl, err = template.New("metric-template").
+		Funcs(outputs.TemplateFuncs).
+		Option("missingkey=zero").
+		Parse(g.Cfg.MetricTemplate)
+	if err != nil {
+		return err
+	}
+	g.bw = socket.NewBatchWriter(
+		socket.NewWriter(g.Cfg.Network, g.Cfg.Address, socket.DefaultDialTimeout),
+		socket.BatchConfig{
+			Name:       name,
+			BufferSize: g.Cfg.BufferSize,
+			BatchSize:  g.Cfg.BatchSize,
+			FlushTimer: g.Cfg.FlushTimer,
+			RetryTimer: g.Cfg.RetryTimer,
+		},
+		g.encode,
+		g.logger,
+	)
+	ctx, g.cancelFn = context.WithCancel(ctx)
+	g.done = ctx.Done()
+	formatters.ConnectEmitters(ctx, g.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			g.WriteEvent(ctx, ev)
+		}
+	})
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.bw.Run(ctx)
+	}()
+	g.logger.Printf("initialized graphite output: %s", g.String())
+	go func() {
+		<-ctx.Done()
+		g.Close()
+	}()
+	return nil
+}
+
+func (g *GraphiteOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, g.Cfg.AddTarget, g.targetTpl)
+	if err != nil {
+		g.logger.Printf("failed to add target to the response: %v", err)
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		name := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			name = subName
+		}
+		events, err := formatters.ResponseToEventMsgs(name, rsp, meta, g.evps...)
+		if err != nil {
+			g.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			g.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (g *GraphiteOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if g.bw == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-g.done:
+	default:
+		if !g.bw.Send(ev) && g.Cfg.Debug {
+			g.logger.Printf("buffer full, dropped event: %s", ev.Name)
+		}
+	}
+}
+
+func (g *GraphiteOutput) Close() error {
+	defer formatters.CloseEventProcessors(g.evps)
+	if g.cancelFn != nil {
+		g.cancelFn()
+	}
+	g.wg.Wait()
+	g.logger.Printf("closed.")
+	return nil
+}
+
+func (g *GraphiteOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if err := socket.RegisterMetrics(reg); err != nil {
+		g.logger.Printf("failed to register metrics: %v", err)
+	}
+}
+
+func (g *GraphiteOutput) SetName(name string)        {}
+func (g *GraphiteOutput) SetClusterName(name string) {}
+
+// Healthy implements outputs.HealthChecker
+func (g *GraphiteOutput) Healthy() bool {
+	return g.bw != nil && g.bw.Healthy()
+}
+
+// encode appends a plaintext line per numeric value of the event to buf
+func (g *GraphiteOutput) encode(buf *bytes.Buffer, ev *formatters.EventMsg) (int, error) {
+	ts := socket.Timestamp(ev, g.Cfg.OverrideTimestamps).Unix()
+	n := 0
+	for _, vn := range socket.ValueNames(ev) {
+		f, ok := socket.Float(ev.Values[vn])
+		if !ok {
+			if g.Cfg.Debug {
+				g.logger.Printf("skipping non numeric value %q: %v", vn, ev.Values[vn])
+			}
+			continue
+		}
+		path, err := socket.MetricName(g.metricTpl, socket.NewMetricData(ev, vn, socket.Clean))
+		if err != nil {
+			return n, err
+		}
+		if g.Cfg.Prefix != "" {
+			path = g.Cfg.Prefix + "." + path
+		}
+		fmt.Fprintf(buf, "%s %s %d\n", path, socket.FormatFloat(f), ts)
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,108 @@
+package graphite_output
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+)
+
+var testEvent = &formatters.EventMsg{
+	Name:      "sub1",
+	Timestamp: 1606824673847153523,
+	Tags: map[string]string{
+		"source":         "router1",
+		"interface_name": "ethernet-1/1",
+	},
+	Values: map[string]interface{}{
+		"/interface/statistics/in-octets": uint64(42),
+		"/interface/oper-state":           "up",
+		"/interface/load":                 "0.5",
+	},
+}
+
+func TestEncode(t *testing.T) {
+	tests := map[string]struct {
+		cfg  map[string]interface{}
+		want string
+	}{
+		"default": {
+			cfg: map[string]interface{}{},
+			want: "sub1.ethernet-1_1.router1.interface.load 0.5 1606824673\n" +
+				"sub1.ethernet-1_1.router1.interface.statistics.in-octets 42 1606824673\n",
+		},
+		"prefix_and_template": {
+			cfg: map[string]interface{}{
+				"prefix":          "gnmic",
+				"metric-template": "{{ .Tags.source }}.{{ .Tags.interface_name }}.{{ .Path }}",
+			},
+			want: "gnmic.router1.ethernet-1_1.interface.load 0.5 1606824673\n" +
+				"gnmic.router1.ethernet-1_1.interface.statistics.in-octets 42 1606824673\n",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			g := outputs.Outputs["graphite"]().(*GraphiteOutput)
+			err := g.Init(context.Background(), name, tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer g.Close()
+			buf := new(bytes.Buffer)
+			n, err := g.encode(buf, testEvent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 2 {
+				t.Errorf("expected 2 lines, got %d", n)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestGraphiteOutput(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	g := outputs.Outputs["graphite"]().(*GraphiteOutput)
+	err = g.Init(context.Background(), "graphite1", map[string]interface{}{
+		"address":     l.Addr().String(),
+		"flush-timer": "10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	g.WriteEvent(context.Background(), testEvent)
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []string{
+		"sub1.ethernet-1_1.router1.interface.load 0.5 1606824673\n",
+		"sub1.ethernet-1_1.router1.interface.statistics.in-octets 42 1606824673\n",
+	} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != want {
+			t.Errorf("got %q, want %q", line, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package grpc_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var GRPCOutputNumberOfSentMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "grpc_output",
+	Name:      "number_of_grpc_msgs_sent_success_total",
+	Help:      "Number of msgs successfully sent by gnmic grpc output",
+}, []string{"name"})
+
+var GRPCOutputNumberOfFailedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "grpc_output",
+	Name:      "number_of_grpc_msgs_sent_fail_total",
+	Help:      "Number of msgs gnmic grpc output failed to send",
+}, []string{"name"})
+
+var GRPCOutputNumberOfReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "grpc_output",
+	Name:      "number_of_reconnects_total",
+	Help:      "Number of times gnmic grpc output reconnected to the grpc input",
+}, []string{"name"})
+
+func initMetrics() {
+	GRPCOutputNumberOfSentMsgs.WithLabelValues("").Add(0)
+	GRPCOutputNumberOfFailedMsgs.WithLabelValues("").Add(0)
+	GRPCOutputNumberOfReconnects.WithLabelValues("").Add(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(GRPCOutputNumberOfSentMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(GRPCOutputNumberOfFailedMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(GRPCOutputNumberOfReconnects); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package grpc_output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/relay"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultBufferSize = 1000
+	defaultTimeout    = 10 * time.Second
+	defaultRetryTimer = 2 * time.Second
+	defaultFormat     = "proto"
+
+	loggingPrefix = "[grpc_output] "
+)
+
+func init() {
+	outputs.Register("grpc", func() outputs.Output {
+		return &GRPCOutput{
+			Cfg:    &Config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+// GRPCOutput forwards subscribe responses or events to a gnmic grpc input
+type GRPCOutput struct {
+	Cfg      *Config
+	logger   *log.Logger
+	cancelFn context.CancelFunc
+	done     <-chan struct{}
+	msgChan  chan *relay.Message
+	wg       *sync.WaitGroup
+	healthy  int32
+	evps     []formatters.EventProcessor
+
+	targetTpl *template.Template
+}
+
+// Config //
+type Config struct {
+	Name string `mapstructure:"name,omitempty"`
+	// address of the gnmic grpc input
+	Address    string `mapstructure:"address,omitempty"`
+	Insecure   bool   `mapstructure:"insecure,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty"`
+	TLSCA      string `mapstructure:"tls-ca,omitempty"`
+	TLSCert    string `mapstructure:"tls-cert,omitempty"`
+	TLSKey     string `mapstructure:"tls-key,omitempty"`
+	// proto or event
+	Format string `mapstructure:"format,omitempty"`
+	// number of messages buffered while the input is unreachable
+	BufferSize         int           `mapstructure:"buffer-size,omitempty"`
+	Timeout            time.Duration `mapstructure:"timeout,omitempty"`
+	RetryTimer         time.Duration `mapstructure:"retry-timer,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty"`
+	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
+}
+
+func (g *GRPCOutput) String() string {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (g *GRPCOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && g.logger != nil {
+		g.logger.SetOutput(logger.Writer())
+		g.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (g *GRPCOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]interface{}) {
+	for _, epName := range g.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
+				if err != nil {
+					g.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				g.evps = append(g.evps, ep)
+				g.logger.Printf("added event processor '%s' of type=%s to grpc output", epName, epType)
+				continue
+			}
+			g.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		g.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (g *GRPCOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, g.Cfg)
+	if err != nil {
+		return err
+	}
+	if g.Cfg.Name == "" {
+		g.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	err = g.setDefaults()
+	if err != nil {
+		return err
+	}
+	formatters.WarnDroppedTags(g.logger.Printf, g.Cfg.Format, g.evps)
+	if g.Cfg.TargetTemplate == "" {
+		g.targetTpl = outputs.DefaultTargetTemplate
+	} else if g.Cfg.AddTarget != "" {
+		g.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(g.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	dialOpts, err := g.dialOpts()
+	if err != nil {
+		return err
+	}
+	g.msgChan = make(chan *relay.Message, g.Cfg.BufferSize)
+	ctx, g.cancelFn = context.WithCancel(ctx)
+	g.done = ctx.Done()
+	formatters.ConnectEmitters(ctx, g.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			g.WriteEvent(ctx, ev)
+		}
+	})
+	g.wg.Add(1)
+	go g.worker(ctx, dialOpts)
+	g.logger.Printf("initialized grpc output: %s", g.String())
+	go func() {
+		<-ctx.Done()
+		g.Close()
+	}()
+	return nil
+}
+
+func (g *GRPCOutput) setDefaults() error {
+	if g.Cfg.Address == "" {
+		return fmt.Errorf("missing address")
+	}
+	switch g.Cfg.Format {
+	case "":
+		g.Cfg.Format = defaultFormat
+	case "proto", "event":
+	default:
+		return fmt.Errorf("unsupported format %q, expected 'proto' or 'event'", g.Cfg.Format)
+	}
+	if g.Cfg.BufferSize <= 0 {
+		g.Cfg.BufferSize = defaultBufferSize
+	}
+	if g.Cfg.Timeout <= 0 {
+		g.Cfg.Timeout = defaultTimeout
+	}
+	if g.Cfg.RetryTimer <= 0 {
+		g.Cfg.RetryTimer = defaultRetryTimer
+	}
+	return nil
+}
+
+func (g *GRPCOutput) dialOpts() ([]grpc.DialOption, error) {
+	opts := []grpc.DialOption{grpc.WithBlock()}
+	if g.Cfg.Insecure {
+		return append(opts, grpc.WithInsecure()), nil
+	}
+	tlsCfg := &relay.TLSConfig{
+		CA:         g.Cfg.TLSCA,
+		Cert:       g.Cfg.TLSCert,
+		Key:        g.Cfg.TLSKey,
+		SkipVerify: g.Cfg.SkipVerify,
+	}
+	tlsConfig, err := tlsCfg.ClientTLS()
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))), nil
+}
+
+func (g *GRPCOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, g.Cfg.AddTarget, g.targetTpl)
+	if err != nil {
+		g.logger.Printf("failed to add target to the response: %v", err)
+	}
+	subRsp, ok := rsp.(*gnmi.SubscribeResponse)
+	if !ok {
+		return
+	}
+	if g.Cfg.Format == "event" {
+		name := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			name = subName
+		}
+		events, err := formatters.ResponseToEventMsgs(name, subRsp, meta, g.evps...)
+		if err != nil {
+			g.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			g.WriteEvent(ctx, ev)
+		}
+		return
+	}
+	subRsp, err = formatters.ProcessResponse(subRsp, meta, g.evps...)
+	if err != nil {
+		g.logger.Printf("failed to apply event processors: %v", err)
+		return
+	}
+	// all the updates were dropped by the event processors
+	if subRsp == nil {
+		return
+	}
+	// the response is encoded by the worker, after it is written to the other outputs.
+	msg := &relay.Message{
+		Response: proto.Clone(subRsp).(*gnmi.SubscribeResponse),
+		Meta:     make(map[string]string, len(meta)),
+	}
+	for k, v := range meta {
+		msg.Meta[k] = v
+	}
+	if g.Cfg.OverrideTimestamps {
+		if r, ok := msg.Response.Response.(*gnmi.SubscribeResponse_Update); ok {
+			r.Update.Timestamp = time.Now().UnixNano()
+		}
+	}
+	g.send(ctx, msg)
+}
+
+func (g *GRPCOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if g.Cfg.OverrideTimestamps {
+		ev.Timestamp = time.Now().UnixNano()
+	}
+	e, err := relay.NewEvent(ev)
+	if err != nil {
+		g.logger.Printf("failed to convert event: %v", err)
+		return
+	}
+	g.send(ctx, &relay.Message{Event: e})
+}
+
+// send queues the message, it blocks while the buffer is full
+func (g *GRPCOutput) send(ctx context.Context, msg *relay.Message) {
+	select {
+	case <-ctx.Done():
+	case <-g.done:
+	case g.msgChan <- msg:
+	}
+}
+
+func (g *GRPCOutput) Close() error {
+	defer formatters.CloseEventProcessors(g.evps)
+	if g.cancelFn != nil {
+		g.cancelFn()
+	}
+	g.wg.Wait()
+	g.logger.Printf("closed.")
+	return nil
+}
+
+func (g *GRPCOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !g.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		g.logger.Printf("failed to register metric: %v", err)
+	}
+}
+
+func (g *GRPCOutput) SetName(name string)        {}
+func (g *GRPCOutput) SetClusterName(name string) {}
+
+// Healthy implements outputs.HealthChecker
+func (g *GRPCOutput) Healthy() bool {
+	return atomic.LoadInt32(&g.healthy) == 1
+}
+
+func (g *GRPCOutput) setHealthy(b bool) {
+	if b {
+		atomic.StoreInt32(&g.healthy, 1)
+		return
+	}
+	atomic.StoreInt32(&g.healthy, 0)
+}
+
+// worker keeps a Publish stream open to the grpc input and sends the buffered messages,
+// the connection is re-established every retry-timer after a failure.
+func (g *GRPCOutput) worker(ctx context.Context, dialOpts []grpc.DialOption) {
+	defer g.wg.Done()
+	// message that failed to be sent, resent first after reconnecting
+	var pending *relay.Message
+	for {
+		conn, stream, cancel, err := g.connect(ctx, dialOpts)
+		if err == nil {
+			g.setHealthy(true)
+			g.logger.Printf("connected to %s", g.Cfg.Address)
+			pending, err = g.publish(ctx, stream, cancel, pending)
+			cancel()
+			conn.Close()
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		g.setHealthy(false)
+		g.logger.Printf("failed to publish to %s: %v", g.Cfg.Address, err)
+		GRPCOutputNumberOfReconnects.WithLabelValues(g.Cfg.Name).Inc()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(g.Cfg.RetryTimer):
+		}
+	}
+}
+
+// connect dials the grpc input and opens a Publish stream,
+// the stream is not tied to ctx so that the buffered messages can be sent when ctx is done.
+func (g *GRPCOutput) connect(ctx context.Context, dialOpts []grpc.DialOption) (*grpc.ClientConn, relay.Relay_PublishClient, context.CancelFunc, error) {
+	dctx, dcancel := context.WithTimeout(ctx, g.Cfg.Timeout)
+	defer dcancel()
+	conn, err := grpc.DialContext(dctx, g.Cfg.Address, dialOpts...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sctx, cancel := context.WithCancel(context.Background())
+	stream, err := relay.NewRelayClient(conn).Publish(sctx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	return conn, stream, cancel, nil
+}
+
+// publish sends the messages on the stream until ctx is done or a send fails,
+// in which case the failed message is returned.
+func (g *GRPCOutput) publish(ctx context.Context, stream relay.Relay_PublishClient, cancel context.CancelFunc, pending *relay.Message) (*relay.Message, error) {
+	// the input replies only once the stream is closed by the output,
+	// an earlier reply means the stream is broken.
+	recvErr := make(chan error, 1)
+	go func() {
+		recvErr <- stream.RecvMsg(new(relay.PublishResponse))
+	}()
+	if pending != nil {
+		if err := g.sendMsg(stream, pending, recvErr); err != nil {
+			return pending, err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, g.drain(stream, cancel, recvErr)
+		case err := <-recvErr:
+			if err == nil {
+				err = errors.New("stream closed by the input")
+			}
+			return nil, err
+		case msg := <-g.msgChan:
+			if err := g.sendMsg(stream, msg, recvErr); err != nil {
+				return msg, err
+			}
+		}
+	}
+}
+
+// drain sends the buffered messages and closes the stream,
+// waiting for the input to confirm it received them.
+// The stream is canceled if this takes longer than timeout.
+func (g *GRPCOutput) drain(stream relay.Relay_PublishClient, cancel context.CancelFunc, recvErr <-chan error) error {
+	timer := time.AfterFunc(g.Cfg.Timeout, cancel)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-g.msgChan:
+			if err := g.sendMsg(stream, msg, recvErr); err != nil {
+				return err
+			}
+		default:
+			err := stream.CloseSend()
+			if err != nil {
+				return err
+			}
+			return <-recvErr
+		}
+	}
+}
+
+func (g *GRPCOutput) sendMsg(stream relay.Relay_PublishClient, msg *relay.Message, recvErr <-chan error) error {
+	err := stream.Send(msg)
+	if err == io.EOF {
+		// the stream is broken, the actual error is returned by RecvMsg
+		err = <-recvErr
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	if err != nil {
+		GRPCOutputNumberOfFailedMsgs.WithLabelValues(g.Cfg.Name).Inc()
+		return err
+	}
+	if g.Cfg.Debug {
+		g.logger.Printf("sent msg: %+v", msg)
+	}
+	GRPCOutputNumberOfSentMsgs.WithLabelValues(g.Cfg.Name).Inc()
+	return nil
+}
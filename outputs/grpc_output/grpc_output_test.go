@@ -0,0 +1,299 @@
+package grpc_output
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/inputs"
+	_ "github.com/karimra/gnmic/inputs/grpc_input"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+// testOutput records the messages written by the grpc input
+type testOutput struct {
+	msgs   chan proto.Message
+	metas  chan outputs.Meta
+	events chan *formatters.EventMsg
+}
+
+func newTestOutput() *testOutput {
+	return &testOutput{
+		msgs:   make(chan proto.Message, 10),
+		metas:  make(chan outputs.Meta, 10),
+		events: make(chan *formatters.EventMsg, 10),
+	}
+}
+
+func (o *testOutput) Init(context.Context, string, map[string]interface{}, ...outputs.Option) error {
+	return nil
+}
+func (o *testOutput) Write(ctx context.Context, m proto.Message, meta outputs.Meta) {
+	o.msgs <- m
+	o.metas <- meta
+}
+func (o *testOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) { o.events <- ev }
+func (o *testOutput) Close() error                                            { return nil }
+func (o *testOutput) RegisterMetrics(*prometheus.Registry)                    {}
+func (o *testOutput) String() string                                          { return "test" }
+func (o *testOutput) SetLogger(*log.Logger)                                   {}
+func (o *testOutput) SetEventProcessors(map[string]map[string]interface{}, *log.Logger, map[string]interface{}) {
+}
+func (o *testOutput) SetName(string)        {}
+func (o *testOutput) SetClusterName(string) {}
+
+var testResponse = &gnmi.SubscribeResponse{
+	Response: &gnmi.SubscribeResponse_Update{
+		Update: &gnmi.Notification{
+			Timestamp: 1606824673847153523,
+			Update: []*gnmi.Update{
+				{
+					Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}}, {Name: "in-octets"}}},
+					Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 42}},
+				},
+			},
+		},
+	},
+}
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// writeCert writes a self signed certificate for 127.0.0.1 and its key to dir,
+// the certificate is used by both the input and the output, and as CA.
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gnmic"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func startInput(t *testing.T, cfg map[string]interface{}, out outputs.Output) inputs.Input {
+	in := inputs.Inputs["grpc"]()
+	err := in.Start(context.Background(), "grpc-in", cfg,
+		inputs.WithOutputs(map[string]outputs.Output{"test": out}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func receive(t *testing.T, out *testOutput) (proto.Message, outputs.Meta) {
+	select {
+	case m := <-out.msgs:
+		return m, <-out.metas
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a message")
+	}
+	return nil, nil
+}
+
+func waitHealthy(t *testing.T, g *GRPCOutput, healthy bool) {
+	for i := 0; i < 50; i++ {
+		if g.Healthy() == healthy {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for the output health to be %v", healthy)
+}
+
+func TestGRPCOutputTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert, key := writeCert(t, dir)
+	addr := freeAddress(t)
+
+	out := newTestOutput()
+	in := startInput(t, map[string]interface{}{
+		"address":  addr,
+		"tls-ca":   cert,
+		"tls-cert": cert,
+		"tls-key":  key,
+	}, out)
+	defer in.Close()
+
+	g := outputs.Outputs["grpc"]().(*GRPCOutput)
+	err = g.Init(context.Background(), "grpc-out", map[string]interface{}{
+		"address":  addr,
+		"tls-ca":   cert,
+		"tls-cert": cert,
+		"tls-key":  key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	g.Write(context.Background(), testResponse, meta)
+	m, gotMeta := receive(t, out)
+	if !proto.Equal(m, testResponse) {
+		t.Errorf("response mismatch: got %v, want %v", m, testResponse)
+	}
+	if !cmp.Equal(gotMeta, meta) {
+		t.Errorf("meta mismatch: %s", cmp.Diff(meta, gotMeta))
+	}
+
+	ev := &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 1606824673847153523,
+		Tags:      map[string]string{"source": "router1:57400"},
+		Values:    map[string]interface{}{"/interface/in-octets": uint64(42), "/interface/description": "uplink"},
+	}
+	g.WriteEvent(context.Background(), ev)
+	select {
+	case got := <-out.events:
+		if !cmp.Equal(got, ev) {
+			t.Errorf("event mismatch: %s", cmp.Diff(ev, got))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for an event")
+	}
+	if !g.Healthy() {
+		t.Errorf("expected the output to be healthy")
+	}
+}
+
+func TestGRPCOutputReconnect(t *testing.T) {
+	addr := freeAddress(t)
+	g := outputs.Outputs["grpc"]().(*GRPCOutput)
+	err := g.Init(context.Background(), "grpc-out", map[string]interface{}{
+		"address":     addr,
+		"insecure":    true,
+		"timeout":     "500ms",
+		"retry-timer": "100ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// the messages are buffered until the input is reachable
+	meta := outputs.Meta{"source": "router1:57400"}
+	g.Write(context.Background(), testResponse, meta)
+	if g.Healthy() {
+		t.Errorf("expected the output to be unhealthy")
+	}
+	inCfg := map[string]interface{}{"address": addr, "insecure": true}
+	out := newTestOutput()
+	in := startInput(t, inCfg, out)
+	m, _ := receive(t, out)
+	if !proto.Equal(m, testResponse) {
+		t.Errorf("response mismatch: got %v, want %v", m, testResponse)
+	}
+	in.Close()
+	waitHealthy(t, g, false)
+
+	// the output reconnects to a restarted input
+	in = startInput(t, inCfg, out)
+	defer in.Close()
+	g.Write(context.Background(), testResponse, meta)
+	m, _ = receive(t, out)
+	if !proto.Equal(m, testResponse) {
+		t.Errorf("response mismatch: got %v, want %v", m, testResponse)
+	}
+}
+
+func TestGRPCInputEventProcessors(t *testing.T) {
+	addr := freeAddress(t)
+	out := newTestOutput()
+	in := inputs.Inputs["grpc"]()
+	err := in.Start(context.Background(), "grpc-in",
+		map[string]interface{}{
+			"address":          addr,
+			"insecure":         true,
+			"event-processors": []string{"add-site"},
+		},
+		inputs.WithEventProcessors(map[string]map[string]interface{}{
+			"add-site": {"event-add-tag": map[string]interface{}{
+				"tag-names": []string{"^source$"},
+				"add":       map[string]string{"site": "paris"},
+			}},
+		}, nil, nil),
+		inputs.WithOutputs(map[string]outputs.Output{"test": out}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	g := outputs.Outputs["grpc"]().(*GRPCOutput)
+	err = g.Init(context.Background(), "grpc-out", map[string]interface{}{
+		"address":  addr,
+		"insecure": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// the relayed response is converted to events processed by the input processors
+	g.Write(context.Background(), testResponse, outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"})
+	select {
+	case ev := <-out.events:
+		if ev.Name != "sub1" || ev.Tags["site"] != "paris" || ev.Tags["interface_name"] != "ethernet-1/1" ||
+			ev.Values["/interface/in-octets"] != uint64(42) {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-out.msgs:
+		t.Fatal("unexpected unprocessed response")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for an event")
+	}
+}
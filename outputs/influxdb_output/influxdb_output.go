@@ -4,16 +4,20 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"math"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/karimra/gnmic/formatters"
 	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/outputs/socket"
 	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/protobuf/proto"
@@ -24,6 +28,9 @@ const (
 	defaultBatchSize         = 1000
 	defaultFlushTimer        = 10 * time.Second
 	defaultHealthCheckPeriod = 30 * time.Second
+	defaultTimeout           = 10 * time.Second
+	defaultSocketDialTimeout = 5 * time.Second
+	defaultRetryTimer        = 2 * time.Second
 
 	numWorkers    = 1
 	loggingPrefix = "[influxdb_output] "
@@ -50,8 +57,15 @@ type InfluxDBOutput struct {
 	reset     chan struct{}
 	startSig  chan struct{}
 	wasUP     bool
+	healthy   int32
 	evps      []formatters.EventProcessor
 	dbVersion string
+	// influxdb 1.x HTTP API client
+	v1Client *v1Client
+	// line protocol over UDP/TCP socket
+	sockWriter *socket.Writer
+	// line protocol workers
+	wg sync.WaitGroup
 
 	targetTpl *template.Template
 }
@@ -60,6 +74,11 @@ type Config struct {
 	Org                string        `mapstructure:"org,omitempty"`
 	Bucket             string        `mapstructure:"bucket,omitempty"`
 	Token              string        `mapstructure:"token,omitempty"`
+	Database           string        `mapstructure:"database,omitempty"`
+	RetentionPolicy    string        `mapstructure:"retention-policy,omitempty"`
+	Username           string        `mapstructure:"username,omitempty"`
+	Password           string        `mapstructure:"password,omitempty"`
+	Timeout            time.Duration `mapstructure:"timeout,omitempty"`
 	BatchSize          uint          `mapstructure:"batch-size,omitempty"`
 	FlushTimer         time.Duration `mapstructure:"flush-timer,omitempty"`
 	UseGzip            bool          `mapstructure:"use-gzip,omitempty"`
@@ -71,6 +90,8 @@ type Config struct {
 	EventProcessors    []string      `mapstructure:"event-processors,omitempty"`
 	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty"`
 	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
+	// UDP/TCP socket mode only, write the unsigned integers with the `u` suffix instead of converting them to integers
+	UintSupport bool `mapstructure:"uint-support,omitempty"`
 }
 
 func (k *InfluxDBOutput) String() string {
@@ -98,7 +119,7 @@ func (i *InfluxDBOutput) SetEventProcessors(ps map[string]map[string]interface{}
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					i.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -134,15 +155,8 @@ func (i *InfluxDBOutput) Init(ctx context.Context, name string, cfg map[string]i
 	if i.Cfg.HealthCheckPeriod == 0 {
 		i.Cfg.HealthCheckPeriod = defaultHealthCheckPeriod
 	}
-
-	iopts := influxdb2.DefaultOptions().
-		SetUseGZip(i.Cfg.UseGzip).
-		SetBatchSize(i.Cfg.BatchSize).
-		SetFlushInterval(uint(i.Cfg.FlushTimer.Milliseconds()))
-	if i.Cfg.EnableTLS {
-		iopts.SetTLSConfig(&tls.Config{
-			InsecureSkipVerify: true,
-		})
+	if i.Cfg.Timeout <= 0 {
+		i.Cfg.Timeout = defaultTimeout
 	}
 	if i.Cfg.TargetTemplate == "" {
 		i.targetTpl = outputs.DefaultTargetTemplate
@@ -154,31 +168,85 @@ func (i *InfluxDBOutput) Init(ctx context.Context, name string, cfg map[string]i
 			return err
 		}
 	}
+	u, err := url.Parse(i.Cfg.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse url %q: %v", i.Cfg.URL, err)
+	}
+	ctx, i.cancelFn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(ctx, i.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			i.WriteEvent(ctx, ev)
+		}
+	})
+	switch {
+	case u.Scheme == "udp" || u.Scheme == "tcp":
+		i.sockWriter = socket.NewWriter(u.Scheme, u.Host, defaultSocketDialTimeout)
+		i.setHealthy(true)
+		i.logger.Printf("initialized influxdb line protocol %s writer: %s", u.Scheme, i.String())
+		for k := 0; k < numWorkers; k++ {
+			i.wg.Add(1)
+			go i.lineWorker(ctx, k, i.writeSocket)
+		}
+	case i.Cfg.Database != "":
+		i.v1Client, err = newV1Client(i.Cfg)
+		if err != nil {
+			return err
+		}
+		i.waitHealthy(ctx)
+		go i.healthCheck(ctx)
+		i.logger.Printf("initialized influxdb v1 client: %s", i.String())
+		for k := 0; k < numWorkers; k++ {
+			i.wg.Add(1)
+			go i.lineWorker(ctx, k, i.writeV1)
+		}
+	default:
+		i.initV2Client(ctx)
+		for k := 0; k < numWorkers; k++ {
+			go i.worker(ctx, k)
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		i.Close()
+	}()
+	return nil
+}
+
+func (i *InfluxDBOutput) initV2Client(ctx context.Context) {
+	iopts := influxdb2.DefaultOptions().
+		SetUseGZip(i.Cfg.UseGzip).
+		SetBatchSize(i.Cfg.BatchSize).
+		SetFlushInterval(uint(i.Cfg.FlushTimer.Milliseconds()))
+	if i.Cfg.EnableTLS {
+		iopts.SetTLSConfig(&tls.Config{
+			InsecureSkipVerify: true,
+		})
+	}
 	if i.Cfg.Debug {
 		iopts.SetLogLevel(3)
 	}
-	ctx, i.cancelFn = context.WithCancel(ctx)
-CRCLIENT:
 	i.client = influxdb2.NewClientWithOptions(i.Cfg.URL, i.Cfg.Token, iopts)
 	// start influx health check
-	err = i.health(ctx)
-	if err != nil {
-		i.logger.Printf("failed to check influxdb health: %v", err)
-		time.Sleep(10 * time.Second)
-		goto CRCLIENT
-	}
-	i.wasUP = true
+	i.waitHealthy(ctx)
 	go i.healthCheck(ctx)
 	i.logger.Printf("initialized influxdb client: %s", i.String())
+}
 
-	for k := 0; k < numWorkers; k++ {
-		go i.worker(ctx, k)
+// waitHealthy blocks until the first successful health check
+func (i *InfluxDBOutput) waitHealthy(ctx context.Context) {
+	for {
+		err := i.health(ctx)
+		if err == nil {
+			break
+		}
+		i.logger.Printf("failed to check influxdb health: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
 	}
-	go func() {
-		<-ctx.Done()
-		i.Close()
-	}()
-	return nil
+	i.wasUP = true
 }
 
 func (i *InfluxDBOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
@@ -212,11 +280,24 @@ func (i *InfluxDBOutput) Write(ctx context.Context, rsp proto.Message, meta outp
 	}
 }
 
-func (i *InfluxDBOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+func (i *InfluxDBOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	select {
+	case <-ctx.Done():
+	case <-i.reset:
+	case i.eventChan <- ev:
+	}
+}
 
 func (i *InfluxDBOutput) Close() error {
+	defer formatters.CloseEventProcessors(i.evps)
 	i.logger.Printf("closing client...")
 	i.cancelFn()
+	// wait for the line workers to write their last batch
+	// before closing the socket they share
+	i.wg.Wait()
+	if i.sockWriter != nil {
+		i.sockWriter.Close()
+	}
 	i.logger.Printf("closed.")
 	return nil
 }
@@ -235,6 +316,17 @@ func (i *InfluxDBOutput) healthCheck(ctx context.Context) {
 }
 
 func (i *InfluxDBOutput) health(ctx context.Context) error {
+	var err error
+	if i.v1Client != nil {
+		err = i.healthV1(ctx)
+	} else {
+		err = i.healthV2(ctx)
+	}
+	i.setHealthy(err == nil)
+	return err
+}
+
+func (i *InfluxDBOutput) healthV2(ctx context.Context) error {
 	res, err := i.client.Health(ctx)
 	if err != nil {
 		i.logger.Printf("failed health check: %v", err)
@@ -271,6 +363,24 @@ func (i *InfluxDBOutput) health(ctx context.Context) error {
 	return nil
 }
 
+func (i *InfluxDBOutput) healthV1(ctx context.Context) error {
+	version, err := i.v1Client.ping(ctx)
+	if err != nil {
+		i.logger.Printf("failed health check: %v", err)
+		if i.wasUP {
+			close(i.reset)
+			i.reset = make(chan struct{})
+		}
+		return err
+	}
+	i.dbVersion = version
+	i.wasUP = true
+	close(i.startSig)
+	i.startSig = make(chan struct{})
+	i.logger.Printf("health check result: version=%s", version)
+	return nil
+}
+
 func (i *InfluxDBOutput) worker(ctx context.Context, idx int) {
 	firstStart := true
 START:
@@ -290,32 +400,157 @@ START:
 			i.logger.Printf("worker-%d terminating...", idx)
 			return
 		case ev := <-i.eventChan:
-			for n, v := range ev.Values {
-				switch v := v.(type) {
-				case *gnmi.Decimal64:
-					ev.Values[n] = float64(v.Digits) / math.Pow10(int(v.Precision))
+			writer.WritePoint(i.eventToPoint(ev))
+		case <-i.reset:
+			firstStart = false
+			i.logger.Printf("resetting worker-%d...", idx)
+			goto START
+		case err := <-writer.Errors():
+			i.logger.Printf("worker-%d write error: %v", idx, err)
+		}
+	}
+}
+
+// lineWorker encodes events into line protocol and writes them in batches using the write function,
+// a batch is written when it reaches batch-size points or when the flush-timer expires.
+func (i *InfluxDBOutput) lineWorker(ctx context.Context, idx int, write func(context.Context, []byte) error) {
+	defer i.wg.Done()
+	firstStart := true
+	le := newLineEncoder(i.uintSupport())
+	ticker := time.NewTicker(i.Cfg.FlushTimer)
+	defer ticker.Stop()
+	var numPoints uint
+	flush := func() {
+		if le.len() == 0 {
+			return
+		}
+		err := write(ctx, le.flush())
+		if err != nil {
+			i.logger.Printf("worker-%d write error: %v", idx, err)
+		}
+		numPoints = 0
+	}
+START:
+	if !firstStart {
+		i.logger.Printf("worker-%d waiting for server recovery", idx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-i.startSig:
+		}
+	}
+	i.logger.Printf("starting worker-%d", idx)
+	for {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() != nil {
+				i.logger.Printf("worker-%d err=%v", idx, ctx.Err())
+			}
+			// the last batch is written once, without retries
+			if le.len() > 0 {
+				var err error
+				if i.sockWriter != nil {
+					err = i.sockWriter.Write(le.flush())
+				} else {
+					err = i.writeV1Once(context.Background(), le.flush())
+				}
+				if err != nil {
+					i.logger.Printf("worker-%d failed to write the last batch: %v", idx, err)
 				}
 			}
-			if ev.Timestamp == 0 || i.Cfg.OverrideTimestamps {
-				ev.Timestamp = time.Now().UnixNano()
+			i.logger.Printf("worker-%d terminating...", idx)
+			return
+		case ev := <-i.eventChan:
+			err := le.encode(i.eventToPoint(ev))
+			if err != nil {
+				i.logger.Printf("worker-%d failed to encode event: %v", idx, err)
+				continue
+			}
+			numPoints++
+			if numPoints >= i.Cfg.BatchSize {
+				flush()
 			}
-			i.convertUints(ev)
-			writer.WritePoint(influxdb2.NewPoint(ev.Name, ev.Tags, ev.Values, time.Unix(0, ev.Timestamp)))
+		case <-ticker.C:
+			flush()
 		case <-i.reset:
 			firstStart = false
 			i.logger.Printf("resetting worker-%d...", idx)
 			goto START
-		case err := <-writer.Errors():
-			i.logger.Printf("worker-%d write error: %v", idx, err)
 		}
 	}
 }
 
+// writeV1 writes b using the influxdb 1.x HTTP API, retrying every defaultRetryTimer
+// until the write succeeds, the server rejects the batch or ctx is done.
+// The output is unhealthy while the writes fail.
+func (i *InfluxDBOutput) writeV1(ctx context.Context, b []byte) error {
+	for {
+		err := i.writeV1Once(ctx, b)
+		if err == nil {
+			i.setHealthy(true)
+			return nil
+		}
+		if !retryable(err) {
+			return err
+		}
+		i.setHealthy(false)
+		i.logger.Printf("failed to write to %s: %v", i.Cfg.URL, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(defaultRetryTimer):
+		}
+	}
+}
+
+func (i *InfluxDBOutput) writeV1Once(ctx context.Context, b []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, i.Cfg.Timeout)
+	defer cancel()
+	return i.v1Client.write(ctx, b)
+}
+
+func (i *InfluxDBOutput) writeSocket(ctx context.Context, b []byte) error {
+	err := i.sockWriter.WriteRetry(ctx, b, defaultRetryTimer, func(err error) {
+		i.setHealthy(false)
+		i.logger.Printf("failed to write to %s: %v", i.sockWriter, err)
+	})
+	if err == nil {
+		i.setHealthy(true)
+	}
+	return err
+}
+
+func (i *InfluxDBOutput) setHealthy(b bool) {
+	if b {
+		atomic.StoreInt32(&i.healthy, 1)
+		return
+	}
+	atomic.StoreInt32(&i.healthy, 0)
+}
+
+// Healthy implements outputs.HealthChecker
+func (i *InfluxDBOutput) Healthy() bool {
+	return atomic.LoadInt32(&i.healthy) == 1
+}
+
 func (i *InfluxDBOutput) SetName(name string)        {}
 func (i *InfluxDBOutput) SetClusterName(name string) {}
 
+// uintSupport reports whether the unsigned integers can be written as such:
+// influxdb 1.x does not support them, neither does its UDP listener,
+// so they are written in socket mode only if uint-support is set.
+func (i *InfluxDBOutput) uintSupport() bool {
+	switch {
+	case i.v1Client != nil:
+		return false
+	case i.sockWriter != nil:
+		return i.Cfg.UintSupport
+	}
+	return !strings.HasPrefix(i.dbVersion, "1.8")
+}
+
 func (i *InfluxDBOutput) convertUints(ev *formatters.EventMsg) {
-	if !strings.HasPrefix(i.dbVersion, "1.8") {
+	if i.uintSupport() {
 		return
 	}
 	for k, v := range ev.Values {
@@ -0,0 +1,189 @@
+package influxdb_output
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func newTestOutput(t *testing.T, ctx context.Context, cfg map[string]interface{}) *InfluxDBOutput {
+	i := outputs.Outputs["influxdb"]().(*InfluxDBOutput)
+	err := i.Init(ctx, "test", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+// writeCounter writes a "counter" update with timestamp 42 from subscription "sub1"
+func writeCounter(ctx context.Context, i *InfluxDBOutput, v *gnmi.TypedValue) {
+	i.Write(ctx, &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "counter"}}},
+						Val:  v,
+					},
+				},
+			},
+		},
+	}, outputs.Meta{"subscription-name": "sub1"})
+}
+
+// testV1Server is an influxdb 1.x HTTP API recording the written lines
+type testV1Server struct {
+	m     sync.Mutex
+	lines string
+	// number of writes to fail before accepting them
+	failures int
+}
+
+func (s *testV1Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case v1PingPath:
+		w.Header().Set("X-Influxdb-Version", "1.8.4")
+	case v1WritePath:
+		b, _ := ioutil.ReadAll(r.Body)
+		s.m.Lock()
+		defer s.m.Unlock()
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.lines += string(b)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *testV1Server) written() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.lines
+}
+
+func TestInitModes(t *testing.T) {
+	v1 := httptest.NewServer(new(testV1Server))
+	defer v1.Close()
+	v2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"influxdb","message":"ready for queries and writes","status":"pass","version":"2.0.4"}`))
+	}))
+	defer v2.Close()
+
+	for _, tc := range []struct {
+		name        string
+		cfg         map[string]interface{}
+		socket      bool
+		v1          bool
+		uintSupport bool
+	}{
+		{name: "udp", cfg: map[string]interface{}{"url": "udp://127.0.0.1:8089"}, socket: true},
+		{name: "tcp with uint-support", cfg: map[string]interface{}{"url": "tcp://127.0.0.1:8094", "uint-support": true}, socket: true, uintSupport: true},
+		{name: "v1", cfg: map[string]interface{}{"url": v1.URL, "database": "telemetry", "uint-support": true}, v1: true},
+		{name: "v2", cfg: map[string]interface{}{"url": v2.URL, "org": "org", "bucket": "bucket"}, uintSupport: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			i := newTestOutput(t, ctx, tc.cfg)
+			defer i.Close()
+			if got := i.sockWriter != nil; got != tc.socket {
+				t.Errorf("socket mode: got %v, want %v", got, tc.socket)
+			}
+			if got := i.v1Client != nil; got != tc.v1 {
+				t.Errorf("v1 mode: got %v, want %v", got, tc.v1)
+			}
+			if got := i.uintSupport(); got != tc.uintSupport {
+				t.Errorf("uint support: got %v, want %v", got, tc.uintSupport)
+			}
+		})
+	}
+}
+
+func TestSocketModeUints(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i := newTestOutput(t, ctx, map[string]interface{}{
+		"url":        "udp://" + conn.LocalAddr().String(),
+		"batch-size": 1,
+	})
+	defer i.Close()
+	writeCounter(ctx, i, &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 10}})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf[:n]), "sub1,subscription-name=sub1 /counter=10i 42\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestV1FlushOnClose(t *testing.T) {
+	s := new(testV1Server)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i := newTestOutput(t, ctx, map[string]interface{}{
+		"url":         srv.URL,
+		"database":    "telemetry",
+		"batch-size":  100,
+		"flush-timer": "1h",
+	})
+	writeCounter(ctx, i, &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: 1}})
+	i.Close()
+	want := "sub1,subscription-name=sub1 /counter=1i 42\n"
+	timeout := time.After(time.Second)
+	for s.written() != want {
+		select {
+		case <-timeout:
+			t.Fatalf("the buffered batch was not written on close, got %q, want %q", s.written(), want)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestV1WriteRetry(t *testing.T) {
+	s := &testV1Server{failures: 1}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i := newTestOutput(t, ctx, map[string]interface{}{
+		"url":        srv.URL,
+		"database":   "telemetry",
+		"batch-size": 1,
+	})
+	defer i.Close()
+	writeCounter(ctx, i, &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: 1}})
+	want := "sub1,subscription-name=sub1 /counter=1i 42\n"
+	timeout := time.After(2*defaultRetryTimer + time.Second)
+	for s.written() != want {
+		select {
+		case <-timeout:
+			t.Fatalf("the failed batch was not retried, got %q, want %q", s.written(), want)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if !i.Healthy() {
+		t.Errorf("expected the output to be healthy after a successful retry")
+	}
+}
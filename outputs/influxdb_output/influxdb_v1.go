@@ -0,0 +1,154 @@
+package influxdb_output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	v1WritePath = "/write"
+	v1PingPath  = "/ping"
+)
+
+// v1Client writes line protocol to the influxdb 1.x HTTP API,
+// using database/retention-policy and username/password semantics.
+type v1Client struct {
+	httpClient *http.Client
+	writeURL   string
+	pingURL    string
+	username   string
+	password   string
+	useGzip    bool
+}
+
+func newV1Client(cfg *Config) (*v1Client, error) {
+	u, err := url.Parse(strings.TrimRight(cfg.URL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	c := &v1Client{
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		username:   cfg.Username,
+		password:   cfg.Password,
+		useGzip:    cfg.UseGzip,
+	}
+	if cfg.EnableTLS {
+		c.httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}
+	}
+	params := url.Values{}
+	params.Set("db", cfg.Database)
+	if cfg.RetentionPolicy != "" {
+		params.Set("rp", cfg.RetentionPolicy)
+	}
+	params.Set("precision", "ns")
+	wu := *u
+	wu.Path = u.Path + v1WritePath
+	wu.RawQuery = params.Encode()
+	c.writeURL = wu.String()
+
+	pu := *u
+	pu.Path = u.Path + v1PingPath
+	c.pingURL = pu.String()
+	return c, nil
+}
+
+// ping checks the server availability and returns its version
+func (c *v1Client) ping(ctx context.Context) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.pingURL, nil)
+	if err != nil {
+		return "", err
+	}
+	c.setAuth(req)
+	rsp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	io.Copy(ioutil.Discard, rsp.Body)
+	if rsp.StatusCode != http.StatusNoContent && rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected ping response status: %s", rsp.Status)
+	}
+	return rsp.Header.Get("X-Influxdb-Version"), nil
+}
+
+// write sends a batch of lines to the server
+func (c *v1Client) write(ctx context.Context, b []byte) error {
+	var body io.Reader = bytes.NewReader(b)
+	if c.useGzip {
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		_, err := zw.Write(b)
+		if err != nil {
+			return err
+		}
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+		body = buf
+	}
+	req, err := http.NewRequest(http.MethodPost, c.writeURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.useGzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	c.setAuth(req)
+	rsp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(rsp.Body)
+		return &v1WriteError{
+			statusCode: rsp.StatusCode,
+			status:     rsp.Status,
+			msg:        strings.TrimSpace(string(msg)),
+		}
+	}
+	io.Copy(ioutil.Discard, rsp.Body)
+	return nil
+}
+
+func (c *v1Client) setAuth(req *http.Request) {
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// v1WriteError is a write rejected by the server
+type v1WriteError struct {
+	statusCode int
+	status     string
+	msg        string
+}
+
+func (e *v1WriteError) Error() string {
+	return fmt.Sprintf("write failed, status=%s: %s", e.status, e.msg)
+}
+
+// retryable reports whether a failed write can succeed if retried:
+// the request did not reach the server, the server failed or is rate limiting.
+// Any other 4xx status means the batch itself is invalid, e.g: a field type conflict.
+func retryable(err error) bool {
+	werr, ok := err.(*v1WriteError)
+	if !ok {
+		return true
+	}
+	return werr.statusCode/100 == 5 || werr.statusCode == http.StatusTooManyRequests
+}
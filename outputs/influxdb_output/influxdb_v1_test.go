@@ -0,0 +1,108 @@
+package influxdb_output
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestV1Client(t *testing.T) {
+	var body, query, user, pass, encoding string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ = r.BasicAuth()
+		switch r.URL.Path {
+		case "/influx/ping":
+			w.Header().Set("X-Influxdb-Version", "1.8.4")
+			w.WriteHeader(http.StatusNoContent)
+		case "/influx/write":
+			query = r.URL.RawQuery
+			encoding = r.Header.Get("Content-Encoding")
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			b, _ := ioutil.ReadAll(zr)
+			body = string(b)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := newV1Client(&Config{
+		URL:             srv.URL + "/influx/",
+		Database:        "telemetry",
+		RetentionPolicy: "autogen",
+		Username:        "admin",
+		Password:        "secret",
+		UseGzip:         true,
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	version, err := c.ping(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "1.8.4" {
+		t.Errorf("unexpected version %q", version)
+	}
+	err = c.write(ctx, []byte("m v=1i 42\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "m v=1i 42\n" || encoding != "gzip" {
+		t.Errorf("unexpected body %q, encoding %q", body, encoding)
+	}
+	if query != "db=telemetry&precision=ns&rp=autogen" {
+		t.Errorf("unexpected query %q", query)
+	}
+	if user != "admin" || pass != "secret" {
+		t.Errorf("unexpected credentials %q:%q", user, pass)
+	}
+}
+
+func TestV1ClientWriteError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c, err := newV1Client(&Config{URL: srv.URL, Database: "telemetry", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.write(context.Background(), []byte("m v=1i 42\n"))
+	if err == nil {
+		t.Fatal("expected a write error")
+	}
+	if retryable(err) {
+		t.Errorf("a %d write error should not be retried", http.StatusNotFound)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := map[string]struct {
+		err  error
+		want bool
+	}{
+		"request_error": {err: context.DeadlineExceeded, want: true},
+		"server_error":  {err: &v1WriteError{statusCode: http.StatusServiceUnavailable}, want: true},
+		"rate_limited":  {err: &v1WriteError{statusCode: http.StatusTooManyRequests}, want: true},
+		"bad_request":   {err: &v1WriteError{statusCode: http.StatusBadRequest}, want: false},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := retryable(tc.err); got != tc.want {
+				t.Errorf("retryable(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package influxdb_output
+
+import (
+	"bytes"
+	"math"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	lp "github.com/influxdata/line-protocol"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// eventToPoint converts an event message into an influxdb point.
+// it is used by all the write modes (v2 API, v1 API and line protocol over UDP/TCP)
+// so that the resulting tags and fields are the same regardless of the mode.
+func (i *InfluxDBOutput) eventToPoint(ev *formatters.EventMsg) *write.Point {
+	for n, v := range ev.Values {
+		switch v := v.(type) {
+		case *gnmi.Decimal64:
+			ev.Values[n] = float64(v.Digits) / math.Pow10(int(v.Precision))
+		}
+	}
+	if ev.Timestamp == 0 || i.Cfg.OverrideTimestamps {
+		ev.Timestamp = time.Now().UnixNano()
+	}
+	i.convertUints(ev)
+	return write.NewPoint(ev.Name, ev.Tags, ev.Values, time.Unix(0, ev.Timestamp))
+}
+
+// lineEncoder encodes points into line protocol,
+// using the same encoder settings as the influxdb v2 client.
+type lineEncoder struct {
+	buf *bytes.Buffer
+	enc *lp.Encoder
+}
+
+func newLineEncoder(uintSupport bool) *lineEncoder {
+	le := &lineEncoder{buf: new(bytes.Buffer)}
+	le.enc = lp.NewEncoder(le.buf)
+	if uintSupport {
+		le.enc.SetFieldTypeSupport(lp.UintSupport)
+	}
+	le.enc.FailOnFieldErr(true)
+	le.enc.SetPrecision(time.Nanosecond)
+	return le
+}
+
+// encode appends the line protocol representation of point p to the encoder buffer
+func (le *lineEncoder) encode(p *write.Point) error {
+	_, err := le.enc.Encode(p)
+	return err
+}
+
+func (le *lineEncoder) len() int {
+	return le.buf.Len()
+}
+
+// flush returns a copy of the encoded lines and resets the buffer
+func (le *lineEncoder) flush() []byte {
+	b := make([]byte, le.buf.Len())
+	copy(b, le.buf.Bytes())
+	le.buf.Reset()
+	return b
+}
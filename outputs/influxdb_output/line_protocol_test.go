@@ -0,0 +1,51 @@
+package influxdb_output
+
+import (
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestLineEncoder(t *testing.T) {
+	i := &InfluxDBOutput{Cfg: &Config{}}
+	ev := &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 42,
+		Tags:      map[string]string{"source": "router1"},
+		Values: map[string]interface{}{
+			"counter": uint64(10),
+			"dec":     &gnmi.Decimal64{Digits: 1234, Precision: 2},
+			"status":  "UP",
+		},
+	}
+	for _, tc := range []struct {
+		uintSupport bool
+		want        string
+	}{
+		{uintSupport: false, want: "sub1,source=router1 counter=10i,dec=12.34,status=\"UP\" 42\n"},
+		{uintSupport: true, want: "sub1,source=router1 counter=10u,dec=12.34,status=\"UP\" 42\n"},
+	} {
+		le := newLineEncoder(tc.uintSupport)
+		err := le.encode(i.eventToPoint(ev))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(le.flush()); got != tc.want {
+			t.Errorf("uint support %v: got %q, want %q", tc.uintSupport, got, tc.want)
+		}
+		if le.len() != 0 {
+			t.Errorf("uint support %v: the buffer is not reset after a flush", tc.uintSupport)
+		}
+	}
+}
+
+func TestEventToPointOverrideTimestamp(t *testing.T) {
+	i := &InfluxDBOutput{Cfg: &Config{OverrideTimestamps: true}}
+	ev := &formatters.EventMsg{Name: "sub1", Timestamp: 42, Values: map[string]interface{}{"v": 1}}
+	p := i.eventToPoint(ev)
+	if time.Since(p.Time()) > time.Minute {
+		t.Errorf("the timestamp was not overridden: %v", p.Time())
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -18,7 +20,9 @@ import (
 	"github.com/damiannolan/sasl/oauthbearer"
 	"github.com/google/uuid"
 	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/formatters/avro"
 	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/protobuf/proto"
 )
@@ -31,12 +35,39 @@ const (
 	defaultFormat           = "event"
 	defaultRecoveryWaitTime = 10 * time.Second
 	defaultAddress          = "localhost:9092"
+	defaultRequiredAcks     = "all"
 	loggingPrefix           = "[kafka_output] "
 )
 
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	"":       sarama.CompressionNone,
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+var requiredAcks = map[string]sarama.RequiredAcks{
+	"none":   sarama.NoResponse,
+	"leader": sarama.WaitForLocal,
+	"all":    sarama.WaitForAll,
+}
+
 type protoMsg struct {
 	m    proto.Message
 	meta outputs.Meta
+	// events emitted by the event processors, set instead of m
+	evs []*formatters.EventMsg
+	// set by the sync writes, receives the delivery result
+	done chan error
+}
+
+// result reports the delivery result of a message written by WriteSync or WriteEventSync
+func (m *protoMsg) result(err error) {
+	if m.done != nil {
+		m.done <- err
+	}
 }
 
 func init() {
@@ -58,29 +89,43 @@ type KafkaOutput struct {
 	msgChan  chan *protoMsg
 	wg       *sync.WaitGroup
 	evps     []formatters.EventProcessor
+	// number of workers with an initialized producer
+	activeProducers int32
 
 	targetTpl *template.Template
+	// set if the topic/key config is a template
+	topicTpl *template.Template
+	keyTpl   *template.Template
+	// avro format encoder
+	serializer *avro.Serializer
 }
 
 // Config //
 type Config struct {
-	Address            string        `mapstructure:"address,omitempty"`
-	Topic              string        `mapstructure:"topic,omitempty"`
-	Name               string        `mapstructure:"name,omitempty"`
-	SASL               *sasl         `mapstructure:"sasl,omitempty"`
-	TLS                *tlsConfig    `mapstructure:"tls,omitempty"`
-	MaxRetry           int           `mapstructure:"max-retry,omitempty"`
-	Timeout            time.Duration `mapstructure:"timeout,omitempty"`
-	RecoveryWaitTime   time.Duration `mapstructure:"recovery-wait-time,omitempty"`
-	Format             string        `mapstructure:"format,omitempty"`
-	AddTarget          string        `mapstructure:"add-target,omitempty"`
-	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
-	NumWorkers         int           `mapstructure:"num-workers,omitempty"`
-	Debug              bool          `mapstructure:"debug,omitempty"`
-	BufferSize         int           `mapstructure:"buffer-size,omitempty"`
-	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
-	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty"`
-	EventProcessors    []string      `mapstructure:"event-processors,omitempty"`
+	Address            string               `mapstructure:"address,omitempty"`
+	Topic              string               `mapstructure:"topic,omitempty"`
+	Key                string               `mapstructure:"key,omitempty"`
+	Name               string               `mapstructure:"name,omitempty"`
+	SASL               *sasl                `mapstructure:"sasl,omitempty"`
+	TLS                *tlsConfig           `mapstructure:"tls,omitempty"`
+	Version            string               `mapstructure:"version,omitempty"`
+	CompressionCodec   string               `mapstructure:"compression-codec,omitempty"`
+	RequiredAcks       string               `mapstructure:"required-acks,omitempty"`
+	AddMetaHeaders     bool                 `mapstructure:"add-meta-headers,omitempty"`
+	SchemaRegistry     *avro.RegistryConfig `mapstructure:"schema-registry,omitempty"`
+	MaxRetry           int                  `mapstructure:"max-retry,omitempty"`
+	Timeout            time.Duration        `mapstructure:"timeout,omitempty"`
+	RecoveryWaitTime   time.Duration        `mapstructure:"recovery-wait-time,omitempty"`
+	Format             string               `mapstructure:"format,omitempty"`
+	MsgTemplate        string               `mapstructure:"msg-template,omitempty"`
+	AddTarget          string               `mapstructure:"add-target,omitempty"`
+	TargetTemplate     string               `mapstructure:"target-template,omitempty"`
+	NumWorkers         int                  `mapstructure:"num-workers,omitempty"`
+	Debug              bool                 `mapstructure:"debug,omitempty"`
+	BufferSize         int                  `mapstructure:"buffer-size,omitempty"`
+	OverrideTimestamps bool                 `mapstructure:"override-timestamps,omitempty"`
+	EnableMetrics      bool                 `mapstructure:"enable-metrics,omitempty"`
+	EventProcessors    []string             `mapstructure:"event-processors,omitempty"`
 }
 type sasl struct {
 	User      string `mapstructure:"user,omitempty"`
@@ -121,7 +166,7 @@ func (k *KafkaOutput) SetEventProcessors(ps map[string]map[string]interface{}, l
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					k.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -154,10 +199,17 @@ func (k *KafkaOutput) Init(ctx context.Context, name string, cfg map[string]inte
 		return err
 	}
 	k.msgChan = make(chan *protoMsg, uint(k.Cfg.BufferSize))
+	formatters.WarnDroppedTags(k.logger.Printf, k.Cfg.Format, k.evps)
 	k.mo = &formatters.MarshalOptions{
 		Format:     k.Cfg.Format,
 		OverrideTS: k.Cfg.OverrideTimestamps,
 	}
+	if k.Cfg.Format == "template" {
+		k.mo.Template, err = formatters.ParseMsgTemplate(k.Cfg.MsgTemplate)
+		if err != nil {
+			return err
+		}
+	}
 
 	if k.Cfg.TargetTemplate == "" {
 		k.targetTpl = outputs.DefaultTargetTemplate
@@ -169,12 +221,29 @@ func (k *KafkaOutput) Init(ctx context.Context, name string, cfg map[string]inte
 			return err
 		}
 	}
+	k.topicTpl, err = parseTemplate("topic", k.Cfg.Topic)
+	if err != nil {
+		return fmt.Errorf("failed to parse topic template: %v", err)
+	}
+	k.keyTpl, err = parseTemplate("key", k.Cfg.Key)
+	if err != nil {
+		return fmt.Errorf("failed to parse key template: %v", err)
+	}
+	if k.Cfg.Format == "avro" {
+		k.serializer, err = avro.NewSerializer(k.Cfg.SchemaRegistry)
+		if err != nil {
+			return err
+		}
+	}
 
 	config, err := k.createConfig()
 	if err != nil {
 		return err
 	}
 	ctx, k.cancelFn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(ctx, k.evps, func(es []*formatters.EventMsg) {
+		k.send(ctx, &protoMsg{meta: formatters.EventMeta(es[0]), evs: es})
+	})
 	k.wg.Add(k.Cfg.NumWorkers)
 	for i := 0; i < k.Cfg.NumWorkers; i++ {
 		cfg := *config
@@ -192,9 +261,12 @@ func (k *KafkaOutput) setDefaults() error {
 	if k.Cfg.Format == "" {
 		k.Cfg.Format = defaultFormat
 	}
-	if !(k.Cfg.Format == "event" || k.Cfg.Format == "protojson" || k.Cfg.Format == "prototext" || k.Cfg.Format == "proto" || k.Cfg.Format == "json") {
+	if !(k.Cfg.Format == "event" || k.Cfg.Format == "protojson" || k.Cfg.Format == "prototext" || k.Cfg.Format == "proto" || k.Cfg.Format == "json" || k.Cfg.Format == "avro" || k.Cfg.Format == "template") {
 		return fmt.Errorf("unsupported output format '%s' for output type kafka", k.Cfg.Format)
 	}
+	if k.Cfg.Format == "avro" && k.Cfg.SchemaRegistry == nil {
+		return errors.New("format 'avro' requires a schema-registry configuration")
+	}
 	if k.Cfg.Address == "" {
 		k.Cfg.Address = defaultAddress
 	}
@@ -216,6 +288,17 @@ func (k *KafkaOutput) setDefaults() error {
 	if k.Cfg.Name == "" {
 		k.Cfg.Name = "gnmic-" + uuid.New().String()
 	}
+	k.Cfg.CompressionCodec = strings.ToLower(k.Cfg.CompressionCodec)
+	if _, ok := compressionCodecs[k.Cfg.CompressionCodec]; !ok {
+		return fmt.Errorf("unsupported compression-codec '%s' for output type kafka", k.Cfg.CompressionCodec)
+	}
+	k.Cfg.RequiredAcks = strings.ToLower(k.Cfg.RequiredAcks)
+	if k.Cfg.RequiredAcks == "" {
+		k.Cfg.RequiredAcks = defaultRequiredAcks
+	}
+	if _, ok := requiredAcks[k.Cfg.RequiredAcks]; !ok {
+		return fmt.Errorf("unsupported required-acks '%s' for output type kafka", k.Cfg.RequiredAcks)
+	}
 	if k.Cfg.SASL == nil {
 		return nil
 	}
@@ -236,14 +319,21 @@ func (k *KafkaOutput) Write(ctx context.Context, rsp proto.Message, meta outputs
 	if rsp == nil {
 		return
 	}
+	k.send(ctx, &protoMsg{m: rsp, meta: meta})
+}
+
+func (k *KafkaOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	k.send(ctx, &protoMsg{meta: formatters.EventMeta(ev), evs: []*formatters.EventMsg{ev}})
+}
 
+func (k *KafkaOutput) send(ctx context.Context, m *protoMsg) {
 	wctx, cancel := context.WithTimeout(ctx, k.Cfg.Timeout)
 	defer cancel()
 
 	select {
 	case <-ctx.Done():
 		return
-	case k.msgChan <- &protoMsg{m: rsp, meta: meta}:
+	case k.msgChan <- m:
 	case <-wctx.Done():
 		if k.Cfg.Debug {
 			k.logger.Printf("writing expired after %s, Kafka output might not be initialized", k.Cfg.Timeout)
@@ -255,10 +345,39 @@ func (k *KafkaOutput) Write(ctx context.Context, rsp proto.Message, meta outputs
 	}
 }
 
-func (k *KafkaOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+// WriteSync implements outputs.SyncWriter,
+// it returns once the message is written to kafka or failed to be.
+func (k *KafkaOutput) WriteSync(ctx context.Context, rsp proto.Message, meta outputs.Meta) error {
+	if rsp == nil {
+		return nil
+	}
+	return k.sendSync(ctx, &protoMsg{m: rsp, meta: meta})
+}
+
+// WriteEventSync implements outputs.SyncWriter
+func (k *KafkaOutput) WriteEventSync(ctx context.Context, ev *formatters.EventMsg) error {
+	return k.sendSync(ctx, &protoMsg{meta: formatters.EventMeta(ev), evs: []*formatters.EventMsg{ev}})
+}
+
+// sendSync queues the message and waits for a worker to report its delivery result
+func (k *KafkaOutput) sendSync(ctx context.Context, m *protoMsg) error {
+	m.done = make(chan error, 1)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case k.msgChan <- m:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-m.done:
+		return err
+	}
+}
 
 // Close //
 func (k *KafkaOutput) Close() error {
+	defer formatters.CloseEventProcessors(k.evps)
 	k.cancelFn()
 	k.wg.Wait()
 	return nil
@@ -274,6 +393,19 @@ func (k *KafkaOutput) RegisterMetrics(reg *prometheus.Registry) {
 	}
 }
 
+// waitRecovery waits RecoveryWaitTime before the producer is recreated,
+// it returns false if ctx is done first.
+func (k *KafkaOutput) waitRecovery(ctx context.Context) bool {
+	timer := time.NewTimer(k.Cfg.RecoveryWaitTime)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 func (k *KafkaOutput) worker(ctx context.Context, idx int, config *sarama.Config) {
 	var producer sarama.SyncProducer
 	var err error
@@ -284,57 +416,74 @@ CRPROD:
 	producer, err = sarama.NewSyncProducer(strings.Split(k.Cfg.Address, ","), config)
 	if err != nil {
 		k.logger.Printf("%s failed to create kafka producer: %v", workerLogPrefix, err)
-		time.Sleep(k.Cfg.RecoveryWaitTime)
+		if !k.waitRecovery(ctx) {
+			k.logger.Printf("%s shutting down", workerLogPrefix)
+			return
+		}
 		goto CRPROD
 	}
 	defer producer.Close()
+	atomic.AddInt32(&k.activeProducers, 1)
 	k.logger.Printf("%s initialized kafka producer: %s", workerLogPrefix, k.String())
 	for {
 		select {
 		case <-ctx.Done():
 			k.logger.Printf("%s shutting down", workerLogPrefix)
+			atomic.AddInt32(&k.activeProducers, -1)
 			return
 		case m := <-k.msgChan:
-			err = outputs.AddSubscriptionTarget(m.m, m.meta, k.Cfg.AddTarget, k.targetTpl)
-			if err != nil {
-				k.logger.Printf("failed to add target to the response: %v", err)
+			if m.m != nil {
+				err = outputs.AddSubscriptionTarget(m.m, m.meta, k.Cfg.AddTarget, k.targetTpl)
+				if err != nil {
+					k.logger.Printf("failed to add target to the response: %v", err)
+				}
 			}
-			b, err := k.mo.Marshal(m.m, m.meta, k.evps...)
+			msgs, err := k.producerMsgs(ctx, m)
 			if err != nil {
 				if k.Cfg.Debug {
-					k.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
+					k.logger.Printf("%s failed to build kafka msg: %v", workerLogPrefix, err)
 				}
 				if k.Cfg.EnableMetrics {
 					KafkaNumberOfFailSendMsgs.WithLabelValues(config.ClientID, "marshal_error").Inc()
 				}
+				// not reported as a delivery failure, writing the message again would fail the same way
+				m.result(nil)
 				continue
 			}
-			msg := &sarama.ProducerMessage{
-				Topic: k.Cfg.Topic,
-				Value: sarama.ByteEncoder(b),
+			if len(msgs) == 0 {
+				m.result(nil)
+				continue
 			}
 
 			var start time.Time
 			if k.Cfg.EnableMetrics {
 				start = time.Now()
 			}
-			_, _, err = producer.SendMessage(msg)
+			err = producer.SendMessages(msgs)
 			if err != nil {
 				if k.Cfg.Debug {
-					k.logger.Printf("%s failed to send a kafka msg to topic '%s': %v", workerLogPrefix, k.Cfg.Topic, err)
+					k.logger.Printf("%s failed to send a kafka msg to topic '%s': %v", workerLogPrefix, msgs[0].Topic, err)
 				}
 				if k.Cfg.EnableMetrics {
 					KafkaNumberOfFailSendMsgs.WithLabelValues(config.ClientID, "send_error").Inc()
 				}
+				m.result(err)
 				producer.Close()
-				time.Sleep(k.Cfg.RecoveryWaitTime)
+				atomic.AddInt32(&k.activeProducers, -1)
+				if !k.waitRecovery(ctx) {
+					k.logger.Printf("%s shutting down", workerLogPrefix)
+					return
+				}
 				goto CRPROD
 			}
 			if k.Cfg.EnableMetrics {
 				KafkaSendDuration.WithLabelValues(config.ClientID).Set(float64(time.Since(start).Nanoseconds()))
-				KafkaNumberOfSentMsgs.WithLabelValues(config.ClientID).Inc()
-				KafkaNumberOfSentBytes.WithLabelValues(config.ClientID).Add(float64(len(b)))
+				for _, msg := range msgs {
+					KafkaNumberOfSentMsgs.WithLabelValues(config.ClientID).Inc()
+					KafkaNumberOfSentBytes.WithLabelValues(config.ClientID).Add(float64(msg.Value.Length()))
+				}
 			}
+			m.result(nil)
 		}
 	}
 }
@@ -352,6 +501,11 @@ func (k *KafkaOutput) SetName(name string) {
 
 func (k *KafkaOutput) SetClusterName(name string) {}
 
+// Healthy implements outputs.HealthChecker
+func (k *KafkaOutput) Healthy() bool {
+	return atomic.LoadInt32(&k.activeProducers) > 0
+}
+
 func (k *KafkaOutput) createConfig() (*sarama.Config, error) {
 	cfg := sarama.NewConfig()
 	cfg.ClientID = k.Cfg.Name
@@ -401,9 +555,164 @@ func (k *KafkaOutput) createConfig() (*sarama.Config, error) {
 	}
 
 	cfg.Producer.Retry.Max = k.Cfg.MaxRetry
-	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.RequiredAcks = requiredAcks[k.Cfg.RequiredAcks]
+	cfg.Producer.Compression = compressionCodecs[k.Cfg.CompressionCodec]
 	cfg.Producer.Return.Successes = true
 	cfg.Producer.Timeout = k.Cfg.Timeout
 
+	if k.Cfg.Version != "" {
+		var err error
+		cfg.Version, err = sarama.ParseKafkaVersion(k.Cfg.Version)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// use the min version supporting the configured features
+		switch {
+		case cfg.Producer.Compression == sarama.CompressionZSTD:
+			cfg.Version = sarama.V2_1_0_0
+		case k.Cfg.AddMetaHeaders:
+			cfg.Version = sarama.V0_11_0_0
+		case cfg.Producer.Compression == sarama.CompressionLZ4:
+			cfg.Version = sarama.V0_10_0_0
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
+
+// producerMsgs builds the kafka messages carrying message m.
+// The avro format produces a kafka message per event, the other formats a single message.
+func (k *KafkaOutput) producerMsgs(ctx context.Context, m *protoMsg) ([]*sarama.ProducerMessage, error) {
+	if k.serializer == nil {
+		var b []byte
+		var err error
+		if m.evs != nil {
+			b, err = k.mo.MarshalEvents(m.evs, m.meta)
+		} else {
+			b, err = k.mo.Marshal(m.m, m.meta, k.evps...)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return nil, nil
+		}
+		var ev *formatters.EventMsg
+		if len(m.evs) == 1 {
+			ev = m.evs[0]
+		}
+		msg, err := k.producerMsg(b, m.meta, ev)
+		if err != nil {
+			return nil, err
+		}
+		return []*sarama.ProducerMessage{msg}, nil
+	}
+	evs := m.evs
+	if evs == nil {
+		rsp, ok := k.mo.OverrideTimestamp(m.m).(*gnmi.SubscribeResponse)
+		if !ok {
+			return nil, fmt.Errorf("format 'avro' not supported for msg type %T", m.m)
+		}
+		subscriptionName, ok := m.meta["subscription-name"]
+		if !ok {
+			subscriptionName = "default"
+		}
+		var err error
+		evs, err = formatters.ResponseToEventMsgs(subscriptionName, rsp, m.meta, k.evps...)
+		if err != nil {
+			return nil, fmt.Errorf("failed converting response to events: %v", err)
+		}
+	}
+	msgs := make([]*sarama.ProducerMessage, 0, len(evs))
+	for _, ev := range evs {
+		msg, err := k.producerMsg(nil, m.meta, ev)
+		if err != nil {
+			return nil, err
+		}
+		b, err := k.serializer.Encode(ctx, avro.Subject(msg.Topic), ev)
+		if err != nil {
+			return nil, err
+		}
+		msg.Value = sarama.ByteEncoder(b)
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// templateInput is the data the topic and key templates are executed with
+type templateInput struct {
+	// the message meta: source, subscription-name...
+	Meta outputs.Meta
+	// the event carried by the message, empty if the message
+	// is a gNMI response or carries several events.
+	Event formatters.EventMsg
+}
+
+// producerMsg builds the kafka message carrying the marshaled message b,
+// the topic and key templates are executed with the message meta and its event ev, if not nil.
+func (k *KafkaOutput) producerMsg(b []byte, meta outputs.Meta, ev *formatters.EventMsg) (*sarama.ProducerMessage, error) {
+	msg := &sarama.ProducerMessage{
+		Topic: k.Cfg.Topic,
+		Value: sarama.ByteEncoder(b),
+	}
+	in := &templateInput{Meta: meta}
+	if ev != nil {
+		in.Event = *ev
+	}
+	var err error
+	if k.topicTpl != nil {
+		msg.Topic, err = execTemplate(k.topicTpl, in)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Topic == "" {
+			return nil, errors.New("topic template returned an empty topic")
+		}
+	}
+	if k.keyTpl != nil {
+		key, err := execTemplate(k.keyTpl, in)
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			msg.Key = sarama.StringEncoder(key)
+		}
+	} else if k.Cfg.Key != "" {
+		msg.Key = sarama.StringEncoder(k.Cfg.Key)
+	}
+	if k.Cfg.AddMetaHeaders {
+		keys := make([]string, 0, len(meta))
+		for mk := range meta {
+			keys = append(keys, mk)
+		}
+		sort.Strings(keys)
+		msg.Headers = make([]sarama.RecordHeader, 0, len(meta))
+		for _, mk := range keys {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(mk), Value: []byte(meta[mk])})
+		}
+	}
+	return msg, nil
+}
+
+// parseTemplate returns a nil template if s is not a Go template
+func parseTemplate(name, s string) (*template.Template, error) {
+	if !strings.Contains(s, "{{") {
+		return nil, nil
+	}
+	return template.New(name).
+		Funcs(outputs.TemplateFuncs).
+		Option("missingkey=zero").
+		Parse(s)
+}
+
+func execTemplate(tpl *template.Template, in *templateInput) (string, error) {
+	sb := new(strings.Builder)
+	err := tpl.Execute(sb, in)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
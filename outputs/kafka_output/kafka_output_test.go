@@ -0,0 +1,182 @@
+package kafka_output
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/formatters/avro"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestProducerMsg(t *testing.T) {
+	k := &KafkaOutput{Cfg: &Config{
+		Topic:          `telemetry_{{ index .Meta "subscription-name" }}`,
+		Key:            `{{ index .Meta "source" | host }}`,
+		AddMetaHeaders: true,
+	}}
+	var err error
+	k.topicTpl, err = parseTemplate("topic", k.Cfg.Topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.keyTpl, err = parseTemplate("key", k.Cfg.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	msg, err := k.producerMsg([]byte("value"), meta, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Topic != "telemetry_sub1" {
+		t.Errorf("expected topic telemetry_sub1, got %s", msg.Topic)
+	}
+	if msg.Key != sarama.StringEncoder("router1") {
+		t.Errorf("expected key router1, got %v", msg.Key)
+	}
+	if len(msg.Headers) != 2 ||
+		string(msg.Headers[0].Key) != "source" || string(msg.Headers[0].Value) != "router1:57400" ||
+		string(msg.Headers[1].Key) != "subscription-name" || string(msg.Headers[1].Value) != "sub1" {
+		t.Errorf("unexpected headers: %v", msg.Headers)
+	}
+	// a template resolving to an empty topic is an error
+	k.topicTpl, err = parseTemplate("topic", `{{ index .Meta "subscription-name" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = k.producerMsg([]byte("value"), outputs.Meta{}, nil)
+	if err == nil {
+		t.Errorf("expected an error for an empty topic")
+	}
+}
+
+func TestProducerMsgEvent(t *testing.T) {
+	k := &KafkaOutput{Cfg: &Config{
+		Topic: `{{ .Event.Name }}_{{ index .Event.Tags "interface_name" }}`,
+		Key:   `{{ index .Meta "source" }}_{{ index .Event.Values "counter" }}`,
+	}}
+	var err error
+	k.topicTpl, err = parseTemplate("topic", k.Cfg.Topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.keyTpl, err = parseTemplate("key", k.Cfg.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &formatters.EventMsg{
+		Name:   "sub1",
+		Tags:   map[string]string{"source": "router1", "interface_name": "eth0"},
+		Values: map[string]interface{}{"counter": 42},
+	}
+	msg, err := k.producerMsg([]byte("value"), outputs.Meta{"source": "router1"}, ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Topic != "sub1_eth0" {
+		t.Errorf("expected topic sub1_eth0, got %s", msg.Topic)
+	}
+	if msg.Key != sarama.StringEncoder("router1_42") {
+		t.Errorf("expected key router1_42, got %v", msg.Key)
+	}
+	// without an event, the event fields are empty
+	k.keyTpl, err = parseTemplate("key", `{{ index .Meta "source" }}{{ .Event.Tags.interface_name }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = k.producerMsg([]byte("value"), outputs.Meta{"source": "router1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Key != sarama.StringEncoder("router1") {
+		t.Errorf("expected key router1, got %v", msg.Key)
+	}
+}
+
+func TestCreateConfigVersion(t *testing.T) {
+	for codec, want := range map[string]sarama.KafkaVersion{
+		"":     sarama.MinVersion,
+		"gzip": sarama.MinVersion,
+		"lz4":  sarama.V0_10_0_0,
+		"zstd": sarama.V2_1_0_0,
+	} {
+		k := &KafkaOutput{Cfg: &Config{Name: "gnmic-test", CompressionCodec: codec, RequiredAcks: "leader", Timeout: defaultKafkaTimeout}}
+		cfg, err := k.createConfig()
+		if err != nil {
+			t.Fatalf("codec %q: %v", codec, err)
+		}
+		if cfg.Version != want {
+			t.Errorf("codec %q: expected version %s, got %s", codec, want, cfg.Version)
+		}
+		if cfg.Producer.RequiredAcks != sarama.WaitForLocal {
+			t.Errorf("codec %q: unexpected required acks %v", codec, cfg.Producer.RequiredAcks)
+		}
+	}
+}
+
+func TestProducerMsgsAvro(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/subjects/telemetry_sub1-value/versions" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"id":3}`)
+	}))
+	defer srv.Close()
+	var err error
+	k := &KafkaOutput{
+		Cfg: &Config{Topic: `telemetry_{{ index .Meta "subscription-name" }}`},
+		mo:  &formatters.MarshalOptions{Format: "avro"},
+	}
+	k.topicTpl, err = parseTemplate("topic", k.Cfg.Topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.serializer, err = avro.NewSerializer(&avro.RegistryConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "a"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: 1}},
+					},
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "b"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "up"}},
+					},
+				},
+			},
+		},
+	}
+	msgs, err := k.producerMsgs(context.Background(), &protoMsg{
+		m:    rsp,
+		meta: outputs.Meta{"source": "router1", "subscription-name": "sub1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a kafka message per event
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	for _, msg := range msgs {
+		if msg.Topic != "telemetry_sub1" {
+			t.Errorf("unexpected topic %s", msg.Topic)
+		}
+		b, _ := msg.Value.Encode()
+		if len(b) < 5 || b[0] != 0 || b[4] != 3 {
+			t.Errorf("unexpected message framing: %v", b)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package loki_output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const pushPath = "/loki/api/v1/push"
+
+// batch is a set of log entries grouped in streams by label set
+type batch struct {
+	streams map[string]*stream
+	size    int
+}
+
+type stream struct {
+	labels  map[string]string
+	entries []entry
+}
+
+type entry struct {
+	ts   int64
+	line string
+}
+
+func newBatch() *batch {
+	return &batch{streams: make(map[string]*stream)}
+}
+
+func (b *batch) add(labels map[string]string, ts int64, line string) {
+	k := labelsKey(labels)
+	s, ok := b.streams[k]
+	if !ok {
+		s = &stream{labels: labels}
+		b.streams[k] = s
+	}
+	s.entries = append(s.entries, entry{ts: ts, line: line})
+	b.size++
+}
+
+// pushRequest is the body of a Loki push API JSON request
+type pushRequest struct {
+	Streams []pushStream `json:"streams"`
+}
+
+type pushStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// encode builds the push request body,
+// the entries of each stream are sorted by timestamp as expected by Loki.
+func (b *batch) encode() ([]byte, error) {
+	req := pushRequest{Streams: make([]pushStream, 0, len(b.streams))}
+	keys := make([]string, 0, len(b.streams))
+	for k := range b.streams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := b.streams[k]
+		sort.SliceStable(s.entries, func(i, j int) bool {
+			return s.entries[i].ts < s.entries[j].ts
+		})
+		ps := pushStream{
+			Stream: s.labels,
+			Values: make([][2]string, 0, len(s.entries)),
+		}
+		for _, e := range s.entries {
+			ps.Values = append(ps.Values, [2]string{strconv.FormatInt(e.ts, 10), e.line})
+		}
+		req.Streams = append(req.Streams, ps)
+	}
+	return json.Marshal(req)
+}
+
+// pushError is returned when Loki rejects a push request
+type pushError struct {
+	status int
+	msg    string
+}
+
+func (e *pushError) Error() string {
+	return fmt.Sprintf("push failed, status=%d %s: %s", e.status, http.StatusText(e.status), e.msg)
+}
+
+// retryable returns true if the request can be retried:
+// the server is overloaded or failed, as opposed to a rejected request.
+func (e *pushError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status/100 == 5
+}
+
+func failureReason(err error) string {
+	if perr, ok := err.(*pushError); ok {
+		return strconv.Itoa(perr.status)
+	}
+	return "error"
+}
+
+type client struct {
+	httpClient *http.Client
+	pushURL    string
+	tenantID   string
+	username   string
+	password   string
+	useGzip    bool
+	backoff    time.Duration
+}
+
+func newClient(cfg *Config) (*client, error) {
+	u, err := url.Parse(strings.TrimRight(cfg.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url %q: %v", cfg.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	if !strings.HasSuffix(u.Path, pushPath) {
+		u.Path += pushPath
+	}
+	c := &client{
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		pushURL:    u.String(),
+		tenantID:   cfg.TenantID,
+		username:   cfg.Username,
+		password:   cfg.Password,
+		useGzip:    cfg.UseGzip,
+		backoff:    cfg.RetryBackoff,
+	}
+	if cfg.SkipVerify {
+		c.httpClient.Transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		}
+	}
+	return c, nil
+}
+
+// push sends the batch to Loki, retrying up to maxRetries times
+// with an exponential backoff if the request fails with a retryable error.
+func (c *client) push(ctx context.Context, b *batch, maxRetries int) error {
+	body, err := b.encode()
+	if err != nil {
+		return err
+	}
+	if c.useGzip {
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		_, err = zw.Write(body)
+		if err != nil {
+			return err
+		}
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err = c.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if perr, ok := err.(*pushError); ok && !perr.retryable() {
+			return err
+		}
+		if attempt >= maxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+func (c *client) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.useGzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", c.tenantID)
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	rsp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 1024))
+		return &pushError{status: rsp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	io.Copy(ioutil.Discard, rsp.Body)
+	return nil
+}
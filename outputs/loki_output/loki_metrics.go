@@ -0,0 +1,45 @@
+package loki_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var LokiNumberOfSentEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "loki_output",
+	Name:      "number_of_loki_entries_sent_success_total",
+	Help:      "Number of log entries successfully pushed by gnmic loki output",
+}, []string{"name"})
+
+var LokiNumberOfFailedEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "loki_output",
+	Name:      "number_of_loki_entries_sent_fail_total",
+	Help:      "Number of log entries gnmic loki output failed to push",
+}, []string{"name", "reason"})
+
+var LokiPushDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "loki_output",
+	Name:      "push_duration_ns",
+	Help:      "gnmic loki output push duration in ns",
+}, []string{"name"})
+
+func initMetrics() {
+	LokiNumberOfSentEntries.WithLabelValues("").Add(0)
+	LokiNumberOfFailedEntries.WithLabelValues("", "").Add(0)
+	LokiPushDuration.WithLabelValues("").Set(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(LokiNumberOfSentEntries); err != nil {
+		return err
+	}
+	if err = reg.Register(LokiNumberOfFailedEntries); err != nil {
+		return err
+	}
+	if err = reg.Register(LokiPushDuration); err != nil {
+		return err
+	}
+	return nil
+}
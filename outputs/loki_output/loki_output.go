@@ -0,0 +1,426 @@
+package loki_output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultURL          = "http://localhost:3100"
+	defaultBatchSize    = 1000
+	defaultFlushTimer   = 5 * time.Second
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff     = 30 * time.Second
+
+	loggingPrefix = "[loki_output] "
+)
+
+var defaultLabels = []string{"source", "subscription-name"}
+
+func init() {
+	outputs.Register("loki", func() outputs.Output {
+		return &LokiOutput{
+			Cfg:       &Config{},
+			eventChan: make(chan *formatters.EventMsg),
+			wg:        new(sync.WaitGroup),
+			logger:    log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+// LokiOutput pushes events as log lines to Grafana Loki
+type LokiOutput struct {
+	Cfg       *Config
+	client    *client
+	logger    *log.Logger
+	cancelFn  context.CancelFunc
+	done      <-chan struct{}
+	eventChan chan *formatters.EventMsg
+	wg        *sync.WaitGroup
+	healthy   int32
+	evps      []formatters.EventProcessor
+
+	lineTpl   *template.Template
+	targetTpl *template.Template
+}
+
+// Config //
+type Config struct {
+	Name string `mapstructure:"name,omitempty"`
+	// Loki address, the push API path is appended to it
+	URL        string `mapstructure:"url,omitempty"`
+	TenantID   string `mapstructure:"tenant-id,omitempty"`
+	Username   string `mapstructure:"username,omitempty"`
+	Password   string `mapstructure:"password,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty"`
+	// event tag names used as stream labels
+	Labels []string `mapstructure:"labels,omitempty"`
+	// labels added to all the streams
+	StaticLabels map[string]string `mapstructure:"static-labels,omitempty"`
+	// Go template executed with each event to produce the log line
+	LineTemplate       string        `mapstructure:"line-template,omitempty"`
+	Timeout            time.Duration `mapstructure:"timeout,omitempty"`
+	BatchSize          int           `mapstructure:"batch-size,omitempty"`
+	FlushTimer         time.Duration `mapstructure:"flush-timer,omitempty"`
+	MaxRetries         int           `mapstructure:"max-retries,omitempty"`
+	RetryBackoff       time.Duration `mapstructure:"retry-backoff,omitempty"`
+	UseGzip            bool          `mapstructure:"use-gzip,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty"`
+	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
+}
+
+func (l *LokiOutput) String() string {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (l *LokiOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && l.logger != nil {
+		l.logger.SetOutput(logger.Writer())
+		l.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (l *LokiOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]interface{}) {
+	for _, epName := range l.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
+				if err != nil {
+					l.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				l.evps = append(l.evps, ep)
+				l.logger.Printf("added event processor '%s' of type=%s to loki output", epName, epType)
+				continue
+			}
+			l.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		l.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (l *LokiOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, l.Cfg)
+	if err != nil {
+		return err
+	}
+	if l.Cfg.Name == "" {
+		l.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	err = l.setDefaults()
+	if err != nil {
+		return err
+	}
+	if l.Cfg.TargetTemplate == "" {
+		l.targetTpl = outputs.DefaultTargetTemplate
+	} else if l.Cfg.AddTarget != "" {
+		l.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(l.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	if l.Cfg.LineTemplate != "" {
+		l.lineTpl, err = template.New("line-template").
+			Funcs(outputs.TemplateFuncs).
+			Option("missingkey=zero").
+			Parse(l.Cfg.LineTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	l.client, err = newClient(l.Cfg)
+	if err != nil {
+		return err
+	}
+	l.setHealthy(true)
+	ctx, l.cancelFn = context.WithCancel(ctx)
+	l.done = ctx.Done()
+	formatters.ConnectEmitters(ctx, l.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			l.WriteEvent(ctx, ev)
+		}
+	})
+	l.wg.Add(1)
+	go l.worker(ctx)
+	l.logger.Printf("initialized loki output: %s", l.String())
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	return nil
+}
+
+func (l *LokiOutput) setDefaults() error {
+	if l.Cfg.URL == "" {
+		l.Cfg.URL = defaultURL
+	}
+	if len(l.Cfg.Labels) == 0 {
+		l.Cfg.Labels = defaultLabels
+	}
+	if l.Cfg.Timeout <= 0 {
+		l.Cfg.Timeout = defaultTimeout
+	}
+	if l.Cfg.BatchSize <= 0 {
+		l.Cfg.BatchSize = defaultBatchSize
+	}
+	if l.Cfg.FlushTimer <= 0 {
+		l.Cfg.FlushTimer = defaultFlushTimer
+	}
+	if l.Cfg.MaxRetries == 0 {
+		l.Cfg.MaxRetries = defaultMaxRetries
+	}
+	if l.Cfg.MaxRetries < 0 {
+		l.Cfg.MaxRetries = 0
+	}
+	if l.Cfg.RetryBackoff <= 0 {
+		l.Cfg.RetryBackoff = defaultRetryBackoff
+	}
+	for n := range l.Cfg.StaticLabels {
+		if labelName(n) != n {
+			return errors.New("invalid static label name: " + strconv.Quote(n))
+		}
+	}
+	return nil
+}
+
+func (l *LokiOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, l.Cfg.AddTarget, l.targetTpl)
+	if err != nil {
+		l.logger.Printf("failed to add target to the response: %v", err)
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		name := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			name = subName
+		}
+		events, err := formatters.ResponseToEventMsgs(name, rsp, meta, l.evps...)
+		if err != nil {
+			l.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			l.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (l *LokiOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	select {
+	case <-ctx.Done():
+	case <-l.done:
+	case l.eventChan <- ev:
+	}
+}
+
+func (l *LokiOutput) Close() error {
+	defer formatters.CloseEventProcessors(l.evps)
+	if l.cancelFn != nil {
+		l.cancelFn()
+	}
+	l.wg.Wait()
+	l.logger.Printf("closed.")
+	return nil
+}
+
+func (l *LokiOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !l.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		l.logger.Printf("failed to register metric: %v", err)
+	}
+}
+
+func (l *LokiOutput) SetName(name string)        {}
+func (l *LokiOutput) SetClusterName(name string) {}
+
+// Healthy implements outputs.HealthChecker
+func (l *LokiOutput) Healthy() bool {
+	return atomic.LoadInt32(&l.healthy) == 1
+}
+
+func (l *LokiOutput) setHealthy(b bool) {
+	if b {
+		atomic.StoreInt32(&l.healthy, 1)
+		return
+	}
+	atomic.StoreInt32(&l.healthy, 0)
+}
+
+// worker accumulates the received events into a batch,
+// the batch is pushed when it reaches batch-size entries or when the flush-timer expires.
+func (l *LokiOutput) worker(ctx context.Context) {
+	defer l.wg.Done()
+	b := newBatch()
+	ticker := time.NewTicker(l.Cfg.FlushTimer)
+	defer ticker.Stop()
+	flush := func(ctx context.Context, retries int) {
+		if b.size == 0 {
+			return
+		}
+		l.push(ctx, b, retries)
+		b = newBatch()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// push the last batch without retries
+			fctx, cancel := context.WithTimeout(context.Background(), l.Cfg.Timeout)
+			flush(fctx, 0)
+			cancel()
+			return
+		case ev := <-l.eventChan:
+			err := l.addEvent(b, ev)
+			if err != nil {
+				l.logger.Printf("failed to format event: %v", err)
+				continue
+			}
+			if b.size >= l.Cfg.BatchSize {
+				flush(ctx, l.Cfg.MaxRetries)
+			}
+		case <-ticker.C:
+			flush(ctx, l.Cfg.MaxRetries)
+		}
+	}
+}
+
+// addEvent formats the event as a log line and appends it to the stream matching its labels
+func (l *LokiOutput) addEvent(b *batch, ev *formatters.EventMsg) error {
+	line, err := l.formatLine(ev)
+	if err != nil {
+		return err
+	}
+	ts := ev.Timestamp
+	if ts == 0 || l.Cfg.OverrideTimestamps {
+		ts = time.Now().UnixNano()
+	}
+	b.add(l.streamLabels(ev), ts, line)
+	return nil
+}
+
+// streamLabels returns the stream labels of an event:
+// the static labels and the configured tags present in the event
+func (l *LokiOutput) streamLabels(ev *formatters.EventMsg) map[string]string {
+	labels := make(map[string]string, len(l.Cfg.StaticLabels)+len(l.Cfg.Labels))
+	for n, v := range l.Cfg.StaticLabels {
+		labels[n] = v
+	}
+	for _, tag := range l.Cfg.Labels {
+		if v, ok := ev.Tags[tag]; ok && v != "" {
+			labels[labelName(tag)] = v
+		}
+	}
+	return labels
+}
+
+func (l *LokiOutput) formatLine(ev *formatters.EventMsg) (string, error) {
+	if l.lineTpl == nil {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	sb := new(strings.Builder)
+	err := l.lineTpl.Execute(sb, ev)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+func (l *LokiOutput) push(ctx context.Context, b *batch, retries int) {
+	start := time.Now()
+	err := l.client.push(ctx, b, retries)
+	l.setHealthy(err == nil)
+	if err != nil {
+		l.logger.Printf("failed to push %d entries: %v", b.size, err)
+		if l.Cfg.EnableMetrics {
+			LokiNumberOfFailedEntries.WithLabelValues(l.Cfg.Name, failureReason(err)).Add(float64(b.size))
+		}
+		return
+	}
+	if l.Cfg.Debug {
+		l.logger.Printf("pushed %d entries in %d streams", b.size, len(b.streams))
+	}
+	if l.Cfg.EnableMetrics {
+		LokiNumberOfSentEntries.WithLabelValues(l.Cfg.Name).Add(float64(b.size))
+		LokiPushDuration.WithLabelValues(l.Cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+	}
+}
+
+// labelName converts a tag name into a valid Loki label name
+func labelName(s string) string {
+	n := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			return r
+		case r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+	if n != "" && n[0] >= '0' && n[0] <= '9' {
+		return "_" + n
+	}
+	return n
+}
+
+// labelsKey returns a string uniquely identifying a label set
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	sb := strings.Builder{}
+	for _, n := range names {
+		sb.WriteString(n)
+		sb.WriteString("=")
+		sb.WriteString(strconv.Quote(labels[n]))
+		sb.WriteString(",")
+	}
+	return sb.String()
+}
@@ -0,0 +1,197 @@
+package loki_output
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+)
+
+// testLoki is a Loki push API stand-in,
+// it replies with the configured status codes before accepting the requests.
+type testLoki struct {
+	m        sync.Mutex
+	statuses []int
+	attempts int
+	pushes   []*pushRequest
+	tenants  []string
+}
+
+func (s *testLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if r.URL.Path != pushPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.attempts++
+	if len(s.statuses) > 0 {
+		status := s.statuses[0]
+		s.statuses = s.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	req := new(pushRequest)
+	err := json.NewDecoder(body).Decode(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.pushes = append(s.pushes, req)
+	s.tenants = append(s.tenants, r.Header.Get("X-Scope-OrgID"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestBatchEncode(t *testing.T) {
+	b := newBatch()
+	r1 := map[string]string{"source": "router1"}
+	r2 := map[string]string{"source": "router2"}
+	b.add(r1, 3, "line3")
+	b.add(r2, 1, "line1")
+	b.add(map[string]string{"source": "router1"}, 2, "line2")
+	if b.size != 3 || len(b.streams) != 2 {
+		t.Fatalf("expected 3 entries in 2 streams, got %d entries in %d streams", b.size, len(b.streams))
+	}
+	body, err := b.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(pushRequest)
+	err = json.Unmarshal(body, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &pushRequest{Streams: []pushStream{
+		{Stream: r1, Values: [][2]string{{"2", "line2"}, {"3", "line3"}}},
+		{Stream: r2, Values: [][2]string{{"1", "line1"}}},
+	}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("push request mismatch: %s", cmp.Diff(want, got))
+	}
+}
+
+func TestLabelName(t *testing.T) {
+	for in, want := range map[string]string{
+		"source":            "source",
+		"subscription-name": "subscription_name",
+		"interface/name":    "interface_name",
+		"0name":             "_0name",
+	} {
+		if got := labelName(in); got != want {
+			t.Errorf("labelName(%q): got %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLokiOutput(t *testing.T) {
+	loki := &testLoki{}
+	srv := httptest.NewServer(loki)
+	defer srv.Close()
+
+	l := outputs.Outputs["loki"]().(*LokiOutput)
+	err := l.Init(context.Background(), "loki1", map[string]interface{}{
+		"url":           srv.URL,
+		"tenant-id":     "tenant1",
+		"labels":        []string{"source", "subscription-name"},
+		"static-labels": map[string]string{"job": "gnmic"},
+		"line-template": `{{ index .Tags "interface_name" }} is {{ index .Values "/interface/oper-state" }}`,
+		"batch-size":    2,
+		"use-gzip":      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i, state := range []string{"down", "up", "down"} {
+		l.WriteEvent(ctx, &formatters.EventMsg{
+			Name:      "sub1",
+			Timestamp: int64(i + 1),
+			Tags: map[string]string{
+				"source":            "router1",
+				"subscription-name": "sub1",
+				"interface_name":    "ethernet-1/1",
+			},
+			Values: map[string]interface{}{"/interface/oper-state": state},
+		})
+	}
+	// the last entry is pushed on close
+	l.Close()
+
+	if len(loki.pushes) != 2 {
+		t.Fatalf("expected 2 push requests, got %d", len(loki.pushes))
+	}
+	labels := map[string]string{"job": "gnmic", "source": "router1", "subscription_name": "sub1"}
+	want := []*pushRequest{
+		{Streams: []pushStream{{Stream: labels, Values: [][2]string{{"1", "ethernet-1/1 is down"}, {"2", "ethernet-1/1 is up"}}}}},
+		{Streams: []pushStream{{Stream: labels, Values: [][2]string{{"3", "ethernet-1/1 is down"}}}}},
+	}
+	if !cmp.Equal(loki.pushes, want) {
+		t.Errorf("push requests mismatch: %s", cmp.Diff(want, loki.pushes))
+	}
+	for _, tenant := range loki.tenants {
+		if tenant != "tenant1" {
+			t.Errorf("unexpected tenant ID %q", tenant)
+		}
+	}
+}
+
+func TestLokiRetry(t *testing.T) {
+	loki := &testLoki{}
+	srv := httptest.NewServer(loki)
+	defer srv.Close()
+
+	c, err := newClient(&Config{URL: srv.URL, Timeout: time.Second, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newBatch()
+	b.add(map[string]string{"source": "router1"}, 1, "line1")
+
+	// server errors are retried
+	loki.statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	err = c.push(context.Background(), b, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loki.attempts != 3 || len(loki.pushes) != 1 {
+		t.Errorf("expected 3 attempts and 1 push, got %d attempts and %d pushes", loki.attempts, len(loki.pushes))
+	}
+	// until max-retries is reached
+	loki.attempts = 0
+	loki.statuses = []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError}
+	err = c.push(context.Background(), b, 2)
+	if err == nil || failureReason(err) != "500" {
+		t.Errorf("expected a 500 push error, got %v", err)
+	}
+	if loki.attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", loki.attempts)
+	}
+	// rejected requests are not retried
+	loki.attempts = 0
+	loki.statuses = []int{http.StatusBadRequest}
+	err = c.push(context.Background(), b, 3)
+	if err == nil || failureReason(err) != "400" {
+		t.Errorf("expected a 400 push error, got %v", err)
+	}
+	if loki.attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", loki.attempts)
+	}
+}
@@ -44,6 +44,8 @@ func init() {
 type protoMsg struct {
 	m    proto.Message
 	meta outputs.Meta
+	// events emitted by the event processors, set instead of m
+	evs []*formatters.EventMsg
 }
 
 // NatsOutput //
@@ -70,6 +72,7 @@ type Config struct {
 	Password           string        `mapstructure:"password,omitempty"`
 	ConnectTimeWait    time.Duration `mapstructure:"connect-time-wait,omitempty"`
 	Format             string        `mapstructure:"format,omitempty"`
+	MsgTemplate        string        `mapstructure:"msg-template,omitempty"`
 	AddTarget          string        `mapstructure:"add-target,omitempty"`
 	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
 	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
@@ -105,7 +108,7 @@ func (n *NatsOutput) SetEventProcessors(ps map[string]map[string]interface{}, lo
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					n.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -139,10 +142,17 @@ func (n *NatsOutput) Init(ctx context.Context, name string, cfg map[string]inter
 	}
 	n.msgChan = make(chan *protoMsg)
 	initMetrics()
+	formatters.WarnDroppedTags(n.logger.Printf, n.Cfg.Format, n.evps)
 	n.mo = &formatters.MarshalOptions{
 		Format:     n.Cfg.Format,
 		OverrideTS: n.Cfg.OverrideTimestamps,
 	}
+	if n.Cfg.Format == "template" {
+		n.mo.Template, err = formatters.ParseMsgTemplate(n.Cfg.MsgTemplate)
+		if err != nil {
+			return err
+		}
+	}
 	if n.Cfg.TargetTemplate == "" {
 		n.targetTpl = outputs.DefaultTargetTemplate
 	} else if n.Cfg.AddTarget != "" {
@@ -154,6 +164,9 @@ func (n *NatsOutput) Init(ctx context.Context, name string, cfg map[string]inter
 		}
 	}
 	n.ctx, n.cancelFn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(n.ctx, n.evps, func(es []*formatters.EventMsg) {
+		n.send(n.ctx, &protoMsg{meta: formatters.EventMeta(es[0]), evs: es})
+	})
 	n.wg.Add(n.Cfg.NumWorkers)
 	for i := 0; i < n.Cfg.NumWorkers; i++ {
 		cfg := *n.Cfg
@@ -172,7 +185,7 @@ func (n *NatsOutput) setDefaults() error {
 	if n.Cfg.Format == "" {
 		n.Cfg.Format = defaultFormat
 	}
-	if !(n.Cfg.Format == "event" || n.Cfg.Format == "protojson" || n.Cfg.Format == "proto" || n.Cfg.Format == "json") {
+	if !(n.Cfg.Format == "event" || n.Cfg.Format == "protojson" || n.Cfg.Format == "proto" || n.Cfg.Format == "json" || n.Cfg.Format == "template") {
 		return fmt.Errorf("unsupported output format '%s' for output type NATS", n.Cfg.Format)
 	}
 	if n.Cfg.Address == "" {
@@ -201,14 +214,24 @@ func (n *NatsOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.
 	if rsp == nil || n.mo == nil {
 		return
 	}
+	n.send(ctx, &protoMsg{m: rsp, meta: meta})
+}
+
+func (n *NatsOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if n.mo == nil {
+		return
+	}
+	n.send(ctx, &protoMsg{meta: formatters.EventMeta(ev), evs: []*formatters.EventMsg{ev}})
+}
 
+func (n *NatsOutput) send(ctx context.Context, m *protoMsg) {
 	wctx, cancel := context.WithTimeout(ctx, n.Cfg.WriteTimeout)
 	defer cancel()
 
 	select {
 	case <-ctx.Done():
 		return
-	case n.msgChan <- &protoMsg{m: rsp, meta: meta}:
+	case n.msgChan <- m:
 	case <-wctx.Done():
 		if n.Cfg.Debug {
 			n.logger.Printf("writing expired after %s, NATS output might not be initialized", n.Cfg.WriteTimeout)
@@ -220,10 +243,9 @@ func (n *NatsOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.
 	}
 }
 
-func (n *NatsOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
-
 // Close //
 func (n *NatsOutput) Close() error {
+	defer formatters.CloseEventProcessors(n.evps)
 	//	n.conn.Close()
 	n.cancelFn()
 	n.wg.Wait()
@@ -314,11 +336,7 @@ CRCONN:
 			n.logger.Printf("%s shutting down", workerLogPrefix)
 			return
 		case m := <-n.msgChan:
-			err = outputs.AddSubscriptionTarget(m.m, m.meta, n.Cfg.AddTarget, n.targetTpl)
-			if err != nil {
-				n.logger.Printf("failed to add target to the response: %v", err)
-			}
-			b, err := n.mo.Marshal(m.m, m.meta, n.evps...)
+			b, err := n.marshal(m)
 			if err != nil {
 				if n.Cfg.Debug {
 					n.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
@@ -328,6 +346,9 @@ CRCONN:
 				}
 				continue
 			}
+			if len(b) == 0 {
+				continue
+			}
 			subject := n.subjectName(cfg, m.meta)
 			var start time.Time
 			if n.Cfg.EnableMetrics {
@@ -354,6 +375,18 @@ CRCONN:
 	}
 }
 
+// marshal adds the target to the message and marshals it, or marshals the emitted events
+func (n *NatsOutput) marshal(m *protoMsg) ([]byte, error) {
+	if m.evs != nil {
+		return n.mo.MarshalEvents(m.evs, m.meta)
+	}
+	err := outputs.AddSubscriptionTarget(m.m, m.meta, n.Cfg.AddTarget, n.targetTpl)
+	if err != nil {
+		n.logger.Printf("failed to add target to the response: %v", err)
+	}
+	return n.mo.Marshal(m.m, m.meta, n.evps...)
+}
+
 func (n *NatsOutput) subjectName(c *Config, meta outputs.Meta) string {
 	if c.SubjectPrefix != "" {
 		ssb := strings.Builder{}
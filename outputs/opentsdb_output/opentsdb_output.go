@@ -0,0 +1,313 @@
+package opentsdb_output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/outputs/socket"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultAddress        = "localhost:4242"
+	defaultNetwork        = "tcp"
+	defaultMetricTemplate = `{{ .Name }}.{{ .Path }}`
+
+	loggingPrefix = "[opentsdb_output] "
+)
+
+func init() {
+	outputs.Register("opentsdb", func() outputs.Output {
+		return &OpenTSDBOutput{
+			Cfg:    &Config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+// OpenTSDBOutput writes event values as OpenTSDB telnet style put lines:
+// put <metric> <timestamp> <value> <tagk1=tagv1 ...tagkN=tagvN>
+type OpenTSDBOutput struct {
+	Cfg      *Config
+	logger   *log.Logger
+	cancelFn context.CancelFunc
+	done     <-chan struct{}
+	wg       *sync.WaitGroup
+	evps     []formatters.EventProcessor
+	bw       *socket.BatchWriter
+
+	metricTpl *template.Template
+	targetTpl *template.Template
+}
+
+// Config //
+type Config struct {
+	// OpenTSDB server address, host:port
+	Address string `mapstructure:"address,omitempty"`
+	// tcp or udp
+	Network string `mapstructure:"network,omitempty"`
+	// string prepended to the metric names
+	Prefix string `mapstructure:"prefix,omitempty"`
+	// Go template executed with each value to produce its metric name
+	MetricTemplate string `mapstructure:"metric-template,omitempty"`
+	// event tags sent as OpenTSDB tags, all the event tags if empty
+	Tags               []string      `mapstructure:"tags,omitempty"`
+	BatchSize          int           `mapstructure:"batch-size,omitempty"`
+	BufferSize         int           `mapstructure:"buffer-size,omitempty"`
+	FlushTimer         time.Duration `mapstructure:"flush-timer,omitempty"`
+	RetryTimer         time.Duration `mapstructure:"retry-timer,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
+}
+
+func (o *OpenTSDBOutput) String() string {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (o *OpenTSDBOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && o.logger != nil {
+		o.logger.SetOutput(logger.Writer())
+		o.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (o *OpenTSDBOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]interface{}) {
+	for _, epName := range o.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
+				if err != nil {
+					o.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				o.evps = append(o.evps, ep)
+				o.logger.Printf("added event processor '%s' of type=%s to opentsdb output", epName, epType)
+				continue
+			}
+			o.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		o.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (o *OpenTSDBOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, o.Cfg)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Cfg.Address == "" {
+		o.Cfg.Address = defaultAddress
+	}
+	if o.Cfg.Network == "" {
+		o.Cfg.Network = defaultNetwork
+	}
+	if o.Cfg.Network != "tcp" && o.Cfg.Network != "udp" {
+		return fmt.Errorf("unsupported network %q", o.Cfg.Network)
+	}
+	if o.Cfg.MetricTemplate == "" {
+		o.Cfg.MetricTemplate = defaultMetricTemplate
+	}
+	if o.Cfg.TargetTemplate == "" {
+		o.targetTpl = outputs.DefaultTargetTemplate
+	} else if o.Cfg.AddTarget != "" {
+		o.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(o.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	o.metricTpl, err = template.New("metric-template").
+		Funcs(outputs.TemplateFuncs).
+		Option("missingkey=zero").
+		Parse(o.Cfg.MetricTemplate)
+	if err != nil {
+		return err
+	}
+	o.bw = socket.NewBatchWriter(
+		socket.NewWriter(o.Cfg.Network, o.Cfg.Address, socket.DefaultDialTimeout),
+		socket.BatchConfig{
+			Name:       name,
+			BufferSize: o.Cfg.BufferSize,
+			BatchSize:  o.Cfg.BatchSize,
+			FlushTimer: o.Cfg.FlushTimer,
+			RetryTimer: o.Cfg.RetryTimer,
+		},
+		o.encode,
+		o.logger,
+	)
+	ctx, o.cancelFn = context.WithCancel(ctx)
+	o.done = ctx.Done()
+	formatters.ConnectEmitters(ctx, o.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			o.WriteEvent(ctx, ev)
+		}
+	})
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		o.bw.Run(ctx)
+	}()
+	o.logger.Printf("initialized opentsdb output: %s", o.String())
+	go func() {
+		<-ctx.Done()
+		o.Close()
+	}()
+	return nil
+}
+
+func (o *OpenTSDBOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, o.Cfg.AddTarget, o.targetTpl)
+	if err != nil {
+		o.logger.Printf("failed to add target to the response: %v", err)
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		name := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			name = subName
+		}
+		events, err := formatters.ResponseToEventMsgs(name, rsp, meta, o.evps...)
+		if err != nil {
+			o.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			o.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (o *OpenTSDBOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if o.bw == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-o.done:
+	default:
+		if !o.bw.Send(ev) && o.Cfg.Debug {
+			o.logger.Printf("buffer full, dropped event: %s", ev.Name)
+		}
+	}
+}
+
+func (o *OpenTSDBOutput) Close() error {
+	defer formatters.CloseEventProcessors(o.evps)
+	if o.cancelFn != nil {
+		o.cancelFn()
+	}
+	o.wg.Wait()
+	o.logger.Printf("closed.")
+	return nil
+}
+
+func (o *OpenTSDBOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if err := socket.RegisterMetrics(reg); err != nil {
+		o.logger.Printf("failed to register metrics: %v", err)
+	}
+}
+
+func (o *OpenTSDBOutput) SetName(name string)        {}
+func (o *OpenTSDBOutput) SetClusterName(name string) {}
+
+// Healthy implements outputs.HealthChecker
+func (o *OpenTSDBOutput) Healthy() bool {
+	return o.bw != nil && o.bw.Healthy()
+}
+
+// encode appends a put line per numeric value of the event to buf
+func (o *OpenTSDBOutput) encode(buf *bytes.Buffer, ev *formatters.EventMsg) (int, error) {
+	tags := o.tags(ev)
+	if tags == "" {
+		return 0, fmt.Errorf("event %q has no tags, OpenTSDB requires at least one tag", ev.Name)
+	}
+	ts := socket.Timestamp(ev, o.Cfg.OverrideTimestamps).UnixNano() / int64(time.Millisecond)
+	n := 0
+	for _, vn := range socket.ValueNames(ev) {
+		f, ok := socket.Float(ev.Values[vn])
+		if !ok {
+			if o.Cfg.Debug {
+				o.logger.Printf("skipping non numeric value %q: %v", vn, ev.Values[vn])
+			}
+			continue
+		}
+		metric, err := socket.MetricName(o.metricTpl, socket.NewMetricData(ev, vn, clean))
+		if err != nil {
+			return n, err
+		}
+		if o.Cfg.Prefix != "" {
+			metric = o.Cfg.Prefix + "." + metric
+		}
+		fmt.Fprintf(buf, "put %s %d %s %s\n", metric, ts, socket.FormatFloat(f), tags)
+		n++
+	}
+	return n, nil
+}
+
+// tags returns the OpenTSDB tags of an event, sorted by name
+func (o *OpenTSDBOutput) tags(ev *formatters.EventMsg) string {
+	names := o.Cfg.Tags
+	if len(names) == 0 {
+		names = make([]string, 0, len(ev.Tags))
+		for k := range ev.Tags {
+			names = append(names, k)
+		}
+	}
+	tags := make([]string, 0, len(names))
+	for _, k := range names {
+		v, ok := ev.Tags[k]
+		if !ok || v == "" {
+			continue
+		}
+		tags = append(tags, clean(k)+"="+clean(v))
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, " ")
+}
+
+// clean replaces the characters not allowed in OpenTSDB metric names and tags with '_'
+func clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_', r == '.', r == '/':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,74 @@
+package opentsdb_output
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+)
+
+func TestEncode(t *testing.T) {
+	ev := &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 1606824673847153523,
+		Tags: map[string]string{
+			"source":            "10.1.1.1:57400",
+			"interface_name":    "ethernet-1/1",
+			"subscription-name": "sub1",
+		},
+		Values: map[string]interface{}{
+			"/interface/statistics/in-octets": uint64(42),
+			"/interface/oper-state":           "up",
+			"/interface/admin-enabled":        true,
+		},
+	}
+	tests := map[string]struct {
+		cfg  map[string]interface{}
+		want string
+	}{
+		"default": {
+			cfg: map[string]interface{}{},
+			want: "put sub1.interface.admin-enabled 1606824673847 1 interface_name=ethernet-1/1 source=10.1.1.1_57400 subscription-name=sub1\n" +
+				"put sub1.interface.statistics.in-octets 1606824673847 42 interface_name=ethernet-1/1 source=10.1.1.1_57400 subscription-name=sub1\n",
+		},
+		"prefix_and_tags": {
+			cfg: map[string]interface{}{
+				"prefix": "gnmic",
+				"tags":   []string{"source", "interface_name", "missing"},
+			},
+			want: "put gnmic.sub1.interface.admin-enabled 1606824673847 1 interface_name=ethernet-1/1 source=10.1.1.1_57400\n" +
+				"put gnmic.sub1.interface.statistics.in-octets 1606824673847 42 interface_name=ethernet-1/1 source=10.1.1.1_57400\n",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := outputs.Outputs["opentsdb"]().(*OpenTSDBOutput)
+			err := o.Init(context.Background(), name, tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer o.Close()
+			buf := new(bytes.Buffer)
+			_, err = o.encode(buf, ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), tc.want)
+			}
+		})
+	}
+	// at least one tag is required
+	o := outputs.Outputs["opentsdb"]().(*OpenTSDBOutput)
+	err := o.Init(context.Background(), "no-tags", map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	_, err = o.encode(new(bytes.Buffer), &formatters.EventMsg{Name: "sub1", Values: map[string]interface{}{"/a": 1}})
+	if err == nil {
+		t.Errorf("expected an error for an event without tags")
+	}
+}
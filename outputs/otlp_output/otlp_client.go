@@ -0,0 +1,272 @@
+package otlp_output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	protocolGRPC = "grpc"
+	protocolHTTP = "http"
+
+	httpMetricsPath = "/v1/metrics"
+)
+
+// exporter sends export requests to an OTLP receiver
+type exporter interface {
+	export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error
+	close() error
+}
+
+// exportError is returned when the receiver rejects an export request
+type exportError struct {
+	// HTTP status or gRPC code name
+	reason    string
+	msg       string
+	retryable bool
+}
+
+func (e *exportError) Error() string {
+	return fmt.Sprintf("export failed, %s: %s", e.reason, e.msg)
+}
+
+func failureReason(err error) string {
+	if eerr, ok := err.(*exportError); ok {
+		return eerr.reason
+	}
+	return "error"
+}
+
+// export sends the request, retrying up to maxRetries times
+// with an exponential backoff if the request fails with a retryable error.
+func (o *OTLPOutput) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest, maxRetries int) error {
+	backoff := o.Cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := o.exporter.export(ctx, req)
+		if err == nil {
+			return nil
+		}
+		if eerr, ok := err.(*exportError); ok && !eerr.retryable {
+			return err
+		}
+		if attempt >= maxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+func (o *OTLPOutput) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		Renegotiation:      tls.RenegotiateNever,
+		InsecureSkipVerify: o.Cfg.SkipVerify,
+	}
+	if o.Cfg.TLSCert != "" && o.Cfg.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(o.Cfg.TLSCert, o.Cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if o.Cfg.TLSCA != "" {
+		b, err := ioutil.ReadFile(o.Cfg.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("failed to append CA certificates from %q", o.Cfg.TLSCA)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// grpcExporter exports over OTLP/gRPC
+type grpcExporter struct {
+	conn    *grpc.ClientConn
+	client  colmetricspb.MetricsServiceClient
+	md      metadata.MD
+	timeout time.Duration
+}
+
+func (o *OTLPOutput) newGRPCExporter() (*grpcExporter, error) {
+	opts := make([]grpc.DialOption, 0, 2)
+	if o.Cfg.Insecure {
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		tlsConfig, err := o.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	if o.Cfg.Compression == "gzip" {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(grpcgzip.Name)))
+	}
+	// the connection is established in the background and re-established on failure
+	conn, err := grpc.Dial(o.Cfg.Endpoint, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcExporter{
+		conn:    conn,
+		client:  colmetricspb.NewMetricsServiceClient(conn),
+		md:      metadata.New(o.Cfg.Headers),
+		timeout: o.Cfg.Timeout,
+	}, nil
+}
+
+func (e *grpcExporter) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+	if len(e.md) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, e.md)
+	}
+	_, err := e.client.Export(ctx, req)
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	return &exportError{
+		reason:    st.Code().String(),
+		msg:       st.Message(),
+		retryable: retryableCode(st.Code()),
+	}
+}
+
+// retryableCode returns true for the gRPC codes the OTLP specification defines as retryable
+func retryableCode(c codes.Code) bool {
+	switch c {
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+func (e *grpcExporter) close() error {
+	return e.conn.Close()
+}
+
+// httpExporter exports over OTLP/HTTP, using the binary protobuf encoding
+type httpExporter struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+	gzip    bool
+}
+
+func (o *OTLPOutput) newHTTPExporter() (*httpExporter, error) {
+	u, err := url.Parse(o.Cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse endpoint %q: %v", o.Cfg.Endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported endpoint scheme %q", u.Scheme)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = httpMetricsPath
+	}
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &httpExporter{
+		client: &http.Client{
+			Timeout: o.Cfg.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		url:     u.String(),
+		headers: o.Cfg.Headers,
+		gzip:    o.Cfg.Compression == "gzip",
+	}, nil
+}
+
+func (e *httpExporter) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if e.gzip {
+		buf := new(bytes.Buffer)
+		zw := gzip.NewWriter(buf)
+		_, err = zw.Write(body)
+		if err != nil {
+			return err
+		}
+		err = zw.Close()
+		if err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	hreq, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range e.headers {
+		hreq.Header.Set(k, v)
+	}
+	hreq.Header.Set("Content-Type", "application/x-protobuf")
+	if e.gzip {
+		hreq.Header.Set("Content-Encoding", "gzip")
+	}
+	rsp, err := e.client.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 1024))
+		return &exportError{
+			reason: strconv.Itoa(rsp.StatusCode),
+			msg:    strings.TrimSpace(string(msg)),
+			// retryable status codes as defined by the OTLP specification
+			retryable: rsp.StatusCode == http.StatusTooManyRequests ||
+				rsp.StatusCode == http.StatusBadGateway ||
+				rsp.StatusCode == http.StatusServiceUnavailable ||
+				rsp.StatusCode == http.StatusGatewayTimeout,
+		}
+	}
+	io.Copy(ioutil.Discard, rsp.Body)
+	return nil
+}
+
+func (e *httpExporter) close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,309 @@
+package otlp_output
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+const (
+	instrumentationLibrary = "github.com/karimra/gnmic"
+
+	metricTypeGauge = "gauge"
+	metricTypeSum   = "sum"
+)
+
+// MetricRule sets the type, description and unit of the metrics generated from the values
+// with a path matching the rule regex.
+type MetricRule struct {
+	// regular expression matched against the value path, e.g: ^/interfaces/interface/state/counters/
+	Path string `mapstructure:"path,omitempty" json:"path,omitempty"`
+	// one of gauge or sum
+	Type string `mapstructure:"type,omitempty" json:"type,omitempty"`
+	// sum only, defaults to true
+	Monotonic   *bool  `mapstructure:"monotonic,omitempty" json:"monotonic,omitempty"`
+	Description string `mapstructure:"description,omitempty" json:"description,omitempty"`
+	Unit        string `mapstructure:"unit,omitempty" json:"unit,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (r *MetricRule) isSum() bool {
+	return r != nil && r.Type == metricTypeSum
+}
+
+func (r *MetricRule) isMonotonic() bool {
+	return r.Monotonic == nil || *r.Monotonic
+}
+
+var metricNameRegex = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+func (o *OTLPOutput) initMetricRules() error {
+	for i, r := range o.Cfg.MetricRules {
+		if r.Path == "" {
+			return fmt.Errorf("metric-rules[%d]: missing path", i)
+		}
+		var err error
+		r.re, err = regexp.Compile(r.Path)
+		if err != nil {
+			return fmt.Errorf("metric-rules[%d]: invalid path regex: %v", i, err)
+		}
+		r.Type = strings.ToLower(r.Type)
+		switch r.Type {
+		case "":
+			r.Type = metricTypeGauge
+		case metricTypeGauge, metricTypeSum:
+		default:
+			return fmt.Errorf("metric-rules[%d]: unknown metric type %q", i, r.Type)
+		}
+	}
+	o.ruleCache = make(map[string]*MetricRule)
+	return nil
+}
+
+// metricRule returns the first rule matching the value path, or nil.
+// must be called from the worker goroutine.
+func (o *OTLPOutput) metricRule(valuePath string) *MetricRule {
+	if len(o.Cfg.MetricRules) == 0 {
+		return nil
+	}
+	if r, ok := o.ruleCache[valuePath]; ok {
+		return r
+	}
+	var rule *MetricRule
+	for _, r := range o.Cfg.MetricRules {
+		if r.re.MatchString(valuePath) {
+			rule = r
+			break
+		}
+	}
+	o.ruleCache[valuePath] = rule
+	return rule
+}
+
+// metricName builds the metric name from the value path:
+// its elements are joined with '.', optionally preceded by the metric prefix and the subscription name.
+func (o *OTLPOutput) metricName(measName, valueName string) string {
+	elems := make([]string, 0, 3)
+	if o.Cfg.MetricPrefix != "" {
+		elems = append(elems, o.Cfg.MetricPrefix)
+	}
+	if o.Cfg.AppendSubscriptionName {
+		elems = append(elems, measName)
+	}
+	elems = append(elems, strings.Replace(strings.Trim(valueName, "/"), "/", ".", -1))
+	return metricNameRegex.ReplaceAllString(strings.Join(elems, "."), "_")
+}
+
+// batch accumulates the data points grouped by resource
+type batch struct {
+	resources map[string]*resourceMetrics
+	// number of data points
+	size int
+}
+
+type resourceMetrics struct {
+	attrs   []*commonpb.KeyValue
+	metrics map[string]*metricspb.Metric
+}
+
+func newBatch() *batch {
+	return &batch{resources: make(map[string]*resourceMetrics)}
+}
+
+// addEvent converts the numeric values of the event to data points,
+// the values of other types are skipped.
+func (o *OTLPOutput) addEvent(b *batch, ev *formatters.EventMsg) {
+	ts := ev.Timestamp
+	if ts == 0 || o.Cfg.OverrideTimestamps {
+		ts = time.Now().UnixNano()
+	}
+	resAttrs := make(map[string]string, len(o.Cfg.ResourceAttributes)+len(o.Cfg.ResourceTags))
+	for k, v := range o.Cfg.ResourceAttributes {
+		resAttrs[k] = v
+	}
+	dpAttrs := make(map[string]string, len(ev.Tags))
+	for k, v := range ev.Tags {
+		if o.resourceTags[k] {
+			resAttrs[k] = v
+			continue
+		}
+		dpAttrs[k] = v
+	}
+	resKey, resKVs := keyValues(resAttrs)
+	res, ok := b.resources[resKey]
+	if !ok {
+		res = &resourceMetrics{
+			attrs:   resKVs,
+			metrics: make(map[string]*metricspb.Metric),
+		}
+		b.resources[resKey] = res
+	}
+	_, dpKVs := keyValues(dpAttrs)
+	for vn, v := range ev.Values {
+		dp := &metricspb.NumberDataPoint{
+			Attributes:   dpKVs,
+			TimeUnixNano: uint64(ts),
+		}
+		if !setValue(dp, v) {
+			continue
+		}
+		name := o.metricName(ev.Name, vn)
+		rule := o.metricRule(vn)
+		m, ok := res.metrics[name]
+		if !ok {
+			m = newMetric(name, rule)
+			res.metrics[name] = m
+		}
+		switch data := m.Data.(type) {
+		case *metricspb.Metric_Sum:
+			dp.StartTimeUnixNano = o.startTime
+			data.Sum.DataPoints = append(data.Sum.DataPoints, dp)
+		case *metricspb.Metric_Gauge:
+			data.Gauge.DataPoints = append(data.Gauge.DataPoints, dp)
+		}
+		b.size++
+	}
+}
+
+func newMetric(name string, rule *MetricRule) *metricspb.Metric {
+	m := &metricspb.Metric{Name: name}
+	if rule != nil {
+		m.Description = rule.Description
+		m.Unit = rule.Unit
+	}
+	if rule.isSum() {
+		// gNMI counters are cumulative, their start time is the output start time
+		m.Data = &metricspb.Metric_Sum{
+			Sum: &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            rule.isMonotonic(),
+			},
+		}
+		return m
+	}
+	m.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+	return m
+}
+
+// request builds the export request, the resources and metrics are sorted
+func (b *batch) request() *colmetricspb.ExportMetricsServiceRequest {
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: make([]*metricspb.ResourceMetrics, 0, len(b.resources)),
+	}
+	resKeys := make([]string, 0, len(b.resources))
+	for k := range b.resources {
+		resKeys = append(resKeys, k)
+	}
+	sort.Strings(resKeys)
+	for _, k := range resKeys {
+		res := b.resources[k]
+		names := make([]string, 0, len(res.metrics))
+		for n := range res.metrics {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		ilm := &metricspb.InstrumentationLibraryMetrics{
+			InstrumentationLibrary: &commonpb.InstrumentationLibrary{Name: instrumentationLibrary},
+			Metrics:                make([]*metricspb.Metric, 0, len(names)),
+		}
+		for _, n := range names {
+			ilm.Metrics = append(ilm.Metrics, res.metrics[n])
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, &metricspb.ResourceMetrics{
+			Resource:                      &resourcepb.Resource{Attributes: res.attrs},
+			InstrumentationLibraryMetrics: []*metricspb.InstrumentationLibraryMetrics{ilm},
+		})
+	}
+	return req
+}
+
+// keyValues returns the attributes sorted by key, and a string identifying them
+func keyValues(m map[string]string) (string, []*commonpb.KeyValue) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb := strings.Builder{}
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(m[k])
+		sb.WriteString(",")
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: m[k]}},
+		})
+	}
+	return sb.String(), kvs
+}
+
+// setValue sets the data point value from a numeric event value,
+// the integers are set as int values, the other values are converted with formatters.ToFloat:
+// booleans are converted to 0 or 1 and strings are parsed as numbers.
+// It returns false if the value is not numeric.
+func setValue(dp *metricspb.NumberDataPoint, v interface{}) bool {
+	switch v := v.(type) {
+	case int:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(v)}
+		return true
+	case int8:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(v)}
+		return true
+	case int16:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(v)}
+		return true
+	case int32:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(v)}
+		return true
+	case int64:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: v}
+		return true
+	case uint:
+		return setValue(dp, uint64(v))
+	case uint8:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(v)}
+		return true
+	case uint16:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(v)}
+		return true
+	case uint32:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(v)}
+		return true
+	case uint64:
+		if v <= math.MaxInt64 {
+			dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(v)}
+			return true
+		}
+	case bool:
+		if v {
+			dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: 1}
+			return true
+		}
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: 0}
+		return true
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: i}
+			return true
+		}
+	}
+	f, ok := formatters.ToFloat(v)
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return false
+	}
+	dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: f}
+	return true
+}
@@ -0,0 +1,371 @@
+package otlp_output
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultGRPCEndpoint = "localhost:4317"
+	defaultHTTPEndpoint = "http://localhost:4318" + httpMetricsPath
+	defaultBatchSize    = 1000
+	defaultFlushTimer   = 10 * time.Second
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff     = 30 * time.Second
+
+	loggingPrefix = "[otlp_output] "
+)
+
+var defaultResourceTags = []string{"source", "subscription-name"}
+
+func init() {
+	outputs.Register("otlp", func() outputs.Output {
+		return &OTLPOutput{
+			Cfg:       &Config{},
+			eventChan: make(chan *formatters.EventMsg),
+			wg:        new(sync.WaitGroup),
+			logger:    log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+// OTLPOutput exports the events numeric values as OpenTelemetry metrics
+type OTLPOutput struct {
+	Cfg       *Config
+	exporter  exporter
+	logger    *log.Logger
+	cancelFn  context.CancelFunc
+	done      <-chan struct{}
+	eventChan chan *formatters.EventMsg
+	wg        *sync.WaitGroup
+	healthy   int32
+	evps      []formatters.EventProcessor
+
+	resourceTags map[string]bool
+	ruleCache    map[string]*MetricRule
+	// start time of the cumulative sums
+	startTime uint64
+
+	targetTpl *template.Template
+}
+
+// Config //
+type Config struct {
+	Name string `mapstructure:"name,omitempty"`
+	// grpc or http
+	Protocol string `mapstructure:"protocol,omitempty"`
+	// host:port for grpc, URL for http
+	Endpoint   string            `mapstructure:"endpoint,omitempty"`
+	Insecure   bool              `mapstructure:"insecure,omitempty"`
+	SkipVerify bool              `mapstructure:"skip-verify,omitempty"`
+	TLSCA      string            `mapstructure:"tls-ca,omitempty"`
+	TLSCert    string            `mapstructure:"tls-cert,omitempty"`
+	TLSKey     string            `mapstructure:"tls-key,omitempty"`
+	Headers    map[string]string `mapstructure:"headers,omitempty"`
+	// none or gzip
+	Compression string `mapstructure:"compression,omitempty"`
+	// event tag names set as resource attributes instead of data point attributes
+	ResourceTags []string `mapstructure:"resource-tags,omitempty"`
+	// attributes added to all the resources
+	ResourceAttributes     map[string]string `mapstructure:"resource-attributes,omitempty"`
+	MetricPrefix           string            `mapstructure:"metric-prefix,omitempty"`
+	AppendSubscriptionName bool              `mapstructure:"append-subscription-name,omitempty"`
+	MetricRules            []*MetricRule     `mapstructure:"metric-rules,omitempty"`
+	Timeout                time.Duration     `mapstructure:"timeout,omitempty"`
+	BatchSize              int               `mapstructure:"batch-size,omitempty"`
+	FlushTimer             time.Duration     `mapstructure:"flush-timer,omitempty"`
+	MaxRetries             int               `mapstructure:"max-retries,omitempty"`
+	RetryBackoff           time.Duration     `mapstructure:"retry-backoff,omitempty"`
+	Debug                  bool              `mapstructure:"debug,omitempty"`
+	AddTarget              string            `mapstructure:"add-target,omitempty"`
+	TargetTemplate         string            `mapstructure:"target-template,omitempty"`
+	EventProcessors        []string          `mapstructure:"event-processors,omitempty"`
+	EnableMetrics          bool              `mapstructure:"enable-metrics,omitempty"`
+	OverrideTimestamps     bool              `mapstructure:"override-timestamps,omitempty"`
+}
+
+func (o *OTLPOutput) String() string {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (o *OTLPOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && o.logger != nil {
+		o.logger.SetOutput(logger.Writer())
+		o.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (o *OTLPOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]interface{}) {
+	for _, epName := range o.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
+				if err != nil {
+					o.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				o.evps = append(o.evps, ep)
+				o.logger.Printf("added event processor '%s' of type=%s to otlp output", epName, epType)
+				continue
+			}
+			o.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		o.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (o *OTLPOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, o.Cfg)
+	if err != nil {
+		return err
+	}
+	if o.Cfg.Name == "" {
+		o.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	err = o.setDefaults()
+	if err != nil {
+		return err
+	}
+	err = o.initMetricRules()
+	if err != nil {
+		return err
+	}
+	if o.Cfg.TargetTemplate == "" {
+		o.targetTpl = outputs.DefaultTargetTemplate
+	} else if o.Cfg.AddTarget != "" {
+		o.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(o.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	switch o.Cfg.Protocol {
+	case protocolGRPC:
+		o.exporter, err = o.newGRPCExporter()
+	case protocolHTTP:
+		o.exporter, err = o.newHTTPExporter()
+	}
+	if err != nil {
+		return err
+	}
+	o.resourceTags = make(map[string]bool, len(o.Cfg.ResourceTags))
+	for _, t := range o.Cfg.ResourceTags {
+		o.resourceTags[t] = true
+	}
+	o.startTime = uint64(time.Now().UnixNano())
+	o.setHealthy(true)
+	ctx, o.cancelFn = context.WithCancel(ctx)
+	o.done = ctx.Done()
+	formatters.ConnectEmitters(ctx, o.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			o.WriteEvent(ctx, ev)
+		}
+	})
+	o.wg.Add(1)
+	go o.worker(ctx)
+	o.logger.Printf("initialized otlp output: %s", o.String())
+	go func() {
+		<-ctx.Done()
+		o.Close()
+	}()
+	return nil
+}
+
+func (o *OTLPOutput) setDefaults() error {
+	o.Cfg.Protocol = strings.ToLower(o.Cfg.Protocol)
+	switch o.Cfg.Protocol {
+	case "":
+		o.Cfg.Protocol = protocolGRPC
+	case protocolGRPC, protocolHTTP:
+	default:
+		return fmt.Errorf("unsupported protocol %q, expected %q or %q", o.Cfg.Protocol, protocolGRPC, protocolHTTP)
+	}
+	if o.Cfg.Endpoint == "" {
+		o.Cfg.Endpoint = defaultGRPCEndpoint
+		if o.Cfg.Protocol == protocolHTTP {
+			o.Cfg.Endpoint = defaultHTTPEndpoint
+		}
+	}
+	switch o.Cfg.Compression {
+	case "", "none", "gzip":
+	default:
+		return fmt.Errorf("unsupported compression %q, expected 'none' or 'gzip'", o.Cfg.Compression)
+	}
+	if o.Cfg.ResourceTags == nil {
+		o.Cfg.ResourceTags = defaultResourceTags
+	}
+	if o.Cfg.Timeout <= 0 {
+		o.Cfg.Timeout = defaultTimeout
+	}
+	if o.Cfg.BatchSize <= 0 {
+		o.Cfg.BatchSize = defaultBatchSize
+	}
+	if o.Cfg.FlushTimer <= 0 {
+		o.Cfg.FlushTimer = defaultFlushTimer
+	}
+	if o.Cfg.MaxRetries == 0 {
+		o.Cfg.MaxRetries = defaultMaxRetries
+	}
+	if o.Cfg.MaxRetries < 0 {
+		o.Cfg.MaxRetries = 0
+	}
+	if o.Cfg.RetryBackoff <= 0 {
+		o.Cfg.RetryBackoff = defaultRetryBackoff
+	}
+	return nil
+}
+
+func (o *OTLPOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, o.Cfg.AddTarget, o.targetTpl)
+	if err != nil {
+		o.logger.Printf("failed to add target to the response: %v", err)
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		name := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			name = subName
+		}
+		events, err := formatters.ResponseToEventMsgs(name, rsp, meta, o.evps...)
+		if err != nil {
+			o.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			o.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (o *OTLPOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	select {
+	case <-ctx.Done():
+	case <-o.done:
+	case o.eventChan <- ev:
+	}
+}
+
+func (o *OTLPOutput) Close() error {
+	defer formatters.CloseEventProcessors(o.evps)
+	if o.cancelFn != nil {
+		o.cancelFn()
+	}
+	o.wg.Wait()
+	if o.exporter != nil {
+		o.exporter.close()
+	}
+	o.logger.Printf("closed.")
+	return nil
+}
+
+func (o *OTLPOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !o.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		o.logger.Printf("failed to register metric: %v", err)
+	}
+}
+
+func (o *OTLPOutput) SetName(name string)        {}
+func (o *OTLPOutput) SetClusterName(name string) {}
+
+// Healthy implements outputs.HealthChecker
+func (o *OTLPOutput) Healthy() bool {
+	return atomic.LoadInt32(&o.healthy) == 1
+}
+
+func (o *OTLPOutput) setHealthy(b bool) {
+	if b {
+		atomic.StoreInt32(&o.healthy, 1)
+		return
+	}
+	atomic.StoreInt32(&o.healthy, 0)
+}
+
+// worker accumulates the data points, they are exported when the batch reaches batch-size data points
+// or when the flush-timer expires.
+func (o *OTLPOutput) worker(ctx context.Context) {
+	defer o.wg.Done()
+	b := newBatch()
+	ticker := time.NewTicker(o.Cfg.FlushTimer)
+	defer ticker.Stop()
+	flush := func(ctx context.Context, retries int) {
+		if b.size == 0 {
+			return
+		}
+		o.flush(ctx, b, retries)
+		b = newBatch()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// export the last batch without retries
+			fctx, cancel := context.WithTimeout(context.Background(), o.Cfg.Timeout)
+			flush(fctx, 0)
+			cancel()
+			return
+		case ev := <-o.eventChan:
+			o.addEvent(b, ev)
+			if b.size >= o.Cfg.BatchSize {
+				flush(ctx, o.Cfg.MaxRetries)
+			}
+		case <-ticker.C:
+			flush(ctx, o.Cfg.MaxRetries)
+		}
+	}
+}
+
+func (o *OTLPOutput) flush(ctx context.Context, b *batch, retries int) {
+	start := time.Now()
+	err := o.export(ctx, b.request(), retries)
+	o.setHealthy(err == nil)
+	if err != nil {
+		o.logger.Printf("failed to export %d data points: %v", b.size, err)
+		if o.Cfg.EnableMetrics {
+			OTLPNumberOfFailedDataPoints.WithLabelValues(o.Cfg.Name, failureReason(err)).Add(float64(b.size))
+		}
+		return
+	}
+	if o.Cfg.Debug {
+		o.logger.Printf("exported %d data points of %d resources", b.size, len(b.resources))
+	}
+	if o.Cfg.EnableMetrics {
+		OTLPNumberOfSentDataPoints.WithLabelValues(o.Cfg.Name).Add(float64(b.size))
+		OTLPExportDuration.WithLabelValues(o.Cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+	}
+}
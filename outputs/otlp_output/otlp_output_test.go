@@ -0,0 +1,292 @@
+package otlp_output
+
+import (
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+var testEvent = &formatters.EventMsg{
+	Name:      "sub1",
+	Timestamp: 1606824673847153523,
+	Tags: map[string]string{
+		"source":            "router1",
+		"subscription-name": "sub1",
+		"interface_name":    "ethernet-1/1",
+	},
+	Values: map[string]interface{}{
+		"/interface/statistics/in-octets": uint64(42),
+		"/interface/oper-state":           "up",
+		"/interface/mtu":                  "9232",
+		"/interface/load":                 0.5,
+	},
+}
+
+func stringKV(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+}
+
+func wantRequest(startTime uint64) *colmetricspb.ExportMetricsServiceRequest {
+	attrs := []*commonpb.KeyValue{stringKV("interface_name", "ethernet-1/1")}
+	ts := uint64(testEvent.Timestamp)
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{
+			{
+				Resource: &resourcepb.Resource{
+					Attributes: []*commonpb.KeyValue{
+						stringKV("service.name", "gnmic"),
+						stringKV("source", "router1"),
+						stringKV("subscription-name", "sub1"),
+					},
+				},
+				InstrumentationLibraryMetrics: []*metricspb.InstrumentationLibraryMetrics{
+					{
+						InstrumentationLibrary: &commonpb.InstrumentationLibrary{Name: instrumentationLibrary},
+						Metrics: []*metricspb.Metric{
+							{
+								Name: "interface.load",
+								Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+									DataPoints: []*metricspb.NumberDataPoint{
+										{Attributes: attrs, TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.5}},
+									},
+								}},
+							},
+							{
+								Name: "interface.mtu",
+								Unit: "By",
+								Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+									DataPoints: []*metricspb.NumberDataPoint{
+										{Attributes: attrs, TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 9232}},
+									},
+								}},
+							},
+							{
+								Name:        "interface.statistics.in-octets",
+								Description: "received octets",
+								Unit:        "By",
+								Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+									AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+									IsMonotonic:            true,
+									DataPoints: []*metricspb.NumberDataPoint{
+										{Attributes: attrs, StartTimeUnixNano: startTime, TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 42}},
+									},
+								}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+var testConfig = map[string]interface{}{
+	"insecure":            true,
+	"resource-attributes": map[string]string{"service.name": "gnmic"},
+	"metric-rules": []map[string]interface{}{
+		{"path": "/statistics/", "type": "sum", "description": "received octets", "unit": "By"},
+		{"path": "/mtu$", "unit": "By"},
+	},
+}
+
+func initOutput(t *testing.T, cfg map[string]interface{}) *OTLPOutput {
+	for k, v := range testConfig {
+		if _, ok := cfg[k]; !ok {
+			cfg[k] = v
+		}
+	}
+	o := outputs.Outputs["otlp"]().(*OTLPOutput)
+	err := o.Init(context.Background(), "otlp1", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestAddEvent(t *testing.T) {
+	o := initOutput(t, map[string]interface{}{})
+	defer o.Close()
+	b := newBatch()
+	o.addEvent(b, testEvent)
+	if b.size != 3 {
+		t.Errorf("unexpected batch size %d, expected 3", b.size)
+	}
+	got := b.request()
+	want := wantRequest(o.startTime)
+	if !cmp.Equal(got, want, protocmp.Transform()) {
+		t.Errorf("request mismatch: %s", cmp.Diff(want, got, protocmp.Transform()))
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value interface{}
+		want  *metricspb.NumberDataPoint
+	}{
+		"uint":        {value: uint32(42), want: &metricspb.NumberDataPoint{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 42}}},
+		"big_uint":    {value: uint64(math.MaxUint64), want: &metricspb.NumberDataPoint{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: math.MaxUint64}}},
+		"bool":        {value: true, want: &metricspb.NumberDataPoint{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 1}}},
+		"int_string":  {value: "-3", want: &metricspb.NumberDataPoint{Value: &metricspb.NumberDataPoint_AsInt{AsInt: -3}}},
+		"float":       {value: "1.5", want: &metricspb.NumberDataPoint{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1.5}}},
+		"decimal":     {value: &gnmi.Decimal64{Digits: 15, Precision: 1}, want: &metricspb.NumberDataPoint{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1.5}}},
+		"nil_decimal": {value: (*gnmi.Decimal64)(nil)},
+		"nan":         {value: math.NaN()},
+		"text":        {value: "up"},
+	} {
+		dp := new(metricspb.NumberDataPoint)
+		ok := setValue(dp, tc.value)
+		if tc.want == nil {
+			if ok {
+				t.Errorf("%s: expected a non numeric value, got %v", name, dp)
+			}
+			continue
+		}
+		if !ok || !proto.Equal(dp, tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, dp)
+		}
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	o := &OTLPOutput{Cfg: &Config{MetricPrefix: "gnmic", AppendSubscriptionName: true}}
+	got := o.metricName("sub 1", "/srl_nokia-interfaces:interface/statistics/in-octets")
+	want := "gnmic.sub_1.srl_nokia-interfaces_interface.statistics.in-octets"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// testMetricsServer is an OTLP/gRPC receiver recording the export requests
+type testMetricsServer struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	reqs chan *colmetricspb.ExportMetricsServiceRequest
+	md   chan metadata.MD
+}
+
+func (s *testMetricsServer) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.md <- md
+	s.reqs <- req
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestGRPCExport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	ms := &testMetricsServer{
+		reqs: make(chan *colmetricspb.ExportMetricsServiceRequest, 1),
+		md:   make(chan metadata.MD, 1),
+	}
+	colmetricspb.RegisterMetricsServiceServer(srv, ms)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	o := initOutput(t, map[string]interface{}{
+		"endpoint": l.Addr().String(),
+		"headers":  map[string]string{"x-api-key": "secret"},
+	})
+	o.WriteEvent(context.Background(), testEvent)
+	// the last batch is exported when the output is closed
+	o.Close()
+
+	select {
+	case got := <-ms.reqs:
+		want := wantRequest(o.startTime)
+		if !cmp.Equal(got, want, protocmp.Transform()) {
+			t.Errorf("request mismatch: %s", cmp.Diff(want, got, protocmp.Transform()))
+		}
+		md := <-ms.md
+		if v := md.Get("x-api-key"); len(v) != 1 || v[0] != "secret" {
+			t.Errorf("unexpected x-api-key header: %v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for an export request")
+	}
+}
+
+func TestHTTPExportRetry(t *testing.T) {
+	m := new(sync.Mutex)
+	attempts := 0
+	reqs := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		attempts++
+		n := attempts
+		m.Unlock()
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// the first attempt fails with a retryable error
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, err := ioutil.ReadAll(zr)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req := new(colmetricspb.ExportMetricsServiceRequest)
+		err = proto.Unmarshal(b, req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reqs <- req
+	}))
+	defer srv.Close()
+
+	o := initOutput(t, map[string]interface{}{
+		"protocol":      "http",
+		"endpoint":      srv.URL,
+		"compression":   "gzip",
+		"batch-size":    1,
+		"retry-backoff": "10ms",
+	})
+	defer o.Close()
+	o.WriteEvent(context.Background(), testEvent)
+
+	select {
+	case got := <-reqs:
+		want := wantRequest(o.startTime)
+		if !cmp.Equal(got, want, protocmp.Transform()) {
+			t.Errorf("request mismatch: %s", cmp.Diff(want, got, protocmp.Transform()))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for an export request")
+	}
+	m.Lock()
+	defer m.Unlock()
+	if attempts != 2 {
+		t.Errorf("unexpected number of attempts %d, expected 2", attempts)
+	}
+}
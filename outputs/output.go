@@ -2,8 +2,10 @@ package outputs
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -29,17 +31,45 @@ type Output interface {
 	SetClusterName(string)
 }
 
+// HealthChecker is an optional interface implemented by outputs
+// able to report whether their sink is currently reachable.
+type HealthChecker interface {
+	Healthy() bool
+}
+
+// SyncWriter is an optional interface implemented by outputs able to write a message
+// and report whether it was delivered to their sink.
+// The output queue removes a message from disk only once it was delivered.
+type SyncWriter interface {
+	WriteSync(ctx context.Context, m proto.Message, meta Meta) error
+	WriteEventSync(ctx context.Context, ev *formatters.EventMsg) error
+}
+
+// Flusher is an optional interface implemented by outputs holding the written messages
+// until they send them, e.g: the prometheus output in pushgateway mode.
+// Flush sends the written messages without closing the output.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
 type Initializer func() Output
 
 var Outputs = map[string]Initializer{}
 
 var OutputTypes = []string{
 	"file",
+	"graphite",
+	"grpc",
 	"influxdb",
 	"kafka",
+	"loki",
 	"nats",
+	"opentsdb",
+	"otlp",
 	"prometheus",
+	"sql",
 	"stan",
+	"statsd",
 	"tcp",
 	"udp",
 }
@@ -124,3 +154,32 @@ func GetHost(hostport string) string {
 	}
 	return h
 }
+
+// ParseSize parses a size string such as 1024, 512KB, 100MB or 2GB into a number of bytes
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, u := range []struct {
+		suffix string
+		m      int64
+	}{
+		{"KB", 1024},
+		{"MB", 1024 * 1024},
+		{"GB", 1024 * 1024 * 1024},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, u.suffix) {
+			multiplier = u.m
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, errors.New("size must be greater than zero")
+	}
+	return n * multiplier, nil
+}
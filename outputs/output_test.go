@@ -0,0 +1,25 @@
+package outputs
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"1024":  1024,
+		"10B":   10,
+		"2KB":   2048,
+		"100MB": 100 * 1024 * 1024,
+		"1gb":   1024 * 1024 * 1024,
+	} {
+		got, err := ParseSize(in)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: expected %d, got %d", in, want, got)
+		}
+	}
+	if _, err := ParseSize("-1"); err == nil {
+		t.Errorf("expected an error for a negative size")
+	}
+}
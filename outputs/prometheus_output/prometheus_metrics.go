@@ -0,0 +1,22 @@
+package prometheus_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var PromNumberOfDroppedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "prometheus_output",
+	Name:      "number_of_dropped_series_total",
+	Help:      "Number of series dropped by gnmic prometheus output because a cardinality limit was reached",
+}, []string{"name", "reason"})
+
+func registerMetrics(reg *prometheus.Registry) error {
+	err := reg.Register(PromNumberOfDroppedSeries)
+	if err == nil {
+		return nil
+	}
+	// the metric is shared by all the prometheus outputs
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
+}
@@ -49,6 +49,12 @@ type promMetric struct {
 	labels []*labelPair
 	time   *time.Time
 	value  float64
+	// value path, used to match gNMI deletes
+	path string
+	// the event before it was processed, used to match gNMI deletes
+	origin *eventOrigin
+	typ    dto.MetricType
+	help   string
 	// addedAt is used to expire metrics if the time field is not initialized
 	// this happens when ExportTimestamp == false
 	addedAt time.Time
@@ -58,7 +64,7 @@ func init() {
 	outputs.Register("prometheus", func() outputs.Output {
 		return &PrometheusOutput{
 			Cfg:         &Config{},
-			eventChan:   make(chan *formatters.EventMsg),
+			eventChan:   make(chan *promEvent),
 			wg:          new(sync.WaitGroup),
 			entries:     make(map[uint64]*promMetric),
 			metricRegex: regexp.MustCompile(metricNameRegex),
@@ -70,7 +76,7 @@ func init() {
 type PrometheusOutput struct {
 	Cfg       *Config
 	logger    *log.Logger
-	eventChan chan *formatters.EventMsg
+	eventChan chan *promEvent
 
 	wg     *sync.WaitGroup
 	server *http.Server
@@ -82,6 +88,18 @@ type PrometheusOutput struct {
 	consulClient *api.Client
 
 	targetTpl *template.Template
+	// metric rule per value path
+	ruleCache map[string]*MetricRule
+	// label name regexes
+	labelInclude []*regexp.Regexp
+	labelExclude []*regexp.Regexp
+	// number of stored series per label name and value,
+	// tracked only if max-label-values is set
+	labelValues map[string]map[string]int
+
+	cancelFn    context.CancelFunc
+	pushgateway *pushgateway
+	pushOnce    sync.Once
 }
 type Config struct {
 	Name                   string               `mapstructure:"name,omitempty"`
@@ -98,6 +116,12 @@ type Config struct {
 	Debug                  bool                 `mapstructure:"debug,omitempty"`
 	EventProcessors        []string             `mapstructure:"event-processors,omitempty"`
 	ServiceRegistration    *ServiceRegistration `mapstructure:"service-registration,omitempty"`
+	MetricRules            []*MetricRule        `mapstructure:"metric-rules,omitempty"`
+	Pushgateway            *PushgatewayConfig   `mapstructure:"pushgateway,omitempty"`
+	MaxSeries              int                  `mapstructure:"max-series,omitempty"`
+	MaxLabelValues         int                  `mapstructure:"max-label-values,omitempty"`
+	LabelInclude           []string             `mapstructure:"label-include,omitempty"`
+	LabelExclude           []string             `mapstructure:"label-exclude,omitempty"`
 
 	clusterName string
 	address     string
@@ -129,7 +153,7 @@ func (p *PrometheusOutput) SetEventProcessors(ps map[string]map[string]interface
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					p.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -170,43 +194,79 @@ func (p *PrometheusOutput) Init(ctx context.Context, name string, cfg map[string
 	if err != nil {
 		return err
 	}
-	// create prometheus registry
-	registry := prometheus.NewRegistry()
-
-	err = registry.Register(p)
+	err = p.initMetricRules()
 	if err != nil {
 		return err
 	}
-	// create http server
-	promHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+	err = p.initLabelRules()
+	if err != nil {
+		return err
+	}
+	if p.Cfg.Pushgateway != nil {
+		p.pushgateway, err = newPushgateway(p.Cfg.Pushgateway)
+		if err != nil {
+			return err
+		}
+	}
+	var listener net.Listener
+	if p.Cfg.Listen != "" {
+		// create prometheus registry
+		registry := prometheus.NewRegistry()
+
+		err = registry.Register(p)
+		if err != nil {
+			return err
+		}
+		if p.Cfg.MaxSeries > 0 || p.Cfg.MaxLabelValues > 0 {
+			err = registerMetrics(registry)
+			if err != nil {
+				return err
+			}
+		}
+		// create http server
+		promHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
 
-	mux := http.NewServeMux()
-	mux.Handle(p.Cfg.Path, promHandler)
+		mux := http.NewServeMux()
+		mux.Handle(p.Cfg.Path, promHandler)
 
-	p.server = &http.Server{
-		Addr:    p.Cfg.Listen,
-		Handler: mux,
-	}
+		p.server = &http.Server{
+			Addr:    p.Cfg.Listen,
+			Handler: mux,
+		}
 
-	// create tcp listener
-	listener, err := net.Listen("tcp", p.Cfg.Listen)
-	if err != nil {
-		return err
+		// create tcp listener
+		listener, err = net.Listen("tcp", p.Cfg.Listen)
+		if err != nil {
+			return err
+		}
 	}
 	// start worker
-	p.wg.Add(2)
+	p.wg.Add(1)
 	wctx, wcancel := context.WithCancel(ctx)
+	p.cancelFn = wcancel
+	formatters.ConnectEmitters(wctx, p.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			p.WriteEvent(wctx, ev)
+		}
+	})
 	go p.worker(wctx)
 	go p.expireMetricsPeriodic(wctx)
-	go func() {
-		defer p.wg.Done()
-		err = p.server.Serve(listener)
-		if err != nil && err != http.ErrServerClosed {
-			p.logger.Printf("prometheus server error: %v", err)
-		}
-		wcancel()
-	}()
-	go p.registerService(wctx)
+	if p.pushgateway != nil {
+		p.wg.Add(1)
+		go p.pushPeriodic(wctx)
+	}
+	if p.server != nil {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			err = p.server.Serve(listener)
+			if err != nil && err != http.ErrServerClosed {
+				p.logger.Printf("prometheus server error: %v", err)
+			}
+			wcancel()
+		}()
+		go p.registerService(wctx)
+	}
 	p.logger.Printf("initialized prometheus output: %s", p.String())
 	go func() {
 		<-ctx.Done()
@@ -230,16 +290,25 @@ func (p *PrometheusOutput) Write(ctx context.Context, rsp proto.Message, meta ou
 		if err != nil {
 			p.logger.Printf("failed to add target to the response: %v", err)
 		}
-		events, err := formatters.ResponseToEventMsgs(measName, rsp, meta, p.evps...)
+		events, err := formatters.ResponseToEventMsgs(measName, rsp, meta)
 		if err != nil {
 			p.logger.Printf("failed to convert message to event: %v", err)
 			return
 		}
-		for _, ev := range events {
+		pes := p.processEvents(events)
+		if p.pushgateway != nil && p.Cfg.Pushgateway.PushOnWrite {
+			// the batch is stored before its push is triggered
+			for _, pe := range pes {
+				p.storeEvent(pe.ev, pe.origin)
+			}
+			p.pushgateway.batchStored()
+			return
+		}
+		for _, pe := range pes {
 			select {
 			case <-ctx.Done():
 				return
-			case p.eventChan <- ev:
+			case p.eventChan <- pe:
 			}
 		}
 	}
@@ -249,11 +318,58 @@ func (p *PrometheusOutput) WriteEvent(ctx context.Context, ev *formatters.EventM
 	select {
 	case <-ctx.Done():
 		return
-	case p.eventChan <- ev:
+	case p.eventChan <- &promEvent{ev: ev}:
 	}
 }
 
+// processEvents applies the event processors to the events converted from a subscribe response,
+// the value names and tags of each event are kept as its origin before it is processed.
+// The deletes event is not processed, its deletes match the stored series using their origin,
+// which still holds the tags and value names the processors may have renamed or removed.
+func (p *PrometheusOutput) processEvents(evs []*formatters.EventMsg) []*promEvent {
+	pes := make([]*promEvent, 0, len(evs))
+	if len(p.evps) == 0 {
+		for _, ev := range evs {
+			pes = append(pes, &promEvent{ev: ev})
+		}
+		return pes
+	}
+	origins := make(map[*formatters.EventMsg]*eventOrigin, len(evs))
+	values := make([]*formatters.EventMsg, 0, len(evs))
+	deletes := make([]*formatters.EventMsg, 0)
+	for _, ev := range evs {
+		if len(ev.Deletes) > 0 {
+			deletes = append(deletes, ev)
+			continue
+		}
+		o := &eventOrigin{
+			valueNames: make([]string, 0, len(ev.Values)),
+			tags:       make(map[string]string, len(ev.Tags)),
+		}
+		for vn := range ev.Values {
+			o.valueNames = append(o.valueNames, vn)
+		}
+		for k, v := range ev.Tags {
+			o.tags[k] = v
+		}
+		origins[ev] = o
+		values = append(values, ev)
+	}
+	for _, ep := range p.evps {
+		values = ep.Apply(values...)
+	}
+	// the events created by a processor have no origin
+	for _, ev := range values {
+		pes = append(pes, &promEvent{ev: ev, origin: origins[ev]})
+	}
+	for _, ev := range deletes {
+		pes = append(pes, &promEvent{ev: ev})
+	}
+	return pes
+}
+
 func (p *PrometheusOutput) Close() error {
+	defer formatters.CloseEventProcessors(p.evps)
 	var err error
 	if p.consulClient != nil {
 		err = p.consulClient.Agent().ServiceDeregister(p.Cfg.ServiceRegistration.Name)
@@ -261,18 +377,60 @@ func (p *PrometheusOutput) Close() error {
 			p.logger.Printf("failed to deregister consul service: %v", err)
 		}
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err = p.server.Shutdown(ctx)
-	if err != nil {
-		p.logger.Printf("failed to shutdown http server: %v", err)
+	if p.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = p.server.Shutdown(ctx)
+		if err != nil {
+			p.logger.Printf("failed to shutdown http server: %v", err)
+		}
+	}
+	if p.cancelFn != nil {
+		p.cancelFn()
 	}
-	p.logger.Printf("closed.")
 	p.wg.Wait()
+	if p.pushgateway != nil {
+		// the worker is stopped, all the received events are stored
+		p.pushOnce.Do(p.closePushgateway)
+	}
+	p.logger.Printf("closed.")
 	return nil
 }
 
-func (p *PrometheusOutput) RegisterMetrics(reg *prometheus.Registry) {}
+// Flush pushes the stored metrics to the pushgateway,
+// it implements the outputs.Flusher interface.
+func (p *PrometheusOutput) Flush(ctx context.Context) error {
+	if p.pushgateway == nil {
+		return nil
+	}
+	// the worker handles the events in order,
+	// once it receives the nil event the previously written events are stored.
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case p.eventChan <- nil:
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.pushMetrics()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+func (p *PrometheusOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if reg == nil || (p.Cfg.MaxSeries <= 0 && p.Cfg.MaxLabelValues <= 0) {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		p.logger.Printf("failed to register metric: %v", err)
+	}
+}
 
 // Describe implements prometheus.Collector
 func (p *PrometheusOutput) Describe(ch chan<- *prometheus.Desc) {}
@@ -296,6 +454,9 @@ func (p *PrometheusOutput) getLabels(ev *formatters.EventMsg) []*labelPair {
 		if _, ok := addedLabels[labelName]; ok {
 			continue
 		}
+		if !p.keepLabel(labelName) {
+			continue
+		}
 		labels = append(labels, &labelPair{Name: labelName, Value: v})
 		addedLabels[labelName] = struct{}{}
 	}
@@ -314,6 +475,9 @@ func (p *PrometheusOutput) getLabels(ev *formatters.EventMsg) []*labelPair {
 			if _, ok := addedLabels[labelName]; ok {
 				continue
 			}
+			if !p.keepLabel(labelName) {
+				continue
+			}
 			labels = append(labels, &labelPair{Name: labelName, Value: vs})
 		}
 	}
@@ -326,47 +490,125 @@ func (p *PrometheusOutput) worker(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case ev := <-p.eventChan:
+		case pe := <-p.eventChan:
+			// a nil event is sent by Flush once the written events are received
+			if pe == nil {
+				continue
+			}
 			if p.Cfg.Debug {
-				p.logger.Printf("got event to store: %+v", ev)
+				p.logger.Printf("got event to store: %+v", pe.ev)
 			}
-			p.Lock()
-			now := time.Now()
-			labels := p.getLabels(ev)
-			for vName, val := range ev.Values {
-				v, err := getFloat(val)
-				if err != nil {
-					if !p.Cfg.StringsAsLabels {
-						continue
-					}
-					v = 1.0
-				}
-				pm := &promMetric{
-					name:    p.metricName(ev.Name, vName),
-					labels:  labels,
-					value:   v,
-					addedAt: now,
-				}
-				if p.Cfg.OverrideTimestamps && p.Cfg.ExportTimestamps {
-					ev.Timestamp = time.Now().UnixNano()
-				}
-				if p.Cfg.ExportTimestamps {
-					tm := time.Unix(0, ev.Timestamp)
-					pm.time = &tm
-				}
-				key := pm.calculateKey()
-				if e, ok := p.entries[key]; ok && pm.time != nil {
-					if e.time.Before(*pm.time) {
-						p.entries[key] = pm
-					}
-				} else {
-					p.entries[key] = pm
-				}
+			p.storeEvent(pe.ev, pe.origin)
+		}
+	}
+}
+
+// storeEvent converts the event values into metrics and stores them,
+// the event deletes remove the matching stored metrics.
+// origin is the event before it was processed, nil if the event was not processed.
+func (p *PrometheusOutput) storeEvent(ev *formatters.EventMsg, origin *eventOrigin) {
+	p.Lock()
+	defer p.Unlock()
+	if len(ev.Deletes) > 0 {
+		p.deleteSeries(ev)
+	}
+	now := time.Now()
+	labels := p.getLabels(ev)
+	for vName, val := range ev.Values {
+		v, err := getFloat(val)
+		if err != nil {
+			if !p.Cfg.StringsAsLabels {
+				continue
+			}
+			v = 1.0
+		}
+		pm := &promMetric{
+			name:    p.metricName(ev.Name, vName),
+			labels:  labels,
+			value:   v,
+			path:    vName,
+			origin:  origin,
+			typ:     dto.MetricType_UNTYPED,
+			addedAt: now,
+		}
+		if rule := p.metricRule(vName); rule != nil {
+			pm.typ = rule.typ
+			pm.help = rule.Help
+		}
+		if p.Cfg.OverrideTimestamps && p.Cfg.ExportTimestamps {
+			ev.Timestamp = time.Now().UnixNano()
+		}
+		if p.Cfg.ExportTimestamps {
+			tm := time.Unix(0, ev.Timestamp)
+			pm.time = &tm
+		}
+		key := pm.calculateKey()
+		e, ok := p.entries[key]
+		switch {
+		case !ok:
+			if reason := p.checkLimits(pm); reason != "" {
+				PromNumberOfDroppedSeries.WithLabelValues(p.Cfg.Name, reason).Inc()
 				if p.Cfg.Debug {
-					p.logger.Printf("saved key=%d, metric: %+v", key, pm)
+					p.logger.Printf("dropped metric %s: %s reached", pm, reason)
 				}
+				continue
 			}
-			p.Unlock()
+			p.addEntry(key, pm)
+		case pm.time == nil:
+			p.entries[key] = pm
+		case e.time.Before(*pm.time):
+			p.entries[key] = pm
+		}
+		if p.Cfg.Debug {
+			p.logger.Printf("saved key=%d, metric: %+v", key, pm)
+		}
+	}
+}
+
+// deleteSeries removes the series matching the event deletes.
+// A series matches a delete path if its value path is the deleted path or one of its children,
+// and its labels include the event tags as well as the deleted path keys.
+// A series built from a processed event is matched using the event origin instead,
+// since the processors may have renamed or removed its tags and value names.
+// must be called with the output lock held.
+func (p *PrometheusOutput) deleteSeries(ev *formatters.EventMsg) {
+	evLabels := p.getLabels(ev)
+	for _, del := range ev.Deletes {
+		path, keys := parseXPath(del)
+		tags := make(map[string]string, len(ev.Tags)+len(keys))
+		for k, v := range ev.Tags {
+			tags[k] = v
+		}
+		for k, v := range keys {
+			tags[k] = v
+		}
+		labels := make(map[string]string, len(evLabels)+len(keys))
+		for _, lb := range evLabels {
+			labels[lb.Name] = lb.Value
+		}
+		for k, v := range keys {
+			labelName := p.metricRegex.ReplaceAllString(k, "_")
+			if !p.keepLabel(labelName) {
+				continue
+			}
+			labels[labelName] = v
+		}
+		numDeleted := 0
+		for key, e := range p.entries {
+			if e.origin != nil {
+				if e.origin.matches(path, tags) {
+					p.removeEntry(key)
+					numDeleted++
+				}
+				continue
+			}
+			if e.matches(path, labels) {
+				p.removeEntry(key)
+				numDeleted++
+			}
+		}
+		if p.Cfg.Debug {
+			p.logger.Printf("deleted %d series matching path %q", numDeleted, del)
 		}
 	}
 }
@@ -379,12 +621,12 @@ func (p *PrometheusOutput) expireMetrics() {
 	for k, e := range p.entries {
 		if p.Cfg.ExportTimestamps {
 			if e.time.Before(expiry) {
-				delete(p.entries, k)
+				p.removeEntry(k)
 			}
 			continue
 		}
 		if e.addedAt.Before(expiry) {
-			delete(p.entries, k)
+			p.removeEntry(k)
 		}
 	}
 }
@@ -409,6 +651,10 @@ func (p *PrometheusOutput) expireMetricsPeriodic(ctx context.Context) {
 
 func (p *PrometheusOutput) setDefaults() error {
 	if p.Cfg.Listen == "" {
+		// with a pushgateway, the metrics are exposed only if listen is set
+		if p.Cfg.Pushgateway != nil {
+			return nil
+		}
 		p.Cfg.Listen = defaultListen
 	}
 	if p.Cfg.Path == "" {
@@ -453,6 +699,55 @@ func (p *promMetric) calculateKey() uint64 {
 	return h.Sum64()
 }
 
+// matches returns true if the metric value path is path or a child of path,
+// and its labels include all the given labels.
+func (p *promMetric) matches(path string, labels map[string]string) bool {
+	if p.path != path && !strings.HasPrefix(p.path, path+"/") {
+		return false
+	}
+LABELS:
+	for name, value := range labels {
+		for _, lb := range p.labels {
+			if lb.Name == name {
+				if lb.Value != value {
+					return false
+				}
+				continue LABELS
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// promEvent is an event to store and its origin
+type promEvent struct {
+	ev     *formatters.EventMsg
+	origin *eventOrigin
+}
+
+// eventOrigin holds the value names and the tags of an event before it was processed
+type eventOrigin struct {
+	valueNames []string
+	tags       map[string]string
+}
+
+// matches reports whether one of the origin value names is path or one of its children,
+// and the origin tags include tags.
+func (o *eventOrigin) matches(path string, tags map[string]string) bool {
+	for k, v := range tags {
+		if tv, ok := o.tags[k]; !ok || tv != v {
+			return false
+		}
+	}
+	for _, vn := range o.valueNames {
+		if vn == path || strings.HasPrefix(vn, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *promMetric) String() string {
 	if p == nil {
 		return ""
@@ -493,13 +788,28 @@ func (p *promMetric) Desc() *prometheus.Desc {
 		labelNames = append(labelNames, label.Name)
 	}
 
-	return prometheus.NewDesc(p.name, defaultMetricHelp, labelNames, nil)
+	help := p.help
+	if help == "" {
+		help = defaultMetricHelp
+	}
+	return prometheus.NewDesc(p.name, help, labelNames, nil)
 }
 
 // Write implements prometheus.Metric
 func (p *promMetric) Write(out *dto.Metric) error {
-	out.Untyped = &dto.Untyped{
-		Value: &p.value,
+	switch p.typ {
+	case dto.MetricType_COUNTER:
+		out.Counter = &dto.Counter{
+			Value: &p.value,
+		}
+	case dto.MetricType_GAUGE:
+		out.Gauge = &dto.Gauge{
+			Value: &p.value,
+		}
+	default:
+		out.Untyped = &dto.Untyped{
+			Value: &p.value,
+		}
 	}
 	out.Label = make([]*dto.LabelPair, 0, len(p.labels))
 	for _, lb := range p.labels {
@@ -513,6 +823,44 @@ func (p *promMetric) Write(out *dto.Metric) error {
 	return nil
 }
 
+// parseXPath splits an xpath into its path without keys and its keys,
+// the keys are named after their list name, e.g:
+// /interfaces/interface[name=ethernet-1/1]/subinterfaces gives
+// /interfaces/interface/subinterfaces and interface_name=ethernet-1/1
+func parseXPath(xpath string) (string, map[string]string) {
+	keys := make(map[string]string)
+	path := new(strings.Builder)
+	elem := new(strings.Builder)
+	key := new(strings.Builder)
+	inKey := false
+	for _, r := range xpath {
+		switch {
+		case r == '[' && !inKey:
+			inKey = true
+			key.Reset()
+		case r == ']' && inKey:
+			inKey = false
+			kv := strings.SplitN(key.String(), "=", 2)
+			if len(kv) == 2 {
+				elemName := elem.String()
+				if i := strings.LastIndex(elemName, ":"); i >= 0 {
+					elemName = elemName[i+1:]
+				}
+				keys[elemName+"_"+kv[0]] = kv[1]
+			}
+		case inKey:
+			key.WriteRune(r)
+		case r == '/':
+			path.WriteRune(r)
+			elem.Reset()
+		default:
+			path.WriteRune(r)
+			elem.WriteRune(r)
+		}
+	}
+	return strings.TrimRight(path.String(), "/"), keys
+}
+
 func getFloat(v interface{}) (float64, error) {
 	switch i := v.(type) {
 	case float64:
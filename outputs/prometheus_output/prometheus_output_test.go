@@ -1,8 +1,17 @@
 package prometheus_output
 
 import (
+	"io/ioutil"
+	"log"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 var metricNameSet = map[string]struct {
@@ -92,3 +101,303 @@ func BenchmarkMetricName(b *testing.B) {
 		})
 	}
 }
+
+func newTestOutput(t *testing.T, cfg *Config) *PrometheusOutput {
+	p := &PrometheusOutput{
+		Cfg:         cfg,
+		entries:     make(map[uint64]*promMetric),
+		metricRegex: regexp.MustCompile(metricNameRegex),
+		logger:      log.New(ioutil.Discard, loggingPrefix, 0),
+	}
+	err := p.initMetricRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseXPath(t *testing.T) {
+	path, keys := parseXPath("/interfaces/interface[name=ethernet-1/1]/subinterfaces/subinterface[index=0]")
+	if path != "/interfaces/interface/subinterfaces/subinterface" {
+		t.Errorf("unexpected path %q", path)
+	}
+	want := map[string]string{"interface_name": "ethernet-1/1", "subinterface_index": "0"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected keys %v, got %v", want, keys)
+	}
+	path, keys = parseXPath("/")
+	if path != "" || len(keys) != 0 {
+		t.Errorf("unexpected root path parsing: %q, %v", path, keys)
+	}
+}
+
+func TestDeleteSeries(t *testing.T) {
+	p := newTestOutput(t, &Config{})
+	for _, src := range []string{"router1", "router2"} {
+		for _, itf := range []string{"ethernet-1/1", "ethernet-1/2"} {
+			p.storeEvent(&formatters.EventMsg{
+				Name:      "sub1",
+				Timestamp: 1,
+				Tags:      map[string]string{"source": src, "interface_name": itf},
+				Values: map[string]interface{}{
+					"/interfaces/interface/state/counters/in-octets":  uint64(1),
+					"/interfaces/interface/state/counters/out-octets": uint64(2),
+				},
+			}, nil)
+		}
+	}
+	if len(p.entries) != 8 {
+		t.Fatalf("expected 8 series, got %d", len(p.entries))
+	}
+	p.storeEvent(&formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 2,
+		Tags:      map[string]string{"source": "router1"},
+		Deletes:   []string{"/interfaces/interface[name=ethernet-1/1]"},
+	}, nil)
+	if len(p.entries) != 6 {
+		t.Fatalf("expected 6 series after delete, got %d", len(p.entries))
+	}
+	for _, e := range p.entries {
+		if e.matches("/interfaces/interface", map[string]string{"source": "router1", "interface_name": "ethernet-1/1"}) {
+			t.Errorf("series %s should have been deleted", e)
+		}
+	}
+	// a sibling path does not match
+	p.storeEvent(&formatters.EventMsg{
+		Name:    "sub1",
+		Tags:    map[string]string{"source": "router2"},
+		Deletes: []string{"/interfaces/interface/state/counters/in-octets-total"},
+	}, nil)
+	if len(p.entries) != 6 {
+		t.Fatalf("expected 6 series, got %d", len(p.entries))
+	}
+	p.storeEvent(&formatters.EventMsg{
+		Name:    "sub1",
+		Tags:    map[string]string{"source": "router2"},
+		Deletes: []string{"/interfaces"},
+	}, nil)
+	if len(p.entries) != 2 {
+		t.Fatalf("expected 2 series after delete, got %d", len(p.entries))
+	}
+}
+
+// renameProcessor renames the source tag and trims the value names prefix
+type renameProcessor struct{}
+
+func (p *renameProcessor) Init(interface{}, ...formatters.Option) error { return nil }
+func (p *renameProcessor) WithTargets(map[string]interface{})           {}
+func (p *renameProcessor) WithLogger(*log.Logger)                       {}
+func (p *renameProcessor) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	for _, e := range es {
+		e.Tags["device"] = e.Tags["source"]
+		delete(e.Tags, "source")
+		for k, v := range e.Values {
+			delete(e.Values, k)
+			e.Values[strings.TrimPrefix(k, "/interfaces/interface/state/counters/")] = v
+		}
+	}
+	return es
+}
+
+func TestDeleteSeriesProcessed(t *testing.T) {
+	p := newTestOutput(t, &Config{})
+	p.evps = []formatters.EventProcessor{&renameProcessor{}}
+	meta := map[string]string{"source": "router1", "subscription-name": "sub1"}
+	for _, itf := range []string{"ethernet-1/1", "ethernet-1/2"} {
+		evs, err := formatters.ResponseToEventMsgs("sub1", &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{
+					Timestamp: 1,
+					Prefix: &gnmi.Path{Elem: []*gnmi.PathElem{
+						{Name: "interfaces"},
+						{Name: "interface", Key: map[string]string{"name": itf}},
+					}},
+					Update: []*gnmi.Update{{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "state"}, {Name: "counters"}, {Name: "in-octets"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 1}},
+					}},
+				},
+			},
+		}, meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pe := range p.processEvents(evs) {
+			p.storeEvent(pe.ev, pe.origin)
+		}
+	}
+	if len(p.entries) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(p.entries))
+	}
+	evs, err := formatters.ResponseToEventMsgs("sub1", &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 2,
+				Delete: []*gnmi.Path{{Elem: []*gnmi.PathElem{
+					{Name: "interfaces"},
+					{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+				}}},
+			},
+		},
+	}, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the deletes match the series on their tags and value names before processing
+	for _, pe := range p.processEvents(evs) {
+		p.storeEvent(pe.ev, pe.origin)
+	}
+	if len(p.entries) != 1 {
+		t.Fatalf("expected 1 series after delete, got %d", len(p.entries))
+	}
+	for _, e := range p.entries {
+		if e.path != "in-octets" || e.origin.tags["interface_name"] != "ethernet-1/2" {
+			t.Errorf("unexpected remaining series: %s", e)
+		}
+	}
+}
+
+func TestMetricRules(t *testing.T) {
+	p := newTestOutput(t, &Config{
+		MetricRules: []*MetricRule{
+			{Path: "^/interfaces/interface/state/counters/", Type: "counter", Help: "interface counters"},
+			{Path: "^/interfaces/interface/state/", Type: "gauge"},
+		},
+	})
+	p.storeEvent(&formatters.EventMsg{
+		Name: "sub1",
+		Tags: map[string]string{"source": "router1"},
+		Values: map[string]interface{}{
+			"/interfaces/interface/state/counters/in-octets": uint64(1),
+			"/interfaces/interface/state/mtu":                uint64(1500),
+			"/system/memory/state/free":                      uint64(1),
+		},
+	}, nil)
+	for _, e := range p.entries {
+		m := new(dto.Metric)
+		err := e.Write(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch e.path {
+		case "/interfaces/interface/state/counters/in-octets":
+			if m.Counter.GetValue() != 1 || !strings.Contains(e.Desc().String(), "interface counters") {
+				t.Errorf("expected a counter with help text, got %v, %s", m, e.Desc())
+			}
+		case "/interfaces/interface/state/mtu":
+			if m.Gauge.GetValue() != 1500 || !strings.Contains(e.Desc().String(), defaultMetricHelp) {
+				t.Errorf("expected a gauge with the default help text, got %v, %s", m, e.Desc())
+			}
+		default:
+			if m.Untyped == nil || m.Counter != nil {
+				t.Errorf("expected an untyped metric, got %v: %s", m, e)
+			}
+		}
+	}
+	err := (&PrometheusOutput{Cfg: &Config{MetricRules: []*MetricRule{{Path: ".*", Type: "histogram"}}}}).initMetricRules()
+	if err == nil {
+		t.Errorf("expected an error for an unknown metric type")
+	}
+}
+
+func TestLabelRules(t *testing.T) {
+	p := newTestOutput(t, &Config{
+		StringsAsLabels: true,
+		LabelExclude:    []string{"description$", "^last_change$"},
+	})
+	err := p.initLabelRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := p.getLabels(&formatters.EventMsg{
+		Tags: map[string]string{
+			"source":                "router1",
+			"interface_name":        "ethernet-1/1",
+			"interface_description": "uplink to spine1",
+		},
+		Values: map[string]interface{}{
+			"/interfaces/interface/state/last-change": "2021-03-15T10:00:00Z",
+			"/interfaces/interface/state/oper-status": "UP",
+		},
+	})
+	got := make(map[string]string)
+	for _, lb := range labels {
+		got[lb.Name] = lb.Value
+	}
+	want := map[string]string{"source": "router1", "interface_name": "ethernet-1/1", "oper_status": "UP"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected labels %v, got %v", want, got)
+	}
+
+	p = newTestOutput(t, &Config{LabelInclude: []string{"^source$", "_name$"}})
+	err = p.initLabelRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels = p.getLabels(&formatters.EventMsg{
+		Tags: map[string]string{
+			"source":            "router1",
+			"interface_name":    "ethernet-1/1",
+			"subscription_name": "sub1",
+			"interface_index":   "1",
+		},
+	})
+	if len(labels) != 3 {
+		t.Errorf("expected 3 labels, got %d", len(labels))
+	}
+	for _, lb := range labels {
+		if lb.Name == "interface_index" {
+			t.Errorf("label %q should have been excluded", lb.Name)
+		}
+	}
+}
+
+func TestSeriesLimits(t *testing.T) {
+	p := newTestOutput(t, &Config{Name: "test-limits", MaxSeries: 4, MaxLabelValues: 2})
+	err := p.initLabelRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := func(src, itf string) {
+		p.storeEvent(&formatters.EventMsg{
+			Name:   "sub1",
+			Tags:   map[string]string{"source": src, "interface_name": itf},
+			Values: map[string]interface{}{"/interfaces/interface/state/counters/in-octets": 1},
+		}, nil)
+	}
+	store("router1", "ethernet-1/1")
+	store("router1", "ethernet-1/2")
+	// a third interface name is dropped
+	store("router1", "ethernet-1/3")
+	if len(p.entries) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(p.entries))
+	}
+	store("router2", "ethernet-1/1")
+	store("router2", "ethernet-1/2")
+	// a fifth series is dropped
+	if len(p.entries) != 4 {
+		t.Fatalf("expected 4 series, got %d", len(p.entries))
+	}
+	store("router2", "ethernet-1/2")
+	if len(p.entries) != 4 {
+		t.Fatalf("expected existing series to be updated, got %d series", len(p.entries))
+	}
+	dropped := testutil.ToFloat64(PromNumberOfDroppedSeries.WithLabelValues("test-limits", "max_label_values"))
+	if dropped != 1 {
+		t.Errorf("expected 1 dropped series, got %v", dropped)
+	}
+	// deleting the router1 series frees label values
+	p.storeEvent(&formatters.EventMsg{
+		Name:    "sub1",
+		Tags:    map[string]string{"source": "router1"},
+		Deletes: []string{"/interfaces"},
+	}, nil)
+	if len(p.labelValues["source"]) != 1 {
+		t.Fatalf("expected 1 source label value, got %v", p.labelValues["source"])
+	}
+	store("router3", "ethernet-1/1")
+	if len(p.entries) != 3 {
+		t.Fatalf("expected 3 series, got %d", len(p.entries))
+	}
+}
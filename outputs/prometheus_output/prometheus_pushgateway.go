@@ -0,0 +1,309 @@
+package prometheus_output
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/outputs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	defaultPushgatewayJob     = "gnmic"
+	defaultPushgatewayTimeout = 10 * time.Second
+)
+
+// PushgatewayConfig //
+type PushgatewayConfig struct {
+	// Pushgateway URL, e.g: http://pushgateway:9091
+	URL string `mapstructure:"url,omitempty" json:"url,omitempty"`
+	// job name template
+	Job string `mapstructure:"job,omitempty" json:"job,omitempty"`
+	// grouping labels name to value template
+	Grouping map[string]string `mapstructure:"grouping,omitempty" json:"grouping,omitempty"`
+	// push interval, if zero the metrics are pushed only when the output is closed
+	Interval time.Duration `mapstructure:"interval,omitempty" json:"interval,omitempty"`
+	// push the metrics after each written batch
+	PushOnWrite bool `mapstructure:"push-on-write,omitempty" json:"push-on-write,omitempty"`
+	// delete the pushed groups when the output is closed instead of pushing the metrics,
+	// requires an interval or push-on-write
+	DeleteOnExit bool          `mapstructure:"delete-on-exit,omitempty" json:"delete-on-exit,omitempty"`
+	Username     string        `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password     string        `mapstructure:"password,omitempty" json:"password,omitempty"`
+	Timeout      time.Duration `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+type pushgateway struct {
+	// serializes the pushes and deletes
+	m           sync.Mutex
+	cfg         *PushgatewayConfig
+	client      *http.Client
+	jobTpl      *template.Template
+	groupingTpl map[string]*template.Template
+	// groups pushed by the last push, indexed by their key
+	pushed map[string]*pushGroup
+	// signals the stored batch should be pushed, when push-on-write is set
+	pushCh chan struct{}
+}
+
+// pushGroup is the set of metrics pushed with the same job and grouping labels
+type pushGroup struct {
+	job      string
+	grouping map[string]string
+	metrics  []prometheus.Metric
+}
+
+// Describe implements prometheus.Collector
+func (g *pushGroup) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector
+func (g *pushGroup) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range g.metrics {
+		ch <- m
+	}
+}
+
+func (g *pushGroup) key() string {
+	names := make([]string, 0, len(g.grouping))
+	for n := range g.grouping {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	sb := strings.Builder{}
+	sb.WriteString(g.job)
+	for _, n := range names {
+		sb.WriteString("/")
+		sb.WriteString(n)
+		sb.WriteString("=")
+		sb.WriteString(g.grouping[n])
+	}
+	return sb.String()
+}
+
+func newPushgateway(cfg *PushgatewayConfig) (*pushgateway, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("missing pushgateway url")
+	}
+	// without periodic pushes, the metrics would be deleted without being pushed
+	if cfg.DeleteOnExit && cfg.Interval <= 0 && !cfg.PushOnWrite {
+		return nil, errors.New("pushgateway delete-on-exit requires an interval or push-on-write")
+	}
+	if cfg.Job == "" {
+		cfg.Job = defaultPushgatewayJob
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultPushgatewayTimeout
+	}
+	pg := &pushgateway{
+		cfg:         cfg,
+		client:      &http.Client{Timeout: cfg.Timeout},
+		groupingTpl: make(map[string]*template.Template),
+		pushed:      make(map[string]*pushGroup),
+		pushCh:      make(chan struct{}, 1),
+	}
+	var err error
+	pg.jobTpl, err = template.New("job").
+		Funcs(outputs.TemplateFuncs).
+		Option("missingkey=zero").
+		Parse(cfg.Job)
+	if err != nil {
+		return nil, err
+	}
+	for name, tpl := range cfg.Grouping {
+		pg.groupingTpl[name], err = template.New(name).
+			Funcs(outputs.TemplateFuncs).
+			Option("missingkey=zero").
+			Parse(tpl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pg, nil
+}
+
+// groups splits the metrics into push groups based on the job and grouping templates
+// executed with each metric labels.
+// The grouping labels are removed from the metrics, the Pushgateway adds them back.
+func (pg *pushgateway) groups(metrics []*promMetric) (map[string]*pushGroup, error) {
+	groups := make(map[string]*pushGroup)
+	for _, m := range metrics {
+		labels := make(map[string]string, len(m.labels))
+		for _, lb := range m.labels {
+			labels[lb.Name] = lb.Value
+		}
+		g := &pushGroup{grouping: make(map[string]string, len(pg.groupingTpl))}
+		var err error
+		g.job, err = execTemplate(pg.jobTpl, labels)
+		if err != nil {
+			return nil, err
+		}
+		if g.job == "" {
+			g.job = defaultPushgatewayJob
+		}
+		for name, tpl := range pg.groupingTpl {
+			g.grouping[name], err = execTemplate(tpl, labels)
+			if err != nil {
+				return nil, err
+			}
+		}
+		k := g.key()
+		if eg, ok := groups[k]; ok {
+			g = eg
+		} else {
+			groups[k] = g
+		}
+		pm := *m
+		pm.labels = make([]*labelPair, 0, len(m.labels))
+		for _, lb := range m.labels {
+			if _, ok := g.grouping[lb.Name]; ok || lb.Name == "job" {
+				continue
+			}
+			pm.labels = append(pm.labels, lb)
+		}
+		g.metrics = append(g.metrics, &pm)
+	}
+	return groups, nil
+}
+
+func (pg *pushgateway) pusher(g *pushGroup) *push.Pusher {
+	pusher := push.New(pg.cfg.URL, g.job).Client(pg.client)
+	for name, value := range g.grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	if pg.cfg.Username != "" {
+		pusher = pusher.BasicAuth(pg.cfg.Username, pg.cfg.Password)
+	}
+	return pusher
+}
+
+// push replaces the metrics of each group in the Pushgateway,
+// the groups pushed previously and without metrics are deleted.
+func (pg *pushgateway) push(metrics []*promMetric) error {
+	pg.m.Lock()
+	defer pg.m.Unlock()
+	groups, err := pg.groups(metrics)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for k, g := range groups {
+		err = pg.pusher(g).Collector(g).Push()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		pg.pushed[k] = g
+	}
+	for k, g := range pg.pushed {
+		if _, ok := groups[k]; ok {
+			continue
+		}
+		err = pg.pusher(g).Delete()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		delete(pg.pushed, k)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// delete removes the pushed groups from the Pushgateway
+func (pg *pushgateway) delete() error {
+	pg.m.Lock()
+	defer pg.m.Unlock()
+	var errs []string
+	for k, g := range pg.pushed {
+		err := pg.pusher(g).Delete()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		delete(pg.pushed, k)
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func execTemplate(tpl *template.Template, data map[string]string) (string, error) {
+	sb := new(strings.Builder)
+	err := tpl.Execute(sb, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// batchStored triggers a push of the stored metrics,
+// the pushes of batches stored while a push is in progress are merged.
+func (pg *pushgateway) batchStored() {
+	select {
+	case pg.pushCh <- struct{}{}:
+	default:
+	}
+}
+
+// pushMetrics pushes the stored metrics to the Pushgateway,
+// the stored metrics are not updated in place so the push is done without holding the output lock.
+func (p *PrometheusOutput) pushMetrics() {
+	p.Lock()
+	p.expireMetrics()
+	metrics := make([]*promMetric, 0, len(p.entries))
+	for _, e := range p.entries {
+		metrics = append(metrics, e)
+	}
+	p.Unlock()
+	err := p.pushgateway.push(metrics)
+	if err != nil {
+		p.logger.Printf("failed to push metrics to pushgateway: %v", err)
+		return
+	}
+	if p.Cfg.Debug {
+		p.logger.Printf("pushed %d metrics to pushgateway", len(metrics))
+	}
+}
+
+// pushPeriodic pushes the stored metrics every interval and after each stored batch if push-on-write is set
+func (p *PrometheusOutput) pushPeriodic(ctx context.Context) {
+	defer p.wg.Done()
+	var tick <-chan time.Time
+	if p.Cfg.Pushgateway.Interval > 0 {
+		ticker := time.NewTicker(p.Cfg.Pushgateway.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			p.pushMetrics()
+		case <-p.pushgateway.pushCh:
+			p.pushMetrics()
+		}
+	}
+}
+
+// closePushgateway pushes the stored metrics, or deletes the pushed groups if delete-on-exit is set
+func (p *PrometheusOutput) closePushgateway() {
+	if p.Cfg.Pushgateway.DeleteOnExit {
+		err := p.pushgateway.delete()
+		if err != nil {
+			p.logger.Printf("failed to delete metrics from pushgateway: %v", err)
+		}
+		return
+	}
+	p.pushMetrics()
+}
@@ -0,0 +1,170 @@
+package prometheus_output
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// testPushgateway records the requests received by a Pushgateway
+type testPushgateway struct {
+	m        sync.Mutex
+	requests []string
+	bodies   map[string]string
+}
+
+func (pg *testPushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pg.m.Lock()
+	defer pg.m.Unlock()
+	b, _ := ioutil.ReadAll(r.Body)
+	pg.requests = append(pg.requests, r.Method+" "+r.URL.EscapedPath())
+	pg.bodies[r.URL.EscapedPath()] = string(b)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (pg *testPushgateway) reset() []string {
+	pg.m.Lock()
+	defer pg.m.Unlock()
+	reqs := pg.requests
+	pg.requests = nil
+	sort.Strings(reqs)
+	return reqs
+}
+
+func TestPushgateway(t *testing.T) {
+	tpg := &testPushgateway{bodies: make(map[string]string)}
+	srv := httptest.NewServer(tpg)
+	defer srv.Close()
+
+	p := newTestOutput(t, &Config{
+		Pushgateway: &PushgatewayConfig{
+			URL:          srv.URL,
+			Grouping:     map[string]string{"source": "{{ .source }}"},
+			PushOnWrite:  true,
+			DeleteOnExit: true,
+		},
+	})
+	var err error
+	p.pushgateway, err = newPushgateway(p.Cfg.Pushgateway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{"router1", "router2"} {
+		p.storeEvent(&formatters.EventMsg{
+			Name:   "sub1",
+			Tags:   map[string]string{"source": src, "interface_name": "ethernet-1/1"},
+			Values: map[string]interface{}{"/interfaces/interface/state/counters/in-octets": 1},
+		}, nil)
+	}
+	p.pushMetrics()
+	want := []string{
+		"PUT /metrics/job/gnmic/source/router1",
+		"PUT /metrics/job/gnmic/source/router2",
+	}
+	if got := tpg.reset(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected pushgateway requests: got %v, want %v", got, want)
+	}
+	body := tpg.bodies["/metrics/job/gnmic/source/router1"]
+	if !strings.Contains(body, "ethernet-1/1") {
+		t.Errorf("pushed metrics are missing the interface_name label: %s", body)
+	}
+	if strings.Contains(body, "router1") {
+		t.Errorf("pushed metrics should not carry the grouping label: %s", body)
+	}
+	// the router2 group is deleted once its series are removed
+	p.storeEvent(&formatters.EventMsg{
+		Name:    "sub1",
+		Tags:    map[string]string{"source": "router2"},
+		Deletes: []string{"/interfaces"},
+	}, nil)
+	p.pushMetrics()
+	want = []string{
+		"DELETE /metrics/job/gnmic/source/router2",
+		"PUT /metrics/job/gnmic/source/router1",
+	}
+	if got := tpg.reset(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected pushgateway requests: got %v, want %v", got, want)
+	}
+	p.pushOnce.Do(p.closePushgateway)
+	want = []string{
+		"DELETE /metrics/job/gnmic/source/router1",
+	}
+	if got := tpg.reset(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected pushgateway requests: got %v, want %v", got, want)
+	}
+}
+
+func TestPushgatewayDeleteOnExit(t *testing.T) {
+	_, err := newPushgateway(&PushgatewayConfig{URL: "http://pushgateway:9091", DeleteOnExit: true})
+	if err == nil {
+		t.Fatal("expected delete-on-exit without an interval or push-on-write to fail")
+	}
+}
+
+func TestPushgatewayPushOnWrite(t *testing.T) {
+	tpg := &testPushgateway{bodies: make(map[string]string)}
+	srv := httptest.NewServer(tpg)
+	defer srv.Close()
+
+	p := newTestOutput(t, &Config{
+		Pushgateway: &PushgatewayConfig{
+			URL:         srv.URL,
+			PushOnWrite: true,
+		},
+	})
+	p.wg = new(sync.WaitGroup)
+	var err error
+	p.pushgateway, err = newPushgateway(p.Cfg.Pushgateway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.wg.Add(1)
+	go p.pushPeriodic(ctx)
+	defer func() {
+		cancel()
+		p.wg.Wait()
+	}()
+	p.Write(ctx, &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "counter"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: 1}},
+					},
+				},
+			},
+		},
+	}, map[string]string{"source": "router1", "subscription-name": "sub1"})
+
+	want := "PUT /metrics/job/gnmic"
+	timeout := time.After(time.Second)
+	for {
+		got := tpg.reset()
+		if len(got) > 0 {
+			if got[0] != want {
+				t.Fatalf("unexpected pushgateway requests: got %v, want %v", got, want)
+			}
+			if !strings.Contains(tpg.bodies["/metrics/job/gnmic"], "router1") {
+				t.Errorf("pushed metrics are missing the written value: %s", tpg.bodies["/metrics/job/gnmic"])
+			}
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatal("the written batch was not pushed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package prometheus_output
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MetricRule sets the type and help text of the metrics generated from the values
+// with a path matching the rule regex.
+type MetricRule struct {
+	// regular expression matched against the value path, e.g: ^/interfaces/interface/state/counters/
+	Path string `mapstructure:"path,omitempty" json:"path,omitempty"`
+	// one of counter, gauge or untyped
+	Type string `mapstructure:"type,omitempty" json:"type,omitempty"`
+	// metric HELP text
+	Help string `mapstructure:"help,omitempty" json:"help,omitempty"`
+
+	re  *regexp.Regexp
+	typ dto.MetricType
+}
+
+var metricTypes = map[string]dto.MetricType{
+	"":        dto.MetricType_UNTYPED,
+	"untyped": dto.MetricType_UNTYPED,
+	"counter": dto.MetricType_COUNTER,
+	"gauge":   dto.MetricType_GAUGE,
+}
+
+func (p *PrometheusOutput) initMetricRules() error {
+	for i, r := range p.Cfg.MetricRules {
+		if r.Path == "" {
+			return fmt.Errorf("metric-rules[%d]: missing path", i)
+		}
+		var err error
+		r.re, err = regexp.Compile(r.Path)
+		if err != nil {
+			return fmt.Errorf("metric-rules[%d]: invalid path regex: %v", i, err)
+		}
+		var ok bool
+		r.typ, ok = metricTypes[strings.ToLower(r.Type)]
+		if !ok {
+			return fmt.Errorf("metric-rules[%d]: unknown metric type %q", i, r.Type)
+		}
+	}
+	p.ruleCache = make(map[string]*MetricRule)
+	return nil
+}
+
+// metricRule returns the first rule matching the value path, or nil.
+// must be called with the output lock held.
+func (p *PrometheusOutput) metricRule(valuePath string) *MetricRule {
+	if len(p.Cfg.MetricRules) == 0 {
+		return nil
+	}
+	if r, ok := p.ruleCache[valuePath]; ok {
+		return r
+	}
+	var rule *MetricRule
+	for _, r := range p.Cfg.MetricRules {
+		if r.re.MatchString(valuePath) {
+			rule = r
+			break
+		}
+	}
+	p.ruleCache[valuePath] = rule
+	return rule
+}
+
+func (p *PrometheusOutput) initLabelRules() error {
+	for _, expr := range p.Cfg.LabelInclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid label-include regex %q: %v", expr, err)
+		}
+		p.labelInclude = append(p.labelInclude, re)
+	}
+	for _, expr := range p.Cfg.LabelExclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid label-exclude regex %q: %v", expr, err)
+		}
+		p.labelExclude = append(p.labelExclude, re)
+	}
+	if p.Cfg.MaxLabelValues > 0 {
+		p.labelValues = make(map[string]map[string]int)
+	}
+	return nil
+}
+
+// keepLabel returns true if the label name matches one of the label-include regexes (if any)
+// and none of the label-exclude regexes
+func (p *PrometheusOutput) keepLabel(name string) bool {
+	for _, re := range p.labelExclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(p.labelInclude) == 0 {
+		return true
+	}
+	for _, re := range p.labelInclude {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLimits returns the name of the cardinality limit reached by adding the new series pm,
+// or an empty string.
+// must be called with the output lock held.
+func (p *PrometheusOutput) checkLimits(pm *promMetric) string {
+	if p.Cfg.MaxSeries > 0 && len(p.entries) >= p.Cfg.MaxSeries {
+		return "max_series"
+	}
+	if p.Cfg.MaxLabelValues <= 0 {
+		return ""
+	}
+	for _, lb := range pm.labels {
+		values := p.labelValues[lb.Name]
+		if _, ok := values[lb.Value]; !ok && len(values) >= p.Cfg.MaxLabelValues {
+			return "max_label_values"
+		}
+	}
+	return ""
+}
+
+// addEntry stores a new series.
+// must be called with the output lock held.
+func (p *PrometheusOutput) addEntry(key uint64, pm *promMetric) {
+	p.entries[key] = pm
+	if p.labelValues == nil {
+		return
+	}
+	for _, lb := range pm.labels {
+		if p.labelValues[lb.Name] == nil {
+			p.labelValues[lb.Name] = make(map[string]int)
+		}
+		p.labelValues[lb.Name][lb.Value]++
+	}
+}
+
+// removeEntry deletes a stored series.
+// must be called with the output lock held.
+func (p *PrometheusOutput) removeEntry(key uint64) {
+	pm, ok := p.entries[key]
+	if !ok {
+		return
+	}
+	delete(p.entries, key)
+	if p.labelValues == nil {
+		return
+	}
+	for _, lb := range pm.labels {
+		values := p.labelValues[lb.Name]
+		values[lb.Value]--
+		if values[lb.Value] <= 0 {
+			delete(values, lb.Value)
+		}
+		if len(values) == 0 {
+			delete(p.labelValues, lb.Name)
+		}
+	}
+}
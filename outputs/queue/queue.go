@@ -0,0 +1,485 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentFileSuffix = ".seg"
+	positionFileName  = "position"
+	// record header: length(4) + crc32(4) + timestamp(8)
+	recordHeaderSize = 16
+	// max size of a segment file, segments are deleted once fully consumed
+	defaultSegmentSize = 16 * 1024 * 1024
+	minSegmentCount    = 4
+)
+
+var ErrCorruptRecord = errors.New("corrupt queue record")
+
+// segment is a file containing a sequence of records
+type segment struct {
+	id    uint64
+	size  int64
+	count int64
+}
+
+// Queue is a FIFO persisted on disk as a sequence of segment files.
+// Records are appended to the last segment and read from the first one,
+// the read position is persisted so that unacknowledged records
+// survive a restart.
+type Queue struct {
+	dir         string
+	maxSize     int64
+	maxAge      time.Duration
+	segmentSize int64
+
+	m        sync.Mutex
+	notify   chan struct{}
+	segments []*segment
+	w        *os.File
+	r        *os.File
+	pos      *os.File
+	// read position in the first segment
+	rOffset   int64
+	rConsumed int64
+	// length of the record returned by the last Peek
+	pending int64
+	// number and size of the records not yet acknowledged
+	depth int64
+	bytes int64
+	// number of records dropped because of the max size or max age limits
+	droppedFull    int64
+	droppedExpired int64
+	closed         bool
+}
+
+// Open opens or creates a queue in directory dir.
+// maxSize is the max size in bytes of the queue files, when reached the oldest records are dropped.
+// records older than maxAge are dropped, a zero maxAge disables this behavior.
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Queue, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{
+		dir:         dir,
+		maxSize:     maxSize,
+		maxAge:      maxAge,
+		segmentSize: defaultSegmentSize,
+		notify:      make(chan struct{}, 1),
+	}
+	if q.maxSize > 0 && q.maxSize/minSegmentCount < q.segmentSize {
+		q.segmentSize = q.maxSize / minSegmentCount
+	}
+	err = q.load()
+	if err != nil {
+		q.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Queue) load() error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), segmentFileSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(fi.Name(), segmentFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, &segment{id: id})
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].id < q.segments[j].id
+	})
+	q.pos, err = os.OpenFile(filepath.Join(q.dir, positionFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	posID, posOffset := q.readPosition()
+	// delete the segments consumed before the last shutdown
+	for len(q.segments) > 0 && q.segments[0].id < posID {
+		os.Remove(q.segmentPath(q.segments[0].id))
+		q.segments = q.segments[1:]
+	}
+	if len(q.segments) == 0 || q.segments[0].id != posID {
+		posOffset = 0
+	}
+	for i, seg := range q.segments {
+		err = q.scanSegment(seg, i == len(q.segments)-1)
+		if err != nil {
+			return err
+		}
+	}
+	if len(q.segments) == 0 {
+		q.segments = append(q.segments, &segment{id: posID + 1})
+	}
+	last := q.segments[len(q.segments)-1]
+	q.w, err = os.OpenFile(q.segmentPath(last.id), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = q.w.Seek(last.size, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = q.openReader()
+	if err != nil {
+		return err
+	}
+	for _, seg := range q.segments {
+		q.depth += seg.count
+		q.bytes += seg.size
+	}
+	// skip the records consumed in the first segment
+	for q.rOffset < posOffset && q.rOffset < q.segments[0].size {
+		n, _, err := q.readHeader()
+		if err != nil {
+			break
+		}
+		q.rOffset += n
+		q.rConsumed++
+		q.depth--
+		q.bytes -= n
+	}
+	return q.writePosition()
+}
+
+// scanSegment counts the valid records in a segment,
+// a partially written record at the end of the last segment is truncated.
+func (q *Queue) scanSegment(seg *segment, last bool) error {
+	f, err := os.Open(q.segmentPath(seg.id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := make([]byte, recordHeaderSize)
+	var offset int64
+	for {
+		_, err = f.ReadAt(hdr, offset)
+		if err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		// a corrupt header may hold any length
+		if offset+recordHeaderSize+length > fi.Size() {
+			break
+		}
+		data := make([]byte, length)
+		_, err = f.ReadAt(data, offset+recordHeaderSize)
+		if err != nil || crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(hdr[4:8]) {
+			break
+		}
+		offset += recordHeaderSize + length
+		seg.count++
+	}
+	seg.size = offset
+	if last {
+		return os.Truncate(q.segmentPath(seg.id), offset)
+	}
+	return nil
+}
+
+// Push appends a record to the queue
+func (q *Queue) Push(b []byte) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.closed {
+		return errors.New("queue closed")
+	}
+	recLen := int64(recordHeaderSize + len(b))
+	last := q.segments[len(q.segments)-1]
+	if last.size > 0 && last.size+recLen > q.segmentSize {
+		err := q.rotate()
+		if err != nil {
+			return err
+		}
+		last = q.segments[len(q.segments)-1]
+	}
+	rec := make([]byte, recLen)
+	binary.BigEndian.PutUint32(rec[0:4], uint32(len(b)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(b))
+	binary.BigEndian.PutUint64(rec[8:16], uint64(time.Now().UnixNano()))
+	copy(rec[recordHeaderSize:], b)
+	_, err := q.w.Write(rec)
+	if err != nil {
+		return err
+	}
+	last.size += recLen
+	last.count++
+	q.depth++
+	q.bytes += recLen
+	q.enforceMaxSize()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Peek blocks until a record is available and returns it without removing it from the queue.
+// The record is removed by calling Ack.
+func (q *Queue) Peek(ctx context.Context) ([]byte, error) {
+	for {
+		q.m.Lock()
+		if q.closed {
+			q.m.Unlock()
+			return nil, errors.New("queue closed")
+		}
+		q.purge()
+		if q.depth > 0 {
+			b, err := q.readRecord()
+			if err == ErrCorruptRecord {
+				// drop the remaining records of the corrupt segment
+				if len(q.segments) == 1 {
+					q.rotate()
+				}
+				q.dropFirstSegment()
+				q.writePosition()
+			}
+			q.m.Unlock()
+			return b, err
+		}
+		q.m.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.notify:
+		}
+	}
+}
+
+// Ack removes the record returned by the last Peek from the queue
+func (q *Queue) Ack() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.pending == 0 {
+		return nil
+	}
+	q.advance(q.pending)
+	q.pending = 0
+	return q.writePosition()
+}
+
+// Purge drops the records older than the queue max age
+func (q *Queue) Purge() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.closed {
+		return
+	}
+	q.purge()
+}
+
+// Len returns the number of records in the queue
+func (q *Queue) Len() int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.depth
+}
+
+// Size returns the size in bytes of the records in the queue
+func (q *Queue) Size() int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.bytes
+}
+
+// Dropped returns the number of records dropped because the queue was full
+// and because they were older than the max age
+func (q *Queue) Dropped() (full int64, expired int64) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.droppedFull, q.droppedExpired
+}
+
+func (q *Queue) Close() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.closed = true
+	for _, f := range []*os.File{q.w, q.r, q.pos} {
+		if f != nil {
+			f.Close()
+		}
+	}
+	return nil
+}
+
+func (q *Queue) readRecord() ([]byte, error) {
+	n, _, err := q.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n-recordHeaderSize)
+	_, err = q.r.ReadAt(data, q.rOffset+recordHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	q.pending = n
+	return data, nil
+}
+
+// readHeader reads the header of the record at the read position,
+// it returns the record total length and its timestamp.
+// the read position is moved to the next segment if the current one is fully consumed.
+func (q *Queue) readHeader() (int64, time.Time, error) {
+	for q.rOffset >= q.segments[0].size {
+		if len(q.segments) == 1 {
+			return 0, time.Time{}, io.EOF
+		}
+		err := q.nextSegment()
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+	}
+	hdr := make([]byte, recordHeaderSize)
+	_, err := q.r.ReadAt(hdr, q.rOffset)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	length := int64(binary.BigEndian.Uint32(hdr[0:4]))
+	if q.rOffset+recordHeaderSize+length > q.segments[0].size {
+		return 0, time.Time{}, ErrCorruptRecord
+	}
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(hdr[8:16])))
+	return recordHeaderSize + length, ts, nil
+}
+
+func (q *Queue) purge() {
+	// do not drop a record that is being delivered
+	if q.maxAge <= 0 || q.pending > 0 {
+		return
+	}
+	expiry := time.Now().Add(-q.maxAge)
+	for q.depth > 0 {
+		n, ts, err := q.readHeader()
+		if err != nil || !ts.Before(expiry) {
+			break
+		}
+		q.advance(n)
+		q.droppedExpired++
+	}
+	q.writePosition()
+}
+
+// advance moves the read position by n bytes
+func (q *Queue) advance(n int64) {
+	q.rOffset += n
+	q.rConsumed++
+	q.depth--
+	q.bytes -= n
+	if q.rOffset >= q.segments[0].size && len(q.segments) > 1 {
+		q.nextSegment()
+	}
+}
+
+// nextSegment deletes the first segment and moves the read position to the start of the next one
+func (q *Queue) nextSegment() error {
+	first := q.segments[0]
+	q.r.Close()
+	os.Remove(q.segmentPath(first.id))
+	q.segments = q.segments[1:]
+	q.rOffset = 0
+	q.rConsumed = 0
+	return q.openReader()
+}
+
+// rotate closes the current write segment and creates a new one
+func (q *Queue) rotate() error {
+	last := q.segments[len(q.segments)-1]
+	err := q.w.Close()
+	if err != nil {
+		return err
+	}
+	seg := &segment{id: last.id + 1}
+	q.w, err = os.OpenFile(q.segmentPath(seg.id), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	q.segments = append(q.segments, seg)
+	return nil
+}
+
+// enforceMaxSize drops the oldest segments until the queue files fit in maxSize
+func (q *Queue) enforceMaxSize() {
+	if q.maxSize <= 0 {
+		return
+	}
+	if q.totalSize() <= q.maxSize {
+		return
+	}
+	for len(q.segments) > 1 && q.totalSize() > q.maxSize {
+		q.dropFirstSegment()
+	}
+	q.writePosition()
+}
+
+// dropFirstSegment drops the records remaining in the first segment
+func (q *Queue) dropFirstSegment() {
+	first := q.segments[0]
+	q.droppedFull += first.count - q.rConsumed
+	q.depth -= first.count - q.rConsumed
+	q.bytes -= first.size - q.rOffset
+	q.pending = 0
+	q.nextSegment()
+}
+
+func (q *Queue) totalSize() int64 {
+	var size int64
+	for _, seg := range q.segments {
+		size += seg.size
+	}
+	return size
+}
+
+func (q *Queue) openReader() error {
+	var err error
+	q.r, err = os.OpenFile(q.segmentPath(q.segments[0].id), os.O_CREATE|os.O_RDONLY, 0644)
+	return err
+}
+
+func (q *Queue) segmentPath(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, segmentFileSuffix))
+}
+
+func (q *Queue) readPosition() (uint64, int64) {
+	b := make([]byte, 16)
+	_, err := q.pos.ReadAt(b, 0)
+	if err != nil {
+		if len(q.segments) > 0 {
+			return q.segments[0].id, 0
+		}
+		return 0, 0
+	}
+	return binary.BigEndian.Uint64(b[0:8]), int64(binary.BigEndian.Uint64(b[8:16]))
+}
+
+func (q *Queue) writePosition() error {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b[0:8], q.segments[0].id)
+	binary.BigEndian.PutUint64(b[8:16], uint64(q.rOffset))
+	_, err := q.pos.WriteAt(b, 0)
+	return err
+}
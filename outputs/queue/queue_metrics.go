@@ -0,0 +1,46 @@
+package queue
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_queue",
+	Name:      "depth_messages",
+	Help:      "Number of messages stored in the output queue",
+}, []string{"output"})
+
+var queueBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_queue",
+	Name:      "depth_bytes",
+	Help:      "Number of bytes stored in the output queue",
+}, []string{"output"})
+
+var queueDroppedMsgs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_queue",
+	Name:      "number_of_dropped_messages",
+	Help:      "Number of messages dropped from the output queue because it was full or they expired",
+}, []string{"output", "reason"})
+
+var queueNumberOfFailedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_queue",
+	Name:      "number_of_failed_messages_total",
+	Help:      "Number of messages that could not be queued or read from the output queue",
+}, []string{"output", "reason"})
+
+func registerMetrics(reg *prometheus.Registry) error {
+	for _, c := range []prometheus.Collector{queueDepth, queueBytes, queueDroppedMsgs, queueNumberOfFailedMsgs} {
+		err := reg.Register(c)
+		if err == nil {
+			continue
+		}
+		// the metrics are shared by all the queued outputs
+		if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			continue
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,368 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gnmic-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func pop(t *testing.T, q *Queue) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b, err := q.Peek(ctx)
+	if err != nil {
+		t.Fatalf("failed to peek: %v", err)
+	}
+	err = q.Ack()
+	if err != nil {
+		t.Fatalf("failed to ack: %v", err)
+	}
+	return string(b)
+}
+
+func TestQueueOrder(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	// small segments to test the segments rotation
+	q.segmentSize = 64
+	for i := 0; i < 100; i++ {
+		err = q.Push([]byte(fmt.Sprintf("msg%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.Len() != 100 {
+		t.Fatalf("expected queue length 100, got %d", q.Len())
+	}
+	for i := 0; i < 100; i++ {
+		got := pop(t, q)
+		if got != fmt.Sprintf("msg%d", i) {
+			t.Fatalf("expected msg%d, got %s", i, got)
+		}
+	}
+	if q.Len() != 0 || q.Size() != 0 {
+		t.Fatalf("expected an empty queue, got length=%d, size=%d", q.Len(), q.Size())
+	}
+	if len(q.segments) != 1 {
+		t.Fatalf("expected consumed segments to be deleted, got %d segments", len(q.segments))
+	}
+}
+
+func TestQueueReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.segmentSize = 64
+	for i := 0; i < 20; i++ {
+		q.Push([]byte(fmt.Sprintf("msg%d", i)))
+	}
+	for i := 0; i < 7; i++ {
+		pop(t, q)
+	}
+	// peeked but not acknowledged
+	_, err = q.Peek(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	q, err = Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 13 {
+		t.Fatalf("expected queue length 13 after reopen, got %d", q.Len())
+	}
+	for i := 7; i < 20; i++ {
+		got := pop(t, q)
+		if got != fmt.Sprintf("msg%d", i) {
+			t.Fatalf("expected msg%d, got %s", i, got)
+		}
+	}
+}
+
+func TestQueueTruncatedRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Push([]byte("msg0"))
+	q.Push([]byte("msg1"))
+	last := q.segmentPath(q.segments[len(q.segments)-1].id)
+	size := q.segments[len(q.segments)-1].size
+	q.Close()
+	// simulate a partially written record
+	err = os.Truncate(last, size-2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err = Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 1 {
+		t.Fatalf("expected queue length 1, got %d", q.Len())
+	}
+	if got := pop(t, q); got != "msg0" {
+		t.Fatalf("expected msg0, got %s", got)
+	}
+}
+
+func TestQueueCorruptLength(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Push([]byte("msg0"))
+	q.Push([]byte("msg1"))
+	last := q.segmentPath(q.segments[len(q.segments)-1].id)
+	q.Close()
+	// the second record header claims a 4GB length
+	f, err := os.OpenFile(last, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, recordHeaderSize+4)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err = Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 1 {
+		t.Fatalf("expected queue length 1, got %d", q.Len())
+	}
+}
+
+func TestQueueMaxSize(t *testing.T) {
+	// 4 segments of 64 bytes
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q, err := Open(dir, 256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for i := 0; i < 100; i++ {
+		q.Push([]byte(fmt.Sprintf("msg%03d", i)))
+	}
+	if q.totalSize() > 256 {
+		t.Fatalf("queue files size %d exceeds max size", q.totalSize())
+	}
+	full, _ := q.Dropped()
+	if full+q.Len() != 100 {
+		t.Fatalf("expected dropped+queued=100, got %d+%d", full, q.Len())
+	}
+	// the newest messages are kept
+	var got string
+	for q.Len() > 0 {
+		got = pop(t, q)
+	}
+	if got != "msg099" {
+		t.Fatalf("expected last message msg099, got %s", got)
+	}
+}
+
+func TestQueueMaxAge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q, err := Open(dir, 0, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.Push([]byte("old"))
+	time.Sleep(100 * time.Millisecond)
+	q.Push([]byte("new"))
+	q.Purge()
+	_, expired := q.Dropped()
+	if expired != 1 {
+		t.Fatalf("expected 1 expired message, got %d", expired)
+	}
+	if got := pop(t, q); got != "new" {
+		t.Fatalf("expected new, got %s", got)
+	}
+}
+
+func TestEncodeMsg(t *testing.T) {
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "a"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_IntVal{IntVal: 1}},
+					},
+				},
+			},
+		},
+	}
+	meta := map[string]string{"source": "router1", "subscription-name": "sub1"}
+	b, err := encodeMsg(rsp, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := decodeMsg(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(rsp, m.rsp) {
+		t.Errorf("expected %v, got %v", rsp, m.rsp)
+	}
+	if !cmp.Equal(map[string]string(m.meta), meta) {
+		t.Errorf("expected %v, got %v", meta, m.meta)
+	}
+	ev := &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 42,
+		Tags:      map[string]string{"source": "router1"},
+		Values:    map[string]interface{}{"counter": uint64(1), "rate": 0.5, "state": "up", "list": []interface{}{int64(1), "a"}},
+	}
+	b, err = encodeEvent(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = decodeMsg(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(m.ev, ev) {
+		t.Errorf("event mismatch: %s", cmp.Diff(ev, m.ev))
+	}
+}
+
+func TestOutputWriteInitFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// a file in place of the queue directory makes the queue open fail
+	f := filepath.Join(dir, "file")
+	err := ioutil.WriteFile(f, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := NewOutput(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rsp := &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}}
+	done := make(chan struct{})
+	// the write waits for Init
+	go func() {
+		defer close(done)
+		o.Write(ctx, rsp, nil)
+	}()
+	err = o.Init(ctx, "out1", map[string]interface{}{"queue": map[string]interface{}{"dir": f}})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("write blocked after Init failure")
+	}
+	o.Write(ctx, rsp, nil)
+}
+
+// syncOutput is an outputs.SyncWriter failing the first writes
+type syncOutput struct {
+	fail    int
+	written chan interface{}
+}
+
+func (o *syncOutput) Init(context.Context, string, map[string]interface{}, ...outputs.Option) error {
+	return nil
+}
+func (o *syncOutput) Write(context.Context, proto.Message, outputs.Meta) {}
+func (o *syncOutput) WriteEvent(context.Context, *formatters.EventMsg)   {}
+func (o *syncOutput) Close() error                                       { return nil }
+func (o *syncOutput) RegisterMetrics(*prometheus.Registry)               {}
+func (o *syncOutput) String() string                                     { return "" }
+func (o *syncOutput) SetLogger(*log.Logger)                              {}
+func (o *syncOutput) SetName(string)                                     {}
+func (o *syncOutput) SetClusterName(string)                              {}
+func (o *syncOutput) SetEventProcessors(map[string]map[string]interface{}, *log.Logger, map[string]interface{}) {
+}
+
+func (o *syncOutput) WriteSync(ctx context.Context, m proto.Message, meta outputs.Meta) error {
+	return o.write(m)
+}
+
+func (o *syncOutput) WriteEventSync(ctx context.Context, ev *formatters.EventMsg) error {
+	return o.write(ev)
+}
+
+func (o *syncOutput) write(m interface{}) error {
+	if o.fail > 0 {
+		o.fail--
+		return fmt.Errorf("sink down")
+	}
+	o.written <- m
+	return nil
+}
+
+func TestOutputSyncWriter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := &syncOutput{fail: 2, written: make(chan interface{}, 2)}
+	o := NewOutput(out)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := o.Init(ctx, "out1", map[string]interface{}{
+		"queue": map[string]interface{}{"dir": dir, "retry-interval": "10ms"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	rsp := &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}}
+	o.Write(ctx, rsp, outputs.Meta{"source": "router1"})
+	o.WriteEvent(ctx, &formatters.EventMsg{Name: "sub1", Values: map[string]interface{}{"counter": 1}})
+	// the failed writes are retried, in order
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-out.written:
+			if _, ok := m.(*gnmi.SubscribeResponse); ok != (i == 0) {
+				t.Errorf("unexpected message %d: %v", i, m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for message %d", i)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/mitchellh/mapstructure"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultMaxSize       = 1024 * 1024 * 1024 // 1GB
+	defaultRetryInterval = time.Second
+	loggingPrefix        = "[output_queue] "
+)
+
+// Config is the output queue configuration,
+// set under the `queue` field of an output.
+type Config struct {
+	// directory where the queue files are stored, a sub directory is created per output
+	Dir string `mapstructure:"dir,omitempty" json:"dir,omitempty"`
+	// max size of the queue files, e.g: 500MB, 2GB or a number of bytes
+	MaxSize string `mapstructure:"max-size,omitempty" json:"max-size,omitempty"`
+	// max age of a queued message
+	MaxAge time.Duration `mapstructure:"max-age,omitempty" json:"max-age,omitempty"`
+	// interval between checks of the output health when it is down
+	RetryInterval time.Duration `mapstructure:"retry-interval,omitempty" json:"retry-interval,omitempty"`
+	Debug         bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	maxSize int64
+}
+
+// Output is an outputs.Output that persists the messages it receives
+// in a disk queue before handing them over to the wrapped output.
+// The messages are delivered in order, the delivery is paused while the wrapped output
+// reports its sink as unhealthy (see outputs.HealthChecker).
+// A message is removed from the queue once delivered if the wrapped output implements outputs.SyncWriter,
+// once handed over to the output otherwise.
+type Output struct {
+	Cfg  *Config
+	name string
+	out  outputs.Output
+	q    *Queue
+	// closed once the queue open was attempted, q is nil if it failed
+	opened     chan struct{}
+	openedOnce sync.Once
+	// closed once the wrapped output is initialized
+	ready  chan struct{}
+	logger *log.Logger
+	reg    *prometheus.Registry
+
+	cancelFn context.CancelFunc
+}
+
+// Enabled returns true if the output configuration contains a queue section
+func Enabled(cfg map[string]interface{}) bool {
+	qcfg, ok := cfg["queue"]
+	return ok && qcfg != nil
+}
+
+// NewOutput wraps output out with a disk queue
+func NewOutput(out outputs.Output) *Output {
+	return &Output{
+		Cfg:    &Config{},
+		out:    out,
+		opened: make(chan struct{}),
+		ready:  make(chan struct{}),
+		logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+	}
+}
+
+func (o *Output) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	// the output is added to the collector before Init returns,
+	// release the pending writes if Init fails before the queue is opened.
+	defer o.setOpened()
+	o.name = name
+	err := decodeConfig(cfg["queue"], o.Cfg)
+	if err != nil {
+		return err
+	}
+	// the options are applied to the queue output to capture the logger and metrics registry,
+	// they are then passed to the wrapped output Init
+	for _, opt := range opts {
+		opt(o)
+	}
+	err = o.setDefaults()
+	if err != nil {
+		return err
+	}
+	o.q, err = Open(filepath.Join(o.Cfg.Dir, name), o.Cfg.maxSize, o.Cfg.MaxAge)
+	o.setOpened()
+	if err != nil {
+		return fmt.Errorf("failed to open output %q queue: %v", name, err)
+	}
+	o.logger.Printf("opened output %q queue: dir=%s, queued=%d, bytes=%d",
+		name, filepath.Join(o.Cfg.Dir, name), o.q.Len(), o.q.Size())
+	o.updateMetrics()
+	ctx, o.cancelFn = context.WithCancel(ctx)
+	go o.deliver(ctx)
+	go o.purgePeriodic(ctx)
+	err = o.out.Init(ctx, name, cfg, opts...)
+	if err != nil {
+		o.cancelFn()
+		o.q.Close()
+		return err
+	}
+	close(o.ready)
+	return nil
+}
+
+func (o *Output) setOpened() {
+	o.openedOnce.Do(func() { close(o.opened) })
+}
+
+func (o *Output) setDefaults() error {
+	if o.Cfg.Dir == "" {
+		o.Cfg.Dir = filepath.Join(xdg.DataHome, "gnmic", "queue")
+	}
+	if o.Cfg.RetryInterval <= 0 {
+		o.Cfg.RetryInterval = defaultRetryInterval
+	}
+	if o.Cfg.MaxSize == "" {
+		o.Cfg.maxSize = defaultMaxSize
+		return nil
+	}
+	var err error
+	o.Cfg.maxSize, err = outputs.ParseSize(o.Cfg.MaxSize)
+	if err != nil {
+		return fmt.Errorf("invalid queue max-size %q: %v", o.Cfg.MaxSize, err)
+	}
+	return nil
+}
+
+// Write stores the message in the queue,
+// it waits for the queue to be opened and drops the message if it could not be.
+func (o *Output) Write(ctx context.Context, m proto.Message, meta outputs.Meta) {
+	if m == nil || !o.waitOpened(ctx) {
+		return
+	}
+	rsp, ok := m.(*gnmi.SubscribeResponse)
+	if !ok {
+		// only subscribe responses are queued
+		o.out.Write(ctx, m, meta)
+		return
+	}
+	b, err := encodeMsg(rsp, meta)
+	if err != nil {
+		o.logger.Printf("output %q: failed to encode message: %v", o.name, err)
+		return
+	}
+	o.push(b)
+}
+
+// WriteEvent stores the event in the queue, like Write
+func (o *Output) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if ev == nil || !o.waitOpened(ctx) {
+		return
+	}
+	b, err := encodeEvent(ev)
+	if err != nil {
+		o.logger.Printf("output %q: failed to encode event: %v", o.name, err)
+		return
+	}
+	o.push(b)
+}
+
+// waitOpened waits for the queue to be opened,
+// it returns false if ctx is done or if the queue could not be opened.
+func (o *Output) waitOpened(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-o.opened:
+	}
+	if o.q == nil {
+		queueNumberOfFailedMsgs.WithLabelValues(o.name, "queue_unavailable").Inc()
+		return false
+	}
+	return true
+}
+
+func (o *Output) push(b []byte) {
+	err := o.q.Push(b)
+	if err != nil {
+		o.logger.Printf("output %q: failed to queue message: %v", o.name, err)
+		queueNumberOfFailedMsgs.WithLabelValues(o.name, "queue_error").Inc()
+		return
+	}
+	o.updateMetrics()
+}
+
+func (o *Output) Close() error {
+	if o.cancelFn != nil {
+		o.cancelFn()
+	}
+	err := o.out.Close()
+	if o.q != nil {
+		o.q.Close()
+	}
+	return err
+}
+
+func (o *Output) RegisterMetrics(reg *prometheus.Registry) {
+	if reg == nil {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		o.logger.Printf("failed to register metric: %v", err)
+	}
+}
+
+func (o *Output) String() string {
+	b, err := json.Marshal(o.Cfg)
+	if err != nil {
+		return o.out.String()
+	}
+	return fmt.Sprintf("{\"queue\":%s,\"output\":%s}", string(b), o.out.String())
+}
+
+func (o *Output) SetLogger(logger *log.Logger) {
+	if logger != nil && o.logger != nil {
+		o.logger.SetOutput(logger.Writer())
+		o.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (o *Output) SetEventProcessors(map[string]map[string]interface{}, *log.Logger, map[string]interface{}) {
+}
+func (o *Output) SetName(string)        {}
+func (o *Output) SetClusterName(string) {}
+
+// deliver reads the queued messages in order and writes them to the wrapped output
+func (o *Output) deliver(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-o.ready:
+	}
+	hc, _ := o.out.(outputs.HealthChecker)
+	wasHealthy := true
+	for {
+		if hc != nil && !hc.Healthy() {
+			if wasHealthy {
+				o.logger.Printf("output %q is unhealthy, pausing delivery: queued=%d", o.name, o.q.Len())
+				wasHealthy = false
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(o.Cfg.RetryInterval):
+				continue
+			}
+		}
+		if !wasHealthy {
+			o.logger.Printf("output %q recovered, resuming delivery: queued=%d", o.name, o.q.Len())
+			wasHealthy = true
+		}
+		b, err := o.q.Peek(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+				return
+			}
+			o.logger.Printf("output %q: failed to read from queue: %v", o.name, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(o.Cfg.RetryInterval):
+				continue
+			}
+		}
+		m, err := decodeMsg(b)
+		if err != nil {
+			o.logger.Printf("output %q: failed to decode queued message: %v", o.name, err)
+			queueNumberOfFailedMsgs.WithLabelValues(o.name, "decode_error").Inc()
+		} else {
+			err = o.write(ctx, m)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				// the message stays in the queue until it is delivered
+				o.logger.Printf("output %q: failed to deliver queued message, retrying in %s: %v", o.name, o.Cfg.RetryInterval, err)
+				queueNumberOfFailedMsgs.WithLabelValues(o.name, "write_error").Inc()
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(o.Cfg.RetryInterval):
+					continue
+				}
+			}
+			if o.Cfg.Debug {
+				o.logger.Printf("output %q: delivered queued message", o.name)
+			}
+		}
+		err = o.q.Ack()
+		if err != nil {
+			o.logger.Printf("output %q: failed to persist queue position: %v", o.name, err)
+		}
+		o.updateMetrics()
+	}
+}
+
+// write hands a queued message over to the wrapped output.
+// If the output implements outputs.SyncWriter, it waits for the message delivery,
+// otherwise the message is considered delivered once handed over.
+func (o *Output) write(ctx context.Context, m *queuedMsg) error {
+	if sw, ok := o.out.(outputs.SyncWriter); ok {
+		if m.ev != nil {
+			return sw.WriteEventSync(ctx, m.ev)
+		}
+		return sw.WriteSync(ctx, m.rsp, m.meta)
+	}
+	if m.ev != nil {
+		o.out.WriteEvent(ctx, m.ev)
+		return nil
+	}
+	o.out.Write(ctx, m.rsp, m.meta)
+	return nil
+}
+
+func (o *Output) purgePeriodic(ctx context.Context) {
+	if o.Cfg.MaxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(o.Cfg.MaxAge / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.q.Purge()
+			o.updateMetrics()
+		}
+	}
+}
+
+func (o *Output) updateMetrics() {
+	queueDepth.WithLabelValues(o.name).Set(float64(o.q.Len()))
+	queueBytes.WithLabelValues(o.name).Set(float64(o.q.Size()))
+	full, expired := o.q.Dropped()
+	queueDroppedMsgs.WithLabelValues(o.name, "full").Set(float64(full))
+	queueDroppedMsgs.WithLabelValues(o.name, "expired").Set(float64(expired))
+}
+
+func init() {
+	// the event values types which are not registered by default
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+	gob.Register(&gnmi.Decimal64{})
+}
+
+// queued record kinds
+const (
+	kindResponse byte = iota + 1
+	kindEvent
+)
+
+// queuedMsg is a decoded queue record: a subscribe response and its meta, or an event
+type queuedMsg struct {
+	rsp  *gnmi.SubscribeResponse
+	meta outputs.Meta
+	ev   *formatters.EventMsg
+}
+
+// encodeMsg encodes a subscribe response and its meta as:
+// kindResponse + uvarint(len(meta)) + meta JSON + proto encoded subscribe response
+func encodeMsg(rsp *gnmi.SubscribeResponse, meta outputs.Meta) ([]byte, error) {
+	mb, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := proto.Marshal(rsp)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1+binary.MaxVarintLen64, 1+binary.MaxVarintLen64+len(mb)+len(pb))
+	b[0] = kindResponse
+	n := binary.PutUvarint(b[1:], uint64(len(mb)))
+	b = append(b[:1+n], mb...)
+	return append(b, pb...), nil
+}
+
+// encodeEvent encodes an event as kindEvent + gob encoded event,
+// gob keeps the values types.
+func encodeEvent(ev *formatters.EventMsg) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{kindEvent})
+	err := gob.NewEncoder(buf).Encode(ev)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeMsg(b []byte) (*queuedMsg, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty message")
+	}
+	switch b[0] {
+	case kindResponse:
+		b = b[1:]
+	case kindEvent:
+		ev := new(formatters.EventMsg)
+		err := gob.NewDecoder(bytes.NewReader(b[1:])).Decode(ev)
+		if err != nil {
+			return nil, err
+		}
+		return &queuedMsg{ev: ev}, nil
+	default:
+		return nil, fmt.Errorf("unknown message kind %d", b[0])
+	}
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return nil, errors.New("invalid message meta length")
+	}
+	meta := outputs.Meta{}
+	err := json.Unmarshal(b[n:n+int(l)], &meta)
+	if err != nil {
+		return nil, err
+	}
+	rsp := new(gnmi.SubscribeResponse)
+	err = proto.Unmarshal(b[n+int(l):], rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &queuedMsg{rsp: rsp, meta: meta}, nil
+}
+
+func decodeConfig(src, dst interface{}) error {
+	decoder, err := mapstructure.NewDecoder(
+		&mapstructure.DecoderConfig{
+			DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+			WeaklyTypedInput: true,
+			Result:           dst,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(src)
+}
@@ -0,0 +1,152 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+const (
+	DefaultBatchSize  = 100
+	DefaultFlushTimer = 5 * time.Second
+	DefaultRetryTimer = 2 * time.Second
+	DefaultBufferSize = 1000
+)
+
+// EncodeFunc appends the lines representing an event to buf,
+// it returns the number of appended lines.
+type EncodeFunc func(buf *bytes.Buffer, ev *formatters.EventMsg) (int, error)
+
+// BatchConfig //
+type BatchConfig struct {
+	// output name, used as the dropped events metric label
+	Name string
+	// number of events buffered while a batch is written,
+	// the events sent while the buffer is full are dropped.
+	BufferSize int
+	// number of lines written at once
+	BatchSize int
+	// period after which the buffered lines are written whether the batch size is reached or not
+	FlushTimer time.Duration
+	// wait time between write attempts
+	RetryTimer time.Duration
+}
+
+// BatchWriter encodes events into lines and writes them in batches through a Writer,
+// a batch is written when it reaches BatchSize lines or when the FlushTimer expires.
+// A failed write is retried until it succeeds or the writer is stopped,
+// meanwhile the events are buffered up to BufferSize then dropped,
+// so that a sink being down does not block the other outputs.
+type BatchWriter struct {
+	w       *Writer
+	events  chan *formatters.EventMsg
+	cfg     BatchConfig
+	encode  EncodeFunc
+	logger  *log.Logger
+	healthy int32
+
+	buf   *bytes.Buffer
+	lines int
+}
+
+// NewBatchWriter //
+func NewBatchWriter(w *Writer, cfg BatchConfig, encode EncodeFunc, logger *log.Logger) *BatchWriter {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.FlushTimer <= 0 {
+		cfg.FlushTimer = DefaultFlushTimer
+	}
+	if cfg.RetryTimer <= 0 {
+		cfg.RetryTimer = DefaultRetryTimer
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultBufferSize
+	}
+	return &BatchWriter{
+		w:       w,
+		events:  make(chan *formatters.EventMsg, cfg.BufferSize),
+		cfg:     cfg,
+		encode:  encode,
+		logger:  logger,
+		healthy: 1,
+		buf:     new(bytes.Buffer),
+	}
+}
+
+// Send buffers an event to be written, without blocking.
+// It returns false if the buffer is full and the event was dropped.
+func (bw *BatchWriter) Send(ev *formatters.EventMsg) bool {
+	select {
+	case bw.events <- ev:
+		return true
+	default:
+		NumberOfDroppedEvents.WithLabelValues(bw.cfg.Name).Inc()
+		return false
+	}
+}
+
+// Run reads the buffered events until ctx is done,
+// the remaining lines are then written once, without retries.
+func (bw *BatchWriter) Run(ctx context.Context) {
+	ticker := time.NewTicker(bw.cfg.FlushTimer)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// the buffered events are added to the final write
+			for len(bw.events) > 0 {
+				bw.encodeEvent(<-bw.events)
+			}
+			if bw.buf.Len() > 0 {
+				err := bw.w.Write(bw.buf.Bytes())
+				if err != nil {
+					bw.logger.Printf("failed to write %d lines to %s: %v", bw.lines, bw.w, err)
+				}
+			}
+			bw.w.Close()
+			return
+		case ev := <-bw.events:
+			bw.encodeEvent(ev)
+			if bw.lines >= bw.cfg.BatchSize {
+				bw.flush(ctx)
+			}
+		case <-ticker.C:
+			bw.flush(ctx)
+		}
+	}
+}
+
+func (bw *BatchWriter) encodeEvent(ev *formatters.EventMsg) {
+	n, err := bw.encode(bw.buf, ev)
+	if err != nil {
+		bw.logger.Printf("failed to encode event: %v", err)
+	}
+	bw.lines += n
+}
+
+func (bw *BatchWriter) flush(ctx context.Context) {
+	if bw.buf.Len() == 0 {
+		return
+	}
+	err := bw.w.WriteRetry(ctx, bw.buf.Bytes(), bw.cfg.RetryTimer, func(err error) {
+		atomic.StoreInt32(&bw.healthy, 0)
+		bw.logger.Printf("failed to write %d lines to %s: %v", bw.lines, bw.w, err)
+	})
+	if err != nil {
+		// ctx is done, keep the lines for the final write
+		return
+	}
+	atomic.StoreInt32(&bw.healthy, 1)
+	bw.buf.Reset()
+	bw.lines = 0
+}
+
+// Healthy returns false if the last write failed
+func (bw *BatchWriter) Healthy() bool {
+	return atomic.LoadInt32(&bw.healthy) == 1
+}
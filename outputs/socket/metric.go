@@ -0,0 +1,117 @@
+package socket
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+// MetricData is the data the metric name templates are executed with
+type MetricData struct {
+	// event name, i.e the subscription name
+	Name string
+	// event tags, with cleaned values
+	Tags map[string]string
+	// value name as found in the event, e.g: /interfaces/interface/state/counters/in-octets
+	ValueName string
+	// cleaned value name elements joined with '.', e.g: interfaces.interface.state.counters.in-octets
+	Path string
+}
+
+// NewMetricData builds the template data of value valueName of event ev,
+// the tag values and the path elements are cleaned using the clean function.
+func NewMetricData(ev *formatters.EventMsg, valueName string, clean func(string) string) *MetricData {
+	md := &MetricData{
+		Name:      ev.Name,
+		Tags:      make(map[string]string, len(ev.Tags)),
+		ValueName: valueName,
+		Path:      ValuePath(valueName, clean),
+	}
+	for k, v := range ev.Tags {
+		md.Tags[k] = clean(v)
+	}
+	return md
+}
+
+// Clean replaces the characters other than letters, digits, '-' and '_' with '_'
+func Clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// ValuePath joins the cleaned elements of a value name with '.',
+// e.g: /interfaces/interface/state/counters/in-octets becomes interfaces.interface.state.counters.in-octets
+func ValuePath(valueName string, clean func(string) string) string {
+	elems := strings.Split(strings.Trim(valueName, "/"), "/")
+	path := make([]string, 0, len(elems))
+	for _, e := range elems {
+		if e == "" {
+			continue
+		}
+		path = append(path, clean(e))
+	}
+	return strings.Join(path, ".")
+}
+
+// Float converts an event value to a float64,
+// it returns false if the value is not a finite number, a boolean or a numeric string.
+func Float(v interface{}) (float64, bool) {
+	f, ok := formatters.ToFloat(v)
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// FormatFloat formats f using the shortest representation
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Timestamp returns the event timestamp,
+// or the current time if the event has no timestamp or override is true
+func Timestamp(ev *formatters.EventMsg, override bool) time.Time {
+	if ev.Timestamp == 0 || override {
+		return time.Now()
+	}
+	return time.Unix(0, ev.Timestamp)
+}
+
+// MetricName executes the metric name template tpl with md,
+// the whitespaces in the result are replaced with '_' and the empty path elements are removed.
+func MetricName(tpl *template.Template, md *MetricData) (string, error) {
+	sb := new(strings.Builder)
+	err := tpl.Execute(sb, md)
+	if err != nil {
+		return "", err
+	}
+	name := strings.Join(strings.Fields(sb.String()), "_")
+	elems := strings.Split(name, ".")
+	path := elems[:0]
+	for _, e := range elems {
+		if e != "" {
+			path = append(path, e)
+		}
+	}
+	return strings.Join(path, "."), nil
+}
+
+// ValueNames returns the event value names, sorted
+func ValueNames(ev *formatters.EventMsg) []string {
+	names := make([]string, 0, len(ev.Values))
+	for n := range ev.Values {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
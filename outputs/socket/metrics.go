@@ -0,0 +1,22 @@
+package socket
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var NumberOfDroppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "socket_output",
+	Name:      "number_of_dropped_events_total",
+	Help:      "Number of events dropped because the output buffer was full",
+}, []string{"output"})
+
+// RegisterMetrics registers the metrics shared by the outputs using a BatchWriter
+func RegisterMetrics(reg *prometheus.Registry) error {
+	err := reg.Register(NumberOfDroppedEvents)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+func TestWriterDatagrams(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	w := NewWriter("udp", conn.LocalAddr().String(), 0)
+	defer w.Close()
+	buf := new(bytes.Buffer)
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(buf, "gnmic.router1.interfaces.interface.state.counters.in-octets %d 1606824673\n", i)
+	}
+	err = w.Write(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := new(bytes.Buffer)
+	b := make([]byte, 2*udpMaxPayloadSize)
+	for received.Len() < buf.Len() {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > udpMaxPayloadSize {
+			t.Errorf("datagram larger than %d bytes: %d", udpMaxPayloadSize, n)
+		}
+		if b[n-1] != '\n' {
+			t.Errorf("datagram does not end with a complete line: %q", b[:n])
+		}
+		received.Write(b[:n])
+	}
+	if received.String() != buf.String() {
+		t.Errorf("received lines mismatch")
+	}
+}
+
+func TestBatchWriterReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	// the server is down when the first batch is written
+	l.Close()
+
+	encode := func(buf *bytes.Buffer, ev *formatters.EventMsg) (int, error) {
+		fmt.Fprintf(buf, "%s %v\n", ev.Name, ev.Values["value"])
+		return 1, nil
+	}
+	bw := NewBatchWriter(NewWriter("tcp", addr, time.Second),
+		BatchConfig{BatchSize: 2, FlushTimer: time.Minute, RetryTimer: 10 * time.Millisecond},
+		encode, log.New(ioutil.Discard, "", 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bw.Run(ctx)
+	}()
+	bw.Send(&formatters.EventMsg{Name: "m1", Values: map[string]interface{}{"value": 1}})
+	bw.Send(&formatters.EventMsg{Name: "m2", Values: map[string]interface{}{"value": 2}})
+
+	time.Sleep(50 * time.Millisecond)
+	if bw.Healthy() {
+		t.Errorf("expected the batch writer to be unhealthy")
+	}
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var lines []string
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			// the last line is written when the batch writer stops
+			bw.Send(&formatters.EventMsg{Name: "m3", Values: map[string]interface{}{"value": 3}})
+			cancel()
+			<-done
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if got, want := strings.Join(lines, ""), "m1 1\nm2 2\nm3 3\n"; got != want {
+		t.Errorf("received lines mismatch: got %q, want %q", got, want)
+	}
+	if !bw.Healthy() {
+		t.Errorf("expected the batch writer to be healthy")
+	}
+}
+
+func TestMetricName(t *testing.T) {
+	ev := &formatters.EventMsg{
+		Name: "sub1",
+		Tags: map[string]string{
+			"source":         "10.1.1.1:57400",
+			"interface_name": "ethernet-1/1",
+		},
+		Values: map[string]interface{}{
+			"/srl_nokia-interfaces:interface/statistics/in-octets": 1,
+		},
+	}
+	for tpl, want := range map[string]string{
+		`{{ .Name }}{{ range .Tags }}.{{ . }}{{ end }}.{{ .Path }}`: "sub1.ethernet-1_1.10_1_1_1_57400.srl_nokia-interfaces_interface.statistics.in-octets",
+		`{{ .Tags.source }}.{{ .Tags.missing }}.{{ .Path }}`:        "10_1_1_1_57400.srl_nokia-interfaces_interface.statistics.in-octets",
+		`{{ .Name }} {{ .Tags.interface_name }}`:                    "sub1_ethernet-1_1",
+	} {
+		got, err := MetricName(
+			template.Must(template.New("metric").Option("missingkey=zero").Parse(tpl)),
+			NewMetricData(ev, "/srl_nokia-interfaces:interface/statistics/in-octets", Clean),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("template %q: got %q, want %q", tpl, got, want)
+		}
+	}
+}
+
+func TestFloat(t *testing.T) {
+	for _, v := range []interface{}{"1.5", float32(1.5), 1.5} {
+		f, ok := Float(v)
+		if !ok || f != 1.5 {
+			t.Errorf("Float(%#v): got %v, %v", v, f, ok)
+		}
+	}
+	for _, v := range []interface{}{"up", nil, []interface{}{1}, "NaN"} {
+		if _, ok := Float(v); ok {
+			t.Errorf("Float(%#v): expected a non numeric value", v)
+		}
+	}
+}
+
+func TestBatchWriterDrop(t *testing.T) {
+	encode := func(buf *bytes.Buffer, ev *formatters.EventMsg) (int, error) {
+		return 1, nil
+	}
+	bw := NewBatchWriter(NewWriter("tcp", "127.0.0.1:0", time.Second),
+		BatchConfig{Name: "test-drop", BufferSize: 2},
+		encode, log.New(ioutil.Discard, "", 0))
+	// the writer is not running, the third event does not fit in the buffer
+	for i, want := range []bool{true, true, false} {
+		if got := bw.Send(&formatters.EventMsg{Name: "m"}); got != want {
+			t.Errorf("event %d: Send returned %v, want %v", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package socket
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// max UDP payload size, lines are packed in datagrams up to this size
+	udpMaxPayloadSize = 1400
+
+	DefaultDialTimeout = 5 * time.Second
+)
+
+// Writer writes lines to a UDP or TCP socket,
+// such as an influxdb UDP service, a telegraf socket_listener, a graphite or a statsd server.
+// It is safe for concurrent use.
+type Writer struct {
+	network     string
+	address     string
+	dialTimeout time.Duration
+
+	m    sync.Mutex
+	conn net.Conn
+}
+
+// NewWriter creates a Writer, the connection is established on the first write.
+func NewWriter(network, address string, dialTimeout time.Duration) *Writer {
+	if dialTimeout <= 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	return &Writer{
+		network:     network,
+		address:     address,
+		dialTimeout: dialTimeout,
+	}
+}
+
+func (s *Writer) String() string {
+	return s.network + "://" + s.address
+}
+
+func (s *Writer) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout(s.network, s.address, s.dialTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// Write sends a batch of lines, for UDP the batch is split into
+// datagrams containing complete lines.
+// on failure the connection is closed and re-established on the next write.
+func (s *Writer) Write(b []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := s.connect()
+	if err != nil {
+		return err
+	}
+	if s.network == "udp" {
+		err = s.writeDatagrams(b)
+	} else {
+		_, err = s.conn.Write(b)
+	}
+	if err != nil {
+		s.closeConn()
+	}
+	return err
+}
+
+func (s *Writer) writeDatagrams(b []byte) error {
+	for len(b) > 0 {
+		n := len(b)
+		if n > udpMaxPayloadSize {
+			n = bytes.LastIndexByte(b[:udpMaxPayloadSize], '\n') + 1
+			if n <= 0 {
+				// single line larger than the max payload size
+				n = bytes.IndexByte(b, '\n') + 1
+				if n <= 0 {
+					n = len(b)
+				}
+			}
+		}
+		_, err := s.conn.Write(b[:n])
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// Close closes the current connection, if any.
+func (s *Writer) Close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.closeConn()
+}
+
+func (s *Writer) closeConn() {
+	if s.conn == nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = nil
+}
+
+// WriteRetry writes b, retrying every retryTimer until the write succeeds or ctx is done.
+// onError, if not nil, is called with each write error.
+func (s *Writer) WriteRetry(ctx context.Context, b []byte, retryTimer time.Duration, onError func(error)) error {
+	for {
+		err := s.Write(b)
+		if err == nil {
+			return nil
+		}
+		if onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryTimer):
+		}
+	}
+}
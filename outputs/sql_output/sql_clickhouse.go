@@ -0,0 +1,141 @@
+package sql_output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClickHouseEngine = "MergeTree() ORDER BY timestamp"
+	clickHouseTimeFormat    = "2006-01-02 15:04:05.999999999"
+)
+
+// clickHouseDialect writes rows to ClickHouse using its HTTP interface,
+// the rows are inserted in the JSONEachRow format.
+type clickHouseDialect struct {
+	client *http.Client
+	url    *url.URL
+	engine string
+}
+
+func newClickHouseDialect(dsn, engine string, timeout time.Duration) (*clickHouseDialect, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse clickhouse url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported clickhouse url scheme %q", u.Scheme)
+	}
+	if engine == "" {
+		engine = defaultClickHouseEngine
+	}
+	return &clickHouseDialect{
+		client: &http.Client{Timeout: timeout},
+		url:    u,
+		engine: engine,
+	}, nil
+}
+
+func (d *clickHouseDialect) quote(id string) string {
+	return "`" + strings.Replace(id, "`", "\\`", -1) + "`"
+}
+
+func (d *clickHouseDialect) typ(k kind) string {
+	switch k {
+	case kindTime:
+		return "DateTime64(9, 'UTC')"
+	case kindInt:
+		return "Nullable(Int64)"
+	case kindUint:
+		return "Nullable(UInt64)"
+	case kindFloat:
+		return "Nullable(Float64)"
+	case kindBool:
+		return "Nullable(UInt8)"
+	}
+	return "Nullable(String)"
+}
+
+func (d *clickHouseDialect) createTable(ctx context.Context, table string, cols []*column) error {
+	defs := make([]string, 0, len(cols))
+	for _, c := range cols {
+		defs = append(defs, d.quote(c.name)+" "+d.typ(c.kind))
+	}
+	return d.exec(ctx, "", fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = %s",
+		d.quote(table), strings.Join(defs, ", "), d.engine))
+}
+
+func (d *clickHouseDialect) addColumn(ctx context.Context, table string, col *column) error {
+	return d.exec(ctx, "", fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+		d.quote(table), d.quote(col.name), d.typ(col.kind)))
+}
+
+func (d *clickHouseDialect) insert(ctx context.Context, table string, cols []*column, rows []*row) error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, r := range rows {
+		obj := make(map[string]interface{}, len(cols))
+		for _, c := range cols {
+			v, ok := r.values[c.name]
+			if !ok {
+				continue
+			}
+			switch v := convert(v, c.kind).(type) {
+			case time.Time:
+				obj[c.name] = v.UTC().Format(clickHouseTimeFormat)
+			case bool:
+				if v {
+					obj[c.name] = 1
+				} else {
+					obj[c.name] = 0
+				}
+			default:
+				obj[c.name] = v
+			}
+		}
+		err := enc.Encode(obj)
+		if err != nil {
+			return err
+		}
+	}
+	return d.exec(ctx, fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", d.quote(table)), buf.String())
+}
+
+func (d *clickHouseDialect) ping(ctx context.Context) error {
+	return d.exec(ctx, "", "SELECT 1")
+}
+
+// exec sends a POST request with the query in the URL query parameters, if not empty, and body
+func (d *clickHouseDialect) exec(ctx context.Context, query, body string) error {
+	u := *d.url
+	if query != "" {
+		params := u.Query()
+		params.Set("query", query)
+		u.RawQuery = params.Encode()
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	rsp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, 1024))
+		return fmt.Errorf("clickhouse error, status=%s: %s", rsp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(ioutil.Discard, rsp.Body)
+	return nil
+}
+
+func (d *clickHouseDialect) close() error { return nil }
@@ -0,0 +1,235 @@
+package sql_output
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	dialectPostgres    = "postgres"
+	dialectTimescaleDB = "timescaledb"
+	dialectClickHouse  = "clickhouse"
+	// the sqlite3 dialect is used as a reference backend by the tests,
+	// its driver is not compiled in gnmic.
+	dialectSQLite = "sqlite3"
+
+	// max number of parameters in a sqlite statement
+	sqliteMaxParams = 999
+)
+
+// dialect creates tables and inserts rows in a database
+type dialect interface {
+	// createTable creates the table with columns cols if it does not exist
+	createTable(ctx context.Context, table string, cols []*column) error
+	// addColumn adds column col to the table if it does not exist
+	addColumn(ctx context.Context, table string, col *column) error
+	// insert inserts the rows in the table, the rows values missing a column are inserted as NULL
+	insert(ctx context.Context, table string, cols []*column, rows []*row) error
+	ping(ctx context.Context) error
+	close() error
+}
+
+// sqlDialect is a database/sql based dialect: postgres, timescaledb or sqlite3
+type sqlDialect struct {
+	name string
+	db   *sql.DB
+}
+
+func newSQLDialect(name, dsn string) (*sqlDialect, error) {
+	driver := name
+	if name == dialectTimescaleDB {
+		driver = dialectPostgres
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlDialect{name: name, db: db}, nil
+}
+
+func (d *sqlDialect) isPostgres() bool {
+	return d.name == dialectPostgres || d.name == dialectTimescaleDB
+}
+
+func (d *sqlDialect) quote(id string) string {
+	return `"` + strings.Replace(id, `"`, `""`, -1) + `"`
+}
+
+func (d *sqlDialect) typ(k kind) string {
+	if d.isPostgres() {
+		switch k {
+		case kindTime:
+			return "TIMESTAMPTZ"
+		case kindInt:
+			return "BIGINT"
+		case kindUint:
+			return "NUMERIC(20)"
+		case kindFloat:
+			return "DOUBLE PRECISION"
+		case kindBool:
+			return "BOOLEAN"
+		case kindJSON:
+			return "JSONB"
+		}
+		return "TEXT"
+	}
+	switch k {
+	case kindTime:
+		return "TIMESTAMP"
+	case kindInt, kindUint:
+		return "INTEGER"
+	case kindFloat:
+		return "REAL"
+	case kindBool:
+		return "BOOLEAN"
+	}
+	return "TEXT"
+}
+
+func (d *sqlDialect) createTable(ctx context.Context, table string, cols []*column) error {
+	defs := make([]string, 0, len(cols))
+	for _, c := range cols {
+		def := d.quote(c.name) + " " + d.typ(c.kind)
+		if c.name == timestampColumn {
+			def += " NOT NULL"
+		}
+		defs = append(defs, def)
+	}
+	_, err := d.db.ExecContext(ctx,
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", d.quote(table), strings.Join(defs, ", ")))
+	if err != nil {
+		return err
+	}
+	if d.name != dialectTimescaleDB {
+		return nil
+	}
+	_, err = d.db.ExecContext(ctx,
+		"SELECT create_hypertable($1, $2, if_not_exists => TRUE)", table, timestampColumn)
+	return err
+}
+
+func (d *sqlDialect) addColumn(ctx context.Context, table string, col *column) error {
+	if d.isPostgres() {
+		_, err := d.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+			d.quote(table), d.quote(col.name), d.typ(col.kind)))
+		return err
+	}
+	_, err := d.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s",
+		d.quote(table), d.quote(col.name), d.typ(col.kind)))
+	if err != nil && strings.Contains(err.Error(), "duplicate column name") {
+		return nil
+	}
+	return err
+}
+
+func (d *sqlDialect) insert(ctx context.Context, table string, cols []*column, rows []*row) error {
+	if d.isPostgres() {
+		return d.copyIn(ctx, table, cols, rows)
+	}
+	// multi rows inserts, limited by the max number of parameters per statement
+	rowsPerStmt := sqliteMaxParams / len(cols)
+	if rowsPerStmt == 0 {
+		return fmt.Errorf("too many columns: %d", len(cols))
+	}
+	names := make([]string, 0, len(cols))
+	for _, c := range cols {
+		names = append(names, d.quote(c.name))
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",") + ")"
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > rowsPerStmt {
+			n = rowsPerStmt
+		}
+		stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+			d.quote(table), strings.Join(names, ", "),
+			strings.TrimSuffix(strings.Repeat(placeholders+",", n), ","))
+		args := make([]interface{}, 0, n*len(cols))
+		for _, r := range rows[:n] {
+			args = append(args, d.values(cols, r)...)
+		}
+		_, err = tx.ExecContext(ctx, stmt, args...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		rows = rows[n:]
+	}
+	return tx.Commit()
+}
+
+// copyIn inserts the rows using the postgres COPY protocol
+func (d *sqlDialect) copyIn(ctx context.Context, table string, cols []*column, rows []*row) error {
+	names := make([]string, 0, len(cols))
+	for _, c := range cols {
+		names = append(names, c.name)
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, names...))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, r := range rows {
+		_, err = stmt.ExecContext(ctx, d.values(cols, r)...)
+		if err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		stmt.Close()
+		tx.Rollback()
+		return err
+	}
+	err = stmt.Close()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// values returns the row values in the columns order
+func (d *sqlDialect) values(cols []*column, r *row) []interface{} {
+	vals := make([]interface{}, 0, len(cols))
+	for _, c := range cols {
+		v := convert(r.values[c.name], c.kind)
+		// database/sql does not support uint64 values with the high bit set
+		if u, ok := v.(uint64); ok {
+			switch {
+			case d.isPostgres():
+				v = strconv.FormatUint(u, 10)
+			case u > math.MaxInt64:
+				v = float64(u)
+			default:
+				v = int64(u)
+			}
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+func (d *sqlDialect) ping(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func (d *sqlDialect) close() error {
+	return d.db.Close()
+}
@@ -0,0 +1,394 @@
+package sql_output
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultBatchSize  = 1000
+	defaultFlushTimer = 10 * time.Second
+	defaultTimeout    = 30 * time.Second
+
+	tagsAsColumns = "columns"
+	tagsAsJSON    = "json"
+
+	loggingPrefix = "[sql_output] "
+)
+
+func init() {
+	outputs.Register("sql", func() outputs.Output {
+		return &SQLOutput{
+			Cfg:       &Config{},
+			eventChan: make(chan *formatters.EventMsg),
+			wg:        new(sync.WaitGroup),
+			logger:    log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+// SQLOutput writes events as rows in relational or columnar databases:
+// the event name is the table, the tags and values are the columns.
+type SQLOutput struct {
+	Cfg       *Config
+	db        dialect
+	logger    *log.Logger
+	cancelFn  context.CancelFunc
+	done      <-chan struct{}
+	eventChan chan *formatters.EventMsg
+	wg        *sync.WaitGroup
+	healthy   int32
+	evps      []formatters.EventProcessor
+
+	// columns known to exist per table, used when auto-create is enabled
+	tables map[string]map[string]struct{}
+	// column kinds per table, set by the first written value of each column
+	kinds map[string]map[string]kind
+
+	targetTpl *template.Template
+}
+
+// Config //
+type Config struct {
+	// one of postgres, timescaledb or clickhouse
+	Dialect string `mapstructure:"dialect,omitempty"`
+	// postgres connection string or clickhouse HTTP URL
+	DSN string `mapstructure:"dsn,omitempty"`
+	// string prepended to the event name to get the table name
+	TablePrefix string `mapstructure:"table-prefix,omitempty"`
+	// store the tags as text columns or as a single JSON column
+	TagsAs string `mapstructure:"tags-as,omitempty"`
+	// create the tables and their missing columns
+	AutoCreate bool `mapstructure:"auto-create,omitempty"`
+	// clickhouse table engine used when creating tables
+	ClickHouseEngine   string        `mapstructure:"clickhouse-engine,omitempty"`
+	BatchSize          int           `mapstructure:"batch-size,omitempty"`
+	FlushTimer         time.Duration `mapstructure:"flush-timer,omitempty"`
+	Timeout            time.Duration `mapstructure:"timeout,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
+}
+
+func (s *SQLOutput) String() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (s *SQLOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && s.logger != nil {
+		s.logger.SetOutput(logger.Writer())
+		s.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (s *SQLOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]interface{}) {
+	for _, epName := range s.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
+				if err != nil {
+					s.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				s.evps = append(s.evps, ep)
+				s.logger.Printf("added event processor '%s' of type=%s to sql output", epName, epType)
+				continue
+			}
+			s.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		s.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (s *SQLOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, s.Cfg)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	err = s.setDefaults()
+	if err != nil {
+		return err
+	}
+	if s.Cfg.TargetTemplate == "" {
+		s.targetTpl = outputs.DefaultTargetTemplate
+	} else if s.Cfg.AddTarget != "" {
+		s.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(s.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	switch s.Cfg.Dialect {
+	case dialectPostgres, dialectTimescaleDB, dialectSQLite:
+		s.db, err = newSQLDialect(s.Cfg.Dialect, s.Cfg.DSN)
+	case dialectClickHouse:
+		s.db, err = newClickHouseDialect(s.Cfg.DSN, s.Cfg.ClickHouseEngine, s.Cfg.Timeout)
+	default:
+		return fmt.Errorf("unsupported sql dialect %q", s.Cfg.Dialect)
+	}
+	if err != nil {
+		return err
+	}
+	s.tables = make(map[string]map[string]struct{})
+	s.kinds = make(map[string]map[string]kind)
+	pctx, cancel := context.WithTimeout(ctx, s.Cfg.Timeout)
+	err = s.db.ping(pctx)
+	cancel()
+	s.setHealthy(err == nil)
+	if err != nil {
+		s.logger.Printf("failed to reach the database: %v", err)
+	}
+	ctx, s.cancelFn = context.WithCancel(ctx)
+	s.done = ctx.Done()
+	formatters.ConnectEmitters(ctx, s.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			s.WriteEvent(ctx, ev)
+		}
+	})
+	s.wg.Add(1)
+	go s.worker(ctx)
+	s.logger.Printf("initialized sql output: %s", s.String())
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	return nil
+}
+
+func (s *SQLOutput) setDefaults() error {
+	if s.Cfg.Dialect == "" {
+		s.Cfg.Dialect = dialectPostgres
+	}
+	if s.Cfg.DSN == "" {
+		return fmt.Errorf("missing dsn")
+	}
+	switch s.Cfg.TagsAs {
+	case "":
+		s.Cfg.TagsAs = tagsAsColumns
+	case tagsAsColumns, tagsAsJSON:
+	default:
+		return fmt.Errorf("unknown tags-as value %q, expected %q or %q", s.Cfg.TagsAs, tagsAsColumns, tagsAsJSON)
+	}
+	if s.Cfg.BatchSize <= 0 {
+		s.Cfg.BatchSize = defaultBatchSize
+	}
+	if s.Cfg.FlushTimer <= 0 {
+		s.Cfg.FlushTimer = defaultFlushTimer
+	}
+	if s.Cfg.Timeout <= 0 {
+		s.Cfg.Timeout = defaultTimeout
+	}
+	return nil
+}
+
+func (s *SQLOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, s.Cfg.AddTarget, s.targetTpl)
+	if err != nil {
+		s.logger.Printf("failed to add target to the response: %v", err)
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		name := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			name = subName
+		}
+		events, err := formatters.ResponseToEventMsgs(name, rsp, meta, s.evps...)
+		if err != nil {
+			s.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			s.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (s *SQLOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	case s.eventChan <- ev:
+	}
+}
+
+func (s *SQLOutput) Close() error {
+	defer formatters.CloseEventProcessors(s.evps)
+	if s.cancelFn != nil {
+		s.cancelFn()
+	}
+	s.wg.Wait()
+	if s.db != nil {
+		s.db.close()
+	}
+	s.logger.Printf("closed.")
+	return nil
+}
+
+func (s *SQLOutput) RegisterMetrics(reg *prometheus.Registry) {}
+
+func (s *SQLOutput) SetName(name string)        {}
+func (s *SQLOutput) SetClusterName(name string) {}
+
+// Healthy implements outputs.HealthChecker
+func (s *SQLOutput) Healthy() bool {
+	return atomic.LoadInt32(&s.healthy) == 1
+}
+
+func (s *SQLOutput) setHealthy(b bool) {
+	if b {
+		atomic.StoreInt32(&s.healthy, 1)
+		return
+	}
+	atomic.StoreInt32(&s.healthy, 0)
+}
+
+// worker accumulates the rows per table,
+// they are written when the batch reaches batch-size rows or when the flush-timer expires.
+func (s *SQLOutput) worker(ctx context.Context) {
+	defer s.wg.Done()
+	batch := make(map[string]*tableBatch)
+	numRows := 0
+	ticker := time.NewTicker(s.Cfg.FlushTimer)
+	defer ticker.Stop()
+	flush := func(ctx context.Context) {
+		if numRows == 0 {
+			return
+		}
+		s.flush(ctx, batch)
+		batch = make(map[string]*tableBatch)
+		numRows = 0
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			fctx, cancel := context.WithTimeout(context.Background(), s.Cfg.Timeout)
+			flush(fctx)
+			cancel()
+			return
+		case ev := <-s.eventChan:
+			r, err := s.eventToRow(ev)
+			if err != nil {
+				s.logger.Printf("failed to convert event to row: %v", err)
+				continue
+			}
+			if r == nil {
+				continue
+			}
+			tb, ok := batch[r.table]
+			if !ok {
+				tb = &tableBatch{columns: make(map[string]kind)}
+				batch[r.table] = tb
+			}
+			tb.add(r)
+			numRows++
+			if numRows >= s.Cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// flush writes the batched rows, a transaction per table
+func (s *SQLOutput) flush(ctx context.Context, batch map[string]*tableBatch) {
+	failed := false
+	for table, tb := range batch {
+		cols := s.columns(table, tb)
+		wctx, cancel := context.WithTimeout(ctx, s.Cfg.Timeout)
+		err := s.ensureTable(wctx, table, cols)
+		if err == nil {
+			err = s.db.insert(wctx, table, cols, tb.rows)
+		}
+		cancel()
+		if err != nil {
+			failed = true
+			s.logger.Printf("failed to write %d rows to table %q: %v", len(tb.rows), table, err)
+			continue
+		}
+		if s.Cfg.Debug {
+			s.logger.Printf("wrote %d rows to table %q", len(tb.rows), table)
+		}
+	}
+	s.setHealthy(!failed)
+}
+
+// columns returns the batch columns with their kind set
+// by the first value written in each column
+func (s *SQLOutput) columns(table string, tb *tableBatch) []*column {
+	kinds, ok := s.kinds[table]
+	if !ok {
+		kinds = make(map[string]kind)
+		s.kinds[table] = kinds
+	}
+	cols := tb.sortedColumns()
+	for _, c := range cols {
+		if k, ok := kinds[c.name]; ok {
+			c.kind = k
+			continue
+		}
+		kinds[c.name] = c.kind
+	}
+	return cols
+}
+
+// ensureTable creates the table and its missing columns if auto-create is enabled
+func (s *SQLOutput) ensureTable(ctx context.Context, table string, cols []*column) error {
+	if !s.Cfg.AutoCreate {
+		return nil
+	}
+	known, ok := s.tables[table]
+	if !ok {
+		err := s.db.createTable(ctx, table, cols)
+		if err != nil {
+			return fmt.Errorf("failed to create table: %v", err)
+		}
+		known = make(map[string]struct{})
+		s.tables[table] = known
+	}
+	for _, c := range cols {
+		if _, ok := known[c.name]; ok {
+			continue
+		}
+		// the table might have been created before, without this column
+		err := s.db.addColumn(ctx, table, c)
+		if err != nil {
+			return fmt.Errorf("failed to add column %q: %v", c.name, err)
+		}
+		known[c.name] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package sql_output
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testEvents = []*formatters.EventMsg{
+	{
+		Name:      "sub1",
+		Timestamp: 1606824673847153523,
+		Tags:      map[string]string{"source": "router1", "interface_name": "ethernet-1/1"},
+		Values: map[string]interface{}{
+			"/interface/statistics/in-octets": uint64(42),
+			"/interface/oper-state":           "up",
+		},
+	},
+	{
+		Name:      "sub1",
+		Timestamp: 1606824673847153524,
+		Tags:      map[string]string{"source": "router1", "interface_name": "ethernet-1/2"},
+		Values: map[string]interface{}{
+			"/interface/statistics/in-octets": uint64(43),
+			"/interface/load":                 0.5,
+		},
+	},
+	{
+		Name:      "sub2",
+		Timestamp: 1606824673847153525,
+		Tags:      map[string]string{"source": "router2"},
+		Values: map[string]interface{}{
+			"/system/cpu/total": int64(12),
+		},
+	},
+	{
+		// events without values are skipped
+		Name:    "sub2",
+		Tags:    map[string]string{"source": "router2"},
+		Deletes: []string{"/system"},
+	},
+}
+
+func initSQLiteOutput(t *testing.T, dir string, cfg map[string]interface{}) *SQLOutput {
+	cfg["dialect"] = dialectSQLite
+	cfg["dsn"] = filepath.Join(dir, "gnmic.db")
+	s := outputs.Outputs["sql"]().(*SQLOutput)
+	err := s.Init(context.Background(), "sql1", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func queryRows(t *testing.T, db *sql.DB, query string) [][]interface{} {
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result [][]interface{}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range vals {
+			if b, ok := v.([]byte); ok {
+				vals[i] = string(b)
+			}
+		}
+		result = append(result, vals)
+	}
+	return result
+}
+
+func TestSQLiteOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := initSQLiteOutput(t, dir, map[string]interface{}{
+		"auto-create":  true,
+		"table-prefix": "gnmic_",
+		"batch-size":   2,
+	})
+	for _, ev := range testEvents {
+		s.WriteEvent(context.Background(), ev)
+	}
+	s.Close()
+
+	db, err := sql.Open(dialectSQLite, filepath.Join(dir, "gnmic.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	got := queryRows(t, db, `SELECT source, interface_name, interface_statistics_in_octets, interface_oper_state, interface_load FROM gnmic_sub1 ORDER BY "timestamp"`)
+	want := [][]interface{}{
+		{"router1", "ethernet-1/1", int64(42), "up", nil},
+		{"router1", "ethernet-1/2", int64(43), nil, 0.5},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("gnmic_sub1 rows mismatch: %s", cmp.Diff(want, got))
+	}
+	got = queryRows(t, db, `SELECT source, system_cpu_total FROM gnmic_sub2`)
+	want = [][]interface{}{{"router2", int64(12)}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("gnmic_sub2 rows mismatch: %s", cmp.Diff(want, got))
+	}
+
+	// a new output adds the missing columns to the existing tables
+	s = initSQLiteOutput(t, dir, map[string]interface{}{
+		"auto-create":  true,
+		"table-prefix": "gnmic_",
+	})
+	s.WriteEvent(context.Background(), &formatters.EventMsg{
+		Name:      "sub2",
+		Timestamp: 1606824673847153526,
+		Tags:      map[string]string{"source": "router2"},
+		Values: map[string]interface{}{
+			"/system/cpu/total":   int64(13),
+			"/system/memory/used": int64(1024),
+		},
+	})
+	s.Close()
+	got = queryRows(t, db, `SELECT source, system_cpu_total, system_memory_used FROM gnmic_sub2 ORDER BY "timestamp"`)
+	want = [][]interface{}{{"router2", int64(12), nil}, {"router2", int64(13), int64(1024)}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("gnmic_sub2 rows mismatch: %s", cmp.Diff(want, got))
+	}
+}
+
+func TestSQLiteOutputTagsAsJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := initSQLiteOutput(t, dir, map[string]interface{}{
+		"auto-create": true,
+		"tags-as":     "json",
+	})
+	s.WriteEvent(context.Background(), testEvents[2])
+	s.Close()
+
+	db, err := sql.Open(dialectSQLite, filepath.Join(dir, "gnmic.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	got := queryRows(t, db, `SELECT tags, system_cpu_total FROM sub2`)
+	want := [][]interface{}{{`{"source":"router2"}`, int64(12)}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("sub2 rows mismatch: %s", cmp.Diff(want, got))
+	}
+}
+
+// testClickHouse records the statements received by a ClickHouse HTTP interface stand-in
+type testClickHouse struct {
+	m          sync.Mutex
+	statements []string
+}
+
+func (c *testClickHouse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	b, _ := ioutil.ReadAll(r.Body)
+	stmt := string(b)
+	if q := r.URL.Query().Get("query"); q != "" {
+		stmt = q + "\n" + stmt
+	}
+	if user, _, ok := r.BasicAuth(); !ok || user != "gnmic" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	c.statements = append(c.statements, strings.TrimSpace(stmt))
+}
+
+func TestClickHouseOutput(t *testing.T) {
+	ch := &testClickHouse{}
+	srv := httptest.NewServer(ch)
+	defer srv.Close()
+
+	s := outputs.Outputs["sql"]().(*SQLOutput)
+	err := s.Init(context.Background(), "sql1", map[string]interface{}{
+		"dialect":     dialectClickHouse,
+		"dsn":         strings.Replace(srv.URL, "http://", "http://gnmic:secret@", 1) + "/?database=telemetry",
+		"auto-create": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WriteEvent(context.Background(), testEvents[0])
+	s.Close()
+
+	want := []string{
+		"SELECT 1",
+		"CREATE TABLE IF NOT EXISTS `sub1` (`timestamp` DateTime64(9, 'UTC'), `interface_name` Nullable(String), `interface_oper_state` Nullable(String), `interface_statistics_in_octets` Nullable(UInt64), `source` Nullable(String)) ENGINE = MergeTree() ORDER BY timestamp",
+		"ALTER TABLE `sub1` ADD COLUMN IF NOT EXISTS `timestamp` DateTime64(9, 'UTC')",
+		"ALTER TABLE `sub1` ADD COLUMN IF NOT EXISTS `interface_name` Nullable(String)",
+		"ALTER TABLE `sub1` ADD COLUMN IF NOT EXISTS `interface_oper_state` Nullable(String)",
+		"ALTER TABLE `sub1` ADD COLUMN IF NOT EXISTS `interface_statistics_in_octets` Nullable(UInt64)",
+		"ALTER TABLE `sub1` ADD COLUMN IF NOT EXISTS `source` Nullable(String)",
+		"INSERT INTO `sub1` FORMAT JSONEachRow\n" +
+			`{"interface_name":"ethernet-1/1","interface_oper_state":"up","interface_statistics_in_octets":42,"source":"router1","timestamp":"2020-12-01 12:11:13.847153523"}`,
+	}
+	if !cmp.Equal(ch.statements, want) {
+		t.Errorf("clickhouse statements mismatch: %s", cmp.Diff(want, ch.statements))
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	received := identifier("/network-instances/network-instance/protocols/protocol/bgp/neighbors/neighbor/afi-safis/afi-safi/state/prefixes/received")
+	sent := identifier("/network-instances/network-instance/protocols/protocol/bgp/neighbors/neighbor/afi-safis/afi-safi/state/prefixes/sent")
+	if len(received) != maxIdentifierLength || len(sent) != maxIdentifierLength {
+		t.Errorf("unexpected identifiers length: %d, %d", len(received), len(sent))
+	}
+	if received == sent {
+		t.Errorf("long names mapped to the same identifier %q", received)
+	}
+	if id := identifier("/interface/statistics/in-octets"); id != "interface_statistics_in_octets" {
+		t.Errorf("unexpected identifier %q", id)
+	}
+}
+
+func TestEventToRowCollision(t *testing.T) {
+	s := &SQLOutput{Cfg: &Config{}}
+	_, err := s.eventToRow(&formatters.EventMsg{
+		Name:   "sub1",
+		Values: map[string]interface{}{"/a/in-octets": 1, "/a/in_octets": 2},
+	})
+	if err == nil {
+		t.Errorf("expected a column collision error")
+	}
+	r, err := s.eventToRow(&formatters.EventMsg{
+		Name:   "sub1",
+		Tags:   map[string]string{"in-octets": "a"},
+		Values: map[string]interface{}{"in-octets": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.values["in_octets"] != "a" || r.values["value_in_octets"] != int64(1) {
+		t.Errorf("unexpected row values: %v", r.values)
+	}
+}
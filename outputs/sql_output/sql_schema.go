@@ -0,0 +1,280 @@
+package sql_output
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+const (
+	timestampColumn = "timestamp"
+	tagsColumn      = "tags"
+	// the identifiers are truncated to the PostgreSQL limit, the lowest of the supported databases
+	maxIdentifierLength = 63
+	// length of the hash suffix of a truncated identifier: '_' and 8 hex digits
+	identifierHashLength = 9
+)
+
+// kind is the type of a column, mapped to a SQL type by each dialect
+type kind int
+
+const (
+	kindTime kind = iota
+	kindString
+	kindInt
+	kindUint
+	kindFloat
+	kindBool
+	kindJSON
+)
+
+type column struct {
+	name string
+	kind kind
+}
+
+// row is a table row built from an event
+type row struct {
+	table  string
+	values map[string]interface{}
+	// column kinds of the row values
+	kinds map[string]kind
+}
+
+// tableBatch accumulates the rows of a table
+type tableBatch struct {
+	columns map[string]kind
+	rows    []*row
+}
+
+func (tb *tableBatch) add(r *row) {
+	for n, k := range r.kinds {
+		if _, ok := tb.columns[n]; !ok {
+			tb.columns[n] = k
+		}
+	}
+	tb.rows = append(tb.rows, r)
+}
+
+// sortedColumns returns the batch columns, the timestamp column first,
+// followed by the other columns sorted by name
+func (tb *tableBatch) sortedColumns() []*column {
+	cols := make([]*column, 0, len(tb.columns))
+	for n, k := range tb.columns {
+		if n == timestampColumn {
+			continue
+		}
+		cols = append(cols, &column{name: n, kind: k})
+	}
+	sort.Slice(cols, func(i, j int) bool {
+		return cols[i].name < cols[j].name
+	})
+	return append([]*column{{name: timestampColumn, kind: kindTime}}, cols...)
+}
+
+// eventToRow maps an event to a row:
+// the event name is the table name, the tags and values are the columns.
+func (s *SQLOutput) eventToRow(ev *formatters.EventMsg) (*row, error) {
+	if len(ev.Values) == 0 {
+		return nil, nil
+	}
+	ts := time.Unix(0, ev.Timestamp).UTC()
+	if ev.Timestamp == 0 || s.Cfg.OverrideTimestamps {
+		ts = time.Now().UTC()
+	}
+	r := &row{
+		table:  identifier(s.Cfg.TablePrefix + ev.Name),
+		values: map[string]interface{}{timestampColumn: ts},
+		kinds:  map[string]kind{timestampColumn: kindTime},
+	}
+	// the tag or value name of each column,
+	// two distinct names mapped to the same column would overwrite each other.
+	names := map[string]string{timestampColumn: timestampColumn}
+	addName := func(n, name string) error {
+		if other, ok := names[n]; ok {
+			return fmt.Errorf("%q and %q map to the same column %q", other, name, n)
+		}
+		names[n] = name
+		return nil
+	}
+	tagColumns := make(map[string]struct{})
+	if s.Cfg.TagsAs == tagsAsJSON {
+		b, err := json.Marshal(ev.Tags)
+		if err != nil {
+			return nil, err
+		}
+		names[tagsColumn] = tagsColumn
+		r.values[tagsColumn] = string(b)
+		r.kinds[tagsColumn] = kindJSON
+	} else {
+		for k, v := range ev.Tags {
+			n := identifier(k)
+			err := addName(n, k)
+			if err != nil {
+				return nil, fmt.Errorf("tag %v", err)
+			}
+			tagColumns[n] = struct{}{}
+			r.values[n] = v
+			r.kinds[n] = kindString
+		}
+	}
+	for k, v := range ev.Values {
+		if v == nil {
+			continue
+		}
+		n := identifier(k)
+		if _, ok := tagColumns[n]; ok {
+			// a value with the same name as a tag
+			n = identifier("value_" + n)
+		}
+		err := addName(n, k)
+		if err != nil {
+			return nil, fmt.Errorf("value %v", err)
+		}
+		v, kd, err := columnValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("value %q: %v", k, err)
+		}
+		r.values[n] = v
+		r.kinds[n] = kd
+	}
+	return r, nil
+}
+
+// columnValue returns the value to be inserted and its column kind
+func columnValue(v interface{}) (interface{}, kind, error) {
+	switch v := v.(type) {
+	case string:
+		return v, kindString, nil
+	case bool:
+		return v, kindBool, nil
+	case int:
+		return int64(v), kindInt, nil
+	case int8:
+		return int64(v), kindInt, nil
+	case int16:
+		return int64(v), kindInt, nil
+	case int32:
+		return int64(v), kindInt, nil
+	case int64:
+		return v, kindInt, nil
+	case uint:
+		return uint64(v), kindUint, nil
+	case uint8:
+		return uint64(v), kindUint, nil
+	case uint16:
+		return uint64(v), kindUint, nil
+	case uint32:
+		return uint64(v), kindUint, nil
+	case uint64:
+		return v, kindUint, nil
+	case float32:
+		return float64(v), kindFloat, nil
+	case float64:
+		return v, kindFloat, nil
+	case *gnmi.Decimal64:
+		return float64(v.Digits) / math.Pow10(int(v.Precision)), kindFloat, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, 0, err
+	}
+	return string(b), kindJSON, nil
+}
+
+// convert converts value v to the kind of the column it is inserted in,
+// the first value of a column sets its kind.
+func convert(v interface{}, k kind) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch k {
+	case kindFloat:
+		switch v := v.(type) {
+		case int64:
+			return float64(v)
+		case uint64:
+			return float64(v)
+		case bool:
+			if v {
+				return float64(1)
+			}
+			return float64(0)
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+	case kindInt:
+		switch v := v.(type) {
+		case uint64:
+			if v <= math.MaxInt64 {
+				return int64(v)
+			}
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < math.MaxInt64 {
+				return int64(v)
+			}
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			}
+		}
+	case kindUint:
+		switch v := v.(type) {
+		case int64:
+			if v >= 0 {
+				return uint64(v)
+			}
+		case float64:
+			if v >= 0 && v == math.Trunc(v) && v < math.MaxUint64 {
+				return uint64(v)
+			}
+		case string:
+			if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+				return u
+			}
+		}
+	case kindString, kindJSON:
+		switch v := v.(type) {
+		case string:
+			return v
+		case time.Time:
+			return v
+		}
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+// identifier converts a name into a table or column name:
+// the leading '/' is removed and the characters other than letters, digits and '_' are replaced with '_'.
+// An identifier longer than maxIdentifierLength is truncated and suffixed with a hash of the name,
+// so that two long names sharing the same beginning are mapped to distinct identifiers.
+func identifier(s string) string {
+	s = strings.TrimLeft(s, "/")
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+	if id != "" && id[0] >= '0' && id[0] <= '9' {
+		id = "_" + id
+	}
+	if len(id) > maxIdentifierLength {
+		h := fnv.New32a()
+		h.Write([]byte(s))
+		id = fmt.Sprintf("%s_%08x", id[:maxIdentifierLength-identifierHashLength], h.Sum32())
+	}
+	return id
+}
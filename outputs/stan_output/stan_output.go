@@ -48,6 +48,8 @@ func init() {
 type protoMsg struct {
 	m    proto.Message
 	meta outputs.Meta
+	// events emitted by the event processors, set instead of m
+	evs []*formatters.EventMsg
 }
 
 // StanOutput //
@@ -75,6 +77,7 @@ type Config struct {
 	PingInterval       int           `mapstructure:"ping-interval,omitempty"`
 	PingRetry          int           `mapstructure:"ping-retry,omitempty"`
 	Format             string        `mapstructure:"format,omitempty"`
+	MsgTemplate        string        `mapstructure:"msg-template,omitempty"`
 	AddTarget          string        `mapstructure:"add-target,omitempty"`
 	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
 	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
@@ -111,7 +114,7 @@ func (s *StanOutput) SetEventProcessors(ps map[string]map[string]interface{}, lo
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					s.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
 					continue
@@ -145,10 +148,17 @@ func (s *StanOutput) Init(ctx context.Context, name string, cfg map[string]inter
 	}
 	s.msgChan = make(chan *protoMsg)
 
+	formatters.WarnDroppedTags(s.logger.Printf, s.Cfg.Format, s.evps)
 	s.mo = &formatters.MarshalOptions{
 		Format:     s.Cfg.Format,
 		OverrideTS: s.Cfg.OverrideTimestamps,
 	}
+	if s.Cfg.Format == "template" {
+		s.mo.Template, err = formatters.ParseMsgTemplate(s.Cfg.MsgTemplate)
+		if err != nil {
+			return err
+		}
+	}
 
 	if s.Cfg.TargetTemplate == "" {
 		s.targetTpl = outputs.DefaultTargetTemplate
@@ -161,6 +171,9 @@ func (s *StanOutput) Init(ctx context.Context, name string, cfg map[string]inter
 		}
 	}
 	ctx, s.cancelFn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(ctx, s.evps, func(es []*formatters.EventMsg) {
+		s.send(ctx, &protoMsg{meta: formatters.EventMeta(es[0]), evs: es})
+	})
 	s.wg.Add(s.Cfg.NumWorkers)
 	for i := 0; i < s.Cfg.NumWorkers; i++ {
 		cfg := *s.Cfg
@@ -180,7 +193,7 @@ func (s *StanOutput) setDefaults() error {
 	if s.Cfg.Format == "" {
 		s.Cfg.Format = defaultFormat
 	}
-	if !(s.Cfg.Format == "event" || s.Cfg.Format == "protojson" || s.Cfg.Format == "proto" || s.Cfg.Format == "json") {
+	if !(s.Cfg.Format == "event" || s.Cfg.Format == "protojson" || s.Cfg.Format == "proto" || s.Cfg.Format == "json" || s.Cfg.Format == "template") {
 		return fmt.Errorf("unsupported output format: %q for output type STAN", s.Cfg.Format)
 	}
 	if s.Cfg.Address == "" {
@@ -218,14 +231,24 @@ func (s *StanOutput) Write(ctx context.Context, rsp protoreflect.ProtoMessage, m
 	if rsp == nil || s.mo == nil {
 		return
 	}
+	s.send(ctx, &protoMsg{m: rsp, meta: meta})
+}
 
+func (s *StanOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if s.mo == nil {
+		return
+	}
+	s.send(ctx, &protoMsg{meta: formatters.EventMeta(ev), evs: []*formatters.EventMsg{ev}})
+}
+
+func (s *StanOutput) send(ctx context.Context, m *protoMsg) {
 	wctx, cancel := context.WithTimeout(ctx, s.Cfg.WriteTimeout)
 	defer cancel()
 
 	select {
 	case <-ctx.Done():
 		return
-	case s.msgChan <- &protoMsg{m: rsp, meta: meta}:
+	case s.msgChan <- m:
 	case <-wctx.Done():
 		if s.Cfg.Debug {
 			s.logger.Printf("writing expired after %s, STAN output might not be initialized", s.Cfg.WriteTimeout)
@@ -237,8 +260,6 @@ func (s *StanOutput) Write(ctx context.Context, rsp protoreflect.ProtoMessage, m
 	}
 }
 
-func (s *StanOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
-
 // Metrics //
 func (s *StanOutput) RegisterMetrics(reg *prometheus.Registry) {
 	if !s.Cfg.EnableMetrics {
@@ -251,6 +272,7 @@ func (s *StanOutput) RegisterMetrics(reg *prometheus.Registry) {
 
 // Close //
 func (s *StanOutput) Close() error {
+	defer formatters.CloseEventProcessors(s.evps)
 	s.cancelFn()
 	s.wg.Wait()
 	return nil
@@ -304,18 +326,13 @@ CRCONN:
 	s.logger.Printf("%s initialized stan producer: %s", workerLogPrefix, s.String())
 	defer stanConn.Close()
 	defer stanConn.NatsConn().Close()
-	var err error
 	for {
 		select {
 		case <-ctx.Done():
 			s.logger.Printf("%s shutting down", workerLogPrefix)
 			return
 		case m := <-s.msgChan:
-			err = outputs.AddSubscriptionTarget(m.m, m.meta, s.Cfg.AddTarget, s.targetTpl)
-			if err != nil {
-				s.logger.Printf("failed to add target to the response: %v", err)
-			}
-			b, err := s.mo.Marshal(m.m, m.meta, s.evps...)
+			b, err := s.marshal(m)
 			if err != nil {
 				if s.Cfg.Debug {
 					s.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
@@ -325,6 +342,9 @@ CRCONN:
 				}
 				continue
 			}
+			if len(b) == 0 {
+				continue
+			}
 			subject := s.subjectName(c, m.meta)
 			start := time.Now()
 			err = stanConn.Publish(subject, b)
@@ -349,6 +369,18 @@ CRCONN:
 	}
 }
 
+// marshal adds the target to the message and marshals it, or marshals the emitted events
+func (s *StanOutput) marshal(m *protoMsg) ([]byte, error) {
+	if m.evs != nil {
+		return s.mo.MarshalEvents(m.evs, m.meta)
+	}
+	err := outputs.AddSubscriptionTarget(m.m, m.meta, s.Cfg.AddTarget, s.targetTpl)
+	if err != nil {
+		s.logger.Printf("failed to add target to the response: %v", err)
+	}
+	return s.mo.Marshal(m.m, m.meta, s.evps...)
+}
+
 func (s *StanOutput) subjectName(c *Config, meta outputs.Meta) string {
 	if c.SubjectPrefix != "" {
 		ssb := strings.Builder{}
@@ -0,0 +1,312 @@
+package statsd_output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/outputs/socket"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultAddress        = "localhost:8125"
+	defaultNetwork        = "udp"
+	defaultMetricTemplate = `{{ .Name }}{{ range .Tags }}.{{ . }}{{ end }}.{{ .Path }}`
+	// with DogStatsD tags, the event tags are not part of the metric name
+	defaultDogStatsDMetricTemplate = `{{ .Name }}.{{ .Path }}`
+
+	loggingPrefix = "[statsd_output] "
+)
+
+func init() {
+	outputs.Register("statsd", func() outputs.Output {
+		return &StatsDOutput{
+			Cfg:    &Config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+// StatsDOutput writes event values as StatsD gauges:
+// <metric>:<value>|g
+type StatsDOutput struct {
+	Cfg      *Config
+	logger   *log.Logger
+	cancelFn context.CancelFunc
+	done     <-chan struct{}
+	wg       *sync.WaitGroup
+	evps     []formatters.EventProcessor
+	bw       *socket.BatchWriter
+
+	metricTpl *template.Template
+	targetTpl *template.Template
+}
+
+// Config //
+type Config struct {
+	// StatsD server address, host:port
+	Address string `mapstructure:"address,omitempty"`
+	// tcp or udp
+	Network string `mapstructure:"network,omitempty"`
+	// string prepended to the metric names
+	Prefix string `mapstructure:"prefix,omitempty"`
+	// Go template executed with each value to produce its metric name
+	MetricTemplate string `mapstructure:"metric-template,omitempty"`
+	// if true, the event tags are appended to the gauges in the DogStatsD format: |#tag1:value1,tag2:value2
+	DogStatsDTags   bool          `mapstructure:"dogstatsd-tags,omitempty"`
+	BatchSize       int           `mapstructure:"batch-size,omitempty"`
+	BufferSize      int           `mapstructure:"buffer-size,omitempty"`
+	FlushTimer      time.Duration `mapstructure:"flush-timer,omitempty"`
+	RetryTimer      time.Duration `mapstructure:"retry-timer,omitempty"`
+	Debug           bool          `mapstructure:"debug,omitempty"`
+	AddTarget       string        `mapstructure:"add-target,omitempty"`
+	TargetTemplate  string        `mapstructure:"target-template,omitempty"`
+	EventProcessors []string      `mapstructure:"event-processors,omitempty"`
+}
+
+func (s *StatsDOutput) String() string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (s *StatsDOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && s.logger != nil {
+		s.logger.SetOutput(logger.Writer())
+		s.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (s *StatsDOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]interface{}) {
+	for _, epName := range s.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
+				if err != nil {
+					s.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				s.evps = append(s.evps, ep)
+				s.logger.Printf("added event processor '%s' of type=%s to statsd output", epName, epType)
+				continue
+			}
+			s.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		s.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (s *StatsDOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, s.Cfg)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.Cfg.Address == "" {
+		s.Cfg.Address = defaultAddress
+	}
+	if s.Cfg.Network == "" {
+		s.Cfg.Network = defaultNetwork
+	}
+	if s.Cfg.Network != "tcp" && s.Cfg.Network != "udp" {
+		return fmt.Errorf("unsupported network %q", s.Cfg.Network)
+	}
+	if s.Cfg.MetricTemplate == "" {
+		s.Cfg.MetricTemplate = defaultMetricTemplate
+		if s.Cfg.DogStatsDTags {
+			s.Cfg.MetricTemplate = defaultDogStatsDMetricTemplate
+		}
+	}
+	if s.Cfg.TargetTemplate == "" {
+		s.targetTpl = outputs.DefaultTargetTemplate
+	} else if s.Cfg.AddTarget != "" {
+		s.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(s.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	s.metricTpl, err = template.New("metric-template").
+		Funcs(outputs.TemplateFuncs).
+		Option("missingkey=zero").
+		Parse(s.Cfg.MetricTemplate)
+	if err != nil {
+		return err
+	}
+	s.bw = socket.NewBatchWriter(
+		socket.NewWriter(s.Cfg.Network, s.Cfg.Address, socket.DefaultDialTimeout),
+		socket.BatchConfig{
+			Name:       name,
+			BufferSize: s.Cfg.BufferSize,
+			BatchSize:  s.Cfg.BatchSize,
+			FlushTimer: s.Cfg.FlushTimer,
+			RetryTimer: s.Cfg.RetryTimer,
+		},
+		s.encode,
+		s.logger,
+	)
+	ctx, s.cancelFn = context.WithCancel(ctx)
+	s.done = ctx.Done()
+	formatters.ConnectEmitters(ctx, s.evps, func(es []*formatters.EventMsg) {
+		for _, ev := range es {
+			s.WriteEvent(ctx, ev)
+		}
+	})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.bw.Run(ctx)
+	}()
+	s.logger.Printf("initialized statsd output: %s", s.String())
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	return nil
+}
+
+func (s *StatsDOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, s.Cfg.AddTarget, s.targetTpl)
+	if err != nil {
+		s.logger.Printf("failed to add target to the response: %v", err)
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		name := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			name = subName
+		}
+		events, err := formatters.ResponseToEventMsgs(name, rsp, meta, s.evps...)
+		if err != nil {
+			s.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			s.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (s *StatsDOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if s.bw == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	default:
+		if !s.bw.Send(ev) && s.Cfg.Debug {
+			s.logger.Printf("buffer full, dropped event: %s", ev.Name)
+		}
+	}
+}
+
+func (s *StatsDOutput) Close() error {
+	defer formatters.CloseEventProcessors(s.evps)
+	if s.cancelFn != nil {
+		s.cancelFn()
+	}
+	s.wg.Wait()
+	s.logger.Printf("closed.")
+	return nil
+}
+
+func (s *StatsDOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if err := socket.RegisterMetrics(reg); err != nil {
+		s.logger.Printf("failed to register metrics: %v", err)
+	}
+}
+
+func (s *StatsDOutput) SetName(name string)        {}
+func (s *StatsDOutput) SetClusterName(name string) {}
+
+// Healthy implements outputs.HealthChecker
+func (s *StatsDOutput) Healthy() bool {
+	return s.bw != nil && s.bw.Healthy()
+}
+
+// encode appends a gauge per numeric value of the event to buf.
+// a negative gauge is preceded by a zero gauge, so that it is not interpreted as a decrement.
+func (s *StatsDOutput) encode(buf *bytes.Buffer, ev *formatters.EventMsg) (int, error) {
+	var tags string
+	if s.Cfg.DogStatsDTags {
+		tags = dogStatsDTags(ev)
+	}
+	n := 0
+	for _, vn := range socket.ValueNames(ev) {
+		f, ok := socket.Float(ev.Values[vn])
+		if !ok {
+			if s.Cfg.Debug {
+				s.logger.Printf("skipping non numeric value %q: %v", vn, ev.Values[vn])
+			}
+			continue
+		}
+		metric, err := socket.MetricName(s.metricTpl, socket.NewMetricData(ev, vn, socket.Clean))
+		if err != nil {
+			return n, err
+		}
+		if s.Cfg.Prefix != "" {
+			metric = s.Cfg.Prefix + "." + metric
+		}
+		if f < 0 {
+			fmt.Fprintf(buf, "%s:0|g%s\n", metric, tags)
+			n++
+		}
+		fmt.Fprintf(buf, "%s:%s|g%s\n", metric, socket.FormatFloat(f), tags)
+		n++
+	}
+	return n, nil
+}
+
+// dogStatsDTags formats the event tags as DogStatsD tags, sorted by name
+func dogStatsDTags(ev *formatters.EventMsg) string {
+	if len(ev.Tags) == 0 {
+		return ""
+	}
+	tags := make([]string, 0, len(ev.Tags))
+	for k, v := range ev.Tags {
+		tags = append(tags, cleanTag(k)+":"+cleanTag(v))
+	}
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
+
+// cleanTag replaces the characters used as separators in DogStatsD tags with '_'
+func cleanTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ',', '|', '#', ':', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,101 @@
+package statsd_output
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+)
+
+var testEvent = &formatters.EventMsg{
+	Name: "sub1",
+	Tags: map[string]string{
+		"source":         "router1",
+		"interface_name": "ethernet-1/1",
+	},
+	Values: map[string]interface{}{
+		"/interface/statistics/in-octets": uint64(42),
+		"/interface/oper-state":           "up",
+		"/interface/temperature":          -5,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	tests := map[string]struct {
+		cfg  map[string]interface{}
+		want string
+	}{
+		"default": {
+			cfg: map[string]interface{}{},
+			want: "sub1.ethernet-1_1.router1.interface.statistics.in-octets:42|g\n" +
+				"sub1.ethernet-1_1.router1.interface.temperature:0|g\n" +
+				"sub1.ethernet-1_1.router1.interface.temperature:-5|g\n",
+		},
+		"dogstatsd": {
+			cfg: map[string]interface{}{
+				"prefix":         "gnmic",
+				"dogstatsd-tags": true,
+			},
+			want: "gnmic.sub1.interface.statistics.in-octets:42|g|#interface_name:ethernet-1/1,source:router1\n" +
+				"gnmic.sub1.interface.temperature:0|g|#interface_name:ethernet-1/1,source:router1\n" +
+				"gnmic.sub1.interface.temperature:-5|g|#interface_name:ethernet-1/1,source:router1\n",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := outputs.Outputs["statsd"]().(*StatsDOutput)
+			err := s.Init(context.Background(), name, tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			buf := new(bytes.Buffer)
+			n, err := s.encode(buf, testEvent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 {
+				t.Errorf("expected 3 lines, got %d", n)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestStatsDOutput(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := outputs.Outputs["statsd"]().(*StatsDOutput)
+	err = s.Init(context.Background(), "statsd1", map[string]interface{}{
+		"address":    conn.LocalAddr().String(),
+		"batch-size": 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.WriteEvent(context.Background(), testEvent)
+
+	b := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "sub1.ethernet-1_1.router1.interface.statistics.in-octets:42|g\n" +
+		"sub1.ethernet-1_1.router1.interface.temperature:0|g\n" +
+		"sub1.ethernet-1_1.router1.interface.temperature:-5|g\n"
+	if string(b[:n]) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b[:n], want)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -40,6 +41,8 @@ type TCPOutput struct {
 	logger   *log.Logger
 	mo       *formatters.MarshalOptions
 	evps     []formatters.EventProcessor
+	// number of connected workers
+	activeConns int32
 
 	targetTpl *template.Template
 }
@@ -49,6 +52,7 @@ type Config struct {
 	Rate               time.Duration `mapstructure:"rate,omitempty"`
 	BufferSize         uint          `mapstructure:"buffer-size,omitempty"`
 	Format             string        `mapstructure:"format,omitempty"`
+	MsgTemplate        string        `mapstructure:"msg-template,omitempty"`
 	AddTarget          string        `mapstructure:"add-target,omitempty"`
 	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
 	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
@@ -76,7 +80,7 @@ func (t *TCPOutput) SetEventProcessors(ps map[string]map[string]interface{}, log
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					t.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -114,10 +118,17 @@ func (t *TCPOutput) Init(ctx context.Context, name string, cfg map[string]interf
 	if t.Cfg.NumWorkers < 1 {
 		t.Cfg.NumWorkers = defaultNumWorkers
 	}
+	formatters.WarnDroppedTags(t.logger.Printf, t.Cfg.Format, t.evps)
 	t.mo = &formatters.MarshalOptions{
 		Format:     t.Cfg.Format,
 		OverrideTS: t.Cfg.OverrideTimestamps,
 	}
+	if t.Cfg.Format == "template" {
+		t.mo.Template, err = formatters.ParseMsgTemplate(t.Cfg.MsgTemplate)
+		if err != nil {
+			return err
+		}
+	}
 
 	if t.Cfg.TargetTemplate == "" {
 		t.targetTpl = outputs.DefaultTargetTemplate
@@ -135,6 +146,9 @@ func (t *TCPOutput) Init(ctx context.Context, name string, cfg map[string]interf
 	}()
 
 	ctx, t.cancelFn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(ctx, t.evps, func(es []*formatters.EventMsg) {
+		t.writeEvents(ctx, es)
+	})
 	for i := 0; i < t.Cfg.NumWorkers; i++ {
 		go t.start(ctx, i)
 	}
@@ -159,13 +173,40 @@ func (t *TCPOutput) Write(ctx context.Context, m proto.Message, meta outputs.Met
 			t.logger.Printf("failed marshaling proto msg: %v", err)
 			return
 		}
+		if len(b) == 0 {
+			return
+		}
 		t.buffer <- b
 	}
 }
 
-func (t *TCPOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+func (t *TCPOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	t.writeEvents(ctx, []*formatters.EventMsg{ev})
+}
+
+// writeEvents writes the events emitted by the event processors
+func (t *TCPOutput) writeEvents(ctx context.Context, evs []*formatters.EventMsg) {
+	if len(evs) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	default:
+		b, err := t.mo.MarshalEvents(evs, formatters.EventMeta(evs[0]))
+		if err != nil {
+			t.logger.Printf("failed marshaling events: %v", err)
+			return
+		}
+		if len(b) == 0 {
+			return
+		}
+		t.buffer <- b
+	}
+}
 
 func (t *TCPOutput) Close() error {
+	defer formatters.CloseEventProcessors(t.evps)
 	t.cancelFn()
 	if t.limiter != nil {
 		t.limiter.Stop()
@@ -198,6 +239,7 @@ START:
 		goto START
 	}
 	defer conn.Close()
+	atomic.AddInt32(&t.activeConns, 1)
 	if t.Cfg.KeepAlive > 0 {
 		conn.SetKeepAlive(true)
 		conn.SetKeepAlivePeriod(t.Cfg.KeepAlive)
@@ -206,6 +248,7 @@ START:
 	for {
 		select {
 		case <-ctx.Done():
+			atomic.AddInt32(&t.activeConns, -1)
 			return
 		case b := <-t.buffer:
 			if t.limiter != nil {
@@ -215,6 +258,7 @@ START:
 			if err != nil {
 				t.logger.Printf("%s failed sending tcp bytes: %v", workerLogPrefix, err)
 				conn.Close()
+				atomic.AddInt32(&t.activeConns, -1)
 				time.Sleep(t.Cfg.RetryInterval)
 				goto START
 			}
@@ -222,5 +266,10 @@ START:
 	}
 }
 
+// Healthy implements outputs.HealthChecker
+func (t *TCPOutput) Healthy() bool {
+	return atomic.LoadInt32(&t.activeConns) > 0
+}
+
 func (t *TCPOutput) SetName(name string)        {}
 func (t *TCPOutput) SetClusterName(name string) {}
@@ -49,6 +49,7 @@ type Config struct {
 	Rate               time.Duration `mapstructure:"rate,omitempty"`
 	BufferSize         uint          `mapstructure:"buffer-size,omitempty"`
 	Format             string        `mapstructure:"format,omitempty"`
+	MsgTemplate        string        `mapstructure:"msg-template,omitempty"`
 	AddTarget          string        `mapstructure:"add-target,omitempty"`
 	TargetTemplate     string        `mapstructure:"target-template,omitempty"`
 	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty"`
@@ -74,7 +75,7 @@ func (u *UDPSock) SetEventProcessors(ps map[string]map[string]interface{}, logge
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithProcessors(ps))
 				if err != nil {
 					u.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -115,10 +116,20 @@ func (u *UDPSock) Init(ctx context.Context, name string, cfg map[string]interfac
 		u.Close()
 	}()
 	ctx, u.cancelFn = context.WithCancel(ctx)
+	formatters.ConnectEmitters(ctx, u.evps, func(es []*formatters.EventMsg) {
+		u.writeEvents(ctx, es)
+	})
+	formatters.WarnDroppedTags(u.logger.Printf, u.Cfg.Format, u.evps)
 	u.mo = &formatters.MarshalOptions{
 		Format:     u.Cfg.Format,
 		OverrideTS: u.Cfg.OverrideTimestamps,
 	}
+	if u.Cfg.Format == "template" {
+		u.mo.Template, err = formatters.ParseMsgTemplate(u.Cfg.MsgTemplate)
+		if err != nil {
+			return err
+		}
+	}
 	if u.Cfg.TargetTemplate == "" {
 		u.targetTpl = outputs.DefaultTargetTemplate
 	} else if u.Cfg.AddTarget != "" {
@@ -151,13 +162,40 @@ func (u *UDPSock) Write(ctx context.Context, m proto.Message, meta outputs.Meta)
 			u.logger.Printf("failed marshaling proto msg: %v", err)
 			return
 		}
+		if len(b) == 0 {
+			return
+		}
 		u.buffer <- b
 	}
 }
 
-func (u *UDPSock) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+func (u *UDPSock) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	u.writeEvents(ctx, []*formatters.EventMsg{ev})
+}
+
+// writeEvents writes the events emitted by the event processors
+func (u *UDPSock) writeEvents(ctx context.Context, evs []*formatters.EventMsg) {
+	if len(evs) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	default:
+		b, err := u.mo.MarshalEvents(evs, formatters.EventMeta(evs[0]))
+		if err != nil {
+			u.logger.Printf("failed marshaling events: %v", err)
+			return
+		}
+		if len(b) == 0 {
+			return
+		}
+		u.buffer <- b
+	}
+}
 
 func (u *UDPSock) Close() error {
+	defer formatters.CloseEventProcessors(u.evps)
 	u.cancelFn()
 	if u.limiter != nil {
 		u.limiter.Stop()
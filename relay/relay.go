@@ -0,0 +1,66 @@
+// Package relay implements the gRPC service used to forward telemetry
+// from a gnmic instance (the grpc output) to another gnmic instance (the grpc input).
+//
+// The service is defined in relay.proto, relay.pb.go is generated from it.
+package relay
+
+import (
+	"fmt"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// NewEvent converts an event message to a relay Event,
+// the values are converted using formatters.EventValueToTypedValue.
+func NewEvent(ev *formatters.EventMsg) (*Event, error) {
+	if ev == nil {
+		return nil, nil
+	}
+	e := &Event{
+		Name:      ev.Name,
+		Timestamp: ev.Timestamp,
+		Deletes:   ev.Deletes,
+	}
+	if len(ev.Tags) > 0 {
+		e.Tags = make(map[string]string, len(ev.Tags))
+	}
+	for k, v := range ev.Tags {
+		e.Tags[k] = v
+	}
+	if len(ev.Values) > 0 {
+		e.Values = make(map[string]*gnmi.TypedValue, len(ev.Values))
+	}
+	for k, v := range ev.Values {
+		tv, err := formatters.EventValueToTypedValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed converting value %q: %v", k, err)
+		}
+		e.Values[k] = tv
+	}
+	return e, nil
+}
+
+// EventMsg converts a relay Event back to an event message
+func (x *Event) EventMsg() (*formatters.EventMsg, error) {
+	if x == nil {
+		return nil, nil
+	}
+	ev := &formatters.EventMsg{
+		Name:      x.GetName(),
+		Timestamp: x.GetTimestamp(),
+		Tags:      x.GetTags(),
+		Deletes:   x.GetDeletes(),
+	}
+	if len(x.GetValues()) > 0 {
+		ev.Values = make(map[string]interface{}, len(x.GetValues()))
+	}
+	for k, tv := range x.GetValues() {
+		v, err := formatters.TypedValueToEventValue(tv)
+		if err != nil {
+			return nil, fmt.Errorf("failed converting value %q: %v", k, err)
+		}
+		ev.Values[k] = v
+	}
+	return ev, nil
+}
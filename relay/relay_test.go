@@ -0,0 +1,96 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestMessageMarshal(t *testing.T) {
+	ev, err := NewEvent(&formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 42,
+		Tags:      map[string]string{"source": "router1:57400"},
+		Values:    map[string]interface{}{"/interface/statistics/in-octets": uint64(42)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []*Message{
+		{
+			Response: &gnmi.SubscribeResponse{
+				Response: &gnmi.SubscribeResponse_Update{
+					Update: &gnmi.Notification{
+						Timestamp: 42,
+						Prefix:    &gnmi.Path{Target: "router1"},
+						Update: []*gnmi.Update{
+							{
+								Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}}}},
+								Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 42}},
+							},
+						},
+					},
+				},
+			},
+			Meta: map[string]string{"source": "router1:57400", "subscription-name": "sub1"},
+		},
+		{Event: ev},
+		{},
+	}
+	for _, msg := range msgs {
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := new(Message)
+		err = proto.Unmarshal(b, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cmp.Equal(got, msg, protocmp.Transform()) {
+			t.Errorf("message mismatch: %s", cmp.Diff(msg, got, protocmp.Transform()))
+		}
+	}
+}
+
+func TestEventValuesTypes(t *testing.T) {
+	in := &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: 42,
+		Tags:      map[string]string{"source": "router1:57400"},
+		Values: map[string]interface{}{
+			"int":     int64(-1),
+			"uint":    uint64(1 << 63),
+			"float32": float32(1.5),
+			"float64": 0.1,
+			"string":  "42",
+			"bool":    true,
+			"list":    []interface{}{"a", "b"},
+		},
+		Deletes: []string{"/interface/description"},
+	}
+	e, err := NewEvent(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(&Message{Event: e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(Message)
+	err = proto.Unmarshal(b, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := msg.GetEvent().EventMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(in, out) {
+		t.Errorf("event mismatch: %s", cmp.Diff(in, out))
+	}
+}
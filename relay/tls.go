@@ -0,0 +1,80 @@
+package relay
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig is the TLS configuration of the relay client and server
+type TLSConfig struct {
+	// CA file, used to verify the server certificate by the client,
+	// and to verify the client certificates by the server.
+	CA   string
+	Cert string
+	Key  string
+	// client only, skip the server certificate verification
+	SkipVerify bool
+}
+
+// ClientTLS returns the tls.Config of a relay client
+func (c *TLSConfig) ClientTLS() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		Renegotiation:      tls.RenegotiateNever,
+		InsecureSkipVerify: c.SkipVerify,
+	}
+	if c.Cert != "" && c.Key != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if c.CA != "" {
+		pool, err := loadCA(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// ServerTLS returns the tls.Config of a relay server,
+// the client certificates are required if a CA is set.
+func (c *TLSConfig) ServerTLS() (*tls.Config, error) {
+	if c.Cert == "" || c.Key == "" {
+		return nil, errors.New("missing server certificate or key")
+	}
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Renegotiation: tls.RenegotiateNever,
+		Certificates:  []tls.Certificate{cert},
+	}
+	if c.CA != "" {
+		pool, err := loadCA(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+func loadCA(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("failed to append CA certificates from %q", file)
+	}
+	return pool, nil
+}